@@ -0,0 +1,108 @@
+// Package accesslog records every HTTP request served: the method and path,
+// the SOAP operation and action, the status and fault code, the bytes read
+// and written and the latency, as one log record per request.
+package accesslog
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"soap-server/soap"
+	"soap-server/soapfault"
+	"soap-server/tracing"
+	"time"
+)
+
+// Handler logs the requests served by next at info level, or warning level
+// for server errors and Server faults
+func Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ctx, exchange := soap.WithExchange(r.Context())
+		body := &countingBody{ReadCloser: r.Body}
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = body
+		}
+		rw := &responseWriter{ResponseWriter: w}
+		next.ServeHTTP(rw, r.WithContext(ctx))
+
+		status := rw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", status),
+		}
+		if id := exchange.RequestID(); id != "" {
+			attrs = append(attrs, slog.String(soap.LogKeyRequestID, id))
+		}
+		if op := exchange.Operation(); op != "" {
+			attrs = append(attrs, slog.String(soap.LogKeyOperation, op))
+		}
+		if action := exchange.Action(); action != "" {
+			attrs = append(attrs, slog.String("soap_action", action))
+		}
+		level := slog.LevelInfo
+		if f := exchange.Fault(); f != nil {
+			attrs = append(attrs, slog.String("fault_code", f.QualifiedCode()))
+			// By code, as SOAP 1.1 faults are all sent with 500
+			if f.Code == soapfault.CodeServer {
+				level = slog.LevelWarn
+			}
+		} else if status >= http.StatusInternalServerError {
+			level = slog.LevelWarn
+		}
+		attrs = append(attrs,
+			slog.Int64("request_bytes", body.n),
+			slog.Int64("response_bytes", rw.n),
+			soap.Duration(start),
+			slog.String("remote_addr", r.RemoteAddr),
+		)
+		if sc := tracing.SpanContextFromContext(ctx); sc.Valid() {
+			attrs = append(attrs, slog.String(soap.LogKeyTraceID, sc.TraceID.String()))
+		}
+		slog.Default().LogAttrs(ctx, level, "HTTP request", attrs...)
+	})
+}
+
+// countingBody counts the bytes read from a request body
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+// responseWriter records the status and counts the bytes of a response
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	n      int64
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,107 @@
+// Package admin serves runtime diagnostics on a listener of its own, apart
+// from the clients: the net/http/pprof profiles, the expvar variables and
+// dumps of the goroutines and the heap written to files for later
+// analysis. Profiles reveal the internals of the process, so the listener
+// must only be reachable by operators.
+package admin
+
+import (
+	"expvar"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"path/filepath"
+	"runtime"
+	runtimepprof "runtime/pprof"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Paths of the admin endpoints
+const (
+	PprofPrefix = "/debug/pprof/"
+	VarsPath    = "/debug/vars"
+	DumpPath    = "/debug/dump"
+)
+
+var inFlight atomic.Int64
+
+func init() {
+	expvar.Publish("goroutines", expvar.Func(func() interface{} { return runtime.NumGoroutine() }))
+	expvar.Publish("requests_in_flight", expvar.Func(func() interface{} { return inFlight.Load() }))
+}
+
+// Handler serves the profiles, the variables and, on POST, dumps to files
+// in dumpDir
+func Handler(dumpDir string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(PprofPrefix, pprof.Index)
+	mux.HandleFunc(PprofPrefix+"cmdline", pprof.Cmdline)
+	mux.HandleFunc(PprofPrefix+"profile", pprof.Profile)
+	mux.HandleFunc(PprofPrefix+"symbol", pprof.Symbol)
+	mux.HandleFunc(PprofPrefix+"trace", pprof.Trace)
+	mux.Handle(VarsPath, expvar.Handler())
+	mux.HandleFunc(DumpPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		files, err := Dump(dumpDir)
+		if err != nil {
+			slog.Error("Failed to dump diagnostics", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		slog.Info("Diagnostics dumped", "files", files)
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintln(w, strings.Join(files, "\n"))
+	})
+	return mux
+}
+
+// Dump writes the stacks of all goroutines and a heap profile, taken after
+// a garbage collection, to new files in dir and returns their paths. The
+// heap profile is read with go tool pprof.
+func Dump(dir string) ([]string, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	stamp := time.Now().UTC().Format("20060102T150405.000Z")
+	goroutines := filepath.Join(dir, "goroutines-"+stamp+".txt")
+	if err := writeProfile(goroutines, "goroutine", 2); err != nil {
+		return nil, err
+	}
+	runtime.GC()
+	heap := filepath.Join(dir, "heap-"+stamp+".pb.gz")
+	if err := writeProfile(heap, "heap", 0); err != nil {
+		return []string{goroutines}, err
+	}
+	return []string{goroutines, heap}, nil
+}
+
+// writeProfile writes a runtime profile to a new file
+func writeProfile(path, profile string, debug int) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if err := runtimepprof.Lookup(profile).WriteTo(f, debug); err != nil {
+		f.Close()
+		return fmt.Errorf("write %s profile: %w", profile, err)
+	}
+	return f.Close()
+}
+
+// CountRequests counts the requests being served by next in the
+// requests_in_flight variable
+func CountRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inFlight.Add(1)
+		defer inFlight.Add(-1)
+		next.ServeHTTP(w, r)
+	})
+}
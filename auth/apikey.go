@@ -0,0 +1,151 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"os"
+	"soap-server/soap"
+	"soap-server/soapfault"
+	"strings"
+)
+
+// MethodAPIKey is the Principal.Method of callers authenticated with an
+// API key
+const MethodAPIKey = "apikey"
+
+// DefaultAPIKeyHeader is the request header carrying the API key unless
+// configured otherwise
+const DefaultAPIKeyHeader = "X-API-Key"
+
+// APIKey is the client an API key identifies and the operations it may
+// invoke
+type APIKey struct {
+	Client     string
+	Operations []string // nil allows every operation
+}
+
+// APIKeys maps the SHA-256 hashes of API keys to their clients, so lookups
+// do not depend on how much of a key matches
+type APIKeys map[[sha256.Size]byte]APIKey
+
+// ParseAPIKeys parses comma-separated key=client entries, optionally
+// limiting the client to operations separated by "|", e.g.
+// "k1=partner:UploadFile|ListFiles,k2=backoffice"
+func ParseAPIKeys(s string) (APIKeys, error) {
+	keys := APIKeys{}
+	for _, entry := range strings.Split(s, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		if err := keys.add(entry); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// LoadAPIKeys reads a file with one key=client[:operations] entry per line.
+// Blank lines and lines starting with # are skipped.
+func LoadAPIKeys(path string) (APIKeys, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	keys := APIKeys{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if err := keys.add(text); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+	}
+	return keys, scanner.Err()
+}
+
+// add adds a key=client[:operations] entry
+func (k APIKeys) add(entry string) error {
+	entry = strings.TrimSpace(entry)
+	i := strings.LastIndex(entry, "=")
+	if i <= 0 {
+		return fmt.Errorf("invalid API key entry, expected key=client[:operations]")
+	}
+	key, client := entry[:i], strings.TrimSpace(entry[i+1:])
+	var apiKey APIKey
+	if name, operations, ok := strings.Cut(client, ":"); ok {
+		client = strings.TrimSpace(name)
+		apiKey.Operations = []string{}
+		for _, op := range strings.Split(operations, "|") {
+			if op = strings.TrimSpace(op); op != "" {
+				apiKey.Operations = append(apiKey.Operations, op)
+			}
+		}
+	}
+	if client == "" {
+		return fmt.Errorf("API key entry without client")
+	}
+	apiKey.Client = client
+	hash := sha256.Sum256([]byte(strings.TrimSpace(key)))
+	if existing, ok := k[hash]; ok {
+		return fmt.Errorf("API key of %s already assigned to %s", client, existing.Client)
+	}
+	k[hash] = apiKey
+	return nil
+}
+
+// Lookup returns the client of an API key
+func (k APIKeys) Lookup(key string) (APIKey, bool) {
+	apiKey, ok := k[sha256.Sum256([]byte(key))]
+	return apiKey, ok
+}
+
+// APIKeyChallenge returns the WWW-Authenticate challenge for API keys sent
+// in header
+func APIKeyChallenge(header string) string {
+	if header == "" {
+		header = DefaultAPIKeyHeader
+	}
+	return fmt.Sprintf(`APIKey header=%q`, header)
+}
+
+// APIKeyAuth returns middleware authenticating callers by the API key in
+// the header (DefaultAPIKeyHeader if empty). Unknown keys are answered with
+// 401, SOAP operations the key does not allow with an Authorization fault;
+// requests without a key are passed on. The operations of the key are
+// recorded in the principal for the handlers outside the SOAP endpoints.
+func APIKeyAuth(header string, keys APIKeys) soap.Middleware {
+	if header == "" {
+		header = DefaultAPIKeyHeader
+	}
+	challenge := APIKeyChallenge(header)
+	return func(next soap.SOAPHandler) soap.SOAPHandler {
+		return func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			key := r.Header.Get(header)
+			if _, authenticated := soap.PrincipalFromContext(ctx); key == "" || authenticated {
+				next(w, r)
+				return
+			}
+			apiKey, ok := keys.Lookup(key)
+			if !ok {
+				soap.Warn(ctx, "API key authentication failed")
+				unauthorized(w, r, "Invalid API key", challenge)
+				return
+			}
+			principal := soap.Principal{Name: apiKey.Client, Method: MethodAPIKey, Operations: apiKey.Operations}
+			if op, ok := soap.OperationFromContext(ctx); ok && !principal.Allows(op.Name) {
+				soap.Warn(ctx, "Access denied: operation not allowed for the API key", "client", apiKey.Client)
+				soap.WriteFault(w, r, soapfault.Client("Access denied",
+					fmt.Sprintf("The API key does not allow operation %s", op.Name)).
+					WithSubcode("", "Authorization"))
+				return
+			}
+			next(w, r.WithContext(soap.WithPrincipal(ctx, principal)))
+		}
+	}
+}
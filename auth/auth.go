@@ -0,0 +1,45 @@
+// Package auth authenticates the callers of the SOAP endpoints and the
+// file downloads. Authenticated callers are recorded as the principal of the
+// request, which the authorization policy checks.
+package auth
+
+import (
+	"net/http"
+	"soap-server/soap"
+	"soap-server/soapfault"
+)
+
+// DefaultRealm is the protection space named in challenges unless
+// configured otherwise
+const DefaultRealm = "soap-server"
+
+// Require returns middleware rejecting the requests that no authentication
+// middleware before it has authenticated, with 401 and the challenges of
+// the accepted methods
+func Require(challenges ...string) soap.Middleware {
+	return func(next soap.SOAPHandler) soap.SOAPHandler {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := soap.PrincipalFromContext(r.Context()); !ok {
+				unauthorized(w, r, "Credentials are required", challenges...)
+				return
+			}
+			next(w, r)
+		}
+	}
+}
+
+// unauthorized answers a request that could not be authenticated with 401
+// and the challenges: a Client fault with the Authentication subcode on the
+// SOAP endpoints, plain text elsewhere
+func unauthorized(w http.ResponseWriter, r *http.Request, detail string, challenges ...string) {
+	for _, challenge := range challenges {
+		w.Header().Add("WWW-Authenticate", challenge)
+	}
+	if _, ok := soap.OperationFromContext(r.Context()); ok {
+		soap.WriteFault(w, r, soapfault.Client("Authentication required", detail).
+			WithSubcode("", "Authentication").
+			WithHTTPStatus(http.StatusUnauthorized))
+		return
+	}
+	http.Error(w, "Authentication required", http.StatusUnauthorized)
+}
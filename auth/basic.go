@@ -0,0 +1,189 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"soap-server/soap"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MethodBasic is the Principal.Method of callers authenticated with HTTP
+// Basic credentials
+const MethodBasic = "basic"
+
+// Credentials maps user names to their passwords, hashed as by htpasswd
+// (bcrypt, "$apr1$" MD5 or "{SHA}") or in plain text
+type Credentials map[string]string
+
+// ParseCredentials parses comma-separated user:password entries
+func ParseCredentials(s string) (Credentials, error) {
+	creds := Credentials{}
+	for _, entry := range strings.Split(s, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		if err := creds.add(entry); err != nil {
+			return nil, err
+		}
+	}
+	return creds, nil
+}
+
+// LoadHtpasswd reads the credentials of an htpasswd file. Blank lines and
+// lines starting with # are skipped.
+func LoadHtpasswd(path string) (Credentials, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	creds := Credentials{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if err := creds.add(text); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+	}
+	return creds, scanner.Err()
+}
+
+// add adds a user:password entry
+func (c Credentials) add(entry string) error {
+	user, password, ok := strings.Cut(strings.TrimSpace(entry), ":")
+	if !ok || user == "" || password == "" {
+		return fmt.Errorf("invalid credentials %q, expected user:password", user)
+	}
+	if strings.HasPrefix(password, "$") && !strings.HasPrefix(password, "$apr1$") && !isBcrypt(password) {
+		return fmt.Errorf("unsupported password hash for %s", user)
+	}
+	c[user] = password
+	return nil
+}
+
+// Verify reports whether password is the password of user
+func (c Credentials) Verify(user, password string) bool {
+	stored, ok := c[user]
+	if !ok {
+		return false
+	}
+	switch {
+	case isBcrypt(stored):
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) == nil
+	case strings.HasPrefix(stored, "$apr1$"):
+		salt, _, _ := strings.Cut(strings.TrimPrefix(stored, "$apr1$"), "$")
+		return equal(apr1(password, salt), stored)
+	case strings.HasPrefix(stored, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		return equal("{SHA}"+base64.StdEncoding.EncodeToString(sum[:]), stored)
+	}
+	return equal(password, stored)
+}
+
+// BasicChallenge returns the WWW-Authenticate challenge for HTTP Basic
+// credentials of the realm, DefaultRealm if empty
+func BasicChallenge(realm string) string {
+	if realm == "" {
+		realm = DefaultRealm
+	}
+	return fmt.Sprintf(`Basic realm=%q, charset="UTF-8"`, realm)
+}
+
+// Basic returns middleware authenticating callers that send HTTP Basic
+// credentials. Invalid credentials are answered with 401 and a challenge
+// for the realm; requests without them are passed on.
+func Basic(realm string, creds Credentials) soap.Middleware {
+	challenge := BasicChallenge(realm)
+	return func(next soap.SOAPHandler) soap.SOAPHandler {
+		return func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			user, password, ok := r.BasicAuth()
+			if _, authenticated := soap.PrincipalFromContext(ctx); !ok || authenticated {
+				next(w, r)
+				return
+			}
+			if !creds.Verify(user, password) {
+				soap.Warn(ctx, "Basic authentication failed", "user", user)
+				unauthorized(w, r, "Invalid user name or password", challenge)
+				return
+			}
+			next(w, r.WithContext(soap.WithPrincipal(ctx, soap.Principal{Name: user, Method: MethodBasic})))
+		}
+	}
+}
+
+func isBcrypt(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// apr1 returns the Apache MD5 hash of password with the salt, as written
+// by htpasswd -m
+func apr1(password, salt string) string {
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	alternate := md5.Sum([]byte(password + salt + password))
+	h := md5.New()
+	h.Write([]byte(password + "$apr1$" + salt))
+	for n := len(password); n > 0; n -= 16 {
+		h.Write(alternate[:min(n, 16)])
+	}
+	for n := len(password); n > 0; n >>= 1 {
+		if n&1 != 0 {
+			h.Write([]byte{0})
+		} else {
+			h.Write([]byte{password[0]})
+		}
+	}
+	sum := h.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		h := md5.New()
+		if i&1 != 0 {
+			h.Write([]byte(password))
+		} else {
+			h.Write(sum)
+		}
+		if i%3 != 0 {
+			h.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			h.Write([]byte(password))
+		}
+		if i&1 != 0 {
+			h.Write(sum)
+		} else {
+			h.Write([]byte(password))
+		}
+		sum = h.Sum(nil)
+	}
+
+	const alphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	var encoded strings.Builder
+	encode := func(v uint32, n int) {
+		for ; n > 0; n-- {
+			encoded.WriteByte(alphabet[v&0x3f])
+			v >>= 6
+		}
+	}
+	for _, i := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		encode(uint32(sum[i[0]])<<16|uint32(sum[i[1]])<<8|uint32(sum[i[2]]), 4)
+	}
+	encode(uint32(sum[11]), 2)
+	return "$apr1$" + salt + "$" + encoded.String()
+}
@@ -0,0 +1,286 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"soap-server/soap"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults of the JWKS refresh
+const (
+	DefaultJWKSRefresh = time.Hour
+
+	// jwksMinRefresh limits the fetches triggered by tokens signed with
+	// unknown keys, so forged key IDs cannot flood the key server
+	jwksMinRefresh = time.Minute
+
+	// maxJWKSSize limits the key set documents
+	maxJWKSSize = 1 << 20
+)
+
+// PublicKey is a key verifying token signatures
+type PublicKey struct {
+	ID        string // Key ID matched against the kid of the tokens; empty matches all
+	Algorithm string // Algorithm the key is restricted to; empty allows all of its type
+	Key       crypto.PublicKey
+}
+
+// KeySource provides the keys verifying token signatures
+type KeySource interface {
+	// Keys returns the keys with the key ID, or all keys if kid is empty
+	Keys(ctx context.Context, kid string) ([]PublicKey, error)
+}
+
+// StaticKeys is a fixed set of verification keys
+type StaticKeys []PublicKey
+
+func (k StaticKeys) Keys(ctx context.Context, kid string) ([]PublicKey, error) {
+	return matchKeys(k, kid), nil
+}
+
+// LoadKeys reads verification keys from a file holding either PEM public
+// keys and certificates or a JSON Web Key Set
+func LoadKeys(path string) (StaticKeys, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keys StaticKeys
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "{") {
+		keys, err = parseJWKS(data)
+	} else {
+		keys, err = parsePEMKeys(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: no keys found", path)
+	}
+	return keys, nil
+}
+
+// parsePEMKeys parses the public keys and certificates of PEM data
+func parsePEMKeys(data []byte) (StaticKeys, error) {
+	var keys StaticKeys
+	for {
+		var block *pem.Block
+		if block, data = pem.Decode(data); block == nil {
+			return keys, nil
+		}
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, PublicKey{Key: key})
+		case "RSA PUBLIC KEY":
+			key, err := x509.ParsePKCS1PublicKey(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, PublicKey{Key: key})
+		case "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, PublicKey{Key: cert.PublicKey})
+		}
+	}
+}
+
+// jwk is a JSON Web Key (RFC 7517) of an RSA, EC or Ed25519 public key
+type jwk struct {
+	Kty string   `json:"kty"`
+	Kid string   `json:"kid"`
+	Use string   `json:"use"`
+	Alg string   `json:"alg"`
+	N   string   `json:"n"`
+	E   string   `json:"e"`
+	Crv string   `json:"crv"`
+	X   string   `json:"x"`
+	Y   string   `json:"y"`
+	X5c []string `json:"x5c"`
+}
+
+// parseJWKS parses the signature keys of a JSON Web Key Set. Encryption
+// keys and keys of unsupported types are skipped.
+func parseJWKS(data []byte) (StaticKeys, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS: %w", err)
+	}
+	var keys StaticKeys
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("JWK %q: %w", k.Kid, err)
+		}
+		if key != nil {
+			keys = append(keys, PublicKey{ID: k.Kid, Algorithm: k.Alg, Key: key})
+		}
+	}
+	return keys, nil
+}
+
+// publicKey returns the key of the JWK, or nil if its type is not supported
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	decode := base64.RawURLEncoding.DecodeString
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %w", err)
+		}
+		e, err := decode(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("invalid exponent")
+		}
+		exponent := 0
+		for _, b := range e {
+			exponent = exponent<<8 | int(b)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exponent}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x coordinate: %w", err)
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y coordinate: %w", err)
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, fmt.Errorf("point not on curve %s", k.Crv)
+		}
+		return key, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, nil
+		}
+		x, err := decode(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, nil
+}
+
+// matchKeys returns the keys with the key ID; keys without an ID match any
+func matchKeys(keys []PublicKey, kid string) []PublicKey {
+	if kid == "" {
+		return keys
+	}
+	var matched []PublicKey
+	for _, key := range keys {
+		if key.ID == "" || key.ID == kid {
+			matched = append(matched, key)
+		}
+	}
+	return matched
+}
+
+// JWKS is a KeySource fetching a JSON Web Key Set from a URL. The set is
+// refreshed after the refresh interval, and early when a token names a key
+// it does not hold, as issuers rotate keys. If a refresh fails, the keys
+// fetched before are kept.
+type JWKS struct {
+	url     string
+	refresh time.Duration
+	client  *http.Client
+
+	mu      sync.Mutex
+	keys    StaticKeys
+	fetched time.Time // Time of the last attempt, successful or not
+}
+
+// NewJWKS returns the key set at url, refreshed after the interval
+// (DefaultJWKSRefresh if zero). The keys are fetched when first needed.
+func NewJWKS(url string, refresh time.Duration) *JWKS {
+	if refresh <= 0 {
+		refresh = DefaultJWKSRefresh
+	}
+	return &JWKS{url: url, refresh: refresh, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (j *JWKS) Keys(ctx context.Context, kid string) ([]PublicKey, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	age := time.Since(j.fetched)
+	stale := j.fetched.IsZero() || age >= j.refresh
+	if !stale && age >= jwksMinRefresh && len(matchKeys(j.keys, kid)) == 0 {
+		stale = true
+	}
+	if stale {
+		j.fetched = time.Now()
+		keys, err := j.fetch(ctx)
+		if err != nil {
+			if j.keys == nil {
+				return nil, err
+			}
+			soap.Warn(ctx, "Failed to refresh JWKS, keeping the current keys", "keys", len(j.keys), soap.LogKeyError, err)
+		} else {
+			j.keys = keys
+		}
+	}
+	return matchKeys(j.keys, kid), nil
+}
+
+// fetch downloads and parses the key set
+func (j *JWKS) fetch(ctx context.Context) (StaticKeys, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := j.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching JWKS: %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize))
+	if err != nil {
+		return nil, fmt.Errorf("fetching JWKS: %w", err)
+	}
+	return parseJWKS(data)
+}
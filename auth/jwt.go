@@ -0,0 +1,281 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"soap-server/soap"
+	"strings"
+	"time"
+)
+
+// MethodJWT is the Principal.Method of callers authenticated with a JWT
+// bearer token
+const MethodJWT = "jwt"
+
+// Defaults of the token validation
+const (
+	DefaultJWTClockSkew = time.Minute
+	DefaultNameClaim    = "sub"
+	DefaultRolesClaim   = "roles"
+)
+
+// JWTConfig configures the validation of JWT bearer tokens
+type JWTConfig struct {
+	// Keys verify the token signatures
+	Keys KeySource
+
+	// Issuer, if set, must be the iss claim of the tokens
+	Issuer string
+
+	// Audience, if set, must be one of the aud claim of the tokens
+	Audience string
+
+	// ClockSkew is tolerated when checking exp and nbf. Defaults to
+	// DefaultJWTClockSkew.
+	ClockSkew time.Duration
+
+	// NameClaim is the claim naming the caller. Defaults to
+	// DefaultNameClaim.
+	NameClaim string
+
+	// RolesClaim is the claim granting roles to the caller, a list or a
+	// space-separated string. Defaults to DefaultRolesClaim.
+	RolesClaim string
+}
+
+// withDefaults returns a copy of the config with empty fields defaulted
+func (cfg JWTConfig) withDefaults() JWTConfig {
+	if cfg.ClockSkew <= 0 {
+		cfg.ClockSkew = DefaultJWTClockSkew
+	}
+	if cfg.NameClaim == "" {
+		cfg.NameClaim = DefaultNameClaim
+	}
+	if cfg.RolesClaim == "" {
+		cfg.RolesClaim = DefaultRolesClaim
+	}
+	return cfg
+}
+
+// jwtHeader is the JOSE header of a token
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// VerifyJWT checks the signature of a compact serialized JWT and its exp,
+// nbf, iss and aud claims, and returns the claims. Tokens must expire;
+// unsigned and HMAC signed tokens are rejected.
+func (cfg JWTConfig) VerifyJWT(ctx context.Context, token string) (map[string]interface{}, error) {
+	cfg = cfg.withDefaults()
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed token header: %w", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token signature: %w", err)
+	}
+	hash, ok := jwtAlgorithms[header.Alg]
+	if !ok {
+		return nil, fmt.Errorf("unsupported algorithm %q", header.Alg)
+	}
+
+	keys, err := cfg.Keys.Keys(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, key := range keys {
+		if key.Algorithm != "" && key.Algorithm != header.Alg {
+			continue
+		}
+		if verifySignature(header.Alg, hash, key.Key, signed, signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, errors.New("invalid signature or unknown key")
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims: %w", err)
+	}
+	if err := cfg.checkClaims(claims, time.Now()); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// checkClaims checks the registered claims of a token at the time now
+func (cfg JWTConfig) checkClaims(claims map[string]interface{}, now time.Time) error {
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return errors.New("token without expiration time")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(cfg.ClockSkew)) {
+		return errors.New("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(cfg.ClockSkew).Before(time.Unix(int64(nbf), 0)) {
+		return errors.New("token not yet valid")
+	}
+	if cfg.Issuer != "" && claims["iss"] != cfg.Issuer {
+		return fmt.Errorf("token issuer %v not accepted", claims["iss"])
+	}
+	if cfg.Audience != "" && !containsString(stringList(claims["aud"]), cfg.Audience) {
+		return errors.New("token not issued for this audience")
+	}
+	return nil
+}
+
+// jwtAlgorithms maps the supported signature algorithms to their hash.
+// EdDSA signs the data itself.
+var jwtAlgorithms = map[string]crypto.Hash{
+	"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+	"PS256": crypto.SHA256, "PS384": crypto.SHA384, "PS512": crypto.SHA512,
+	"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512,
+	"EdDSA": 0,
+}
+
+// verifySignature verifies the signature of the signed data with the key,
+// if the key suits the algorithm
+func verifySignature(alg string, hash crypto.Hash, key crypto.PublicKey, signed, signature []byte) bool {
+	var digest []byte
+	if hash != 0 {
+		h := hash.New()
+		h.Write(signed)
+		digest = h.Sum(nil)
+	}
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		switch {
+		case strings.HasPrefix(alg, "RS"):
+			return rsa.VerifyPKCS1v15(k, hash, digest, signature) == nil
+		case strings.HasPrefix(alg, "PS"):
+			return rsa.VerifyPSS(k, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil
+		}
+	case *ecdsa.PublicKey:
+		// The curve must match the algorithm, e.g. P-256 for ES256
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if !strings.HasPrefix(alg, "ES") || len(signature) != 2*size || hash != ecdsaHash(size) {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		return ecdsa.Verify(k, digest, r, s)
+	case ed25519.PublicKey:
+		return alg == "EdDSA" && ed25519.Verify(k, signed, signature)
+	}
+	return false
+}
+
+// ecdsaHash returns the hash of the ES algorithm of a curve with
+// coordinates of size bytes
+func ecdsaHash(size int) crypto.Hash {
+	switch size {
+	case 32:
+		return crypto.SHA256
+	case 48:
+		return crypto.SHA384
+	}
+	return crypto.SHA512
+}
+
+// decodeSegment decodes a base64url encoded JSON segment of a token
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// stringList returns the strings of a claim holding a string or a list
+func stringList(claim interface{}) []string {
+	switch v := claim.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var list []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// BearerChallenge returns the WWW-Authenticate challenge for bearer tokens
+// of the realm, DefaultRealm if empty
+func BearerChallenge(realm string) string {
+	if realm == "" {
+		realm = DefaultRealm
+	}
+	return fmt.Sprintf(`Bearer realm=%q`, realm)
+}
+
+// JWT returns middleware authenticating callers that send a JWT in an
+// "Authorization: Bearer" header. The caller is named by the name claim and
+// granted the roles of the roles claim; all claims are recorded in the
+// principal for the handlers. Invalid tokens are answered with 401 and a
+// challenge for the realm; requests without a bearer token are passed on.
+func JWT(realm string, cfg JWTConfig) soap.Middleware {
+	cfg = cfg.withDefaults()
+	challenge := BearerChallenge(realm) + `, error="invalid_token"`
+	return func(next soap.SOAPHandler) soap.SOAPHandler {
+		return func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+			if _, authenticated := soap.PrincipalFromContext(ctx); !strings.EqualFold(scheme, "Bearer") || authenticated {
+				next(w, r)
+				return
+			}
+			claims, err := cfg.VerifyJWT(ctx, strings.TrimSpace(token))
+			if err != nil {
+				soap.Warn(ctx, "JWT authentication failed", soap.LogKeyError, err)
+				unauthorized(w, r, "Invalid bearer token: "+err.Error(), challenge)
+				return
+			}
+			name, _ := claims[cfg.NameClaim].(string)
+			if name == "" {
+				soap.Warn(ctx, "JWT authentication failed: name claim missing", "claim", cfg.NameClaim)
+				unauthorized(w, r, "Invalid bearer token: no "+cfg.NameClaim+" claim", challenge)
+				return
+			}
+			roles := stringList(claims[cfg.RolesClaim])
+			if s, ok := claims[cfg.RolesClaim].(string); ok {
+				roles = strings.Fields(s)
+			}
+			principal := soap.Principal{Name: name, Method: MethodJWT, Roles: roles, Claims: claims}
+			next(w, r.WithContext(soap.WithPrincipal(ctx, principal)))
+		}
+	}
+}
@@ -0,0 +1,86 @@
+// Package avscan implements the malware scanners of the upload pipeline: a
+// ClamAV daemon client using the INSTREAM command and an ICAP client using
+// RESPMOD, as offered by c-icap and most antivirus gateways.
+package avscan
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"soap-server/handler"
+	"soap-server/tracing"
+	"time"
+)
+
+// DefaultTimeout bounds a scan unless configured otherwise
+const DefaultTimeout = time.Minute
+
+// New creates the scanner of a URL: clamd://host:port or clamd:///path for
+// a clamd TCP or Unix socket, or icap://host:port/service for an ICAP
+// service. A zero timeout means DefaultTimeout.
+func New(rawURL string, timeout time.Duration) (handler.Scanner, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid scanner URL: %w", err)
+	}
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	switch u.Scheme {
+	case "clamd":
+		if u.Host == "" {
+			if u.Path == "" {
+				return nil, fmt.Errorf("invalid scanner URL %q: missing socket", rawURL)
+			}
+			return &Clamd{Network: "unix", Address: u.Path, Timeout: timeout}, nil
+		}
+		return &Clamd{Network: "tcp", Address: withPort(u.Host, "3310"), Timeout: timeout}, nil
+	case "icap":
+		if u.Host == "" {
+			return nil, fmt.Errorf("invalid scanner URL %q: missing host", rawURL)
+		}
+		u.Host = withPort(u.Host, "1344")
+		return &ICAP{URL: u, Timeout: timeout}, nil
+	}
+	return nil, fmt.Errorf("unknown scanner %q: want clamd or icap", u.Scheme)
+}
+
+// startScan starts the span of a scan by the scanner at the address
+func startScan(ctx context.Context, system, address string) (context.Context, *tracing.Span) {
+	return tracing.Start(ctx, system+" scan", tracing.KindClient,
+		tracing.String("scanner.system", system), tracing.String("server.address", address))
+}
+
+// endScan ends the span of a scan with its result
+func endScan(span *tracing.Span, threat string, err error) {
+	span.SetAttributes(tracing.Bool("scanner.infected", threat != ""))
+	span.SetError(err)
+	span.End()
+}
+
+// withPort adds the default port to a host without one
+func withPort(host, port string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// dial connects to a scanner, with a deadline for the whole scan
+func dial(ctx context.Context, network, address string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+	return conn, nil
+}
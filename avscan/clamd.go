@@ -0,0 +1,91 @@
+package avscan
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// chunkSize is the size of the INSTREAM chunks; clamd rejects chunks over
+// its StreamMaxLength
+const chunkSize = 64 << 10
+
+// Clamd scans content with a ClamAV daemon. Streams over the daemon's
+// StreamMaxLength (25 MB by default) fail to scan.
+type Clamd struct {
+	Network string // tcp or unix
+	Address string
+	Timeout time.Duration
+}
+
+// Scan streams the content to clamd and returns the name of the signature
+// it matched, or "" if the content is clean
+func (c *Clamd) Scan(ctx context.Context, r io.Reader) (string, error) {
+	ctx, span := startScan(ctx, "clamd", c.Address)
+	threat, err := c.scan(ctx, r)
+	endScan(span, threat, err)
+	return threat, err
+}
+
+func (c *Clamd) scan(ctx context.Context, r io.Reader) (string, error) {
+	conn, err := dial(ctx, c.Network, c.Address, c.Timeout)
+	if err != nil {
+		return "", fmt.Errorf("clamd: %w", err)
+	}
+	defer conn.Close()
+
+	w := bufio.NewWriterSize(conn, chunkSize+4)
+	if _, err := w.WriteString("zINSTREAM\x00"); err != nil {
+		return "", fmt.Errorf("clamd: %w", err)
+	}
+	chunk := make([]byte, chunkSize)
+	for {
+		n, readErr := io.ReadFull(r, chunk)
+		if n > 0 {
+			if err := binary.Write(w, binary.BigEndian, uint32(n)); err != nil {
+				return "", fmt.Errorf("clamd: %w", err)
+			}
+			if _, err := w.Write(chunk[:n]); err != nil {
+				return "", fmt.Errorf("clamd: %w", err)
+			}
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			return "", readErr
+		}
+	}
+	// A zero-length chunk ends the stream
+	if err := binary.Write(w, binary.BigEndian, uint32(0)); err != nil {
+		return "", fmt.Errorf("clamd: %w", err)
+	}
+	if err := w.Flush(); err != nil {
+		return "", fmt.Errorf("clamd: %w", err)
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && reply == "" {
+		return "", fmt.Errorf("clamd: %w", err)
+	}
+	return parseClamdReply(strings.TrimRight(reply, "\x00\n"))
+}
+
+// parseClamdReply interprets "stream: OK", "stream: <signature> FOUND" and
+// "<message> ERROR" replies
+func parseClamdReply(reply string) (string, error) {
+	result := strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case result == "OK":
+		return "", nil
+	case strings.HasSuffix(result, " FOUND"):
+		return strings.TrimSuffix(result, " FOUND"), nil
+	case strings.HasSuffix(result, " ERROR"):
+		return "", fmt.Errorf("clamd: %s", strings.TrimSuffix(result, " ERROR"))
+	}
+	return "", fmt.Errorf("clamd: unexpected reply %q", reply)
+}
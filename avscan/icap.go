@@ -0,0 +1,133 @@
+package avscan
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// icapResponseHeader is the encapsulated HTTP response the content is sent
+// as; RESPMOD services scan response bodies
+const icapResponseHeader = "HTTP/1.1 200 OK\r\nContent-Type: application/octet-stream\r\nTransfer-Encoding: chunked\r\n\r\n"
+
+// ICAP scans content with an ICAP antivirus service using RESPMOD
+type ICAP struct {
+	URL     *url.URL // icap://host:port/service
+	Timeout time.Duration
+}
+
+// Scan sends the content to the ICAP service and returns the name of the
+// threat it reported, or "" if the content is clean
+func (c *ICAP) Scan(ctx context.Context, r io.Reader) (string, error) {
+	ctx, span := startScan(ctx, "icap", c.URL.Host)
+	threat, err := c.scan(ctx, r)
+	endScan(span, threat, err)
+	return threat, err
+}
+
+func (c *ICAP) scan(ctx context.Context, r io.Reader) (string, error) {
+	conn, err := dial(ctx, "tcp", c.URL.Host, c.Timeout)
+	if err != nil {
+		return "", fmt.Errorf("icap: %w", err)
+	}
+	defer conn.Close()
+
+	w := bufio.NewWriterSize(conn, chunkSize+16)
+	fmt.Fprintf(w, "RESPMOD %s ICAP/1.0\r\n", c.URL)
+	fmt.Fprintf(w, "Host: %s\r\n", c.URL.Host)
+	// 204 answers a clean scan without echoing the content back
+	fmt.Fprintf(w, "Allow: 204\r\n")
+	fmt.Fprintf(w, "Encapsulated: res-hdr=0, res-body=%d\r\n\r\n", len(icapResponseHeader))
+	w.WriteString(icapResponseHeader)
+
+	chunk := make([]byte, chunkSize)
+	for {
+		n, readErr := io.ReadFull(r, chunk)
+		if n > 0 {
+			fmt.Fprintf(w, "%x\r\n", n)
+			w.Write(chunk[:n])
+			w.WriteString("\r\n")
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			return "", readErr
+		}
+	}
+	w.WriteString("0\r\n\r\n")
+	if err := w.Flush(); err != nil {
+		return "", fmt.Errorf("icap: %w", err)
+	}
+
+	br := bufio.NewReader(conn)
+	tp := textproto.NewReader(br)
+	status, err := tp.ReadLine()
+	if err != nil {
+		return "", fmt.Errorf("icap: %w", err)
+	}
+	code, err := icapStatus(status)
+	if err != nil {
+		return "", err
+	}
+	header, err := tp.ReadMIMEHeader()
+	if err != nil {
+		return "", fmt.Errorf("icap: %w", err)
+	}
+	switch {
+	case code == http.StatusNoContent:
+		return "", nil
+	case code != http.StatusOK:
+		return "", fmt.Errorf("icap: %s", status)
+	}
+
+	if threat := icapThreat(header); threat != "" {
+		return threat, nil
+	}
+	// Without a threat header, a modified response such as an error page
+	// means the content was blocked, the unchanged one that it is clean
+	if strings.Contains(header.Get("Encapsulated"), "res-hdr=0") {
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			return "", fmt.Errorf("icap: invalid encapsulated response: %w", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 == 2 {
+			return "", nil
+		}
+	}
+	return "unknown threat", nil
+}
+
+// icapStatus returns the status code of an "ICAP/1.0 204 No Content" line
+func icapStatus(line string) (int, error) {
+	proto, rest, _ := strings.Cut(line, " ")
+	codeText, _, _ := strings.Cut(rest, " ")
+	code, err := strconv.Atoi(codeText)
+	if !strings.HasPrefix(proto, "ICAP/") || err != nil {
+		return 0, fmt.Errorf("icap: invalid status line %q", line)
+	}
+	return code, nil
+}
+
+// icapThreat returns the threat named by the headers antivirus services
+// report infections with
+func icapThreat(header textproto.MIMEHeader) string {
+	// X-Infection-Found: Type=0; Resolution=2; Threat=Eicar-Test-Signature;
+	for _, field := range strings.Split(header.Get("X-Infection-Found"), ";") {
+		if name, value, ok := strings.Cut(strings.TrimSpace(field), "="); ok && name == "Threat" && value != "" {
+			return value
+		}
+	}
+	if threat := strings.TrimSpace(header.Get("X-Virus-ID")); threat != "" {
+		return threat
+	}
+	return ""
+}
@@ -0,0 +1,122 @@
+package blobcrypt
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// KeyWrapper protects the data keys of the blobs with a master key that is
+// not stored with them
+type KeyWrapper interface {
+	// WrapKey encrypts a data key
+	WrapKey(ctx context.Context, key []byte) ([]byte, error)
+
+	// UnwrapKey decrypts a data key wrapped by WrapKey
+	UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+// keyIDSize is the size of the master key IDs in the wrapped keys
+const keyIDSize = 8
+
+// MasterKeys is a KeyWrapper encrypting the data keys with AES-256-GCM
+// under a master key from the configuration. Data keys wrapped by the
+// previous master keys can still be unwrapped, so the master key can be
+// rotated without re-encrypting the stored blobs.
+type MasterKeys struct {
+	current  masterKey
+	previous []masterKey
+}
+
+// masterKey is a master key with its ID, the start of its SHA-256 digest
+type masterKey struct {
+	id  []byte
+	key []byte
+}
+
+func newMasterKey(key []byte) (masterKey, error) {
+	if len(key) != 32 {
+		return masterKey{}, fmt.Errorf("master key must be 32 bytes, got %d", len(key))
+	}
+	sum := sha256.Sum256(key)
+	return masterKey{id: sum[:keyIDSize], key: key}, nil
+}
+
+// NewMasterKeys returns a KeyWrapper wrapping the data keys with the
+// 32-byte current key and unwrapping them with any of the keys
+func NewMasterKeys(current []byte, previous ...[]byte) (*MasterKeys, error) {
+	k, err := newMasterKey(current)
+	if err != nil {
+		return nil, err
+	}
+	keys := &MasterKeys{current: k}
+	for _, key := range previous {
+		k, err := newMasterKey(key)
+		if err != nil {
+			return nil, err
+		}
+		keys.previous = append(keys.previous, k)
+	}
+	return keys, nil
+}
+
+// ParseMasterKey decodes a master key given in base64 or hex
+func ParseMasterKey(s string) ([]byte, error) {
+	if key, err := hex.DecodeString(s); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("master key must be 32 bytes in base64 or hex")
+	}
+	return key, nil
+}
+
+// ID returns the hex-encoded ID of the current master key, which can be
+// shown without revealing the key
+func (m *MasterKeys) ID() string {
+	return hex.EncodeToString(m.current.id)
+}
+
+// WrapKey returns the ID of the current master key, a random nonce and the
+// sealed data key
+func (m *MasterKeys) WrapKey(ctx context.Context, key []byte) ([]byte, error) {
+	aead, err := newAEAD(m.current.key)
+	if err != nil {
+		return nil, err
+	}
+	wrapped := make([]byte, keyIDSize+aead.NonceSize(), keyIDSize+aead.NonceSize()+len(key)+aead.Overhead())
+	copy(wrapped, m.current.id)
+	nonce := wrapped[keyIDSize:]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(wrapped, nonce, key, m.current.id), nil
+}
+
+func (m *MasterKeys) UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	if len(wrapped) < keyIDSize {
+		return nil, errors.New("wrapped key too short")
+	}
+	id := wrapped[:keyIDSize]
+	for _, k := range append([]masterKey{m.current}, m.previous...) {
+		if !bytes.Equal(k.id, id) {
+			continue
+		}
+		aead, err := newAEAD(k.key)
+		if err != nil {
+			return nil, err
+		}
+		rest := wrapped[keyIDSize:]
+		if len(rest) < aead.NonceSize() {
+			return nil, errors.New("wrapped key too short")
+		}
+		return aead.Open(nil, rest[:aead.NonceSize()], rest[aead.NonceSize():], id)
+	}
+	return nil, fmt.Errorf("unknown master key %x", id)
+}
@@ -0,0 +1,364 @@
+// Package blobcrypt encrypts the content of the uploaded files at rest. Each
+// blob is encrypted with AES-256-GCM under its own random data key, which is
+// stored with the blob wrapped by a master key kept in the configuration or
+// in a key management service.
+package blobcrypt
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"soap-server/handler"
+)
+
+// SegmentSize is the size of the plaintext segments blobs are encrypted in,
+// each sealed separately so that ranges can be read without decrypting what
+// precedes them
+const SegmentSize = 64 << 10
+
+// magic starts the encrypted blobs, telling them apart from blobs stored
+// before encryption was enabled
+const magic = "\x93ENCv1\r\n"
+
+// dataKeySize is the size of the AES-256 data keys
+const dataKeySize = 32
+
+// Store is a handler.BlobStore encrypting the blobs of another store. Blobs
+// without the header of encrypted blobs, stored before encryption was
+// enabled, are read as they are; they are encrypted when uploaded again.
+// The sizes returned by List are those of the stored, encrypted blobs.
+type Store struct {
+	blobs handler.BlobStore
+	keys  KeyWrapper
+}
+
+// New returns a store encrypting the blobs of blobs with data keys wrapped
+// by keys
+func New(blobs handler.BlobStore, keys KeyWrapper) *Store {
+	return &Store{blobs: blobs, keys: keys}
+}
+
+// header is the start of an encrypted blob:
+//
+//	magic | segment size (uint32) | wrapped key length (uint16) | wrapped key
+//
+// It is authenticated as the additional data of every segment.
+type header struct {
+	raw         []byte
+	segmentSize int
+	wrappedKey  []byte
+}
+
+func newHeader(segmentSize int, wrappedKey []byte) (header, error) {
+	if len(wrappedKey) > 0xffff {
+		return header{}, errors.New("wrapped data key too long")
+	}
+	raw := make([]byte, 0, len(magic)+6+len(wrappedKey))
+	raw = append(raw, magic...)
+	raw = binary.BigEndian.AppendUint32(raw, uint32(segmentSize))
+	raw = binary.BigEndian.AppendUint16(raw, uint16(len(wrappedKey)))
+	raw = append(raw, wrappedKey...)
+	return header{raw: raw, segmentSize: segmentSize, wrappedKey: wrappedKey}, nil
+}
+
+// readHeader reads the header of an encrypted blob following the magic
+func readHeader(r io.Reader) (header, error) {
+	fixed := make([]byte, len(magic)+6)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return header{}, fmt.Errorf("truncated encryption header: %w", err)
+	}
+	segmentSize := int(binary.BigEndian.Uint32(fixed[len(magic):]))
+	if segmentSize <= 0 || segmentSize > 16<<20 {
+		return header{}, fmt.Errorf("invalid segment size %d", segmentSize)
+	}
+	wrappedKey := make([]byte, binary.BigEndian.Uint16(fixed[len(magic)+4:]))
+	if _, err := io.ReadFull(r, wrappedKey); err != nil {
+		return header{}, fmt.Errorf("truncated encryption header: %w", err)
+	}
+	return newHeader(segmentSize, wrappedKey)
+}
+
+// plaintextSize returns the size of the content of an encrypted blob of the
+// given size, or -1 if it is not known
+func (h header) plaintextSize(size int64) int64 {
+	if size < 0 {
+		return -1
+	}
+	sealed := size - int64(len(h.raw))
+	segments := (sealed + int64(h.segmentSize+aesOverhead) - 1) / int64(h.segmentSize+aesOverhead)
+	return max(sealed-segments*aesOverhead, 0)
+}
+
+// aesOverhead is the size of the GCM tag of each segment
+const aesOverhead = 16
+
+// nonce returns the nonce of a segment: its index and whether it is the
+// last one. The data keys are used for a single blob, so the nonces need
+// not be random.
+func nonce(index uint64, final bool) []byte {
+	n := make([]byte, 12)
+	binary.BigEndian.PutUint64(n[3:11], index)
+	if final {
+		n[11] = 1
+	}
+	return n
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Put encrypts the content under a new data key and stores it. The size
+// returned is that of the content.
+func (s *Store) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return 0, err
+	}
+	wrapped, err := s.keys.WrapKey(ctx, dataKey)
+	if err != nil {
+		return 0, fmt.Errorf("failed to wrap data key: %w", err)
+	}
+	h, err := newHeader(SegmentSize, wrapped)
+	if err != nil {
+		return 0, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return 0, err
+	}
+	enc := &encrypter{aead: aead, header: h, src: bufio.NewReader(r), plain: make([]byte, SegmentSize)}
+	// The header is the additional data of the segments and must not be
+	// overwritten by them
+	enc.out = append(make([]byte, 0, SegmentSize+aesOverhead), h.raw...)
+	if _, err := s.blobs.Put(ctx, key, enc); err != nil {
+		return 0, err
+	}
+	return enc.size, nil
+}
+
+// encrypter reads the header and the sealed segments of its source
+type encrypter struct {
+	aead   cipher.AEAD
+	header header
+	src    *bufio.Reader
+	plain  []byte
+	index  uint64
+	out    []byte // Pending output
+	done   bool   // The last segment was sealed
+	size   int64  // Plaintext read
+}
+
+func (e *encrypter) Read(p []byte) (int, error) {
+	for len(e.out) == 0 {
+		if e.done {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(e.src, e.plain)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+		final := err != nil
+		if !final {
+			// A full segment is the last one if nothing follows it
+			if _, err := e.src.Peek(1); err == io.EOF {
+				final = true
+			} else if err != nil {
+				return 0, err
+			}
+		}
+		e.out = e.aead.Seal(e.out[:0], nonce(e.index, final), e.plain[:n], e.header.raw)
+		e.index++
+		e.size += int64(n)
+		e.done = final
+	}
+	n := copy(p, e.out)
+	e.out = e.out[n:]
+	return n, nil
+}
+
+// Open returns the decrypted content of the blob and its size
+func (s *Store) Open(ctx context.Context, key string) (io.ReadCloser, int64, error) {
+	b, err := s.open(ctx, key)
+	if err != nil {
+		return nil, 0, err
+	}
+	if b.aead == nil {
+		return b, b.size, nil
+	}
+	return &decrypter{blob: b}, b.header.plaintextSize(b.size), nil
+}
+
+// OpenRange returns the decrypted content of the blob from offset. Only the
+// segment holding offset and those following it are read if the stored
+// blobs can be read from an offset.
+func (s *Store) OpenRange(ctx context.Context, key string, offset int64) (io.ReadCloser, error) {
+	b, err := s.open(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if b.aead == nil {
+		if err := b.skip(ctx, s.blobs, key, offset, offset); err != nil {
+			b.Close()
+			return nil, err
+		}
+		return b, nil
+	}
+
+	if size := b.header.plaintextSize(b.size); size >= 0 && offset >= size {
+		b.Close()
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+	segment := offset / int64(b.header.segmentSize)
+	start := int64(len(b.header.raw)) + segment*int64(b.header.segmentSize+aesOverhead)
+	if err := b.skip(ctx, s.blobs, key, start, start-int64(len(b.header.raw))); err != nil {
+		b.Close()
+		return nil, err
+	}
+	d := &decrypter{blob: b, index: uint64(segment)}
+	if _, err := io.CopyN(io.Discard, d, offset-segment*int64(b.header.segmentSize)); err != nil && err != io.EOF {
+		d.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+func (s *Store) Delete(ctx context.Context, key string) error {
+	return s.blobs.Delete(ctx, key)
+}
+
+func (s *Store) List(ctx context.Context) ([]handler.BlobInfo, error) {
+	return s.blobs.List(ctx)
+}
+
+// blob is an opened blob of the underlying store, positioned after the
+// header of encrypted blobs
+type blob struct {
+	r      *bufio.Reader
+	stored io.ReadCloser
+	size   int64 // Stored size
+	header header
+	aead   cipher.AEAD // Nil for blobs stored unencrypted
+}
+
+// open opens a blob and reads its header, unwrapping its data key
+func (s *Store) open(ctx context.Context, key string) (*blob, error) {
+	r, size, err := s.blobs.Open(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	b := &blob{r: bufio.NewReader(r), stored: r, size: size}
+	if head, _ := b.r.Peek(len(magic)); string(head) != magic {
+		return b, nil
+	}
+	if b.header, err = readHeader(b.r); err != nil {
+		r.Close()
+		return nil, err
+	}
+	dataKey, err := s.keys.UnwrapKey(ctx, b.header.wrappedKey)
+	if err != nil {
+		r.Close()
+		return nil, fmt.Errorf("failed to unwrap data key of %s: %w", key, err)
+	}
+	if b.aead, err = newAEAD(dataKey); err != nil {
+		r.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+// skip advances the blob by n bytes to offset to of the stored blob: by
+// seeking or reopening the stored blob at the offset if the store allows
+// it, by reading otherwise
+func (b *blob) skip(ctx context.Context, blobs handler.BlobStore, key string, to, n int64) error {
+	if n <= 0 {
+		return nil
+	}
+	if seeker, ok := b.stored.(io.Seeker); ok {
+		if _, err := seeker.Seek(to, io.SeekStart); err != nil {
+			return err
+		}
+		b.r.Reset(b.stored)
+		return nil
+	}
+	if opener, ok := blobs.(handler.RangeOpener); ok {
+		r, err := opener.OpenRange(ctx, key, to)
+		if err != nil {
+			return err
+		}
+		b.stored.Close()
+		b.r, b.stored = bufio.NewReader(r), r
+		return nil
+	}
+	if _, err := io.CopyN(io.Discard, b.r, n); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+func (b *blob) Read(p []byte) (int, error) {
+	return b.r.Read(p)
+}
+
+func (b *blob) Close() error {
+	return b.stored.Close()
+}
+
+// decrypter reads the opened segments of an encrypted blob
+type decrypter struct {
+	*blob
+	sealed []byte
+	plain  []byte
+	index  uint64
+	out    []byte // Pending output
+	done   bool   // The last segment was opened
+}
+
+func (d *decrypter) Read(p []byte) (int, error) {
+	for len(d.out) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if d.sealed == nil {
+			d.sealed = make([]byte, d.header.segmentSize+aesOverhead)
+			d.plain = make([]byte, 0, d.header.segmentSize)
+		}
+		n, err := io.ReadFull(d.r, d.sealed)
+		if err == io.EOF {
+			// The last segment, if empty, is still sealed
+			return 0, errors.New("encrypted blob truncated")
+		}
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+		final := err != nil
+		if !final {
+			if _, err := d.r.Peek(1); err == io.EOF {
+				final = true
+			} else if err != nil {
+				return 0, err
+			}
+		}
+		out, err := d.aead.Open(d.plain[:0], nonce(d.index, final), d.sealed[:n], d.header.raw)
+		if err != nil {
+			return 0, fmt.Errorf("encrypted blob segment %d: %w", d.index, err)
+		}
+		d.out = out
+		d.index++
+		d.done = final
+	}
+	n := copy(p, d.out)
+	d.out = d.out[n:]
+	return n, nil
+}
@@ -0,0 +1,132 @@
+package blobcrypt
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// VaultTransit is a KeyWrapper having the data keys encrypted by a named
+// key of the transit secrets engine of HashiCorp Vault or OpenBao, so the
+// master key never leaves the key management service. Rotating the key in
+// Vault keeps the data keys wrapped by its previous versions usable.
+type VaultTransit struct {
+	Address string // Vault URL, e.g. https://vault:8200
+	Token   string
+	Mount   string // Mount path of the engine, default "transit"
+	Key     string // Name of the transit key
+	Client  *http.Client
+}
+
+// vaultTimeout bounds a Vault request unless the client has a timeout
+const vaultTimeout = 10 * time.Second
+
+// NewVaultTransit returns the transit key given as a URL such as
+// https://vault:8200/transit/uploads, mount path and key name following the
+// address, authenticated with the token
+func NewVaultTransit(rawURL, token string) (*VaultTransit, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid vault URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("invalid vault URL %q", rawURL)
+	}
+	path := strings.Trim(u.Path, "/")
+	i := strings.LastIndex(path, "/")
+	if i < 0 {
+		return nil, fmt.Errorf("invalid vault URL %q: want <address>/<mount>/<key>", rawURL)
+	}
+	if token == "" {
+		return nil, errors.New("vault token is required")
+	}
+	return &VaultTransit{
+		Address: u.Scheme + "://" + u.Host,
+		Token:   token,
+		Mount:   path[:i],
+		Key:     path[i+1:],
+		Client:  &http.Client{Timeout: vaultTimeout},
+	}, nil
+}
+
+// String describes the key without the token
+func (v *VaultTransit) String() string {
+	return "vault " + v.Address + "/" + v.mount() + "/" + v.Key
+}
+
+func (v *VaultTransit) mount() string {
+	if v.Mount == "" {
+		return "transit"
+	}
+	return v.Mount
+}
+
+// WrapKey returns the Vault ciphertext of the data key, "vault:v<N>:..."
+func (v *VaultTransit) WrapKey(ctx context.Context, key []byte) ([]byte, error) {
+	var resp struct {
+		Data struct {
+			Ciphertext string `json:"ciphertext"`
+		} `json:"data"`
+	}
+	if err := v.do(ctx, "encrypt", map[string]string{"plaintext": base64.StdEncoding.EncodeToString(key)}, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Data.Ciphertext == "" {
+		return nil, errors.New("vault returned no ciphertext")
+	}
+	return []byte(resp.Data.Ciphertext), nil
+}
+
+func (v *VaultTransit) UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	var resp struct {
+		Data struct {
+			Plaintext string `json:"plaintext"`
+		} `json:"data"`
+	}
+	if err := v.do(ctx, "decrypt", map[string]string{"ciphertext": string(wrapped)}, &resp); err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(resp.Data.Plaintext)
+}
+
+// do posts a request to an endpoint of the transit key and decodes the
+// response into v
+func (v *VaultTransit) do(ctx context.Context, endpoint string, body map[string]string, out interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	u := v.Address + "/v1/" + v.mount() + "/" + endpoint + "/" + url.PathEscape(v.Key)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Vault-Token", v.Token)
+
+	client := v.Client
+	if client == nil {
+		client = &http.Client{Timeout: vaultTimeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var failure struct {
+			Errors []string `json:"errors"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&failure)
+		return fmt.Errorf("vault %s: HTTP %d %s", endpoint, resp.StatusCode, strings.Join(failure.Errors, "; "))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,98 @@
+// Package buildinfo identifies the build of the running server: the
+// version, commit and build date set at build time with
+//
+//	go build -ldflags "-X soap-server/buildinfo.Version=1.4.0 \
+//	    -X soap-server/buildinfo.Commit=$(git rev-parse HEAD) \
+//	    -X soap-server/buildinfo.Date=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// or else the version control information Go records in binaries built in
+// a checkout.
+package buildinfo
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"sync"
+)
+
+// Set with -ldflags -X at build time
+var (
+	Version string // Release version, e.g. "1.4.0"
+	Commit  string // VCS revision
+	Date    string // Build or commit time, RFC 3339
+)
+
+// devVersion is the version of builds that were given none
+const devVersion = "dev"
+
+// Info describes a build
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	Date      string `json:"date,omitempty"`
+	Modified  bool   `json:"modified,omitempty"` // Built with uncommitted changes
+	GoVersion string `json:"goVersion"`
+}
+
+var (
+	once sync.Once
+	info Info
+)
+
+// Get returns the build of the running binary. Values set with -ldflags
+// take precedence over those recorded by Go; the recorded commit and its
+// time are only used if no commit was set.
+func Get() Info {
+	once.Do(func() {
+		info = Info{Version: Version, Commit: Commit, Date: Date, GoVersion: runtime.Version()}
+		if build, ok := debug.ReadBuildInfo(); ok {
+			if info.Version == "" && build.Main.Version != "(devel)" {
+				info.Version = build.Main.Version
+			}
+			for _, setting := range build.Settings {
+				if Commit != "" {
+					break
+				}
+				switch setting.Key {
+				case "vcs.revision":
+					info.Commit = setting.Value
+				case "vcs.time":
+					if info.Date == "" {
+						info.Date = setting.Value
+					}
+				case "vcs.modified":
+					info.Modified = setting.Value == "true"
+				}
+			}
+		}
+		if info.Version == "" {
+			info.Version = devVersion
+		}
+	})
+	return info
+}
+
+// ShortCommit returns the commit abbreviated to 12 characters
+func (i Info) ShortCommit() string {
+	if len(i.Commit) > 12 {
+		return i.Commit[:12]
+	}
+	return i.Commit
+}
+
+// String describes the build, e.g. "1.4.0 (commit 3f2c9a1b7d4e, 2024-05-01T09:00:00Z, go1.22.3)"
+func (i Info) String() string {
+	s := i.Version + " ("
+	if i.Commit != "" {
+		s += "commit " + i.ShortCommit()
+		if i.Modified {
+			s += "+modified"
+		}
+		s += ", "
+	}
+	if i.Date != "" {
+		s += i.Date + ", "
+	}
+	return fmt.Sprintf("%s%s)", s, i.GoVersion)
+}
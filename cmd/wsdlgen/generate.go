@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"soap-server/xsd"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+// goType is a generated struct type
+type goType struct {
+	Name    string
+	Element string // Element name for the XMLName field; empty for named complex types
+	Embed   string // Named complex type embedded in an element struct
+	Value   string // Go type of the character data of a simple element
+	Fields  []goField
+}
+
+type goField struct {
+	Name string
+	Type string
+	Tag  string
+}
+
+// generator collects the struct types for the schema elements
+type generator struct {
+	types []*goType
+	names map[string]bool
+	named map[*xsd.ComplexType]string
+}
+
+// generate renders the Go source for the definitions
+func generate(def *definitions, pkg string, stubs bool) ([]byte, error) {
+	ops, err := def.operations()
+	if err != nil {
+		return nil, err
+	}
+
+	g := &generator{names: make(map[string]bool), named: make(map[*xsd.ComplexType]string)}
+	for _, el := range def.schema.Elements() {
+		g.element(el)
+	}
+
+	var buf bytes.Buffer
+	err = sourceTemplate.Execute(&buf, struct {
+		Package    string
+		Namespace  string
+		Service    string
+		Types      []*goType
+		Operations []operation
+		Stubs      bool
+	}{pkg, def.TargetNamespace, goName(def.serviceName()), g.types, ops, stubs})
+	if err != nil {
+		return nil, err
+	}
+
+	code, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("generated code does not compile: %w", err)
+	}
+	return code, nil
+}
+
+// element adds the struct type of a global element
+func (g *generator) element(el *xsd.Element) {
+	t := &goType{Name: g.unique(goName(el.Name.Local)), Element: el.Name.Local}
+	g.types = append(g.types, t)
+
+	ct := el.ComplexType()
+	switch {
+	case ct == nil:
+		t.Value = simpleGoType(el.SimpleType())
+	case ct.Name.Local != "":
+		t.Embed = g.complexType(ct, "")
+	default:
+		t.Fields = g.fields(ct, t.Name)
+	}
+}
+
+// complexType returns the Go type name of a complex type, adding its struct
+// on first use. Anonymous types are named after their enclosing element.
+func (g *generator) complexType(ct *xsd.ComplexType, context string) string {
+	if name, ok := g.named[ct]; ok {
+		return name
+	}
+
+	name := context
+	if ct.Name.Local != "" {
+		name = goName(ct.Name.Local)
+	}
+	name = g.unique(name)
+	g.named[ct] = name
+
+	t := &goType{Name: name}
+	g.types = append(g.types, t)
+	t.Fields = g.fields(ct, name)
+	return name
+}
+
+func (g *generator) fields(ct *xsd.ComplexType, context string) []goField {
+	var fields []goField
+	for _, p := range ct.Particles {
+		name := goName(p.Name.Local)
+
+		var typ string
+		if nested := p.ComplexType(); nested != nil {
+			typ = g.complexType(nested, context+name)
+		} else {
+			typ = simpleGoType(p.SimpleType())
+		}
+
+		tag := p.Name.Local
+		switch {
+		case p.MaxOccurs == xsd.Unbounded || p.MaxOccurs > 1:
+			typ = "[]" + typ
+		case p.MinOccurs == 0 && p.ComplexType() != nil:
+			typ = "*" + typ
+			tag += ",omitempty"
+		case p.MinOccurs == 0:
+			tag += ",omitempty"
+		}
+
+		fields = append(fields, goField{Name: name, Type: typ, Tag: tag})
+	}
+	return fields
+}
+
+// unique returns name, suffixed with a number if it is already taken
+func (g *generator) unique(name string) string {
+	candidate := name
+	for i := 2; g.names[candidate]; i++ {
+		candidate = fmt.Sprintf("%s%d", name, i)
+	}
+	g.names[candidate] = true
+	return candidate
+}
+
+// simpleGoType maps a built-in XML Schema type to a Go type. base64Binary
+// stays a string because encoding/xml does not encode []byte as base64.
+func simpleGoType(st *xsd.SimpleType) string {
+	if st == nil {
+		return "string"
+	}
+	switch st.Base {
+	case "boolean":
+		return "bool"
+	case "int":
+		return "int32"
+	case "long", "integer":
+		return "int64"
+	case "short":
+		return "int16"
+	case "byte":
+		return "int8"
+	case "unsignedInt":
+		return "uint32"
+	case "unsignedLong", "nonNegativeInteger", "positiveInteger":
+		return "uint64"
+	case "double", "decimal":
+		return "float64"
+	case "float":
+		return "float32"
+	}
+	return "string"
+}
+
+// initialisms are written in upper case in Go names
+var initialisms = map[string]bool{
+	"id": true, "url": true, "uri": true, "xml": true, "http": true, "https": true,
+	"api": true, "uuid": true, "json": true, "soap": true, "mtom": true,
+}
+
+// goName converts an XML name such as "userId" or "file-name" to an
+// exported Go identifier ("UserID", "FileName")
+func goName(name string) string {
+	var words []string
+	var word []rune
+	flush := func() {
+		if len(word) > 0 {
+			words = append(words, string(word))
+			word = word[:0]
+		}
+	}
+	for i, r := range name {
+		switch {
+		case !unicode.IsLetter(r) && !unicode.IsDigit(r):
+			flush()
+		case unicode.IsUpper(r) && i > 0 && len(word) > 0 && !unicode.IsUpper(word[len(word)-1]):
+			flush()
+			word = append(word, r)
+		default:
+			word = append(word, r)
+		}
+	}
+	flush()
+
+	var b strings.Builder
+	for _, w := range words {
+		if initialisms[strings.ToLower(w)] {
+			b.WriteString(strings.ToUpper(w))
+			continue
+		}
+		runes := []rune(w)
+		runes[0] = unicode.ToUpper(runes[0])
+		b.WriteString(string(runes))
+	}
+	if b.Len() == 0 || unicode.IsDigit([]rune(b.String())[0]) {
+		return "X" + b.String()
+	}
+	return b.String()
+}
+
+var sourceTemplate = template.Must(template.New("source").Parse(`// Code generated by wsdlgen. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+{{- if .Operations}}
+	"context"
+{{- end}}
+	"encoding/xml"
+{{- if .Operations}}
+	"soap-server/soap"
+{{- end}}
+{{- if and .Operations .Stubs}}
+	"soap-server/soapfault"
+{{- end}}
+)
+
+{{- if .Namespace}}
+
+// Namespace is the target namespace of the service elements
+const Namespace = "{{.Namespace}}"
+{{- end}}
+{{range .Types}}
+{{- if .Element}}
+// {{.Name}} is the {{.Element}} element
+type {{.Name}} struct {
+	XMLName xml.Name ` + "`" + `xml:"{{.Element}}"` + "`" + `
+{{- if .Embed}}
+	{{.Embed}}
+{{- end}}
+{{- if .Value}}
+	Value {{.Value}} ` + "`" + `xml:",chardata"` + "`" + `
+{{- end}}
+{{- else}}
+// {{.Name}} is a complex type of the schema
+type {{.Name}} struct {
+{{- end}}
+{{- range .Fields}}
+	{{.Name}} {{.Type}} ` + "`" + `xml:"{{.Tag}}"` + "`" + `
+{{- end}}
+}
+{{end}}
+{{- if .Operations}}
+// {{.Service}} is implemented by the operations of the {{.Service}} service
+type {{.Service}} interface {
+{{- range .Operations}}
+	{{.Name}}(ctx context.Context, req {{.RequestElement}}) ({{.ResponseElement}}, error)
+{{- end}}
+}
+
+// Register{{.Service}} registers the operations of svc
+func Register{{.Service}}(reg *soap.OperationRegistry, svc {{.Service}}) error {
+{{- range .Operations}}
+	if err := reg.RegisterFunc(soap.Operation{
+		Name:            "{{.Name}}",
+		Namespace:       Namespace,
+		SOAPAction:      "{{.SOAPAction}}",
+		RequestElement:  "{{.RequestElement}}",
+		ResponseElement: "{{.ResponseElement}}",
+{{- if .Faults}}
+		Faults:          []string{ {{- range $i, $f := .Faults}}{{if $i}}, {{end}}"{{$f}}"{{end -}} },
+{{- end}}
+	}, svc.{{.Name}}); err != nil {
+		return err
+	}
+{{- end}}
+	return nil
+}
+{{- if .Stubs}}
+{{$service := .Service}}
+// {{$service}}Stub implements {{$service}} with operations that are not
+// implemented yet
+type {{$service}}Stub struct{}
+{{range .Operations}}
+// {{.Name}} handles the {{.Name}} operation
+func ({{$service}}Stub) {{.Name}}(ctx context.Context, req {{.RequestElement}}) ({{.ResponseElement}}, error) {
+	return {{.ResponseElement}}{}, soapfault.Server("Not implemented", "{{.Name}} is not implemented")
+}
+{{end}}
+{{- end}}
+{{- end}}
+`))
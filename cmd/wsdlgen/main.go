@@ -0,0 +1,70 @@
+// Command wsdlgen generates Go request/response structs and handler stubs
+// from a WSDL document or XSD schema.
+//
+// Usage:
+//
+//	wsdlgen -in service.wsdl -package legacy -out legacy/service_gen.go
+//
+// For a WSDL the output contains a struct per schema element, an interface
+// with one method per operation and a Register function that adds the
+// operations to a soap.OperationRegistry. With -stubs a stub implementation
+// returning "not implemented" faults is generated as well. For a plain XSD
+// only the structs are generated.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+func main() {
+	in := flag.String("in", "", "WSDL or XSD document to read")
+	out := flag.String("out", "", "Go file to write (default: standard output)")
+	pkg := flag.String("package", "", "package name of the generated code (default: directory name of -out)")
+	stubs := flag.Bool("stubs", false, "also generate a stub implementation of the service interface")
+	flag.Parse()
+
+	if *in == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if *pkg == "" {
+		*pkg = "service"
+		if *out != "" {
+			if abs, err := filepath.Abs(*out); err == nil {
+				*pkg = filepath.Base(filepath.Dir(abs))
+			}
+		}
+	}
+
+	if err := run(*in, *out, *pkg, *stubs); err != nil {
+		fmt.Fprintln(os.Stderr, "wsdlgen:", err)
+		os.Exit(1)
+	}
+}
+
+func run(in, out, pkg string, stubs bool) error {
+	data, err := os.ReadFile(in)
+	if err != nil {
+		return err
+	}
+
+	def, err := parseDefinitions(data)
+	if err != nil {
+		return err
+	}
+
+	code, err := generate(def, pkg, stubs)
+	if err != nil {
+		return err
+	}
+
+	if out == "" {
+		_, err = os.Stdout.Write(code)
+		return err
+	}
+	return os.WriteFile(out, code, 0644)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"soap-server/xsd"
+	"strings"
+)
+
+// definitions is the part of a WSDL 1.1 document the generator uses
+type definitions struct {
+	Name            string        `xml:"name,attr"`
+	TargetNamespace string        `xml:"targetNamespace,attr"`
+	Messages        []wsdlMessage `xml:"message"`
+	PortTypes       []wsdlPort    `xml:"portType"`
+	Bindings        []wsdlBinding `xml:"binding"`
+	Services        []struct {
+		Name string `xml:"name,attr"`
+	} `xml:"service"`
+
+	schema *xsd.Schema
+}
+
+type wsdlMessage struct {
+	Name  string `xml:"name,attr"`
+	Parts []struct {
+		Name    string `xml:"name,attr"`
+		Element string `xml:"element,attr"`
+	} `xml:"part"`
+}
+
+type wsdlPort struct {
+	Name       string `xml:"name,attr"`
+	Operations []struct {
+		Name   string `xml:"name,attr"`
+		Input  wsdlIO `xml:"input"`
+		Output wsdlIO `xml:"output"`
+		Faults []struct {
+			Name    string `xml:"name,attr"`
+			Message string `xml:"message,attr"`
+		} `xml:"fault"`
+	} `xml:"operation"`
+}
+
+type wsdlIO struct {
+	Message string `xml:"message,attr"`
+}
+
+type wsdlBinding struct {
+	Operations []struct {
+		Name      string `xml:"name,attr"`
+		Operation struct {
+			SOAPAction string `xml:"soapAction,attr"`
+		} `xml:"operation"`
+	} `xml:"operation"`
+}
+
+// operation is an operation resolved to its request, response and fault
+// elements
+type operation struct {
+	Name            string
+	SOAPAction      string
+	RequestElement  string
+	ResponseElement string
+	Faults          []string
+}
+
+// parseDefinitions reads a WSDL document, or an XSD schema when the root
+// element is xsd:schema
+func parseDefinitions(data []byte) (*definitions, error) {
+	schema, err := xsd.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var def definitions
+	if err := xml.Unmarshal(data, &def); err != nil {
+		return nil, fmt.Errorf("failed to parse WSDL: %w", err)
+	}
+	def.schema = schema
+
+	return &def, nil
+}
+
+// serviceName returns the name of the first service, used to name the
+// generated interface
+func (def *definitions) serviceName() string {
+	if len(def.Services) > 0 && def.Services[0].Name != "" {
+		return def.Services[0].Name
+	}
+	if def.Name != "" {
+		return def.Name
+	}
+	return "Service"
+}
+
+// operations resolves the operations of the first port type
+func (def *definitions) operations() ([]operation, error) {
+	if len(def.PortTypes) == 0 {
+		return nil, nil
+	}
+
+	actions := make(map[string]string)
+	for _, b := range def.Bindings {
+		for _, op := range b.Operations {
+			actions[op.Name] = op.Operation.SOAPAction
+		}
+	}
+
+	var ops []operation
+	for _, pt := range def.PortTypes[0].Operations {
+		request, err := def.messageElement(pt.Input.Message)
+		if err != nil {
+			return nil, fmt.Errorf("operation %s input: %w", pt.Name, err)
+		}
+		response, err := def.messageElement(pt.Output.Message)
+		if err != nil {
+			return nil, fmt.Errorf("operation %s output: %w", pt.Name, err)
+		}
+
+		op := operation{
+			Name:            pt.Name,
+			SOAPAction:      actions[pt.Name],
+			RequestElement:  request,
+			ResponseElement: response,
+		}
+		for _, f := range pt.Faults {
+			element, err := def.messageElement(f.Message)
+			if err != nil {
+				return nil, fmt.Errorf("operation %s fault %s: %w", pt.Name, f.Name, err)
+			}
+			op.Faults = append(op.Faults, element)
+		}
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+// messageElement returns the local name of the element of a document/literal
+// message, which has a single part referencing a schema element
+func (def *definitions) messageElement(qname string) (string, error) {
+	name := localName(qname)
+	for _, m := range def.Messages {
+		if m.Name != name {
+			continue
+		}
+		if len(m.Parts) != 1 || m.Parts[0].Element == "" {
+			return "", fmt.Errorf("message %s is not a document/literal message with a single element part", name)
+		}
+		return localName(m.Parts[0].Element), nil
+	}
+	return "", fmt.Errorf("message %s not found", name)
+}
+
+func localName(qname string) string {
+	if i := strings.LastIndexByte(qname, ':'); i >= 0 {
+		return qname[i+1:]
+	}
+	return qname
+}
@@ -0,0 +1,213 @@
+// Package config loads the settings of the server from a YAML (or JSON)
+// configuration file. The settings are those of the environment variables;
+// a file such as
+//
+//	port: 8443
+//	upload_dir: /var/lib/soap/uploads
+//	tls:
+//	  cert: /etc/ssl/server.pem
+//	  key: /etc/ssl/server.key
+//	api_keys: [pk-7f3a9c=partner, bk-19d2e4=backoffice]
+//
+// sets SOAP_PORT, SOAP_UPLOAD_DIR, SOAP_TLS_CERT, SOAP_TLS_KEY and
+// SOAP_API_KEYS: nested keys are joined by "_", and lists by commas. Each
+// setting is also a command line flag, such as -port and -upload-dir. Flags
+// take precedence over the environment variables, which take precedence
+// over the file.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// prefix is the start of the names of the settings
+const prefix = "SOAP_"
+
+// aliases are the environment variables conventionally used for settings
+// by container platforms, read if the setting is not
+var aliases = map[string]string{
+	"SOAP_PORT":            "PORT",
+	"SOAP_UPLOAD_DIR":      "UPLOAD_DIR",
+	"SOAP_MAX_UPLOAD_SIZE": "MAX_UPLOAD_SIZE",
+	"SOAP_LOG_LEVEL":       "LOG_LEVEL",
+}
+
+// Config holds the settings of the command line and the configuration file
+type Config struct {
+	path   string
+	flags  map[string]string
+	values map[string]string
+}
+
+// Parse parses the command line flags of the settings and loads the
+// configuration file named by the -config flag or SOAP_CONFIG_FILE. Like
+// the flag package, it exits after printing the usage for -h and invalid
+// flags.
+func Parse(name string, args []string) (*Config, error) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	path := fs.String("config", os.Getenv("SOAP_CONFIG_FILE"), "YAML configuration `file` (SOAP_CONFIG_FILE)")
+	names := map[string]string{}
+	for _, setting := range Settings {
+		flagName := FlagName(setting)
+		names[flagName] = setting
+		fs.String(flagName, "", setting)
+	}
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+
+	c, err := Load(*path)
+	if err != nil {
+		return nil, err
+	}
+	fs.Visit(func(f *flag.Flag) {
+		if setting, ok := names[f.Name]; ok {
+			c.flags[setting] = f.Value.String()
+		}
+	})
+	return c, nil
+}
+
+// FlagName returns the command line flag of a setting, e.g. upload-dir for
+// SOAP_UPLOAD_DIR
+func FlagName(setting string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimPrefix(setting, prefix)), "_", "-")
+}
+
+// Load reads a configuration file. Keys that are not Settings are
+// rejected. An empty path loads no file, leaving the environment alone.
+func Load(path string) (*Config, error) {
+	c := &Config{path: path, flags: map[string]string{}, values: map[string]string{}}
+	if path == "" {
+		return c, nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(doc.Content) == 0 {
+		return c, nil
+	}
+	if err := c.add("", doc.Content[0]); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	known := make(map[string]bool, len(Settings))
+	for _, name := range Settings {
+		known[name] = true
+	}
+	var unknown []string
+	for name := range c.values {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("%s: unknown settings %s", path, strings.Join(unknown, ", "))
+	}
+	return c, nil
+}
+
+// add records the settings of a node under the name of its key
+func (c *Config) add(name string, node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i]
+			if key.Kind != yaml.ScalarNode || key.Value == "" {
+				return fmt.Errorf("line %d: invalid key", key.Line)
+			}
+			child := settingName(key.Value)
+			if name != "" {
+				child = name + "_" + child
+			} else if !strings.HasPrefix(child, prefix) {
+				child = prefix + child
+			}
+			if err := c.add(child, node.Content[i+1]); err != nil {
+				return err
+			}
+		}
+		return nil
+	case yaml.SequenceNode:
+		var values []string
+		for _, item := range node.Content {
+			if item.Kind != yaml.ScalarNode {
+				return fmt.Errorf("line %d: %s must be a list of values", item.Line, name)
+			}
+			values = append(values, item.Value)
+		}
+		return c.set(name, strings.Join(values, ","), node.Line)
+	case yaml.ScalarNode:
+		if node.Tag == "!!null" {
+			return c.set(name, "", node.Line)
+		}
+		return c.set(name, node.Value, node.Line)
+	case yaml.AliasNode:
+		return c.add(name, node.Alias)
+	}
+	return fmt.Errorf("line %d: invalid value of %s", node.Line, name)
+}
+
+func (c *Config) set(name, value string, line int) error {
+	if name == "" {
+		return errors.New("the configuration must be a mapping of settings")
+	}
+	if _, ok := c.values[name]; ok {
+		return fmt.Errorf("line %d: %s is set twice", line, name)
+	}
+	c.values[name] = value
+	return nil
+}
+
+// settingName returns the part of a setting name written as a key, e.g.
+// "upload-dir" for UPLOAD_DIR
+func settingName(key string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(key))
+}
+
+// Get returns the value of a setting: its flag if given, else the
+// environment variable or its alias if set, else the value of the file
+func (c *Config) Get(name string) string {
+	if v, ok := c.flags[name]; ok {
+		return v
+	}
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	if alias, ok := aliases[name]; ok {
+		if v := os.Getenv(alias); v != "" {
+			return v
+		}
+	}
+	return c.values[name]
+}
+
+// First returns the value of the first of the settings that is set, such
+// as a setting and the environment variable of a client library it
+// defaults to
+func (c *Config) First(names ...string) string {
+	for _, name := range names {
+		if v := c.Get(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// Path returns the path of the configuration file, empty if none was loaded
+func (c *Config) Path() string {
+	return c.path
+}
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAliases(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	content := "port: 1000\nupload_dir: /file\nmax_upload_size: 1KB\nlog_level: error\n"
+	if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct{ setting, alias string }{
+		{"SOAP_PORT", "PORT"},
+		{"SOAP_UPLOAD_DIR", "UPLOAD_DIR"},
+		{"SOAP_MAX_UPLOAD_SIZE", "MAX_UPLOAD_SIZE"},
+		{"SOAP_LOG_LEVEL", "LOG_LEVEL"},
+	}
+	for _, tt := range tests {
+		setting, alias := tt.setting, tt.alias
+		t.Run(alias, func(t *testing.T) {
+			t.Setenv(setting, "")
+			t.Setenv(alias, "alias")
+			c, err := Parse("test", []string{"-config", file})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v := c.Get(setting); v != "alias" {
+				t.Errorf("%s = %q, want the value of %s over the file", setting, v, alias)
+			}
+
+			t.Setenv(setting, "env")
+			if v := c.Get(setting); v != "env" {
+				t.Errorf("%s = %q, want its own variable over %s", setting, v, alias)
+			}
+
+			c, err = Parse("test", []string{"-config", file, "-" + FlagName(setting), "flag"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v := c.Get(setting); v != "flag" {
+				t.Errorf("%s = %q, want the flag over the environment", setting, v)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package config
+
+// Settings are the names of the settings of the server, the environment
+// variables configuring it
+var Settings = []string{
+	"SOAP_ACCESS_LOG",
+	"SOAP_ACTION_BASE",
+	"SOAP_ADMIN_ADDR",
+	"SOAP_ADMIN_DUMP_DIR",
+	"SOAP_API_KEYS",
+	"SOAP_API_KEYS_FILE",
+	"SOAP_API_KEY_HEADER",
+	"SOAP_ASYNC_UPLOADS",
+	"SOAP_AUDIT_DB",
+	"SOAP_AUDIT_LOG",
+	"SOAP_AUTHZ",
+	"SOAP_AUTHZ_POLICY",
+	"SOAP_AUTHZ_POLICY_FILE",
+	"SOAP_BASIC_AUTH_FILE",
+	"SOAP_BASIC_AUTH_REALM",
+	"SOAP_BASIC_AUTH_USERS",
+	"SOAP_BLOB_STORE",
+	"SOAP_CHECKSUM_ALGORITHM",
+	"SOAP_CONCURRENCY_QUEUE_TIMEOUT",
+	"SOAP_DOWNLOAD_LINK_KEY",
+	"SOAP_DOWNLOAD_LINK_MAX_TTL",
+	"SOAP_ENCRYPTION_KEY",
+	"SOAP_ENCRYPTION_PREVIOUS_KEYS",
+	"SOAP_ENCRYPTION_VAULT_TOKEN",
+	"SOAP_ENCRYPTION_VAULT_URL",
+	"SOAP_EXTERNAL_URL",
+	"SOAP_FILE_ALLOW_TYPES",
+	"SOAP_FILE_CATALOG",
+	"SOAP_FILE_CLEANUP_INTERVAL",
+	"SOAP_FILE_DB",
+	"SOAP_FILE_DEDUP",
+	"SOAP_FILE_DENY_TYPES",
+	"SOAP_FILE_LAYOUT",
+	"SOAP_FILE_NAMESPACE",
+	"SOAP_FILE_STRICT",
+	"SOAP_FILE_TTL",
+	"SOAP_HTTP_IDLE_TIMEOUT",
+	"SOAP_HTTP_MAX_HEADER_BYTES",
+	"SOAP_HTTP_READ_HEADER_TIMEOUT",
+	"SOAP_HTTP_READ_TIMEOUT",
+	"SOAP_HTTP_WRITE_TIMEOUT",
+	"SOAP_INTERNAL_ADDR",
+	"SOAP_IP_ALLOW",
+	"SOAP_IP_DENY",
+	"SOAP_JWT_AUDIENCE",
+	"SOAP_JWT_CLOCK_SKEW",
+	"SOAP_JWT_ISSUER",
+	"SOAP_JWT_JWKS_REFRESH",
+	"SOAP_JWT_JWKS_URL",
+	"SOAP_JWT_KEYS_FILE",
+	"SOAP_JWT_NAME_CLAIM",
+	"SOAP_JWT_ROLES_CLAIM",
+	"SOAP_LISTEN",
+	"SOAP_LOG_FORMAT",
+	"SOAP_LOG_LEVEL",
+	"SOAP_MAX_CONCURRENT_REQUESTS",
+	"SOAP_MAX_CONCURRENT_UPLOADS",
+	"SOAP_MAX_PARTS",
+	"SOAP_MAX_PART_HEADER_SIZE",
+	"SOAP_MAX_PART_SIZE",
+	"SOAP_MAX_REQUEST_SIZE",
+	"SOAP_MAX_UPLOAD_SIZE",
+	"SOAP_MTOM_THRESHOLD",
+	"SOAP_NAMESPACE",
+	"SOAP_OTLP_ENDPOINT",
+	"SOAP_OTLP_HEADERS",
+	"SOAP_PORT",
+	"SOAP_QUOTAS",
+	"SOAP_QUOTA_DEFAULT",
+	"SOAP_RPC_ENCODED",
+	"SOAP_S3_ACCESS_KEY_ID",
+	"SOAP_S3_BUCKET",
+	"SOAP_S3_ENDPOINT",
+	"SOAP_S3_PATH_STYLE",
+	"SOAP_S3_PREFIX",
+	"SOAP_S3_REGION",
+	"SOAP_S3_SECRET_ACCESS_KEY",
+	"SOAP_S3_SESSION_TOKEN",
+	"SOAP_SCANNER",
+	"SOAP_SCAN_FAIL_OPEN",
+	"SOAP_SCAN_QUARANTINE_DIR",
+	"SOAP_SCAN_TIMEOUT",
+	"SOAP_SEED",
+	"SOAP_SERVICE_NAME",
+	"SOAP_SHUTDOWN_TIMEOUT",
+	"SOAP_STRICT",
+	"SOAP_STRICT_ACTION",
+	"SOAP_SWA_RESPONSES",
+	"SOAP_TLS_CERT",
+	"SOAP_TLS_KEY",
+	"SOAP_TLS_MIN_VERSION",
+	"SOAP_TLS_RELOAD_INTERVAL",
+	"SOAP_TRACE_SAMPLE_RATIO",
+	"SOAP_TRUSTED_PROXIES",
+	"SOAP_UNIX_SOCKET_MODE",
+	"SOAP_UPLOAD_DIR",
+	"SOAP_UPLOAD_WORKERS",
+	"SOAP_USER_DB",
+	"SOAP_USER_DB_CONN_LIFETIME",
+	"SOAP_USER_DB_MAX_CONNS",
+	"SOAP_USER_NAMESPACE",
+	"SOAP_USER_STORE",
+	"SOAP_USER_STRICT",
+	"SOAP_VALIDATE_REQUESTS",
+	"SOAP_VALIDATE_RESPONSES",
+	"SOAP_WEBHOOK_MAX_ATTEMPTS",
+	"SOAP_WEBHOOK_SECRET",
+	"SOAP_WEBHOOK_URLS",
+	"SOAP_WSDL_DERIVED_TYPES",
+	"SOAP_WSDL_IMPORT_SCHEMAS",
+	"SOAP_WSDL_POLICY",
+	"SOAP_WSS_CLOCK_SKEW",
+	"SOAP_WSS_MAX_AGE",
+	"SOAP_WSS_PRIVATE_KEY",
+	"SOAP_WSS_REPLAY_WINDOW",
+	"SOAP_WSS_REQUIRE_NONCE",
+	"SOAP_WSS_REQUIRE_SIGNATURE",
+	"SOAP_WSS_REQUIRE_TIMESTAMP",
+	"SOAP_WSS_SAML_AUDIENCE",
+	"SOAP_WSS_SAML_ISSUER",
+	"SOAP_WSS_SAML_NAME_ATTRIBUTE",
+	"SOAP_WSS_SAML_ROLE_ATTRIBUTE",
+	"SOAP_WSS_SAML_TRUST_STORE",
+	"SOAP_WSS_TRUST_STORE",
+	"SOAP_XML_MAX_ATTRIBUTES",
+	"SOAP_XML_MAX_DEPTH",
+	"SOAP_XML_MAX_SIZE",
+}
@@ -0,0 +1,342 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"os"
+	"soap-server/soap"
+	"soap-server/soapfault"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Outcomes of audited invocations
+const (
+	AuditSuccess = "success"
+	AuditFault   = "fault"
+)
+
+// AuditRecord is the audit log entry of one operation invocation
+type AuditRecord struct {
+	Time       time.Time `xml:"time" json:"time"`
+	RequestID  string    `xml:"requestId" json:"requestId"`
+	MessageID  string    `xml:"messageId,omitempty" json:"messageId,omitempty"` // WS-Addressing MessageID
+	Caller     string    `xml:"caller,omitempty" json:"caller,omitempty"`
+	AuthMethod string    `xml:"authMethod,omitempty" json:"authMethod,omitempty"`
+	Tenant     string    `xml:"tenant,omitempty" json:"tenant,omitempty"`
+	Operation  string    `xml:"operation" json:"operation"`
+	FileIDs    []string  `xml:"fileId,omitempty" json:"fileIds,omitempty"`
+	Outcome    string    `xml:"outcome" json:"outcome"`
+	FaultCode  string    `xml:"faultCode,omitempty" json:"faultCode,omitempty"` // Code, with the subcode after a dot
+}
+
+// AuditQuery selects audit records. Empty fields do not filter.
+type AuditQuery struct {
+	From      time.Time // Earliest time (inclusive)
+	To        time.Time // Latest time (inclusive)
+	Caller    string
+	Operation string
+}
+
+// matches reports whether the record passes the filters of the query
+func (q AuditQuery) matches(rec AuditRecord) bool {
+	if !q.From.IsZero() && rec.Time.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && rec.Time.After(q.To) {
+		return false
+	}
+	if q.Caller != "" && rec.Caller != q.Caller {
+		return false
+	}
+	return q.Operation == "" || rec.Operation == q.Operation
+}
+
+// AuditLog is an append-only record of the operation invocations, kept
+// apart from the debug log for compliance review
+type AuditLog interface {
+	// Append adds a record to the log
+	Append(ctx context.Context, rec AuditRecord) error
+
+	// Query returns the records matching the query in the order they were
+	// appended
+	Query(ctx context.Context, q AuditQuery) ([]AuditRecord, error)
+}
+
+// Audit returns middleware recording every SOAP operation invocation in the
+// log. It must run before the authentication middleware so that rejected
+// callers are recorded too; the caller is taken from the principal the
+// authentication established. Records that cannot be appended are reported
+// in the debug log without failing the request.
+func Audit(log AuditLog) soap.Middleware {
+	return func(next soap.SOAPHandler) soap.SOAPHandler {
+		return func(w http.ResponseWriter, r *http.Request) {
+			op, ok := soap.OperationFromContext(r.Context())
+			if !ok {
+				next(w, r)
+				return
+			}
+
+			ctx, inv, rec := startAudit(r.Context(), op.Name)
+			next(w, r.WithContext(ctx))
+			finishAudit(ctx, log, inv, rec)
+		}
+	}
+}
+
+// AuditRequests returns middleware recording in the log the invocations of
+// the operations served outside the SOAP servers, such as the JSON API.
+// operation names the operation a request invokes, or returns "" for
+// requests that are not audited. Handlers record their faults with
+// soap.RecordFault; other failed responses, such as the 401 of the
+// authentication middleware, are recorded by their status.
+func AuditRequests(log AuditLog, operation func(*http.Request) string) soap.Middleware {
+	return func(next soap.SOAPHandler) soap.SOAPHandler {
+		return func(w http.ResponseWriter, r *http.Request) {
+			name := operation(r)
+			if name == "" {
+				next(w, r)
+				return
+			}
+
+			ctx, inv, rec := startAudit(r.Context(), name)
+			aw := &auditWriter{ResponseWriter: w}
+			next(aw, r.WithContext(ctx))
+			if inv.Fault() == nil && aw.status >= http.StatusBadRequest {
+				soap.RecordFault(ctx, statusFault(aw.status))
+			}
+			finishAudit(ctx, log, inv, rec)
+		}
+	}
+}
+
+// startAudit returns a copy of ctx recording the invocation of the
+// operation, and its audit record so far
+func startAudit(ctx context.Context, operation string) (context.Context, *soap.Invocation, AuditRecord) {
+	ctx, inv := soap.WithInvocation(ctx)
+	rec := AuditRecord{
+		Time:      time.Now().UTC(),
+		RequestID: soap.RequestIDFromContext(ctx),
+		Tenant:    soap.TenantFromContext(ctx),
+		Operation: operation,
+	}
+	if a, ok := soap.AddressingFromContext(ctx); ok {
+		rec.MessageID = a.MessageID
+	}
+	return ctx, inv, rec
+}
+
+// finishAudit completes the record with what the invocation recorded and
+// appends it to the log
+func finishAudit(ctx context.Context, log AuditLog, inv *soap.Invocation, rec AuditRecord) {
+	if principal, ok := inv.Principal(); ok {
+		rec.Caller, rec.AuthMethod = principal.Name, principal.Method
+	}
+	rec.FileIDs = inv.FileIDs()
+	rec.Outcome = AuditSuccess
+	if f := inv.Fault(); f != nil {
+		rec.Outcome, rec.FaultCode = AuditFault, f.QualifiedCode()
+	}
+	// The request may have been cancelled by now; the record is still
+	// written
+	if err := log.Append(context.WithoutCancel(ctx), rec); err != nil {
+		soap.Error(ctx, "Failed to append audit record", soap.LogKeyError, err)
+	}
+}
+
+// statusFault returns the fault recorded for a failed response written
+// without one
+func statusFault(status int) *soapfault.Fault {
+	switch {
+	case status == http.StatusUnauthorized:
+		return soapfault.Client("Authentication required", nil).WithSubcode("", "Authentication")
+	case status == http.StatusForbidden:
+		return soapfault.Client("Access denied", nil).WithSubcode("", "Authorization")
+	case status >= http.StatusInternalServerError:
+		return soapfault.Server(http.StatusText(status), nil)
+	}
+	return soapfault.Client(http.StatusText(status), nil)
+}
+
+// auditWriter records the status of a response
+type auditWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *auditWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *auditWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController
+func (w *auditWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// AuditFiles wraps a file catalog so that the files read or changed by an
+// operation are recorded in its audit record. Listing files does not count
+// as touching them.
+func AuditFiles(files FileCatalog) FileCatalog {
+	return &auditedCatalog{FileCatalog: files}
+}
+
+type auditedCatalog struct {
+	FileCatalog
+}
+
+func (c *auditedCatalog) Add(ctx context.Context, file FileRecord) error {
+	soap.TouchFile(ctx, file.ID)
+	return c.FileCatalog.Add(ctx, file)
+}
+
+func (c *auditedCatalog) Get(ctx context.Context, id string) (FileRecord, error) {
+	soap.TouchFile(ctx, id)
+	return c.FileCatalog.Get(ctx, id)
+}
+
+func (c *auditedCatalog) Update(ctx context.Context, id string, fn func(*FileRecord) error) (FileRecord, error) {
+	soap.TouchFile(ctx, id)
+	return c.FileCatalog.Update(ctx, id, fn)
+}
+
+func (c *auditedCatalog) Delete(ctx context.Context, id string) error {
+	soap.TouchFile(ctx, id)
+	return c.FileCatalog.Delete(ctx, id)
+}
+
+// FileAuditLog is an AuditLog appending JSON lines to a file. The file is
+// only ever opened for appending, so records cannot be changed through it.
+type FileAuditLog struct {
+	path string
+	mu   sync.Mutex
+	file *os.File
+}
+
+// OpenFileAuditLog opens the audit log file at path, creating it if needed
+func OpenFileAuditLog(path string) (*FileAuditLog, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileAuditLog{path: path, file: file}, nil
+}
+
+func (l *FileAuditLog) Append(ctx context.Context, rec AuditRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// One write per record keeps the lines whole
+	_, err = l.file.Write(append(line, '\n'))
+	return err
+}
+
+func (l *FileAuditLog) Query(ctx context.Context, q AuditQuery) ([]AuditRecord, error) {
+	file, err := os.Open(l.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var records []AuditRecord
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var rec AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", l.path, line, err)
+		}
+		if q.matches(rec) {
+			records = append(records, rec)
+		}
+	}
+	return records, scanner.Err()
+}
+
+// Close closes the audit log file
+func (l *FileAuditLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+// ExportAuditLogRequest represents the SOAP request for exporting audit
+// records. The times are xsd:dateTime values.
+type ExportAuditLogRequest struct {
+	XMLName   xml.Name `xml:"ExportAuditLogRequest"`
+	From      string   `xml:"from,omitempty"`
+	To        string   `xml:"to,omitempty"`
+	Caller    string   `xml:"caller,omitempty"`
+	Operation string   `xml:"operation,omitempty"`
+}
+
+// Validate checks the format of the time range
+func (req ExportAuditLogRequest) Validate() error {
+	var errs soap.FieldErrors
+	for _, f := range []struct{ name, value string }{
+		{"from", req.From},
+		{"to", req.To},
+	} {
+		if _, err := parseAuditTime(f.value); err != nil {
+			errs.Add(f.name, "must be a date and time in the format YYYY-MM-DDThh:mm:ssZ")
+		}
+	}
+	return errs.Err()
+}
+
+// parseAuditTime parses an optional time of the export request
+func parseAuditTime(s string) (time.Time, error) {
+	if s = strings.TrimSpace(s); s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// ExportAuditLogResponse represents the SOAP response with the audit records
+type ExportAuditLogResponse struct {
+	XMLName xml.Name      `xml:"ExportAuditLogResponse"`
+	Total   int           `xml:"total"`
+	Records []AuditRecord `xml:"record"`
+}
+
+// ExportAuditLog handles the ExportAuditLog SOAP operation
+func ExportAuditLog(log AuditLog) func(context.Context, ExportAuditLogRequest) (ExportAuditLogResponse, error) {
+	return func(ctx context.Context, req ExportAuditLogRequest) (ExportAuditLogResponse, error) {
+		if log == nil {
+			return ExportAuditLogResponse{}, soapfault.Server("Audit log disabled", "No audit log is configured")
+		}
+
+		// Validate has checked the times
+		from, _ := parseAuditTime(req.From)
+		to, _ := parseAuditTime(req.To)
+		records, err := log.Query(ctx, AuditQuery{
+			From:      from,
+			To:        to,
+			Caller:    strings.TrimSpace(req.Caller),
+			Operation: strings.TrimSpace(req.Operation),
+		})
+		if err != nil {
+			return ExportAuditLogResponse{}, soapfault.Server("Internal error", "Audit log failed: "+err.Error())
+		}
+
+		soap.Info(ctx, "Audit records exported", "records", len(records))
+		return ExportAuditLogResponse{Total: len(records), Records: records}, nil
+	}
+}
@@ -0,0 +1,298 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"soap-server/soap"
+	"soap-server/soapfault"
+	"strings"
+)
+
+// Grant permits callers to invoke an operation: those with the role, the
+// client of the name, or, if neither is set, every authenticated caller
+type Grant struct {
+	Role   string // Role granted to the user or to the principal
+	Client string // Principal name of the caller, e.g. an API key client
+
+	// OwnFiles limits the caller to the files they uploaded; other files
+	// are neither listed nor found
+	OwnFiles bool
+}
+
+// String returns the grant in the syntax of ParsePolicy
+func (g Grant) String() string {
+	s := anyCaller
+	switch {
+	case g.Role != "":
+		s = g.Role
+	case g.Client != "":
+		s = clientPrefix + g.Client
+	}
+	if g.OwnFiles {
+		s += ownFilesSuffix
+	}
+	return s
+}
+
+// matches reports whether the grant applies to the principal, whose stored
+// user is caller
+func (g Grant) matches(principal soap.Principal, caller User) bool {
+	switch {
+	case g.Role != "":
+		return caller.HasRole(g.Role) || hasRole(principal.Roles, g.Role)
+	case g.Client != "":
+		return principal.Name == g.Client
+	}
+	return true
+}
+
+// Syntax of the grants of a policy
+const (
+	anyOperation   = "*"
+	anyCaller      = "*"
+	clientPrefix   = "client:"
+	ownFilesSuffix = "+own"
+)
+
+// Policy maps operation names to the grants permitting them. The "*" entry
+// applies to the operations without an entry of their own; without it,
+// those operations are open to every caller.
+type Policy map[string][]Grant
+
+// DefaultPolicy restricts the operations that change users or delete files,
+// and the export of the audit log
+func DefaultPolicy() Policy {
+	return Policy{
+		"UpdateUser":     roleGrants(RoleAdmin, RoleEditor),
+		"DeleteUser":     roleGrants(RoleAdmin),
+		"RestoreUser":    roleGrants(RoleAdmin),
+		"AssignRole":     roleGrants(RoleAdmin),
+		"ImportUsers":    roleGrants(RoleAdmin),
+		"ExportAuditLog": roleGrants(RoleAdmin),
+		"RenameFile":     roleGrants(RoleAdmin, RoleEditor),
+		"DeleteFile":     roleGrants(RoleAdmin),
+	}
+}
+
+func roleGrants(roles ...string) []Grant {
+	grants := make([]Grant, len(roles))
+	for i, role := range roles {
+		grants[i] = Grant{Role: role}
+	}
+	return grants
+}
+
+// ParsePolicy parses a policy of comma-separated operation=grants entries,
+// with the grants separated by "|", e.g.
+// "DeleteUser=admin,DownloadFileMTOM=admin|client:partner+own". A grant is
+// a role, "client:" and a client name, or "*" for every authenticated
+// caller; "+own" limits it to the files the caller uploaded. The operation
+// "*" applies to the operations without an entry.
+func ParsePolicy(s string) (Policy, error) {
+	policy := Policy{}
+	for _, entry := range strings.Split(s, ",") {
+		if err := policy.add(entry); err != nil {
+			return nil, err
+		}
+	}
+	return policy, nil
+}
+
+// LoadPolicy reads a policy file with one operation=grants entry of the
+// ParsePolicy syntax per line. Blank lines and lines starting with # are
+// skipped.
+func LoadPolicy(path string) (Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	policy := Policy{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(text, "#") {
+			continue
+		}
+		if err := policy.add(text); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+	}
+	return policy, scanner.Err()
+}
+
+// add adds an operation=grants entry; blank entries are skipped
+func (p Policy) add(entry string) error {
+	entry = strings.TrimSpace(entry)
+	if entry == "" {
+		return nil
+	}
+	op, grants, ok := strings.Cut(entry, "=")
+	op = strings.TrimSpace(op)
+	if !ok || op == "" || strings.TrimSpace(grants) == "" {
+		return fmt.Errorf("invalid policy entry %q, expected operation=grant|grant", entry)
+	}
+	for _, s := range strings.Split(grants, "|") {
+		var grant Grant
+		s = strings.TrimSpace(s)
+		if name, ok := strings.CutSuffix(s, ownFilesSuffix); ok {
+			s, grant.OwnFiles = strings.TrimSpace(name), true
+		}
+		if name, ok := strings.CutPrefix(s, clientPrefix); ok {
+			grant.Client = strings.TrimSpace(name)
+			s = grant.Client
+		} else if s != anyCaller {
+			grant.Role = s
+		}
+		if s == "" {
+			return fmt.Errorf("invalid policy entry %q, empty grant", entry)
+		}
+		p[op] = append(p[op], grant)
+	}
+	return nil
+}
+
+// Check returns nil if the caller of ctx may invoke the named operation,
+// and an Authentication or Authorization Client fault otherwise. The caller
+// is the user whose ID is the name of the request principal, set by the
+// authentication middleware; an operation of the policy requires one of its
+// grants. Limits to the caller's own files are left to Permit.
+func (p Policy) Check(ctx context.Context, users UserStore, operation string) error {
+	_, err := p.Permit(ctx, users, operation)
+	return err
+}
+
+// Permit checks the caller like Check and returns the context to invoke
+// the operation in. If only grants limited to the caller's own files permit
+// it, the catalogs wrapped by AuthorizeFiles only show those files in the
+// returned context.
+func (p Policy) Permit(ctx context.Context, users UserStore, operation string) (context.Context, error) {
+	grants, restricted := p[operation]
+	if !restricted {
+		if grants, restricted = p[anyOperation]; !restricted {
+			return ctx, nil
+		}
+	}
+
+	principal, ok := soap.PrincipalFromContext(ctx)
+	if !ok || principal.Name == "" {
+		return ctx, soapfault.Client("Authentication required",
+			fmt.Sprintf("Operation %s requires an authenticated caller", operation)).
+			WithSubcode("", "Authentication")
+	}
+
+	caller, err := users.Get(ctx, principal.Name)
+	if err != nil && !errors.Is(err, ErrUserNotFound) {
+		return ctx, userError(principal.Name, err)
+	}
+	ownFiles := false
+	for _, grant := range grants {
+		if !grant.matches(principal, caller) {
+			continue
+		}
+		if !grant.OwnFiles {
+			return ctx, nil
+		}
+		ownFiles = true
+	}
+	if ownFiles {
+		return withFileOwner(ctx, principal.Name), nil
+	}
+
+	names := make([]string, len(grants))
+	for i, grant := range grants {
+		names[i] = grant.String()
+	}
+	soap.Warn(ctx, "Access denied", "required", strings.Join(names, ", "))
+	return ctx, soapfault.Client("Access denied",
+		fmt.Sprintf("Operation %s requires one of: %s", operation, strings.Join(names, ", "))).
+		WithSubcode("", "Authorization")
+}
+
+type fileOwnerKey struct{}
+
+// withFileOwner limits the files of the catalogs wrapped by AuthorizeFiles
+// to those uploaded by owner
+func withFileOwner(ctx context.Context, owner string) context.Context {
+	return context.WithValue(ctx, fileOwnerKey{}, owner)
+}
+
+// fileOwnerFromContext returns the uploader the files are limited to
+func fileOwnerFromContext(ctx context.Context) (string, bool) {
+	owner, ok := ctx.Value(fileOwnerKey{}).(string)
+	return owner, ok
+}
+
+// AuthorizeFiles wraps a file catalog so that operations the policy limits
+// to the caller's own files only see the files the caller uploaded. Other
+// files are left out of lists and reported as ErrFileNotFound, so their
+// existence is not revealed.
+func AuthorizeFiles(files FileCatalog) FileCatalog {
+	return &ownedCatalog{FileCatalog: files}
+}
+
+type ownedCatalog struct {
+	FileCatalog
+}
+
+// owns reports whether the file is visible in ctx
+func owns(ctx context.Context, file FileRecord) bool {
+	owner, ok := fileOwnerFromContext(ctx)
+	return !ok || file.Uploader == owner
+}
+
+func (c *ownedCatalog) Get(ctx context.Context, id string) (FileRecord, error) {
+	record, err := c.FileCatalog.Get(ctx, id)
+	if err == nil && !owns(ctx, record) {
+		return FileRecord{}, ErrFileNotFound
+	}
+	return record, err
+}
+
+func (c *ownedCatalog) Update(ctx context.Context, id string, fn func(*FileRecord) error) (FileRecord, error) {
+	return c.FileCatalog.Update(ctx, id, func(f *FileRecord) error {
+		if !owns(ctx, *f) {
+			return ErrFileNotFound
+		}
+		return fn(f)
+	})
+}
+
+func (c *ownedCatalog) Delete(ctx context.Context, id string) error {
+	if _, err := c.Get(ctx, id); err != nil {
+		return err
+	}
+	return c.FileCatalog.Delete(ctx, id)
+}
+
+func (c *ownedCatalog) List(ctx context.Context, q FileQuery) ([]FileRecord, error) {
+	if owner, ok := fileOwnerFromContext(ctx); ok {
+		if q.Uploader != "" && q.Uploader != owner {
+			return nil, nil
+		}
+		q.Uploader = owner
+	}
+	return c.FileCatalog.List(ctx, q)
+}
+
+// Authorize returns middleware enforcing the policy on the SOAP operations,
+// before any handler runs
+func Authorize(users UserStore, policy Policy) soap.Middleware {
+	return func(next soap.SOAPHandler) soap.SOAPHandler {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if op, ok := soap.OperationFromContext(r.Context()); ok {
+				ctx, err := policy.Permit(r.Context(), users, op.Name)
+				if err != nil {
+					soap.WriteError(w, r, err)
+					return
+				}
+				r = r.WithContext(ctx)
+			}
+			next(w, r)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrBlobNotFound is returned by a BlobStore when no blob has the given key
+var ErrBlobNotFound = errors.New("blob not found")
+
+// BlobInfo describes a blob in a BlobStore
+type BlobInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time // Time the blob was written
+}
+
+// BlobStore stores the content of the uploaded files. Blobs are keyed by
+// the stored name of the file (<fileId>_<name>, prefixed by shard
+// directories such as "2024/05/01/" in sharded layouts); their metadata is
+// kept in the FileCatalog.
+type BlobStore interface {
+	// Put stores the content read from r under key and returns its size. A
+	// failed or cancelled Put leaves no blob behind.
+	Put(ctx context.Context, key string, r io.Reader) (int64, error)
+
+	// Open returns the content and size of the blob or ErrBlobNotFound. The
+	// caller closes the reader.
+	Open(ctx context.Context, key string) (io.ReadCloser, int64, error)
+
+	// Delete removes the blob. Removing a missing blob is not an error.
+	Delete(ctx context.Context, key string) error
+
+	// List returns all blobs in the store
+	List(ctx context.Context) ([]BlobInfo, error)
+}
+
+// RangeOpener is implemented by blob stores that can read a blob from an
+// offset without transferring what precedes it. Readers returned by Open
+// that implement io.Seeker are seeked instead.
+type RangeOpener interface {
+	// OpenRange returns the content of the blob from offset to the end or
+	// ErrBlobNotFound
+	OpenRange(ctx context.Context, key string, offset int64) (io.ReadCloser, error)
+}
+
+// DiskBlobStore is a BlobStore keeping the blobs as files in a local
+// directory, created on the first Put. Keys with "/" separators are stored
+// in subdirectories. It only works for a single server instance unless the
+// directory is shared.
+type DiskBlobStore struct {
+	dir string
+}
+
+// NewDiskBlobStore creates a blob store in dir
+func NewDiskBlobStore(dir string) *DiskBlobStore {
+	return &DiskBlobStore{dir: dir}
+}
+
+// path returns the file of a key. Keys are file names, optionally in
+// subdirectories separated by "/"; anything that could escape the directory
+// is rejected.
+func (s *DiskBlobStore) path(key string) (string, error) {
+	for _, name := range strings.Split(key, "/") {
+		if name == "" || name != filepath.Base(name) || name == "." || name == ".." {
+			return "", fmt.Errorf("invalid blob key %q", key)
+		}
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
+
+func (s *DiskBlobStore) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return 0, fmt.Errorf("failed to create upload directory: %w", err)
+	}
+	return saveFile(ctx, path, r)
+}
+
+func (s *DiskBlobStore) Open(ctx context.Context, key string) (io.ReadCloser, int64, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0, ErrBlobNotFound
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	return file, info.Size(), nil
+}
+
+func (s *DiskBlobStore) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Ping checks that files can be written to the directory by creating and
+// removing a temporary file, whose name is not that of an upload
+func (s *DiskBlobStore) Ping(ctx context.Context) error {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create upload directory: %w", err)
+	}
+	file, err := os.CreateTemp(s.dir, ".health-*")
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteString("ok")
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if removeErr := os.Remove(file.Name()); err == nil {
+		err = removeErr
+	}
+	return err
+}
+
+func (s *DiskBlobStore) List(ctx context.Context) ([]BlobInfo, error) {
+	var blobs []BlobInfo
+	err := filepath.WalkDir(s.dir, func(path string, entry fs.DirEntry, err error) error {
+		if errors.Is(err, os.ErrNotExist) {
+			// Removed since its parent was read, or no uploads yet
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.dir, path)
+		if err != nil {
+			return err
+		}
+		blobs = append(blobs, BlobInfo{Key: filepath.ToSlash(rel), Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return blobs, nil
+}
@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"io"
+	"soap-server/tracing"
+)
+
+// TraceBlobs wraps a blob store so that its calls are recorded as spans of
+// the traces of the requests. Reading an opened blob is not part of the
+// span of Open. Stores that are RangeOpeners remain so.
+func TraceBlobs(blobs BlobStore, system string) BlobStore {
+	traced := &tracedBlobs{blobs: blobs, system: system}
+	if _, ok := blobs.(RangeOpener); ok {
+		return &tracedRangeBlobs{traced}
+	}
+	return traced
+}
+
+type tracedBlobs struct {
+	blobs  BlobStore
+	system string // Name of the store in the spans, e.g. "s3"
+}
+
+func (s *tracedBlobs) start(ctx context.Context, operation, key string) (context.Context, *tracing.Span) {
+	attrs := []tracing.Attr{tracing.String("blob.system", s.system)}
+	if key != "" {
+		attrs = append(attrs, tracing.String("blob.key", key))
+	}
+	return tracing.Start(ctx, "blob "+operation, tracing.KindInternal, attrs...)
+}
+
+// endBlobSpan ends a span with the error of the call, except for missing blobs
+func endBlobSpan(span *tracing.Span, err error) {
+	if err != nil && !errors.Is(err, ErrBlobNotFound) {
+		span.SetError(err)
+	}
+	span.End()
+}
+
+func (s *tracedBlobs) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	ctx, span := s.start(ctx, "Put", key)
+	size, err := s.blobs.Put(ctx, key, r)
+	span.SetAttributes(tracing.Int64("blob.size", size))
+	endBlobSpan(span, err)
+	return size, err
+}
+
+func (s *tracedBlobs) Open(ctx context.Context, key string) (io.ReadCloser, int64, error) {
+	ctx, span := s.start(ctx, "Open", key)
+	r, size, err := s.blobs.Open(ctx, key)
+	endBlobSpan(span, err)
+	return r, size, err
+}
+
+func (s *tracedBlobs) Delete(ctx context.Context, key string) error {
+	ctx, span := s.start(ctx, "Delete", key)
+	err := s.blobs.Delete(ctx, key)
+	endBlobSpan(span, err)
+	return err
+}
+
+func (s *tracedBlobs) List(ctx context.Context) ([]BlobInfo, error) {
+	ctx, span := s.start(ctx, "List", "")
+	blobs, err := s.blobs.List(ctx)
+	span.SetAttributes(tracing.Int64("blob.count", int64(len(blobs))))
+	endBlobSpan(span, err)
+	return blobs, err
+}
+
+type tracedRangeBlobs struct {
+	*tracedBlobs
+}
+
+func (s *tracedRangeBlobs) OpenRange(ctx context.Context, key string, offset int64) (io.ReadCloser, error) {
+	ctx, span := s.start(ctx, "OpenRange", key)
+	span.SetAttributes(tracing.Int64("blob.offset", offset))
+	r, err := s.blobs.(RangeOpener).OpenRange(ctx, key, offset)
+	endBlobSpan(span, err)
+	return r, err
+}
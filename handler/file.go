@@ -1,32 +1,66 @@
 package handler
 
 import (
+	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/xml"
-	"fmt"
-	"net/http"
-	"os"
-	"path/filepath"
+	"soap-server/soap"
+	"soap-server/soapfault"
 	"strings"
 	"time"
-
-	"github.com/google/uuid"
 )
 
 // UploadFileRequest represents the SOAP request for uploading a file
 type UploadFileRequest struct {
-	XMLName  xml.Name `xml:"http://example.com/soap/user UploadFileRequest"`
-	FileName string   `xml:"fileName"`
-	FileData string   `xml:"fileData"`
+	XMLName  xml.Name `xml:"UploadFileRequest"`
+	FileName string   `xml:"fileName" validate:"required,max=255"`
+	FileData string   `xml:"fileData" validate:"required"`
+
+	// Checksum of the content computed by the client, verified on upload
+	Checksum          string `xml:"checksum,omitempty"`
+	ChecksumAlgorithm string `xml:"checksumAlgorithm,omitempty" xsd:"enum=md5|sha1|sha256|sha384|sha512" validate:"oneof=md5|sha1|sha256|sha384|sha512"`
+
+	// Lifetime of the file in seconds, instead of the server default
+	TTLSeconds int `xml:"ttlSeconds,omitempty" validate:"min=0"`
+}
+
+// Validate checks the format of the expected checksum
+func (req UploadFileRequest) Validate() error {
+	return validateChecksum(req.Checksum)
 }
 
 // UploadFileResponse represents the SOAP response for file upload
 type UploadFileResponse struct {
-	XMLName  xml.Name `xml:"http://example.com/soap/user UploadFileResponse"`
+	XMLName  xml.Name `xml:"UploadFileResponse"`
 	FileID   string   `xml:"fileId"`
 	FileName string   `xml:"fileName"`
 	Size     int64    `xml:"size"`
 	Path     string   `xml:"path"`
+
+	// Digest of the stored content, for end-to-end integrity checks
+	Checksum          string `xml:"checksum"`
+	ChecksumAlgorithm string `xml:"checksumAlgorithm"`
+
+	// RFC 3339 time after which the file is deleted, if it expires
+	ExpiresAt string `xml:"expiresAt,omitempty"`
+
+	// Set if the content was already stored and the upload shares it
+	Deduplicated bool `xml:"deduplicated,omitempty"`
+
+	// Background job storing the file when uploads are asynchronous; the
+	// elements above only give the name and size until it is complete
+	JobID string `xml:"jobId,omitempty"`
+}
+
+// ChecksumMismatchFault is the fault detail returned when the content of an
+// upload does not match the checksum sent by the client
+type ChecksumMismatchFault struct {
+	XMLName   xml.Name `xml:"ChecksumMismatchFault"`
+	FileName  string   `xml:"fileName"`
+	Algorithm string   `xml:"algorithm"`
+	Expected  string   `xml:"expected"`
+	Actual    string   `xml:"actual"`
 }
 
 // FileUploadResult stores the result of a file upload
@@ -37,80 +71,60 @@ type FileUploadResult struct {
 	Path     string
 }
 
-// UploadFile handles the UploadFile SOAP operation
-func UploadFile(uploadDir string) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		// Read and parse the SOAP request body
-		var soapEnvelope struct {
-			XMLName xml.Name `xml:"http://schemas.xmlsoap.org/soap/envelope/ Envelope"`
-			Body    struct {
-				XMLName xml.Name          `xml:"http://schemas.xmlsoap.org/soap/envelope/ Body"`
-				Request UploadFileRequest `xml:"UploadFileRequest"`
-			}
-		}
-
-		if err := xml.NewDecoder(r.Body).Decode(&soapEnvelope); err != nil {
-			sendSOAPError(w, "Client", "Invalid XML format", err.Error())
-			return
-		}
-
-		fileName := soapEnvelope.Body.Request.FileName
-		fileData := soapEnvelope.Body.Request.FileData
-
-		// Validate input
-		if fileName == "" {
-			sendSOAPError(w, "Client", "Invalid input", "File name is required")
-			return
-		}
-
-		if fileData == "" {
-			sendSOAPError(w, "Client", "Invalid input", "File data is required")
-			return
-		}
+// UploadFile handles the UploadFile SOAP operation. Files are stored as
+// configured by the Blobs, Files, ChecksumAlgorithm and FileTTL of cfg, in
+// the background if AsyncUploads is set.
+func UploadFile(cfg Config) func(context.Context, UploadFileRequest) (UploadFileResponse, error) {
+	return func(ctx context.Context, req UploadFileRequest) (UploadFileResponse, error) {
+		fileName := req.FileName
+		fileData := req.FileData
 
 		// Decode base64 file data
 		decodedData, err := base64.StdEncoding.DecodeString(fileData)
 		if err != nil {
-			sendSOAPError(w, "Client", "Invalid file data", "Failed to decode base64 data: "+err.Error())
-			return
+			return UploadFileResponse{}, soapfault.Client("Invalid file data", "Failed to decode base64 data: "+err.Error())
 		}
 
-		// Generate unique file ID
-		fileID := uuid.New().String()
-
-		// Create upload directory if it doesn't exist
-		if err := os.MkdirAll(uploadDir, 0755); err != nil {
-			sendSOAPError(w, "Server", "Internal error", "Failed to create upload directory: "+err.Error())
-			return
+		opts := uploadOptions{
+			Expected: expectedChecksum{Algorithm: req.ChecksumAlgorithm, Value: req.Checksum},
+			TTL:      time.Duration(req.TTLSeconds) * time.Second,
 		}
-
-		// Sanitize filename and create file path
-		safeFileName := sanitizeFileName(fileName)
-		uniqueFileName := fmt.Sprintf("%s_%s", fileID, safeFileName)
-		filePath := filepath.Join(uploadDir, uniqueFileName)
-
-		// Write file to disk
-		if err := os.WriteFile(filePath, decodedData, 0644); err != nil {
-			sendSOAPError(w, "Server", "Internal error", "Failed to save file: "+err.Error())
-			return
+		if cfg.AsyncUploads {
+			jobID := cfg.Jobs.start(ctx, func(ctx context.Context) ([]FileRecord, error) {
+				record, err := storeUpload(ctx, cfg, fileName, bytes.NewReader(decodedData), opts)
+				if err != nil {
+					return nil, err
+				}
+				return []FileRecord{record}, nil
+			})
+			soap.Info(ctx, "File upload queued", "job_id", jobID, "name", fileName, soap.LogKeyBytes, len(decodedData))
+			return UploadFileResponse{FileName: fileName, Size: int64(len(decodedData)), JobID: jobID}, nil
 		}
 
-		// Get file size
-		fileSize := int64(len(decodedData))
+		// Store the file, verify it and record it in the catalog
+		record, err := storeUpload(ctx, cfg, fileName, bytes.NewReader(decodedData), opts)
+		if err != nil {
+			return UploadFileResponse{}, err
+		}
 
 		// Create response
 		response := UploadFileResponse{
-			FileID:   fileID,
-			FileName: fileName,
-			Size:     fileSize,
-			Path:     fmt.Sprintf("/uploads/%s", uniqueFileName),
+			FileID:   record.ID,
+			FileName: record.Name,
+			Size:     record.Size,
+			Path:     uploadPath(record.uploadKey()),
+
+			Checksum:          record.Checksum,
+			ChecksumAlgorithm: record.ChecksumAlgorithm,
+			ExpiresAt:         record.ExpiresAt,
+			Deduplicated:      record.sharesBlob(),
 		}
 
-		sendSOAPResponse(w, "UploadFileResponse", response)
-
 		// Log the upload
-		fmt.Printf("[%s] File uploaded: ID=%s, Name=%s, Size=%d bytes, Path=%s\n",
-			time.Now().Format("2006-01-02 15:04:05"), fileID, fileName, fileSize, filePath)
+		soap.Info(ctx, "File uploaded", "file_id", record.ID, "name", fileName, soap.LogKeyBytes, record.Size,
+			"key", record.StoredName)
+
+		return response, nil
 	}
 }
 
@@ -0,0 +1,160 @@
+package handler
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"soap-server/soap"
+	"soap-server/soapfault"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DownloadArchiveRequest represents the SOAP request for downloading
+// several uploaded files as one zip archive
+type DownloadArchiveRequest struct {
+	XMLName xml.Name `xml:"DownloadArchiveRequest"`
+	FileIDs []string `xml:"fileId" validate:"required,max=1000"`
+
+	// Name of the archive, "files.zip" if empty
+	ArchiveName string `xml:"archiveName,omitempty" validate:"max=255"`
+}
+
+// Validate checks that the file IDs are UUIDs as assigned on upload
+func (req DownloadArchiveRequest) Validate() error {
+	var errs soap.FieldErrors
+	for i, id := range req.FileIDs {
+		if _, err := uuid.Parse(id); err != nil {
+			errs.Add(fmt.Sprintf("fileId[%d]", i), "must be a UUID")
+		}
+	}
+	return errs.Err()
+}
+
+// DownloadArchiveResponse represents the SOAP response with the zip
+// archive, sent as an MTOM or SwA attachment. Its size is not known in
+// advance, as the archive is written while it is sent.
+type DownloadArchiveResponse struct {
+	XMLName     xml.Name   `xml:"DownloadArchiveResponse"`
+	ArchiveName string     `xml:"archiveName"`
+	ContentType string     `xml:"contentType"`
+	FileCount   int        `xml:"fileCount"`
+	ArchiveData BinaryData `xml:"archiveData"`
+}
+
+// DownloadArchive handles the DownloadArchive SOAP operation. The files are
+// looked up before the response is started, so unknown IDs get a
+// FileNotFoundFault; the archive is then compressed from the blob store
+// into the attachment as it is sent, without holding it in memory. Only
+// clients accepting multipart/related are served. Files sharing a name are
+// numbered in the archive.
+func DownloadArchive(blobs BlobStore, files FileCatalog, swa bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		var req DownloadArchiveRequest
+		if err := soap.DecodeRequest(r, &req); err != nil {
+			soap.WriteError(w, r, err)
+			return
+		}
+		if !soap.AcceptsMTOM(r) {
+			soap.WriteFault(w, r, soapfault.Client("MTOM required",
+				"Archives are only sent as attachments; send an MTOM request or Accept: multipart/related"))
+			return
+		}
+
+		var records []FileRecord
+		for _, id := range req.FileIDs {
+			record, err := files.Get(ctx, id)
+			if err != nil {
+				soap.WriteError(w, r, fileError(id, err))
+				return
+			}
+			records = append(records, record)
+		}
+
+		name := sanitizeFileName(req.ArchiveName)
+		if name == "" {
+			name = "files.zip"
+		}
+		response := DownloadArchiveResponse{ArchiveName: name, ContentType: "application/zip", FileCount: len(records)}
+
+		// The archive is written to a pipe read by the response writer.
+		// Closing the reader stops the writer when the response fails.
+		reader, writer := io.Pipe()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			writer.CloseWithError(writeArchive(ctx, blobs, records, writer))
+		}()
+		defer func() {
+			reader.Close()
+			<-done
+		}()
+
+		contentID := uuid.New().String() + "@soap-server"
+		attachment := soap.Attachment{ContentID: contentID, ContentType: "application/zip", Body: reader}
+		write := soap.WriteMTOMResponse
+		if swa || soap.IsSwA(r) {
+			response.ArchiveData.Href = "cid:" + contentID
+			write = soap.WriteSwAResponse
+		} else {
+			response.ArchiveData.Include = &XOPInclude{Href: "cid:" + contentID}
+		}
+		if err := write(w, r, response, attachment); err != nil {
+			// The response has been started; the client sees a truncated message
+			soap.Error(ctx, "Archive download failed", "files", len(records), soap.LogKeyError, err)
+			return
+		}
+
+		soap.Info(ctx, "Archive downloaded", "name", name, "files", len(records))
+	}
+}
+
+// writeArchive writes a zip archive of the files to w
+func writeArchive(ctx context.Context, blobs BlobStore, records []FileRecord, w io.Writer) error {
+	archive := zip.NewWriter(w)
+	names := map[string]int{}
+	for _, record := range records {
+		header := &zip.FileHeader{Name: archiveEntryName(record.Name, names), Method: zip.Deflate}
+		if uploadedAt, err := time.Parse(time.RFC3339, record.UploadedAt); err == nil {
+			header.Modified = uploadedAt
+		}
+		entry, err := archive.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		content, _, err := blobs.Open(ctx, record.StoredName)
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", record.ID, err)
+		}
+		_, err = io.Copy(entry, contextReader{ctx: ctx, r: content})
+		content.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+// archiveEntryName returns the name of a file in an archive: its name
+// without path elements, numbered if already taken, e.g. "report (2).pdf"
+func archiveEntryName(name string, taken map[string]int) string {
+	name = sanitizeFileName(name)
+	if name == "" {
+		name = "file"
+	}
+	taken[name]++
+	if n := taken[name]; n > 1 {
+		ext := path.Ext(name)
+		name = fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), n, ext)
+		taken[name]++
+	}
+	return name
+}
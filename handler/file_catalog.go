@@ -0,0 +1,243 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrFileNotFound is returned by a FileCatalog when no file has the given ID
+var ErrFileNotFound = errors.New("file not found")
+
+// FileRecord is the catalog entry of an uploaded file
+type FileRecord struct {
+	ID                string `json:"id"`
+	Name              string `json:"name"`       // File name given by the uploader
+	StoredName        string `json:"storedName"` // Key of the file in the blob store, a relative path in sharded layouts
+	Size              int64  `json:"size"`
+	ContentType       string `json:"contentType"`
+	DetectedType      string `json:"detectedType,omitempty"` // Media type detected from the content
+	Checksum          string `json:"checksum"`               // Hex-encoded digest of the content
+	ChecksumAlgorithm string `json:"checksumAlgorithm"`      // Hash of the checksum, e.g. sha256
+	Uploader          string `json:"uploader,omitempty"`     // Principal name of the uploader, if authenticated
+	UploadedAt        string `json:"uploadedAt"`             // RFC 3339 time of the upload
+	ExpiresAt         string `json:"expiresAt,omitempty"`    // RFC 3339 time after which the file is deleted; kept if empty
+}
+
+// expiredBy reports whether the file expires at or before t
+func (f FileRecord) expiredBy(t time.Time) bool {
+	if f.ExpiresAt == "" {
+		return false
+	}
+	expiresAt, err := time.Parse(time.RFC3339, f.ExpiresAt)
+	return err == nil && !expiresAt.After(t)
+}
+
+// FileQuery filters and orders the files listed by a FileCatalog
+type FileQuery struct {
+	SortBy     string // uploadedAt, size or name; defaults to uploadedAt
+	Descending bool
+	ExpiredBy  time.Time // If set, only files expiring at or before this time
+	Uploader   string    // If set, only files uploaded by this principal
+
+	// If set, only files with this checksum of ChecksumAlgorithm
+	Checksum          string
+	ChecksumAlgorithm string
+}
+
+// matches reports whether the file passes the filters of the query
+func (q FileQuery) matches(f FileRecord) bool {
+	if !q.ExpiredBy.IsZero() && !f.expiredBy(q.ExpiredBy) {
+		return false
+	}
+	if q.Uploader != "" && f.Uploader != q.Uploader {
+		return false
+	}
+	if q.Checksum != "" && (f.Checksum != q.Checksum || f.ChecksumAlgorithm != q.ChecksumAlgorithm) {
+		return false
+	}
+	return true
+}
+
+// FileCatalog records the metadata of the uploaded files, which are stored
+// in the upload directory
+type FileCatalog interface {
+	// Add records an uploaded file, replacing the record with the same ID
+	Add(ctx context.Context, file FileRecord) error
+
+	// Get returns the file with the given ID or ErrFileNotFound
+	Get(ctx context.Context, id string) (FileRecord, error)
+
+	// Update applies fn to the record of the file with the given ID and
+	// stores the result atomically, so concurrent updates are not lost. It
+	// returns the updated record, ErrFileNotFound, or the error returned by
+	// fn.
+	Update(ctx context.Context, id string, fn func(*FileRecord) error) (FileRecord, error)
+
+	// Delete removes the record of the file with the given ID or returns
+	// ErrFileNotFound
+	Delete(ctx context.Context, id string) error
+
+	// List returns the files matching the query in the requested order. Ties
+	// are broken by ID.
+	List(ctx context.Context, q FileQuery) ([]FileRecord, error)
+}
+
+// SyncFileCatalog reconciles the catalog with the blob store: files without
+// a record, such as uploads made before the catalog was kept, are added with
+// a checksum of the given algorithm, and records of files that no longer
+// exist are removed. Blobs not named <fileId>_<name>, in a shard directory
+// or not, are ignored. It returns
+// the number of records added and removed.
+func SyncFileCatalog(ctx context.Context, files FileCatalog, blobs BlobStore, algorithm string) (added, removed int, err error) {
+	stored, err := blobs.List(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	exists := make(map[string]bool, len(stored))
+	for _, blob := range stored {
+		exists[blob.Key] = true
+	}
+
+	records, err := files.List(ctx, FileQuery{})
+	if err != nil {
+		return 0, 0, err
+	}
+	// Blobs are known by the ID of their key or, if shared, by their key
+	known := make(map[string]bool, len(records))
+	for _, record := range records {
+		if !exists[record.StoredName] {
+			if err := files.Delete(ctx, record.ID); err != nil && !errors.Is(err, ErrFileNotFound) {
+				return added, removed, err
+			}
+			removed++
+			continue
+		}
+		known[record.ID] = true
+		known[record.StoredName] = true
+	}
+
+	for _, blob := range stored {
+		id, ok := uploadID(blob.Key)
+		if !ok || known[id] || known[blob.Key] {
+			continue
+		}
+		checksum, detectedType, size, err := inspectBlob(ctx, blobs, blob.Key, algorithm)
+		if errors.Is(err, ErrBlobNotFound) {
+			// Removed since the store was listed
+			continue
+		}
+		if err != nil {
+			return added, removed, err
+		}
+		// The name given by the uploader is not known; the sanitized name
+		// the file was stored under is the best approximation
+		name := strings.TrimPrefix(path.Base(blob.Key), id+"_")
+		if err := files.Add(ctx, FileRecord{
+			ID:                id,
+			Name:              name,
+			StoredName:        blob.Key,
+			Size:              size,
+			ContentType:       contentTypeOf(name, detectedType),
+			DetectedType:      detectedType,
+			Checksum:          checksum,
+			ChecksumAlgorithm: algorithm,
+			UploadedAt:        blob.ModTime.UTC().Format(time.RFC3339),
+		}); err != nil {
+			return added, removed, err
+		}
+		added++
+	}
+	return added, removed, nil
+}
+
+// MemoryFileCatalog is a FileCatalog keeping the records in a map. It is
+// safe for concurrent use. Its contents are lost when the process exits.
+type MemoryFileCatalog struct {
+	mu    sync.RWMutex
+	files map[string]FileRecord
+}
+
+// NewMemoryFileCatalog creates an empty in-memory catalog
+func NewMemoryFileCatalog() *MemoryFileCatalog {
+	return &MemoryFileCatalog{files: make(map[string]FileRecord)}
+}
+
+func (c *MemoryFileCatalog) Add(ctx context.Context, file FileRecord) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.files[file.ID] = file
+	return nil
+}
+
+func (c *MemoryFileCatalog) Get(ctx context.Context, id string) (FileRecord, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	file, ok := c.files[id]
+	if !ok {
+		return FileRecord{}, ErrFileNotFound
+	}
+	return file, nil
+}
+
+func (c *MemoryFileCatalog) Update(ctx context.Context, id string, fn func(*FileRecord) error) (FileRecord, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	file, ok := c.files[id]
+	if !ok {
+		return FileRecord{}, ErrFileNotFound
+	}
+	if err := fn(&file); err != nil {
+		return FileRecord{}, err
+	}
+	file.ID = id
+	c.files[id] = file
+	return file, nil
+}
+
+func (c *MemoryFileCatalog) Delete(ctx context.Context, id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.files[id]; !ok {
+		return ErrFileNotFound
+	}
+	delete(c.files, id)
+	return nil
+}
+
+func (c *MemoryFileCatalog) List(ctx context.Context, q FileQuery) ([]FileRecord, error) {
+	c.mu.RLock()
+	files := make([]FileRecord, 0, len(c.files))
+	for _, f := range c.files {
+		if q.matches(f) {
+			files = append(files, f)
+		}
+	}
+	c.mu.RUnlock()
+
+	sort.Slice(files, func(i, j int) bool {
+		a, b := files[i], files[j]
+		if q.Descending {
+			a, b = b, a
+		}
+		switch {
+		case q.SortBy == "size" && a.Size != b.Size:
+			return a.Size < b.Size
+		case q.SortBy == "name" && a.Name != b.Name:
+			return a.Name < b.Name
+		case (q.SortBy == "" || q.SortBy == "uploadedAt") && a.UploadedAt != b.UploadedAt:
+			return a.UploadedAt < b.UploadedAt
+		}
+		// Ties are broken by ID so pages are stable
+		return a.ID < b.ID
+	})
+	return files, nil
+}
@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"context"
+	"encoding/xml"
+	"soap-server/soap"
+)
+
+// DeleteFileRequest represents the SOAP request for deleting an uploaded
+// file
+type DeleteFileRequest struct {
+	XMLName xml.Name `xml:"DeleteFileRequest"`
+	FileID  string   `xml:"fileId" validate:"required"`
+}
+
+// Validate checks that the file ID is a UUID as assigned on upload
+func (req DeleteFileRequest) Validate() error {
+	return validateFileID(req.FileID)
+}
+
+// DeleteFileResponse confirms the deletion of a file
+type DeleteFileResponse struct {
+	XMLName xml.Name `xml:"DeleteFileResponse"`
+	FileID  string   `xml:"fileId"`
+	Deleted bool     `xml:"deleted"`
+}
+
+// DeleteFile handles the DeleteFile SOAP operation. The file is removed from
+// the catalog, and from the blob store unless other uploads of the same
+// content share it; callers are authorized by the policy middleware.
+func DeleteFile(blobs BlobStore, files FileCatalog) func(context.Context, DeleteFileRequest) (DeleteFileResponse, error) {
+	return func(ctx context.Context, req DeleteFileRequest) (DeleteFileResponse, error) {
+		record, err := files.Get(ctx, req.FileID)
+		if err != nil {
+			return DeleteFileResponse{}, fileError(req.FileID, err)
+		}
+		if err := deleteFile(ctx, blobs, files, record); err != nil {
+			return DeleteFileResponse{}, fileError(req.FileID, err)
+		}
+
+		soap.Info(ctx, "File deleted", "file_id", req.FileID, "name", record.Name)
+		return DeleteFileResponse{FileID: req.FileID, Deleted: true}, nil
+	}
+}
+
+// deleteFile removes the record of a file, and its blob unless other
+// uploads of the same content share it. A blob that fails to be removed is
+// only logged, as the file is deleted.
+func deleteFile(ctx context.Context, blobs BlobStore, files FileCatalog, record FileRecord) error {
+	if err := files.Delete(ctx, record.ID); err != nil {
+		return err
+	}
+	if err := releaseBlob(ctx, blobs, files, record); err != nil {
+		soap.Error(ctx, "Failed to remove blob", "key", record.StoredName, soap.LogKeyError, err)
+	}
+	return nil
+}
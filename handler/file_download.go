@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"soap-server/soap"
+	"soap-server/soapfault"
+
+	"github.com/google/uuid"
+)
+
+// DownloadFileMTOMRequest represents the SOAP request for downloading an
+// uploaded file
+type DownloadFileMTOMRequest struct {
+	XMLName xml.Name `xml:"DownloadFileMTOMRequest"`
+	FileID  string   `xml:"fileId" validate:"required"`
+}
+
+// Validate checks that the file ID is a UUID as assigned on upload
+func (req DownloadFileMTOMRequest) Validate() error {
+	return validateFileID(req.FileID)
+}
+
+// DownloadFileMTOMResponse represents the SOAP response with the file
+// content, sent as an MTOM or SwA attachment or inline as base64
+type DownloadFileMTOMResponse struct {
+	XMLName     xml.Name   `xml:"DownloadFileMTOMResponse"`
+	FileID      string     `xml:"fileId"`
+	FileName    string     `xml:"fileName"`
+	ContentType string     `xml:"contentType"`
+	Size        int64      `xml:"size"`
+	FileData    BinaryData `xml:"fileData"`
+}
+
+// FileNotFoundFault is the fault detail returned when no file has the
+// requested ID
+type FileNotFoundFault struct {
+	XMLName xml.Name `xml:"FileNotFoundFault"`
+	FileID  string   `xml:"fileId"`
+}
+
+// DownloadFileMTOM handles the DownloadFileMTOM SOAP operation. Clients that
+// sent an MTOM request or accept multipart/related get the content as an
+// MTOM attachment, streamed from the blob store; others get it inline as
+// base64. Clients that sent an SwA request get an SwA response instead of
+// MTOM, as do all multipart clients if swa is set.
+func DownloadFileMTOM(blobs BlobStore, files FileCatalog, swa bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		var req DownloadFileMTOMRequest
+		if err := soap.DecodeRequest(r, &req); err != nil {
+			soap.WriteError(w, r, err)
+			return
+		}
+
+		record, err := files.Get(ctx, req.FileID)
+		if err != nil {
+			soap.WriteError(w, r, fileError(req.FileID, err))
+			return
+		}
+		file, size, err := blobs.Open(ctx, record.StoredName)
+		if err != nil {
+			soap.WriteError(w, r, fileError(req.FileID, err))
+			return
+		}
+		defer file.Close()
+
+		response := DownloadFileMTOMResponse{
+			FileID:      req.FileID,
+			FileName:    record.Name,
+			ContentType: record.ContentType,
+			Size:        size,
+		}
+
+		if soap.AcceptsMTOM(r) {
+			contentID := req.FileID + "@soap-server"
+			attachment := soap.Attachment{ContentID: contentID, ContentType: record.ContentType, Body: contextReader{ctx: ctx, r: file}}
+			write := soap.WriteMTOMResponse
+			if swa || soap.IsSwA(r) {
+				response.FileData.Href = "cid:" + contentID
+				write = soap.WriteSwAResponse
+			} else {
+				response.FileData.Include = &XOPInclude{Href: "cid:" + contentID}
+			}
+			if err := write(w, r, response, attachment); err != nil {
+				// The response has been started; the client sees a truncated message
+				soap.Error(ctx, "Multipart download failed", "file_id", req.FileID, soap.LogKeyError, err)
+				return
+			}
+		} else {
+			data, err := io.ReadAll(contextReader{ctx: ctx, r: file})
+			if err != nil {
+				soap.WriteError(w, r, fileError(req.FileID, err))
+				return
+			}
+			response.FileData.Base64 = base64.StdEncoding.EncodeToString(data)
+			if err := soap.WriteResponse(w, r, response); err != nil {
+				soap.WriteFault(w, r, soapfault.Server("Internal error", "Failed to encode response: "+err.Error()))
+				return
+			}
+		}
+
+		soap.Info(ctx, "File downloaded", "file_id", req.FileID, "name", record.Name, soap.LogKeyBytes, size,
+			"mtom", response.FileData.Include != nil, "swa", response.FileData.Href != "")
+	}
+}
+
+// validateFileID checks that a file ID is a UUID as assigned on upload.
+// Empty IDs are left to the required rule.
+func validateFileID(id string) error {
+	var errs soap.FieldErrors
+	if _, err := uuid.Parse(id); id != "" && err != nil {
+		errs.Add("fileId", "must be a UUID")
+	}
+	return errs.Err()
+}
+
+// fileError maps a storage error to a SOAP fault
+func fileError(id string, err error) error {
+	if errors.Is(err, ErrFileNotFound) || errors.Is(err, ErrBlobNotFound) {
+		return soapfault.Client("File not found", FileNotFoundFault{FileID: id})
+	}
+	return soapfault.Server("Internal error", "File storage failed: "+err.Error())
+}
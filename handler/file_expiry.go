@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"soap-server/soap"
+	"sync"
+	"time"
+)
+
+// DeleteExpiredFiles deletes the files that expired at or before now from
+// the blob store and the catalog. It returns the number of files deleted
+// and their total size.
+func DeleteExpiredFiles(ctx context.Context, files FileCatalog, blobs BlobStore, now time.Time) (deleted int, reclaimed int64, err error) {
+	expired, err := files.List(ctx, FileQuery{ExpiredBy: now})
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, record := range expired {
+		// Files deleted concurrently count as reclaimed by their deleter
+		if err := files.Delete(ctx, record.ID); errors.Is(err, ErrFileNotFound) {
+			continue
+		} else if err != nil {
+			return deleted, reclaimed, err
+		}
+		if err := releaseBlob(ctx, blobs, files, record); err != nil {
+			return deleted, reclaimed, err
+		}
+		deleted++
+		reclaimed += record.Size
+	}
+	return deleted, reclaimed, nil
+}
+
+// FileJanitor deletes expired files on a schedule
+type FileJanitor struct {
+	files    FileCatalog
+	blobs    BlobStore
+	interval time.Duration
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// StartFileJanitor starts deleting the expired files every interval, the
+// first time right away
+func StartFileJanitor(files FileCatalog, blobs BlobStore, interval time.Duration) *FileJanitor {
+	j := &FileJanitor{files: files, blobs: blobs, interval: interval, stop: make(chan struct{})}
+	j.wg.Add(1)
+	go j.run()
+	return j
+}
+
+// Close stops the janitor and waits for a running cleanup to finish
+func (j *FileJanitor) Close() {
+	close(j.stop)
+	j.wg.Wait()
+}
+
+func (j *FileJanitor) run() {
+	defer j.wg.Done()
+
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+	for {
+		j.clean()
+		select {
+		case <-j.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// clean deletes the files expired by now and logs the reclaimed space
+func (j *FileJanitor) clean() {
+	ctx := context.Background()
+	deleted, reclaimed, err := DeleteExpiredFiles(ctx, j.files, j.blobs, time.Now())
+	if err != nil {
+		soap.Error(ctx, "File cleanup failed", "deleted", deleted, soap.LogKeyError, err)
+	}
+	if deleted > 0 {
+		soap.Info(ctx, "Expired files deleted", "deleted", deleted, soap.LogKeyBytes, reclaimed)
+	}
+}
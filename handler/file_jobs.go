@@ -0,0 +1,250 @@
+package handler
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"runtime/debug"
+	"soap-server/soap"
+	"soap-server/soapfault"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Statuses of an upload job
+const (
+	JobPending    = "pending"    // Waiting for a worker
+	JobProcessing = "processing" // Being scanned, checksummed and stored
+	JobComplete   = "complete"   // The files are stored
+	JobFailed     = "failed"     // Nothing was stored; see the error
+)
+
+// DefaultUploadWorkers is the number of uploads processed at once in the
+// background unless configured otherwise
+const DefaultUploadWorkers = 4
+
+// uploadJobRetention is how long finished jobs can be queried
+const uploadJobRetention = 24 * time.Hour
+
+// UploadJob is an upload processed in the background
+type UploadJob struct {
+	ID        string
+	Owner     string // Principal that started the job, "" if anonymous
+	Status    string
+	Files     []UploadedFile   // The stored files once complete
+	Err       *soapfault.Fault // The fault the upload failed with
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// UploadJobs runs the uploads processed in the background and records
+// their status. Jobs are kept in memory by the process running them.
+type UploadJobs struct {
+	mu      sync.Mutex
+	jobs    map[string]*UploadJob
+	workers chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewUploadJobs returns an empty job registry processing up to workers
+// uploads at once; zero means DefaultUploadWorkers
+func NewUploadJobs(workers int) *UploadJobs {
+	if workers <= 0 {
+		workers = DefaultUploadWorkers
+	}
+	return &UploadJobs{jobs: map[string]*UploadJob{}, workers: make(chan struct{}, workers)}
+}
+
+// Get returns a copy of the job with the given ID
+func (j *UploadJobs) Get(id string) (UploadJob, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	job, ok := j.jobs[id]
+	if !ok {
+		return UploadJob{}, false
+	}
+	return *job, true
+}
+
+// Wait waits for the started jobs to finish
+func (j *UploadJobs) Wait() {
+	j.wg.Wait()
+}
+
+// Counts returns the number of known jobs by status
+func (j *UploadJobs) Counts() map[string]int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	counts := map[string]int{JobPending: 0, JobProcessing: 0, JobComplete: 0, JobFailed: 0}
+	for _, job := range j.jobs {
+		counts[job.Status]++
+	}
+	return counts
+}
+
+// start records a pending job and runs process in the background once a
+// worker is free. The job keeps the values of ctx, such as the principal
+// and request ID, but is not canceled with it.
+func (j *UploadJobs) start(ctx context.Context, process func(context.Context) ([]FileRecord, error)) string {
+	now := time.Now().UTC()
+	job := &UploadJob{ID: uuid.New().String(), Status: JobPending, CreatedAt: now, UpdatedAt: now}
+	if principal, ok := soap.PrincipalFromContext(ctx); ok {
+		job.Owner = principal.Name
+	}
+
+	j.mu.Lock()
+	for id, old := range j.jobs {
+		finished := old.Status == JobComplete || old.Status == JobFailed
+		if finished && now.Sub(old.UpdatedAt) > uploadJobRetention {
+			delete(j.jobs, id)
+		}
+	}
+	j.jobs[job.ID] = job
+	j.mu.Unlock()
+
+	ctx = context.WithoutCancel(ctx)
+	j.wg.Add(1)
+	go func() {
+		defer j.wg.Done()
+		j.workers <- struct{}{}
+		defer func() { <-j.workers }()
+
+		j.update(job.ID, func(job *UploadJob) { job.Status = JobProcessing })
+		records, err := runJob(ctx, process)
+		if err != nil {
+			soap.Error(ctx, "Upload job failed", "job_id", job.ID, soap.LogKeyError, err)
+			j.update(job.ID, func(job *UploadJob) {
+				job.Status = JobFailed
+				job.Err = soapfault.FromError(err)
+			})
+			return
+		}
+		var files []UploadedFile
+		for _, record := range records {
+			files = append(files, uploadedFile(record))
+		}
+		soap.Info(ctx, "Upload job complete", "job_id", job.ID, "files", len(files))
+		j.update(job.ID, func(job *UploadJob) {
+			job.Status = JobComplete
+			job.Files = files
+		})
+	}()
+	return job.ID
+}
+
+// runJob runs the process of a job. A panic fails the job with a Server
+// fault instead of bringing down the server.
+func runJob(ctx context.Context, process func(context.Context) ([]FileRecord, error)) (records []FileRecord, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			soap.Error(ctx, "Panic processing upload job", "panic", fmt.Sprint(v), "stack", string(debug.Stack()))
+			records, err = nil, soapfault.Server("Internal server error", nil)
+		}
+	}()
+	return process(ctx)
+}
+
+// update changes a job under the lock
+func (j *UploadJobs) update(id string, change func(*UploadJob)) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if job, ok := j.jobs[id]; ok {
+		change(job)
+		job.UpdatedAt = time.Now().UTC()
+	}
+}
+
+// GetUploadStatusRequest represents the SOAP request for the status of an
+// upload job
+type GetUploadStatusRequest struct {
+	XMLName xml.Name `xml:"GetUploadStatusRequest"`
+	JobID   string   `xml:"jobId" validate:"required"`
+}
+
+// Validate checks that the job ID is a UUID as assigned on upload
+func (req GetUploadStatusRequest) Validate() error {
+	var errs soap.FieldErrors
+	if _, err := uuid.Parse(req.JobID); req.JobID != "" && err != nil {
+		errs.Add("jobId", "must be a UUID")
+	}
+	return errs.Err()
+}
+
+// GetUploadStatusResponse represents the SOAP response with the status of
+// an upload job
+type GetUploadStatusResponse struct {
+	XMLName   xml.Name        `xml:"GetUploadStatusResponse"`
+	JobID     string          `xml:"jobId"`
+	Status    string          `xml:"status" xsd:"enum=pending|processing|complete|failed"`
+	CreatedAt string          `xml:"createdAt"`
+	UpdatedAt string          `xml:"updatedAt"`
+	Files     []UploadedFile  `xml:"file,omitempty"`
+	Error     *UploadJobError `xml:"error,omitempty"`
+}
+
+// UploadJobError is the fault a failed upload job would have returned
+// synchronously, with its typed detail if it has one
+type UploadJobError struct {
+	Code   string `xml:"code"`
+	Reason string `xml:"reason"`
+	Detail string `xml:"detail,omitempty"` // Text detail
+
+	ChecksumMismatch   *ChecksumMismatchFault   `xml:"ChecksumMismatchFault,omitempty"`
+	FileTypeNotAllowed *FileTypeNotAllowedFault `xml:"FileTypeNotAllowedFault,omitempty"`
+	MalwareDetected    *MalwareDetectedFault    `xml:"MalwareDetectedFault,omitempty"`
+	QuotaExceeded      *QuotaExceededFault      `xml:"QuotaExceededFault,omitempty"`
+}
+
+// UploadJobNotFoundFault is the fault detail returned when no job has the
+// requested ID
+type UploadJobNotFoundFault struct {
+	XMLName xml.Name `xml:"UploadJobNotFoundFault"`
+	JobID   string   `xml:"jobId"`
+}
+
+// GetUploadStatus handles the GetUploadStatus SOAP operation. Jobs started
+// by an authenticated client are only reported to that client.
+func GetUploadStatus(jobs *UploadJobs) func(context.Context, GetUploadStatusRequest) (GetUploadStatusResponse, error) {
+	return func(ctx context.Context, req GetUploadStatusRequest) (GetUploadStatusResponse, error) {
+		job, ok := jobs.Get(req.JobID)
+		if ok && job.Owner != "" {
+			principal, _ := soap.PrincipalFromContext(ctx)
+			ok = principal.Name == job.Owner
+		}
+		if !ok {
+			return GetUploadStatusResponse{}, soapfault.Client("Upload job not found", UploadJobNotFoundFault{JobID: req.JobID})
+		}
+
+		response := GetUploadStatusResponse{
+			JobID:     job.ID,
+			Status:    job.Status,
+			CreatedAt: job.CreatedAt.Format(time.RFC3339),
+			UpdatedAt: job.UpdatedAt.Format(time.RFC3339),
+			Files:     job.Files,
+		}
+		if job.Err != nil {
+			response.Error = uploadJobError(job.Err)
+		}
+		return response, nil
+	}
+}
+
+// uploadJobError describes the fault of a failed job
+func uploadJobError(fault *soapfault.Fault) *UploadJobError {
+	jobErr := &UploadJobError{Code: string(fault.Code), Reason: fault.Reason}
+	switch detail := fault.Detail.(type) {
+	case string:
+		jobErr.Detail = detail
+	case ChecksumMismatchFault:
+		jobErr.ChecksumMismatch = &detail
+	case FileTypeNotAllowedFault:
+		jobErr.FileTypeNotAllowed = &detail
+	case MalwareDetectedFault:
+		jobErr.MalwareDetected = &detail
+	case QuotaExceededFault:
+		jobErr.QuotaExceeded = &detail
+	}
+	return jobErr
+}
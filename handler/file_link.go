@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/url"
+	"soap-server/soap"
+	"soap-server/soapfault"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DownloadLinksPrefix is the path of the pre-signed downloads, as
+// DownloadLinksPrefix + <fileId>?expires=<unix time>&signature=<HMAC>
+const DownloadLinksPrefix = "/downloads/"
+
+// Lifetimes of the download links
+const (
+	DefaultLinkTTL    = 15 * time.Minute
+	DefaultLinkMaxTTL = 24 * time.Hour
+)
+
+// errLinkExpired is returned for links past their expiry
+var errLinkExpired = errors.New("link expired")
+
+// DownloadLinks signs and checks the URLs of downloads that need no
+// authentication until they expire, for browsers and third parties
+type DownloadLinks struct {
+	key []byte
+
+	// BaseURL is prepended to the paths of the links, e.g.
+	// "https://files.example.com". Links are relative paths without it.
+	BaseURL string
+
+	// MaxTTL caps the lifetime of the links. Defaults to DefaultLinkMaxTTL.
+	MaxTTL time.Duration
+}
+
+// NewDownloadLinks returns links signed with HMAC-SHA256 under the key,
+// which must be kept secret
+func NewDownloadLinks(key []byte) *DownloadLinks {
+	return &DownloadLinks{key: key}
+}
+
+// URL returns the signed URL of the file, valid until expires
+func (l *DownloadLinks) URL(id string, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	query := url.Values{"expires": {exp}, "signature": {l.sign(id, exp)}}
+	return strings.TrimSuffix(l.BaseURL, "/") + DownloadLinksPrefix + url.PathEscape(id) + "?" + query.Encode()
+}
+
+// sign returns the signature of a link
+func (l *DownloadLinks) sign(id, expires string) string {
+	mac := hmac.New(sha256.New, l.key)
+	mac.Write([]byte(id + "\n" + expires))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verify checks the signature and expiry of a link at now
+func (l *DownloadLinks) verify(id, expires, signature string, now time.Time) error {
+	given, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return errors.New("malformed signature")
+	}
+	expected, _ := base64.RawURLEncoding.DecodeString(l.sign(id, expires))
+	if !hmac.Equal(given, expected) {
+		return errors.New("invalid signature")
+	}
+	exp, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return errors.New("malformed expiry")
+	}
+	if !now.Before(time.Unix(exp, 0)) {
+		return errLinkExpired
+	}
+	return nil
+}
+
+// ttl returns the lifetime of a link requested for the given seconds
+func (l *DownloadLinks) ttl(seconds int) time.Duration {
+	maxTTL := l.MaxTTL
+	if maxTTL <= 0 {
+		maxTTL = DefaultLinkMaxTTL
+	}
+	ttl := DefaultLinkTTL
+	if seconds > 0 {
+		ttl = time.Duration(seconds) * time.Second
+	}
+	return min(ttl, maxTTL)
+}
+
+// CreateDownloadLinkRequest represents the SOAP request for a pre-signed
+// download URL of a file
+type CreateDownloadLinkRequest struct {
+	XMLName    xml.Name `xml:"CreateDownloadLinkRequest"`
+	FileID     string   `xml:"fileId" validate:"required"`
+	TTLSeconds int      `xml:"ttlSeconds,omitempty" validate:"min=0"`
+}
+
+// Validate checks that the file ID is a UUID as assigned on upload
+func (req CreateDownloadLinkRequest) Validate() error {
+	return validateFileID(req.FileID)
+}
+
+// CreateDownloadLinkResponse represents the SOAP response with the URL
+type CreateDownloadLinkResponse struct {
+	XMLName   xml.Name `xml:"CreateDownloadLinkResponse"`
+	FileID    string   `xml:"fileId"`
+	URL       string   `xml:"url"`
+	ExpiresAt string   `xml:"expiresAt"`
+}
+
+// CreateDownloadLink handles the CreateDownloadLink SOAP operation. The
+// link is valid for the requested time, DefaultLinkTTL by default, capped
+// by the maximum of the links; anyone holding it can download the file.
+func CreateDownloadLink(links *DownloadLinks, files FileCatalog) func(context.Context, CreateDownloadLinkRequest) (CreateDownloadLinkResponse, error) {
+	return func(ctx context.Context, req CreateDownloadLinkRequest) (CreateDownloadLinkResponse, error) {
+		if links == nil {
+			return CreateDownloadLinkResponse{}, soapfault.Server("Download links disabled", "No download link key is configured")
+		}
+		if _, err := files.Get(ctx, req.FileID); err != nil {
+			return CreateDownloadLinkResponse{}, fileError(req.FileID, err)
+		}
+
+		expires := time.Now().Add(links.ttl(req.TTLSeconds)).Truncate(time.Second)
+		soap.Info(ctx, "Download link created", "file_id", req.FileID, "expires", expires.UTC().Format(time.RFC3339))
+		return CreateDownloadLinkResponse{
+			FileID:    req.FileID,
+			URL:       links.URL(req.FileID, expires),
+			ExpiresAt: expires.UTC().Format(time.RFC3339),
+		}, nil
+	}
+}
+
+// ServeDownloadLinks serves the files of valid download links without
+// authentication, like ServeUploads. Links with an invalid signature are
+// answered with 403, expired ones with 410.
+func ServeDownloadLinks(links *DownloadLinks, blobs BlobStore, files FileCatalog) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		ctx := r.Context()
+		id := strings.TrimPrefix(r.URL.Path, DownloadLinksPrefix)
+		query := r.URL.Query()
+		if err := links.verify(id, query.Get("expires"), query.Get("signature"), time.Now()); err != nil {
+			soap.Warn(ctx, "Download link rejected", "file_id", id, soap.LogKeyError, err)
+			if errors.Is(err, errLinkExpired) {
+				http.Error(w, "Link expired", http.StatusGone)
+				return
+			}
+			http.Error(w, "Access denied", http.StatusForbidden)
+			return
+		}
+
+		record, err := files.Get(ctx, id)
+		if errors.Is(err, ErrFileNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			soap.Error(ctx, "Failed to serve file", "file_id", id, soap.LogKeyError, err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		serveFile(w, r, blobs, record)
+	})
+}
@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"context"
+	"encoding/xml"
+	"soap-server/soapfault"
+)
+
+// defaultListLimit is the page size of ListFiles requests without a limit
+const defaultListLimit = 100
+
+// ListFilesRequest represents the SOAP request for listing uploaded files.
+// Files are sorted by upload time unless sortBy is given.
+type ListFilesRequest struct {
+	XMLName   xml.Name `xml:"ListFilesRequest"`
+	Offset    int      `xml:"offset,omitempty" validate:"min=0"`
+	Limit     int      `xml:"limit,omitempty" validate:"min=0,max=1000"`
+	SortBy    string   `xml:"sortBy,omitempty" xsd:"enum=uploadedAt|size|name" validate:"oneof=uploadedAt|size|name"`
+	SortOrder string   `xml:"sortOrder,omitempty" xsd:"enum=asc|desc" validate:"oneof=asc|desc"`
+}
+
+// ListFilesResponse represents the SOAP response with a page of files and
+// the total number of files
+type ListFilesResponse struct {
+	XMLName xml.Name   `xml:"ListFilesResponse"`
+	Total   int        `xml:"total"`
+	Files   []FileInfo `xml:"file"`
+}
+
+// FileInfo is the metadata of an uploaded file
+type FileInfo struct {
+	FileID            string `xml:"fileId"`
+	FileName          string `xml:"fileName"`
+	Size              int64  `xml:"size"`
+	ContentType       string `xml:"contentType"`
+	DetectedType      string `xml:"detectedType,omitempty"` // Media type detected from the content, if known
+	Checksum          string `xml:"checksum"`               // Hex-encoded digest of the content
+	ChecksumAlgorithm string `xml:"checksumAlgorithm"`
+	Uploader          string `xml:"uploader,omitempty"`  // Principal that uploaded the file, if known
+	UploadedAt        string `xml:"uploadedAt"`          // RFC 3339 time of the upload
+	ExpiresAt         string `xml:"expiresAt,omitempty"` // RFC 3339 time after which the file is deleted, if it expires
+}
+
+// fileInfo returns the metadata element of a catalog record
+func fileInfo(f FileRecord) FileInfo {
+	return FileInfo{
+		FileID:            f.ID,
+		FileName:          f.Name,
+		Size:              f.Size,
+		ContentType:       f.ContentType,
+		DetectedType:      f.DetectedType,
+		Checksum:          f.Checksum,
+		ChecksumAlgorithm: f.ChecksumAlgorithm,
+		Uploader:          f.Uploader,
+		UploadedAt:        f.UploadedAt,
+		ExpiresAt:         f.ExpiresAt,
+	}
+}
+
+// ListFiles handles the ListFiles SOAP operation
+func ListFiles(files FileCatalog) func(context.Context, ListFilesRequest) (ListFilesResponse, error) {
+	return func(ctx context.Context, req ListFilesRequest) (ListFilesResponse, error) {
+		found, err := files.List(ctx, FileQuery{SortBy: req.SortBy, Descending: req.SortOrder == "desc"})
+		if err != nil {
+			return ListFilesResponse{}, soapfault.Server("Internal error", "File catalog failed: "+err.Error())
+		}
+
+		limit := req.Limit
+		if limit == 0 {
+			limit = defaultListLimit
+		}
+		response := ListFilesResponse{Total: len(found)}
+		if req.Offset >= len(found) {
+			return response, nil
+		}
+		found = found[req.Offset:]
+		if len(found) > limit {
+			found = found[:limit]
+		}
+		for _, f := range found {
+			response.Files = append(response.Files, fileInfo(f))
+		}
+		return response, nil
+	}
+}
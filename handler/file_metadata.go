@@ -0,0 +1,37 @@
+package handler
+
+import (
+	"context"
+	"encoding/xml"
+)
+
+// GetFileMetadataRequest represents the SOAP request for the metadata of an
+// uploaded file
+type GetFileMetadataRequest struct {
+	XMLName xml.Name `xml:"GetFileMetadataRequest"`
+	FileID  string   `xml:"fileId" validate:"required"`
+}
+
+// Validate checks that the file ID is a UUID as assigned on upload
+func (req GetFileMetadataRequest) Validate() error {
+	return validateFileID(req.FileID)
+}
+
+// GetFileMetadataResponse represents the SOAP response with the metadata of
+// a file
+type GetFileMetadataResponse struct {
+	XMLName xml.Name `xml:"GetFileMetadataResponse"`
+	File    FileInfo `xml:"file"`
+}
+
+// GetFileMetadata handles the GetFileMetadata SOAP operation. Clients can
+// compare the checksum with their copy without downloading the content.
+func GetFileMetadata(files FileCatalog) func(context.Context, GetFileMetadataRequest) (GetFileMetadataResponse, error) {
+	return func(ctx context.Context, req GetFileMetadataRequest) (GetFileMetadataResponse, error) {
+		record, err := files.Get(ctx, req.FileID)
+		if err != nil {
+			return GetFileMetadataResponse{}, fileError(req.FileID, err)
+		}
+		return GetFileMetadataResponse{File: fileInfo(record)}, nil
+	}
+}
@@ -2,132 +2,506 @@ package handler
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/xml"
 	"fmt"
 	"io"
 	"mime"
 	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
-	"path/filepath"
-	"regexp"
+	"soap-server/soap"
+	"soap-server/soapfault"
 	"strings"
 	"time"
-
-	"github.com/google/uuid"
 )
 
 // UploadFileMTOMRequest represents the SOAP request for uploading a file via MTOM
 type UploadFileMTOMRequest struct {
-	XMLName  xml.Name `xml:"http://example.com/soap/user UploadFileMTOMRequest"`
-	FileName string   `xml:"fileName"`
-	FileData string   `xml:"fileData"` // Can be base64 or XOP include reference
+	XMLName  xml.Name   `xml:"UploadFileMTOMRequest"`
+	FileName string     `xml:"fileName,omitempty"`
+	FileData BinaryData `xml:"fileData,omitempty"` // Base64, xop:Include or SwA href
+
+	// Checksum of the content computed by the client, verified on upload
+	Checksum          string `xml:"checksum,omitempty"`
+	ChecksumAlgorithm string `xml:"checksumAlgorithm,omitempty" xsd:"enum=md5|sha1|sha256|sha384|sha512" validate:"oneof=md5|sha1|sha256|sha384|sha512"`
+
+	// Lifetime of the file in seconds, instead of the server default
+	TTLSeconds int `xml:"ttlSeconds,omitempty" validate:"min=0"`
+
+	// Files uploaded by the same request, each with its own attachment. The
+	// file above may be left out when there are entries.
+	Files []UploadFileEntry `xml:"file,omitempty"`
+}
+
+// UploadFileEntry is one of the files of an UploadFileMTOM request
+type UploadFileEntry struct {
+	FileName          string     `xml:"fileName" validate:"required"`
+	FileData          BinaryData `xml:"fileData"`
+	Checksum          string     `xml:"checksum,omitempty"`
+	ChecksumAlgorithm string     `xml:"checksumAlgorithm,omitempty" xsd:"enum=md5|sha1|sha256|sha384|sha512" validate:"oneof=md5|sha1|sha256|sha384|sha512"`
+}
+
+// Validate checks the format of the expected checksums
+func (req UploadFileMTOMRequest) Validate() error {
+	if err := validateChecksum(req.Checksum); err != nil {
+		return err
+	}
+	var errs soap.FieldErrors
+	for i, file := range req.Files {
+		if _, err := hex.DecodeString(file.Checksum); err != nil {
+			errs.Add(fmt.Sprintf("file[%d].checksum", i), "must be hex-encoded")
+		}
+	}
+	return errs.Err()
 }
 
 // UploadFileMTOMResponse represents the SOAP response for MTOM file upload
 type UploadFileMTOMResponse struct {
-	XMLName  xml.Name `xml:"http://example.com/soap/user UploadFileMTOMResponse"`
+	XMLName  xml.Name `xml:"UploadFileMTOMResponse"`
 	FileID   string   `xml:"fileId"`
 	FileName string   `xml:"fileName"`
 	Size     int64    `xml:"size"`
 	Path     string   `xml:"path"`
+
+	// Digest of the stored content, for end-to-end integrity checks
+	Checksum          string `xml:"checksum"`
+	ChecksumAlgorithm string `xml:"checksumAlgorithm"`
+
+	// RFC 3339 time after which the file is deleted, if it expires
+	ExpiresAt string `xml:"expiresAt,omitempty"`
+
+	// Set if the content was already stored and the upload shares it
+	Deduplicated bool `xml:"deduplicated,omitempty"`
+
+	// The stored files of requests with file entries, in request order;
+	// the elements above describe the first of them
+	Files []UploadedFile `xml:"file,omitempty"`
+
+	// Background job storing the files when uploads are asynchronous; the
+	// elements above only give the name and size of the first file until
+	// it is complete
+	JobID string `xml:"jobId,omitempty"`
+}
+
+// UploadedFile is a file stored by an UploadFileMTOM request with file
+// entries
+type UploadedFile struct {
+	FileID            string `xml:"fileId"`
+	FileName          string `xml:"fileName"`
+	Size              int64  `xml:"size"`
+	Path              string `xml:"path"`
+	Checksum          string `xml:"checksum"`
+	ChecksumAlgorithm string `xml:"checksumAlgorithm"`
+	ExpiresAt         string `xml:"expiresAt,omitempty"`
+	Deduplicated      bool   `xml:"deduplicated,omitempty"`
 }
 
+// uploadedFile describes a stored file
+func uploadedFile(record FileRecord) UploadedFile {
+	return UploadedFile{
+		FileID:            record.ID,
+		FileName:          record.Name,
+		Size:              record.Size,
+		Path:              uploadPath(record.uploadKey()),
+		Checksum:          record.Checksum,
+		ChecksumAlgorithm: record.ChecksumAlgorithm,
+		ExpiresAt:         record.ExpiresAt,
+		Deduplicated:      record.sharesBlob(),
+	}
+}
+
+// xopNamespace is the namespace of the xop:Include element
+const xopNamespace = "http://www.w3.org/2004/08/xop/include"
+
 // XOPInclude represents an XOP Include element for MTOM
 type XOPInclude struct {
 	XMLName   xml.Name `xml:"http://www.w3.org/2004/08/xop/include Include"`
 	Href      string   `xml:"href,attr"`
-	ContentID string   // Extracted from href (e.g., "cid:example" -> "example")
+	ContentID string   `xml:"-"` // Extracted from href (e.g., "cid:example" -> "example")
+}
+
+// BinaryData is binary content: base64 encoded text, an xop:Include
+// referencing an MTOM attachment, or an href attribute referencing an SwA
+// attachment. The xop:Include is matched by namespace, whatever its prefix.
+type BinaryData struct {
+	Include *XOPInclude `xml:"http://www.w3.org/2004/08/xop/include Include"`
+	Href    string      `xml:"href,attr,omitempty"` // SwA reference: "cid:" URL or Content-Location
+	Base64  string      `xml:",chardata"`
+}
+
+// ref returns the attachment reference of the data, "" if it is inline
+func (b BinaryData) ref() string {
+	if b.Include != nil {
+		return b.Include.Href
+	}
+	return b.Href
+}
+
+// empty reports whether the data has neither a reference nor content
+func (b BinaryData) empty() bool {
+	return b.ref() == "" && strings.TrimSpace(b.Base64) == ""
+}
+
+// MarshalXML writes the xop:Include without indentation, as XOP requires it
+// to be the only child of its element
+func (b BinaryData) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if b.Include == nil {
+		if b.Href != "" {
+			start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "href"}, Value: b.Href})
+		}
+		return e.EncodeElement(b.Base64, start)
+	}
+	var href bytes.Buffer
+	if err := xml.EscapeText(&href, []byte(b.Include.Href)); err != nil {
+		return err
+	}
+	include := struct {
+		XML string `xml:",innerxml"`
+	}{`<xop:Include xmlns:xop="http://www.w3.org/2004/08/xop/include" href="` + href.String() + `"/>`}
+	return e.EncodeElement(include, start)
 }
 
-// MultipartPart represents a parsed MIME part
+// MultipartPart represents a parsed MIME part, spooled to a temporary file
 type MultipartPart struct {
-	ContentID string
-	ContentType string
-	Data []byte
+	ContentID       string
+	ContentType     string
+	ContentLocation string
+	Path            string // Temporary file holding the part content
+	Size            int64
+}
+
+// findPart returns the attachment an href refers to: "cid:" URLs by
+// Content-ID, other references by Content-Location, as SwA clients may
+// use either
+func findPart(parts []MultipartPart, href string) (MultipartPart, bool) {
+	id, ok := cidContentID(href)
+	for _, part := range parts {
+		if ok && part.ContentID == id ||
+			!ok && part.ContentLocation != "" && part.ContentLocation == href {
+			return part, true
+		}
+	}
+	return MultipartPart{}, false
+}
+
+// cidContentID returns the Content-ID a "cid:" URL refers to. Per RFC 2392
+// the scheme is case-insensitive and the ID is percent-encoded in the URL.
+func cidContentID(href string) (string, bool) {
+	if len(href) < 4 || !strings.EqualFold(href[:4], "cid:") {
+		return "", false
+	}
+	id, err := url.PathUnescape(href[4:])
+	if err != nil {
+		// Malformed escapes are taken literally
+		return href[4:], true
+	}
+	return id, true
+}
+
+// checkIncludes checks that every xop:Include in the Body of an envelope
+// refers to an attachment, including those outside the elements the
+// operation reads
+func checkIncludes(ctx context.Context, envelope []byte, parts []MultipartPart) error {
+	decoder := soap.NewDecoder(ctx, bytes.NewReader(envelope))
+	depth, bodyDepth := 0, 0
+	var envelopeSpace string
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("XML parse error: %w", err)
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			if depth == 1 {
+				envelopeSpace = t.Name.Space
+			}
+			if bodyDepth == 0 && depth == 2 && t.Name.Space == envelopeSpace && t.Name.Local == "Body" {
+				bodyDepth = depth
+			}
+			if bodyDepth > 0 && t.Name.Space == xopNamespace && t.Name.Local == "Include" {
+				href := ""
+				for _, attr := range t.Attr {
+					if attr.Name.Space == "" && attr.Name.Local == "href" {
+						href = attr.Value
+					}
+				}
+				if _, found := findPart(parts, href); !found {
+					return fmt.Errorf("attachment reference not found: %s", href)
+				}
+			}
+		case xml.EndElement:
+			if depth == bodyDepth {
+				bodyDepth = 0
+			}
+			depth--
+		}
+	}
+}
+
+// mtomFile is a file of an UploadFileMTOM request with its content: an
+// attachment part of MTOM and SwA requests, or decoded base64 data
+type mtomFile struct {
+	Name     string
+	Expected expectedChecksum
+	Part     MultipartPart
+	Data     []byte
+}
+
+func (f mtomFile) size() int64 {
+	if f.Part.Path != "" {
+		return f.Part.Size
+	}
+	return int64(len(f.Data))
+}
+
+func (f mtomFile) open() (io.ReadCloser, error) {
+	if f.Part.Path != "" {
+		return os.Open(f.Part.Path)
+	}
+	return io.NopCloser(bytes.NewReader(f.Data)), nil
+}
+
+// requestFiles returns the files of a request: the file given by the
+// request elements unless it was left out for entries, followed by the
+// entries, each with the content it refers to
+func requestFiles(req UploadFileMTOMRequest, parts []MultipartPart) ([]mtomFile, error) {
+	var files []mtomFile
+	if len(req.Files) == 0 || req.FileName != "" || !req.FileData.empty() {
+		file, err := binaryFile(req.FileName, req.FileData, parts)
+		if err != nil {
+			return nil, err
+		}
+		file.Expected = expectedChecksum{Algorithm: req.ChecksumAlgorithm, Value: req.Checksum}
+		files = append(files, file)
+	}
+	for _, entry := range req.Files {
+		file, err := binaryFile(entry.FileName, entry.FileData, parts)
+		if err != nil {
+			return nil, err
+		}
+		file.Expected = expectedChecksum{Algorithm: entry.ChecksumAlgorithm, Value: entry.Checksum}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// binaryFile returns a file with the attachment its data refers to, or its
+// decoded inline content
+func binaryFile(name string, data BinaryData, parts []MultipartPart) (mtomFile, error) {
+	if href := data.ref(); href != "" {
+		part, found := findPart(parts, href)
+		if !found {
+			return mtomFile{}, fmt.Errorf("attachment reference not found: %s", href)
+		}
+		return mtomFile{Name: name, Part: part}, nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(data.Base64))
+	if err != nil {
+		return mtomFile{}, fmt.Errorf("base64 decode error: %w", err)
+	}
+	return mtomFile{Name: name, Data: decoded}, nil
+}
+
+// removeParts deletes the temporary files of the parts
+func removeParts(parts []MultipartPart) {
+	for _, part := range parts {
+		os.Remove(part.Path)
+	}
 }
 
-// UploadFileMTOM handles the UploadFileMTOM SOAP operation with MTOM/XOP support
-func UploadFileMTOM(uploadDir string) http.HandlerFunc {
+// UploadFileMTOM handles the UploadFileMTOM SOAP operation with MTOM/XOP
+// support. Files are stored as configured by cfg, like UploadFile. Background
+// jobs take over the spooled attachments and remove them when done.
+func UploadFileMTOM(cfg Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
 		contentType := r.Header.Get("Content-Type")
 
-		fmt.Printf("[%s] MTOM Request - ContentType: %s\n",
-			time.Now().Format("2006-01-02 15:04:05"), contentType)
+		soap.Debug(ctx, "MTOM request", "content_type", contentType)
 
-		var fileName string
-		var fileData []byte
-		var err error
+		var req UploadFileMTOMRequest
+		var files []mtomFile
+		var parts []MultipartPart
+		defer func() { removeParts(parts) }()
 
-		// Check if this is a MTOM multipart/related request
+		// Check if this is a MTOM or SwA multipart/related request
 		if strings.HasPrefix(contentType, "multipart/related") {
-			fileName, fileData, err = parseMTOMRequest(r)
+			// Attachments are spooled to temporary files, so large uploads
+			// are not held in memory
+			soapPart, attachments, err := readMTOMParts(r)
 			if err != nil {
-				sendSOAPError(w, "Client", "Invalid MTOM request", err.Error())
+				soap.WriteFault(w, r, mtomFault(err))
+				return
+			}
+			parts = attachments
+
+			req, files, err = parseMTOMRequest(r, soapPart, parts)
+			if fault, ok := soapfault.As(err); ok {
+				soap.WriteFault(w, r, fault)
+				return
+			} else if err != nil {
+				soap.WriteFault(w, r, soapfault.Client("Invalid MTOM request", err.Error()))
 				return
 			}
 		} else {
 			// Fallback to regular SOAP with base64 (for non-MTOM clients)
-			fileName, fileData, err = parseBase64SOAPRequest(r)
-			if err != nil {
-				sendSOAPError(w, "Client", "Invalid SOAP request", err.Error())
+			var err error
+			req, files, err = parseBase64SOAPRequest(r)
+			if fault, ok := soapfault.As(err); ok {
+				soap.WriteFault(w, r, fault)
+				return
+			} else if fault, ok := soap.LimitFault(err); ok {
+				soap.WriteFault(w, r, fault)
+				return
+			} else if err != nil {
+				soap.WriteFault(w, r, soapfault.Client("Invalid SOAP request", err.Error()))
 				return
 			}
 		}
 
 		// Validate input
-		if fileName == "" {
-			sendSOAPError(w, "Client", "Invalid input", "File name is required")
+		if err := soap.ValidateRequest(req); err != nil {
+			soap.WriteError(w, r, err)
 			return
 		}
 
-		if len(fileData) == 0 {
-			sendSOAPError(w, "Client", "Invalid input", "File data is required")
-			return
+		for _, file := range files {
+			if file.Name == "" {
+				soap.WriteFault(w, r, soapfault.Client("Invalid input", "File name is required"))
+				return
+			}
+
+			if file.size() == 0 {
+				soap.WriteFault(w, r, soapfault.Client("Invalid input", "File data is required"))
+				return
+			}
 		}
 
-		// Generate unique file ID
-		fileID := uuid.New().String()
+		ttl := time.Duration(req.TTLSeconds) * time.Second
+		if cfg.AsyncUploads {
+			jobParts := parts
+			parts = nil
+			jobID := cfg.Jobs.start(ctx, func(ctx context.Context) ([]FileRecord, error) {
+				defer removeParts(jobParts)
+				return storeMTOMFiles(ctx, cfg, files, ttl)
+			})
+			soap.Info(ctx, "MTOM file upload queued", "job_id", jobID, "files", len(files))
 
-		// Create upload directory if it doesn't exist
-		if err := os.MkdirAll(uploadDir, 0755); err != nil {
-			sendSOAPError(w, "Server", "Internal error", "Failed to create upload directory: "+err.Error())
+			response := UploadFileMTOMResponse{FileName: files[0].Name, Size: files[0].size(), JobID: jobID}
+			if err := soap.WriteResponse(w, r, response); err != nil {
+				soap.WriteFault(w, r, soapfault.Server("Internal error", "Failed to encode response: "+err.Error()))
+			}
 			return
 		}
 
-		// Sanitize filename and create file path
-		safeFileName := sanitizeFileName(fileName)
-		uniqueFileName := fmt.Sprintf("%s_%s", fileID, safeFileName)
-		filePath := filepath.Join(uploadDir, uniqueFileName)
-
-		// Write file to disk
-		if err := os.WriteFile(filePath, fileData, 0644); err != nil {
-			sendSOAPError(w, "Server", "Internal error", "Failed to save file: "+err.Error())
+		// Write the files to disk and record them in the catalog
+		records, err := storeMTOMFiles(ctx, cfg, files, ttl)
+		if err != nil {
+			soap.WriteError(w, r, err)
 			return
 		}
 
-		// Get file size
-		fileSize := int64(len(fileData))
-
 		// Create response
+		record := records[0]
 		response := UploadFileMTOMResponse{
-			FileID:   fileID,
-			FileName: fileName,
-			Size:     fileSize,
-			Path:     fmt.Sprintf("/uploads/%s", uniqueFileName),
+			FileID:   record.ID,
+			FileName: record.Name,
+			Size:     record.Size,
+			Path:     uploadPath(record.uploadKey()),
+
+			Checksum:          record.Checksum,
+			ChecksumAlgorithm: record.ChecksumAlgorithm,
+			ExpiresAt:         record.ExpiresAt,
+			Deduplicated:      record.sharesBlob(),
 		}
+		if len(req.Files) > 0 {
+			for _, record := range records {
+				response.Files = append(response.Files, uploadedFile(record))
+			}
+		}
+
+		if err := soap.WriteResponse(w, r, response); err != nil {
+			soap.WriteFault(w, r, soapfault.Server("Internal error", "Failed to encode response: "+err.Error()))
+			return
+		}
+
+		// Log the uploads
+		for _, record := range records {
+			soap.Info(ctx, "MTOM file uploaded", "file_id", record.ID, "name", record.Name, soap.LogKeyBytes, record.Size,
+				"key", record.StoredName)
+		}
+	}
+}
 
-		sendSOAPResponse(w, "UploadFileMTOMResponse", response)
+// storeMTOMFiles stores the files of an UploadFileMTOM request together:
+// when one fails, those already stored are released
+func storeMTOMFiles(ctx context.Context, cfg Config, files []mtomFile, ttl time.Duration) ([]FileRecord, error) {
+	var records []FileRecord
+	for _, file := range files {
+		record, err := storeMTOMFile(ctx, cfg, file, ttl)
+		if err != nil {
+			for _, stored := range records {
+				if err := deleteFile(context.WithoutCancel(ctx), cfg.Blobs, cfg.Files, stored); err != nil {
+					soap.Error(ctx, "Failed to release file", "file_id", stored.ID, soap.LogKeyError, err)
+				}
+			}
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
 
-		// Log the upload
-		fmt.Printf("[%s] MTOM File uploaded: ID=%s, Name=%s, Size=%d bytes, Path=%s\n",
-			time.Now().Format("2006-01-02 15:04:05"), fileID, fileName, fileSize, filePath)
+// storeMTOMFile stores a file of an UploadFileMTOM request like storeUpload
+func storeMTOMFile(ctx context.Context, cfg Config, file mtomFile, ttl time.Duration) (FileRecord, error) {
+	content, err := file.open()
+	if err != nil {
+		return FileRecord{}, soapfault.Server("Internal error", "Failed to read attachment: "+err.Error())
 	}
+	defer content.Close()
+	return storeUpload(ctx, cfg, file.Name, content, uploadOptions{Expected: file.Expected, TTL: ttl})
 }
 
-// parseMTOMRequest parses a MTOM multipart/related SOAP request
-func parseMTOMRequest(r *http.Request) (string, []byte, error) {
+// parseMTOMRequest parses the SOAP envelope of a MTOM or SwA
+// multipart/related request and returns the request and its files, each
+// with the attachment part holding its data
+func parseMTOMRequest(r *http.Request, soapPart string, parts []MultipartPart) (UploadFileMTOMRequest, []mtomFile, error) {
+	// Parse the SOAP envelope to extract the request
+	req, err := parseMTOMSOAPEnvelope(r, soapPart)
+	if err != nil {
+		return UploadFileMTOMRequest{}, nil, err
+	}
+	if err := checkIncludes(r.Context(), []byte(soapPart), parts); err != nil {
+		return UploadFileMTOMRequest{}, nil, err
+	}
+
+	// Requests with file entries may leave out the file of the request
+	// elements
+	if req.FileData.empty() && len(req.Files) == 0 {
+		return UploadFileMTOMRequest{}, nil, fmt.Errorf("no file data found in MTOM request")
+	}
+
+	// Resolve the references to the attachment parts
+	files, err := requestFiles(req, parts)
+	if err != nil {
+		return UploadFileMTOMRequest{}, nil, err
+	}
+	return req, files, nil
+}
+
+// readMTOMParts reads a multipart/related request, MTOM or SwA, and returns
+// the SOAP envelope part and the attachment parts. The body is read as a stream:
+// only the envelope is kept in memory, attachments are written to temporary
+// files the caller removes with removeParts.
+func readMTOMParts(r *http.Request) (string, []MultipartPart, error) {
 	contentType := r.Header.Get("Content-Type")
 
 	// Parse the Content-Type header to get the boundary
@@ -140,26 +514,25 @@ func parseMTOMRequest(r *http.Request) (string, []byte, error) {
 	if !ok {
 		return "", nil, fmt.Errorf("boundary not found in content-type")
 	}
+	start := strings.Trim(params["start"], "<>")
 
-	// Read the entire body
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		return "", nil, fmt.Errorf("failed to read request body: %w", err)
-	}
-
-	// Parse multipart
-	mr := multipart.NewReader(bytes.NewReader(body), boundary)
+	// Parse multipart within the request limits, aborting if the client
+	// goes away
+	mr := soap.NewPartReader(r.Context(), contextReader{ctx: r.Context(), r: r.Body}, boundary)
 
 	var parts []MultipartPart
 	var soapPart string
+	rootFound := false
 
-	// Read all parts
+	// Read all parts; parts already spooled are removed when a later part
+	// fails
 	for {
-		part, err := mr.NextPart()
+		part, raw, err := mr.NextPart()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
+			removeParts(parts)
 			return "", nil, fmt.Errorf("failed to read multipart part: %w", err)
 		}
 
@@ -169,117 +542,116 @@ func parseMTOMRequest(r *http.Request) (string, []byte, error) {
 
 		partContentType := part.Header.Get("Content-Type")
 
-		data, err := io.ReadAll(part)
+		// The envelope is the root part (RFC 2387): the one named by the
+		// start parameter, or else the first XML part, as SwA messages may
+		// carry XML attachments as well
+		isRoot := start != "" && contentID == start
+		if start == "" && !rootFound {
+			isRoot = strings.Contains(partContentType, "application/xop+xml") ||
+				strings.Contains(partContentType, "text/xml") ||
+				strings.Contains(partContentType, "application/soap+xml")
+		}
+
+		content, err := partContent(part, raw)
 		if err != nil {
 			part.Close()
-			return "", nil, fmt.Errorf("failed to read part data: %w", err)
+			removeParts(parts)
+			return "", nil, err
 		}
-		part.Close()
 
-		if strings.Contains(partContentType, "application/xop+xml") ||
-		   strings.Contains(partContentType, "text/xml") ||
-		   strings.Contains(partContentType, "application/soap+xml") {
+		if isRoot {
 			// This is the SOAP envelope part
+			rootFound = true
+			data, err := io.ReadAll(content)
+			part.Close()
+			if err != nil {
+				removeParts(parts)
+				return "", nil, fmt.Errorf("failed to read part data: %w", err)
+			}
 			soapPart = string(data)
 		} else {
 			// This is a binary attachment part
-			parts = append(parts, MultipartPart{
-				ContentID: contentID,
-				ContentType: partContentType,
-				Data: data,
-			})
-		}
-	}
-
-	// Parse the SOAP envelope to extract file name and XOP references
-	fileName, xopRefs, err := parseMTOMSOAPEnvelope(soapPart)
-	if err != nil {
-		return "", nil, fmt.Errorf("failed to parse SOAP envelope: %w", err)
-	}
-
-	// Resolve XOP references to actual binary data
-	var fileData []byte
-	for _, xopRef := range xopRefs {
-		found := false
-		for _, part := range parts {
-			if part.ContentID == xopRef {
-				fileData = part.Data
-				found = true
-				break
+			spooled, err := spoolPart(content)
+			part.Close()
+			if err != nil {
+				removeParts(parts)
+				return "", nil, fmt.Errorf("failed to read part data: %w", err)
 			}
-		}
-		if !found {
-			return "", nil, fmt.Errorf("XOP reference not found: %s", xopRef)
+			spooled.ContentID = contentID
+			spooled.ContentType = partContentType
+			spooled.ContentLocation = part.Header.Get("Content-Location")
+			parts = append(parts, spooled)
 		}
 	}
 
-	if len(fileData) == 0 {
-		return "", nil, fmt.Errorf("no file data found in MTOM request")
+	if !rootFound {
+		removeParts(parts)
+		return "", nil, fmt.Errorf("SOAP envelope part not found")
 	}
 
-	return fileName, fileData, nil
+	return soapPart, parts, nil
 }
 
-// parseMTOMSOAPEnvelope parses the SOAP envelope from MTOM request
-func parseMTOMSOAPEnvelope(soapEnvelope string) (string, []string, error) {
-	// Parse the XML to extract the request
-	var envelope struct {
-		XMLName xml.Name `xml:"http://schemas.xmlsoap.org/soap/envelope/ Envelope"`
-		Body    struct {
-			XMLName xml.Name `xml:"http://schemas.xmlsoap.org/soap/envelope/ Body"`
-			Request struct {
-				XMLName  xml.Name `xml:"http://example.com/soap/user UploadFileMTOMRequest"`
-				FileName string   `xml:"fileName"`
-				FileData string   `xml:"fileData"`
-			} `xml:"UploadFileMTOMRequest"`
-		}
+// mtomFault returns the fault for a multipart/related request that could
+// not be read
+func mtomFault(err error) *soapfault.Fault {
+	if fault, ok := soap.LimitFault(err); ok {
+		return fault
 	}
+	return soapfault.Client("Invalid MTOM request", err.Error())
+}
 
-	if err := xml.Unmarshal([]byte(soapEnvelope), &envelope); err != nil {
-		return "", nil, fmt.Errorf("XML parse error: %w", err)
+// partContent returns the decoded content of a part read from raw. Base64
+// parts are decoded; quoted-printable parts are decoded by the multipart
+// reader already, and binary, 8bit and 7bit content is taken as is.
+func partContent(part *multipart.Part, raw io.Reader) (io.Reader, error) {
+	switch encoding := strings.ToLower(strings.TrimSpace(part.Header.Get("Content-Transfer-Encoding"))); encoding {
+	case "", "binary", "8bit", "7bit":
+		return raw, nil
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, raw), nil
+	default:
+		return nil, fmt.Errorf("unsupported Content-Transfer-Encoding %q", encoding)
 	}
+}
 
-	fileName := envelope.Body.Request.FileName
-	fileDataElement := envelope.Body.Request.FileData
-
-	var xopRefs []string
-
-	// Check if fileData contains an XOP Include reference
-	// XOP include format: <xop:Include xmlns:xop="http://www.w3.org/2004/08/xop/include" href="cid:..."/>
-	if strings.Contains(fileDataElement, "<xop:Include") || strings.Contains(fileDataElement, "Include") {
-		// Extract Content-ID from XOP Include
-		re := regexp.MustCompile(`href=["']cid:([^"']+)["']`)
-		matches := re.FindStringSubmatch(fileDataElement)
-		if len(matches) > 1 {
-			xopRefs = append(xopRefs, matches[1])
-		}
+// spoolPart copies the content of a part to a temporary file
+func spoolPart(part io.Reader) (MultipartPart, error) {
+	file, err := os.CreateTemp("", "mtom-part-*")
+	if err != nil {
+		return MultipartPart{}, err
 	}
-
-	return fileName, xopRefs, nil
+	size, err := io.Copy(file, part)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return MultipartPart{}, err
+	}
+	return MultipartPart{Path: file.Name(), Size: size}, nil
 }
 
-// parseBase64SOAPRequest parses a regular SOAP request with base64 encoded file data
-func parseBase64SOAPRequest(r *http.Request) (string, []byte, error) {
-	var soapEnvelope struct {
-		XMLName xml.Name `xml:"http://schemas.xmlsoap.org/soap/envelope/ Envelope"`
-		Body    struct {
-			XMLName xml.Name `xml:"http://schemas.xmlsoap.org/soap/envelope/ Body"`
-			Request UploadFileMTOMRequest `xml:"UploadFileMTOMRequest"`
-		}
+// parseMTOMSOAPEnvelope parses the SOAP envelope from MTOM request
+func parseMTOMSOAPEnvelope(r *http.Request, soapEnvelope string) (UploadFileMTOMRequest, error) {
+	var req UploadFileMTOMRequest
+	if err := soap.DecodeEnvelope(r, strings.NewReader(soapEnvelope), &req); err != nil {
+		return UploadFileMTOMRequest{}, err
 	}
+	return req, nil
+}
 
-	if err := xml.NewDecoder(r.Body).Decode(&soapEnvelope); err != nil {
-		return "", nil, fmt.Errorf("XML decode error: %w", err)
+// parseBase64SOAPRequest parses a regular SOAP request with base64 encoded file data
+func parseBase64SOAPRequest(r *http.Request) (UploadFileMTOMRequest, []mtomFile, error) {
+	var req UploadFileMTOMRequest
+	if err := soap.DecodeEnvelope(r, r.Body, &req); err != nil {
+		return UploadFileMTOMRequest{}, nil, err
 	}
 
-	fileName := soapEnvelope.Body.Request.FileName
-	fileData := soapEnvelope.Body.Request.FileData
-
-	// Decode base64
-	decodedData, err := base64.StdEncoding.DecodeString(fileData)
+	// Decode base64; there are no attachments to refer to
+	files, err := requestFiles(req, nil)
 	if err != nil {
-		return "", nil, fmt.Errorf("base64 decode error: %w", err)
+		return UploadFileMTOMRequest{}, nil, err
 	}
-
-	return fileName, decodedData, nil
+	return req, files, nil
 }
@@ -0,0 +1,161 @@
+package handler
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"soap-server/soap"
+	"soap-server/soapfault"
+)
+
+// QuotaPolicy limits the bytes stored per authenticated client: the total
+// size of the files uploaded by a principal. Anonymous uploads are not
+// limited.
+type QuotaPolicy struct {
+	// Default is the limit of the clients without their own. Zero is
+	// unlimited.
+	Default int64
+
+	// Clients are the limits of individual clients by principal name. Zero
+	// is unlimited.
+	Clients map[string]int64
+}
+
+// validate checks that no limit is negative
+func (p QuotaPolicy) validate() error {
+	if p.Default < 0 {
+		return fmt.Errorf("invalid default quota %d", p.Default)
+	}
+	for client, limit := range p.Clients {
+		if limit < 0 {
+			return fmt.Errorf("invalid quota %d of %s", limit, client)
+		}
+	}
+	return nil
+}
+
+// limit returns the quota of a client, zero if unlimited
+func (p QuotaPolicy) limit(client string) int64 {
+	if limit, ok := p.Clients[client]; ok {
+		return limit
+	}
+	return p.Default
+}
+
+// QuotaExceededFault is the fault detail returned when an upload would take
+// a client over its quota
+type QuotaExceededFault struct {
+	XMLName    xml.Name `xml:"QuotaExceededFault"`
+	Client     string   `xml:"client"`
+	LimitBytes int64    `xml:"limitBytes"`
+	UsedBytes  int64    `xml:"usedBytes"` // Before the upload
+}
+
+// quotaUsage returns the number and total size of the files uploaded by a
+// client. Deduplicated files count for each client sharing the content.
+func quotaUsage(ctx context.Context, files FileCatalog, client string) (count int, used int64, err error) {
+	uploaded, err := files.List(ctx, FileQuery{Uploader: client})
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, f := range uploaded {
+		used += f.Size
+	}
+	return len(uploaded), used, nil
+}
+
+// limitUpload returns the content of an upload by the caller of ctx, failing
+// with a QuotaExceededFault once it exceeds the caller's remaining quota.
+// Concurrent uploads of a client are checked against the same usage and may
+// exceed the quota together.
+func limitUpload(ctx context.Context, cfg Config, content io.Reader) (io.Reader, error) {
+	principal, ok := soap.PrincipalFromContext(ctx)
+	if !ok || principal.Name == "" {
+		return content, nil
+	}
+	limit := cfg.Quotas.limit(principal.Name)
+	if limit == 0 {
+		return content, nil
+	}
+	_, used, err := quotaUsage(ctx, cfg.Files, principal.Name)
+	if err != nil {
+		return nil, soapfault.Server("Internal error", "File catalog failed: "+err.Error())
+	}
+	fault := soapfault.Client("Quota exceeded", QuotaExceededFault{Client: principal.Name, LimitBytes: limit, UsedBytes: used})
+	if used >= limit {
+		return nil, fault
+	}
+	return &quotaReader{r: content, remaining: limit - used, fault: fault}, nil
+}
+
+// quotaReader fails with its fault once more than the remaining bytes are
+// read
+type quotaReader struct {
+	r         io.Reader
+	remaining int64
+	fault     error
+}
+
+func (qr *quotaReader) Read(p []byte) (int, error) {
+	n, err := qr.r.Read(p)
+	qr.remaining -= int64(n)
+	if qr.remaining < 0 {
+		return 0, qr.fault
+	}
+	return n, err
+}
+
+// uploadFault returns the fault of a failed write of an upload: the fault
+// the content failed with, such as a QuotaExceededFault, or a Server fault
+func uploadFault(err error) error {
+	if fault, ok := soapfault.As(err); ok {
+		return fault
+	}
+	return soapfault.Server("Internal error", "Failed to save file: "+err.Error())
+}
+
+// GetQuotaUsageRequest represents the SOAP request for the storage used by
+// the caller
+type GetQuotaUsageRequest struct {
+	XMLName xml.Name `xml:"GetQuotaUsageRequest"`
+}
+
+// GetQuotaUsageResponse represents the SOAP response with the storage used
+// by the caller. Limit and remaining bytes are omitted if unlimited.
+type GetQuotaUsageResponse struct {
+	XMLName        xml.Name `xml:"GetQuotaUsageResponse"`
+	Client         string   `xml:"client"`
+	FileCount      int      `xml:"fileCount"`
+	UsedBytes      int64    `xml:"usedBytes"`
+	LimitBytes     *int64   `xml:"limitBytes,omitempty"`
+	RemainingBytes *int64   `xml:"remainingBytes,omitempty"`
+}
+
+// GetQuotaUsage handles the GetQuotaUsage SOAP operation, which lets
+// authenticated clients check their headroom before uploading
+func GetQuotaUsage(files FileCatalog, quotas QuotaPolicy) func(context.Context, GetQuotaUsageRequest) (GetQuotaUsageResponse, error) {
+	return func(ctx context.Context, req GetQuotaUsageRequest) (GetQuotaUsageResponse, error) {
+		principal, ok := soap.PrincipalFromContext(ctx)
+		if !ok || principal.Name == "" {
+			return GetQuotaUsageResponse{}, soapfault.Client("Authentication required",
+				"Quotas apply to authenticated callers").
+				WithSubcode("", "Authentication")
+		}
+
+		count, used, err := quotaUsage(ctx, files, principal.Name)
+		if err != nil {
+			return GetQuotaUsageResponse{}, soapfault.Server("Internal error", "File catalog failed: "+err.Error())
+		}
+		resp := GetQuotaUsageResponse{Client: principal.Name, FileCount: count, UsedBytes: used}
+		if limit := quotas.limit(principal.Name); limit > 0 {
+			remaining := limit - used
+			if remaining < 0 {
+				remaining = 0
+			}
+			resp.LimitBytes = &limit
+			resp.RemainingBytes = &remaining
+		}
+		return resp, nil
+	}
+}
@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"context"
+	"encoding/xml"
+	"soap-server/soap"
+	"soap-server/soapfault"
+)
+
+// RenameFileRequest represents the SOAP request for changing the name of an
+// uploaded file
+type RenameFileRequest struct {
+	XMLName  xml.Name `xml:"RenameFileRequest"`
+	FileID   string   `xml:"fileId" validate:"required"`
+	FileName string   `xml:"fileName" validate:"required,max=255"`
+}
+
+// Validate checks that the file ID is a UUID as assigned on upload
+func (req RenameFileRequest) Validate() error {
+	return validateFileID(req.FileID)
+}
+
+// RenameFileResponse represents the SOAP response with the metadata of the
+// renamed file
+type RenameFileResponse struct {
+	XMLName xml.Name `xml:"RenameFileResponse"`
+	File    FileInfo `xml:"file"`
+}
+
+// RenameFile handles the RenameFile SOAP operation. Only the name in the
+// catalog changes, together with the content type derived from it; the
+// content and its path stay where they are. The new name must be allowed by
+// the file type policy like an upload.
+func RenameFile(files FileCatalog, types FileTypePolicy) func(context.Context, RenameFileRequest) (RenameFileResponse, error) {
+	return func(ctx context.Context, req RenameFileRequest) (RenameFileResponse, error) {
+		var oldName string
+		record, err := files.Update(ctx, req.FileID, func(f *FileRecord) error {
+			if err := types.check(req.FileName, f.DetectedType); err != nil {
+				return err
+			}
+			oldName = f.Name
+			f.Name = req.FileName
+			f.ContentType = contentTypeOf(req.FileName, f.DetectedType)
+			return nil
+		})
+		if _, ok := soapfault.As(err); ok {
+			return RenameFileResponse{}, err
+		}
+		if err != nil {
+			return RenameFileResponse{}, fileError(req.FileID, err)
+		}
+
+		soap.Info(ctx, "File renamed", "file_id", record.ID, "name", record.Name, "old_name", oldName)
+		return RenameFileResponse{File: fileInfo(record)}, nil
+	}
+}
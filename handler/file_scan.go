@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"os"
+	"soap-server/soap"
+	"soap-server/soapfault"
+)
+
+// Scanner checks content for malware
+type Scanner interface {
+	// Scan reads the content and returns the name of the threat found in
+	// it, or "" if it is clean. Errors mean the content was not scanned.
+	Scan(ctx context.Context, r io.Reader) (threat string, err error)
+}
+
+// MalwareDetectedFault is the fault detail returned when the scanner found
+// a threat in an upload
+type MalwareDetectedFault struct {
+	XMLName  xml.Name `xml:"MalwareDetectedFault"`
+	FileName string   `xml:"fileName"`
+	Threat   string   `xml:"threat"`
+}
+
+// scanUpload spools the content to a temporary file and scans it, so
+// infected files never reach the blob store. Infected files are put in
+// the quarantine under key, if there is one, and rejected with a
+// MalwareDetectedFault. Files that could not be scanned are rejected unless
+// the scanner fails open. The returned content must be closed.
+func scanUpload(ctx context.Context, cfg Config, name, key string, content io.Reader) (io.ReadCloser, error) {
+	file, err := os.CreateTemp("", "upload-scan-*")
+	if err != nil {
+		return nil, soapfault.Server("Internal error", "Failed to save file: "+err.Error())
+	}
+	spooled := spooledFile{file}
+	if _, err := io.Copy(file, contextReader{ctx: ctx, r: content}); err != nil {
+		spooled.Close()
+		return nil, uploadFault(err)
+	}
+
+	if err := spooled.rewind(); err != nil {
+		spooled.Close()
+		return nil, soapfault.Server("Internal error", "Failed to read file: "+err.Error())
+	}
+	threat, err := cfg.Scanner.Scan(ctx, file)
+	switch {
+	case err != nil && cfg.ScanFailOpen:
+		soap.Warn(ctx, "Virus scan failed, storing the file unscanned", "name", name, soap.LogKeyError, err)
+	case err != nil:
+		spooled.Close()
+		soap.Error(ctx, "Virus scan failed", "name", name, soap.LogKeyError, err)
+		return nil, soapfault.Server("Virus scan failed", "The file could not be scanned")
+	case threat != "":
+		soap.Warn(ctx, "Infected file rejected", "name", name, "threat", threat)
+		if cfg.Quarantine != nil {
+			quarantine(ctx, cfg.Quarantine, key, spooled)
+		}
+		spooled.Close()
+		return nil, soapfault.Client("Malware detected", MalwareDetectedFault{FileName: name, Threat: threat})
+	}
+
+	if err := spooled.rewind(); err != nil {
+		spooled.Close()
+		return nil, soapfault.Server("Internal error", "Failed to read file: "+err.Error())
+	}
+	return spooled, nil
+}
+
+// quarantine keeps an infected file for inspection. Failures are logged.
+func quarantine(ctx context.Context, blobs BlobStore, key string, spooled spooledFile) {
+	err := spooled.rewind()
+	if err == nil {
+		_, err = blobs.Put(context.WithoutCancel(ctx), key, spooled)
+	}
+	if err != nil {
+		soap.Error(ctx, "Failed to quarantine file", "key", key, soap.LogKeyError, err)
+		return
+	}
+	soap.Info(ctx, "File quarantined", "key", key)
+}
+
+// spooledFile is a temporary file removed when closed
+type spooledFile struct {
+	*os.File
+}
+
+func (f spooledFile) rewind() error {
+	_, err := f.Seek(0, io.SeekStart)
+	return err
+}
+
+func (f spooledFile) Close() error {
+	f.File.Close()
+	return os.Remove(f.Name())
+}
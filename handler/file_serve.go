@@ -0,0 +1,190 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"soap-server/soap"
+	"soap-server/soapfault"
+	"strings"
+	"time"
+)
+
+// UploadsPrefix is the path the stored files are served at, as
+// UploadsPrefix + <fileId>_<name>
+const UploadsPrefix = "/uploads/"
+
+// uploadPath returns the path a stored file is served at
+func uploadPath(key string) string {
+	return UploadsPrefix + key
+}
+
+// ServeUploads serves the stored files at the paths returned by the upload
+// operations, with the content type of their metadata. Range and
+// conditional requests are supported; the checksum is the ETag. Callers are
+// authenticated by the middleware in front, which rejects those without
+// credentials if an authentication method is configured, as on the SOAP
+// endpoints. They are authorized with the policy and the operations of
+// their principal as for DownloadFileMTOM; a nil policy allows every
+// caller. Callers the policy requires to authenticate are answered 401 with
+// the challenges. Files the policy hides from the caller are not found if
+// files is wrapped by AuthorizeFiles.
+func ServeUploads(blobs BlobStore, files FileCatalog, users UserStore, policy Policy, challenges ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		ctx := r.Context()
+		if principal, ok := soap.PrincipalFromContext(ctx); ok && !principal.Allows("DownloadFileMTOM") {
+			http.Error(w, "Access denied", http.StatusForbidden)
+			return
+		}
+		ctx, err := policy.Permit(ctx, users, "DownloadFileMTOM")
+		if err != nil {
+			fault, _ := soapfault.As(err)
+			switch {
+			case fault != nil && fault.Subcode.Local == "Authentication":
+				for _, challenge := range challenges {
+					w.Header().Add("WWW-Authenticate", challenge)
+				}
+				http.Error(w, "Authentication required", http.StatusUnauthorized)
+			case fault != nil && fault.Subcode.Local == "Authorization":
+				http.Error(w, "Access denied", http.StatusForbidden)
+			default:
+				http.Error(w, "Access denied", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		key := strings.TrimPrefix(r.URL.Path, UploadsPrefix)
+		id, ok := uploadID(key)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		record, err := files.Get(ctx, id)
+		if errors.Is(err, ErrFileNotFound) || err == nil && record.uploadKey() != key {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			soap.Error(ctx, "Failed to serve file", "key", key, soap.LogKeyError, err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		serveFile(w, r.WithContext(ctx), blobs, record)
+	})
+}
+
+// serveFile writes the content of a stored file with the content type of
+// its metadata, answering range and conditional requests
+func serveFile(w http.ResponseWriter, r *http.Request, blobs BlobStore, record FileRecord) {
+	ctx := r.Context()
+	key := record.StoredName
+	content, size, err := blobs.Open(ctx, key)
+	if errors.Is(err, ErrBlobNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		soap.Error(ctx, "Failed to serve file", "key", key, soap.LogKeyError, err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	reader, ok := content.(io.ReadSeekCloser)
+	if !ok {
+		reader = &blobReader{ctx: ctx, blobs: blobs, key: key, size: size, r: content}
+	}
+	defer reader.Close()
+
+	if record.ContentType != "" {
+		w.Header().Set("Content-Type", record.ContentType)
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": record.Name}))
+	// Uploaded HTML or SVG must not run scripts in the origin of the
+	// server, nor be sniffed into something else
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if record.Checksum != "" {
+		w.Header().Set("ETag", fmt.Sprintf("%q", record.Checksum))
+	}
+	uploadedAt, _ := time.Parse(time.RFC3339, record.UploadedAt)
+	http.ServeContent(w, r, record.Name, uploadedAt, reader)
+}
+
+// blobReader makes a blob seekable, as range requests need, by reopening it
+// at the offset read from. Stores implementing RangeOpener read from the
+// offset; others are read from the start and skip to it.
+type blobReader struct {
+	ctx    context.Context
+	blobs  BlobStore
+	key    string
+	size   int64
+	offset int64         // Offset of the next Read
+	pos    int64         // Offset of r
+	r      io.ReadCloser // Nil until reopened
+}
+
+func (b *blobReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += b.offset
+	case io.SeekEnd:
+		offset += b.size
+	}
+	if offset < 0 {
+		return 0, errors.New("negative offset")
+	}
+	b.offset = offset
+	return offset, nil
+}
+
+func (b *blobReader) Read(p []byte) (int, error) {
+	if b.r != nil && b.pos != b.offset {
+		b.r.Close()
+		b.r = nil
+	}
+	if b.r == nil {
+		if err := b.reopen(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := b.r.Read(p)
+	b.offset += int64(n)
+	b.pos = b.offset
+	return n, err
+}
+
+// reopen opens the blob at the current offset
+func (b *blobReader) reopen() error {
+	if opener, ok := b.blobs.(RangeOpener); ok {
+		r, err := opener.OpenRange(b.ctx, b.key, b.offset)
+		if err != nil {
+			return err
+		}
+		b.r, b.pos = r, b.offset
+		return nil
+	}
+	r, _, err := b.blobs.Open(b.ctx, b.key)
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyN(io.Discard, r, b.offset); err != nil && err != io.EOF {
+		r.Close()
+		return err
+	}
+	b.r, b.pos = r, b.offset
+	return nil
+}
+
+func (b *blobReader) Close() error {
+	if b.r == nil {
+		return nil
+	}
+	return b.r.Close()
+}
@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"soap-server/auth"
+	"soap-server/soap"
+	"strings"
+	"testing"
+)
+
+func TestServeUploadsAuthentication(t *testing.T) {
+	cfg := Config{Blobs: NewDiskBlobStore(t.TempDir()), Files: NewMemoryFileCatalog(), ChecksumAlgorithm: "sha256"}
+	record := uploadAs(t, cfg, "alice", "report.txt", "report content")
+	path := uploadPath(record.uploadKey())
+	users := NewMemoryUserStore()
+	creds, err := auth.ParseCredentials("alice:secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	restricted, err := ParsePolicy("DownloadFileMTOM=*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge := auth.BasicChallenge("test")
+
+	tests := []struct {
+		name       string
+		handler    http.Handler
+		user       string // Basic credentials user:password
+		status     int
+		challenged bool
+	}{
+		{"no authentication configured", ServeUploads(cfg.Blobs, cfg.Files, users, nil), "", http.StatusOK, false},
+		{"without credentials", withBasicAuth(ServeUploads(cfg.Blobs, cfg.Files, users, nil, challenge), creds), "", http.StatusUnauthorized, true},
+		{"wrong password", withBasicAuth(ServeUploads(cfg.Blobs, cfg.Files, users, nil, challenge), creds), "alice:wrong", http.StatusUnauthorized, true},
+		{"with credentials", withBasicAuth(ServeUploads(cfg.Blobs, cfg.Files, users, nil, challenge), creds), "alice:secret", http.StatusOK, false},
+		{"policy requiring a caller", ServeUploads(cfg.Blobs, cfg.Files, users, restricted, challenge), "", http.StatusUnauthorized, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			if user, password, ok := strings.Cut(tt.user, ":"); ok {
+				req.SetBasicAuth(user, password)
+			}
+			rec := httptest.NewRecorder()
+			tt.handler.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d (%s)", rec.Code, tt.status, strings.TrimSpace(rec.Body.String()))
+			}
+			if challenged := rec.Header().Get("WWW-Authenticate") == challenge; challenged != tt.challenged {
+				t.Errorf("WWW-Authenticate %q, want challenge %v", rec.Header().Get("WWW-Authenticate"), tt.challenged)
+			}
+			if tt.status == http.StatusOK && rec.Body.String() != "report content" {
+				t.Errorf("body %q, want the uploaded content", rec.Body.String())
+			}
+		})
+	}
+}
+
+// withBasicAuth puts the Basic authentication middleware of the server in
+// front of h, requiring credentials
+func withBasicAuth(h http.Handler, creds auth.Credentials) http.Handler {
+	return http.HandlerFunc(soap.Chain(h.ServeHTTP, auth.Basic("test", creds), auth.Require(auth.BasicChallenge("test"))))
+}
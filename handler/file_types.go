@@ -0,0 +1,151 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"soap-server/soapfault"
+	"strings"
+)
+
+// sniffLen is the number of leading bytes content types are detected from
+const sniffLen = 512
+
+// executableSignatures detect executables and scripts, which
+// http.DetectContentType does not recognize
+var executableSignatures = []struct {
+	match       func(head []byte) bool
+	contentType string
+}{
+	{hasPrefix("\x7fELF"), "application/x-executable"},
+	{isPortableExecutable, "application/vnd.microsoft.portable-executable"},
+	{hasPrefix("\xfe\xed\xfa\xce", "\xfe\xed\xfa\xcf", "\xce\xfa\xed\xfe", "\xcf\xfa\xed\xfe"), "application/x-mach-binary"},
+	{hasPrefix("#!"), "text/x-shellscript"},
+}
+
+// hasPrefix matches content starting with any of the magic numbers
+func hasPrefix(magic ...string) func([]byte) bool {
+	return func(head []byte) bool {
+		for _, m := range magic {
+			if bytes.HasPrefix(head, []byte(m)) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// isPortableExecutable matches Windows executables and DLLs: an MZ header
+// whose offset at 0x3c points to the PE signature
+func isPortableExecutable(head []byte) bool {
+	if len(head) < 0x40 || !bytes.HasPrefix(head, []byte("MZ")) {
+		return false
+	}
+	offset := int(binary.LittleEndian.Uint32(head[0x3c:]))
+	return offset >= 0x40 && offset+4 <= len(head) && bytes.Equal(head[offset:offset+4], []byte("PE\x00\x00"))
+}
+
+// detectContentType returns the media type of content from its leading
+// bytes, without parameters. Unknown content is application/octet-stream.
+func detectContentType(head []byte) string {
+	for _, sig := range executableSignatures {
+		if sig.match(head) {
+			return sig.contentType
+		}
+	}
+	mediaType, _, err := mime.ParseMediaType(http.DetectContentType(head))
+	if err != nil {
+		return "application/octet-stream"
+	}
+	return mediaType
+}
+
+// FileTypePolicy restricts the types of the uploaded files. Rules are media
+// types ("application/pdf"), media type ranges ("image/*") or file name
+// extensions (".pdf"). Media types are matched against the type detected
+// from the content, extensions against the file name.
+type FileTypePolicy struct {
+	// Allow lists the accepted types. If it has media types, the detected
+	// type must match one; if it has extensions, the name must match one.
+	// Empty allows all types.
+	Allow []string
+
+	// Deny lists types rejected even if allowed
+	Deny []string
+}
+
+// FileTypeNotAllowedFault is the fault detail returned when an upload is
+// rejected by the file type policy
+type FileTypeNotAllowedFault struct {
+	XMLName     xml.Name `xml:"FileTypeNotAllowedFault"`
+	FileName    string   `xml:"fileName"`
+	ContentType string   `xml:"contentType"` // Detected from the content
+}
+
+// validate checks the syntax of the rules
+func (p FileTypePolicy) validate() error {
+	for _, rule := range append(append([]string(nil), p.Allow...), p.Deny...) {
+		if strings.HasPrefix(rule, ".") && len(rule) > 1 && !strings.ContainsAny(rule, "/\\") {
+			continue
+		}
+		if mediaType, sub, ok := strings.Cut(rule, "/"); ok && mediaType != "" && sub != "" && !strings.Contains(sub, "/") {
+			continue
+		}
+		return fmt.Errorf("invalid file type rule %q: want a media type or an extension", rule)
+	}
+	return nil
+}
+
+// check returns a FileTypeNotAllowedFault if a file with the given name
+// and detected content type is not allowed
+func (p FileTypePolicy) check(name, contentType string) error {
+	ext := strings.ToLower(filepath.Ext(name))
+	if matchFileType(p.Deny, ext, contentType) || !p.allows(ext, contentType) {
+		return soapfault.Client("File type not allowed", FileTypeNotAllowedFault{FileName: name, ContentType: contentType})
+	}
+	return nil
+}
+
+// allows reports whether the extension and content type pass the allow list
+func (p FileTypePolicy) allows(ext, contentType string) bool {
+	var types, exts []string
+	for _, rule := range p.Allow {
+		if strings.HasPrefix(rule, ".") {
+			exts = append(exts, rule)
+		} else {
+			types = append(types, rule)
+		}
+	}
+	if len(types) > 0 && !matchFileType(types, "", contentType) {
+		return false
+	}
+	if len(exts) > 0 && !matchFileType(exts, ext, "") {
+		return false
+	}
+	return true
+}
+
+// matchFileType reports whether any rule matches the extension or the
+// content type
+func matchFileType(rules []string, ext, contentType string) bool {
+	for _, rule := range rules {
+		rule = strings.ToLower(rule)
+		switch {
+		case strings.HasPrefix(rule, "."):
+			if ext != "" && rule == ext {
+				return true
+			}
+		case strings.HasSuffix(rule, "/*"):
+			if contentType != "" && strings.HasPrefix(contentType, strings.TrimSuffix(rule, "*")) {
+				return true
+			}
+		case rule == contentType:
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,353 @@
+package handler
+
+import (
+	"reflect"
+	"soap-server/soap"
+	"strings"
+	"time"
+)
+
+// DefaultNamespace is the default target namespace of the user service
+const DefaultNamespace = "http://example.com/soap/user"
+
+// Config holds the service settings applied when registering operations
+type Config struct {
+	// Namespace is the target namespace of the request and response
+	// elements. Defaults to DefaultNamespace.
+	Namespace string
+
+	// SOAPActionBase is the prefix of the operation SOAPAction URIs; the
+	// action of an operation is SOAPActionBase + "/" + name. Defaults to
+	// Namespace.
+	SOAPActionBase string
+
+	// UploadDir is the directory uploaded files are stored in when no Blobs
+	// are given
+	UploadDir string
+
+	// Blobs stores the content of the uploaded files. Defaults to a
+	// DiskBlobStore in UploadDir.
+	Blobs BlobStore
+
+	// Files records the metadata of the uploaded files. Defaults to an
+	// empty in-memory catalog.
+	Files FileCatalog
+
+	// ChecksumAlgorithm is the hash of the upload checksums: md5, sha1,
+	// sha256, sha384 or sha512. Defaults to DefaultChecksumAlgorithm.
+	ChecksumAlgorithm string
+
+	// FileTTL is the lifetime of uploaded files unless the upload request
+	// asks for another one. Zero keeps files until they are deleted.
+	FileTTL time.Duration
+
+	// MaxFileSize rejects uploads of larger files, with HTTP status 413.
+	// Zero allows any size.
+	MaxFileSize int64
+
+	// FileLayout shards the blob keys of new uploads into directories:
+	// LayoutDate or LayoutHash. Defaults to LayoutFlat, all files in one
+	// directory. The key of each file is recorded in the catalog, so files
+	// stored with another layout are still found.
+	FileLayout string
+
+	// FileTypes restricts the types of the uploaded files. Defaults to
+	// allowing all types.
+	FileTypes FileTypePolicy
+
+	// Deduplicate stores identical content once: an upload whose checksum
+	// matches a stored file still gets a file of its own, sharing the
+	// stored content, which is removed with the last file sharing it
+	Deduplicate bool
+
+	// Scanner checks uploads for malware before they are stored. Optional.
+	Scanner Scanner
+
+	// ScanFailOpen stores uploads that could not be scanned, e.g. while the
+	// scanner is down, instead of rejecting them
+	ScanFailOpen bool
+
+	// Quarantine keeps the infected uploads for inspection. Optional;
+	// infected uploads are discarded without it.
+	Quarantine BlobStore
+
+	// SwAResponses answers clients accepting multipart/related with SOAP
+	// with Attachments (SwA) messages instead of MTOM, for legacy clients
+	// such as Axis1 that do not understand XOP. Clients sending SwA
+	// requests always get SwA responses.
+	SwAResponses bool
+
+	// AsyncUploads processes uploads in the background: the upload
+	// operations answer with a jobId once the content is received, and
+	// clients poll GetUploadStatus until the files are scanned, checksummed
+	// and stored
+	AsyncUploads bool
+
+	// Jobs runs the background uploads. Defaults to an empty registry with
+	// DefaultUploadWorkers workers.
+	Jobs *UploadJobs
+
+	// Quotas limits the bytes stored per authenticated client. Defaults to
+	// no limits.
+	Quotas QuotaPolicy
+
+	// Users stores the users of the user service. Defaults to an empty
+	// in-memory store; services that must share their users need to be
+	// given the same store.
+	Users UserStore
+
+	// Audit is the audit log exported by ExportAuditLog. Optional; the
+	// operation fails without it.
+	Audit AuditLog
+
+	// DownloadLinks signs the URLs returned by CreateDownloadLink.
+	// Optional; the operation fails without it.
+	DownloadLinks *DownloadLinks
+}
+
+// withDefaults returns a copy of the config with empty fields defaulted
+func (cfg Config) withDefaults() Config {
+	if cfg.Namespace == "" {
+		cfg.Namespace = DefaultNamespace
+	}
+	if cfg.SOAPActionBase == "" {
+		cfg.SOAPActionBase = cfg.Namespace
+	}
+	cfg.SOAPActionBase = strings.TrimSuffix(cfg.SOAPActionBase, "/")
+	if cfg.UploadDir == "" {
+		cfg.UploadDir = "./uploads"
+	}
+	if cfg.Blobs == nil {
+		cfg.Blobs = NewDiskBlobStore(cfg.UploadDir)
+	}
+	if cfg.ChecksumAlgorithm == "" {
+		cfg.ChecksumAlgorithm = DefaultChecksumAlgorithm
+	}
+	if cfg.Files == nil {
+		cfg.Files = NewMemoryFileCatalog()
+	}
+	if cfg.Users == nil {
+		cfg.Users = NewMemoryUserStore()
+	}
+	if cfg.Jobs == nil {
+		cfg.Jobs = NewUploadJobs(0)
+	}
+	return cfg
+}
+
+// UploadOperations are the operations receiving files or user lists, whose
+// requests carry the largest bodies
+var UploadOperations = []string{"UploadFile", "UploadFileMTOM", "ImportUsers"}
+
+// RegisterOperations registers all SOAP operations provided by this package
+func RegisterOperations(reg *soap.OperationRegistry, cfg Config) error {
+	if err := RegisterUserOperations(reg, cfg); err != nil {
+		return err
+	}
+	return RegisterFileOperations(reg, cfg)
+}
+
+// RegisterUserOperations registers the user service operations. Version 1
+// uses the configured namespace, version 2 the namespace with a "/v2"
+// suffix; requests are routed by the namespace of their Body element.
+func RegisterUserOperations(reg *soap.OperationRegistry, cfg Config) error {
+	cfg = cfg.withDefaults()
+
+	getUser := cfg.operation("GetUser")
+	getUser.Faults = []string{"UserNotFoundFault"}
+	getUser.FaultTypes = []reflect.Type{reflect.TypeOf(UserNotFoundFault{})}
+	if err := reg.RegisterFunc(getUser, GetUser(cfg.Users)); err != nil {
+		return err
+	}
+
+	if err := reg.RegisterFunc(cfg.operation("GetUsers"), GetUsers(cfg.Users)); err != nil {
+		return err
+	}
+
+	updateUser := cfg.operation("UpdateUser")
+	updateUser.Faults = getUser.Faults
+	updateUser.FaultTypes = getUser.FaultTypes
+	if err := reg.RegisterFunc(updateUser, UpdateUser(cfg.Users)); err != nil {
+		return err
+	}
+
+	deleteUser := cfg.operation("DeleteUser")
+	deleteUser.Faults = getUser.Faults
+	deleteUser.FaultTypes = getUser.FaultTypes
+	if err := reg.RegisterFunc(deleteUser, DeleteUser(cfg.Users)); err != nil {
+		return err
+	}
+
+	restoreUser := cfg.operation("RestoreUser")
+	restoreUser.Faults = getUser.Faults
+	restoreUser.FaultTypes = getUser.FaultTypes
+	if err := reg.RegisterFunc(restoreUser, RestoreUser(cfg.Users)); err != nil {
+		return err
+	}
+
+	if err := reg.RegisterFunc(cfg.operation("SearchUsers"), SearchUsers(cfg.Users)); err != nil {
+		return err
+	}
+
+	assignRole := cfg.operation("AssignRole")
+	assignRole.Faults = getUser.Faults
+	assignRole.FaultTypes = getUser.FaultTypes
+	if err := reg.RegisterFunc(assignRole, AssignRole(cfg.Users)); err != nil {
+		return err
+	}
+
+	getUserRoles := cfg.operation("GetUserRoles")
+	getUserRoles.Faults = getUser.Faults
+	getUserRoles.FaultTypes = getUser.FaultTypes
+	if err := reg.RegisterFunc(getUserRoles, GetUserRoles(cfg.Users)); err != nil {
+		return err
+	}
+
+	// Imports may carry the payload as an MTOM attachment and use a plain
+	// handler
+	importUsers := cfg.operation("ImportUsers")
+	importUsers.Handler = ImportUsers(cfg.Users)
+	importUsers.RequestType = reflect.TypeOf(ImportUsersRequest{})
+	importUsers.ResponseType = reflect.TypeOf(ImportUsersResponse{})
+	if err := reg.Register(importUsers); err != nil {
+		return err
+	}
+
+	if err := reg.RegisterFunc(cfg.operation("ExportAuditLog"), ExportAuditLog(cfg.Audit)); err != nil {
+		return err
+	}
+
+	if err := reg.RegisterFunc(cfg.operation("GetServerInfo"), GetServerInfo()); err != nil {
+		return err
+	}
+
+	getUserV2 := cfg.Versioned("v2").operation("GetUser")
+	getUserV2.Faults = getUser.Faults
+	getUserV2.FaultTypes = getUser.FaultTypes
+
+	return reg.RegisterFunc(getUserV2, GetUserV2(cfg.Users))
+}
+
+// RegisterFileOperations registers the file service operations
+func RegisterFileOperations(reg *soap.OperationRegistry, cfg Config) error {
+	cfg = cfg.withDefaults()
+	if _, err := newChecksum(cfg.ChecksumAlgorithm); err != nil {
+		return err
+	}
+	if err := validateLayout(cfg.FileLayout); err != nil {
+		return err
+	}
+	if err := cfg.FileTypes.validate(); err != nil {
+		return err
+	}
+	if err := cfg.Quotas.validate(); err != nil {
+		return err
+	}
+
+	upload := cfg.operation("UploadFile")
+	upload.Faults = []string{"ChecksumMismatchFault", "FileTypeNotAllowedFault", "MalwareDetectedFault", "QuotaExceededFault"}
+	upload.FaultTypes = []reflect.Type{
+		reflect.TypeOf(ChecksumMismatchFault{}),
+		reflect.TypeOf(FileTypeNotAllowedFault{}),
+		reflect.TypeOf(MalwareDetectedFault{}),
+		reflect.TypeOf(QuotaExceededFault{}),
+	}
+	if err := reg.RegisterFunc(upload, UploadFile(cfg)); err != nil {
+		return err
+	}
+
+	// MTOM uploads need the raw multipart request and use a plain handler
+	mtom := cfg.operation("UploadFileMTOM")
+	mtom.Handler = UploadFileMTOM(cfg)
+	mtom.RequestType = reflect.TypeOf(UploadFileMTOMRequest{})
+	mtom.ResponseType = reflect.TypeOf(UploadFileMTOMResponse{})
+	mtom.Faults = upload.Faults
+	mtom.FaultTypes = upload.FaultTypes
+	if err := reg.Register(mtom); err != nil {
+		return err
+	}
+
+	getUploadStatus := cfg.operation("GetUploadStatus")
+	getUploadStatus.Faults = []string{"UploadJobNotFoundFault"}
+	getUploadStatus.FaultTypes = []reflect.Type{reflect.TypeOf(UploadJobNotFoundFault{})}
+	if err := reg.RegisterFunc(getUploadStatus, GetUploadStatus(cfg.Jobs)); err != nil {
+		return err
+	}
+
+	if err := reg.RegisterFunc(cfg.operation("ListFiles"), ListFiles(cfg.Files)); err != nil {
+		return err
+	}
+
+	// Downloads write MTOM or SwA responses and use a plain handler as well
+	download := cfg.operation("DownloadFileMTOM")
+	download.Handler = DownloadFileMTOM(cfg.Blobs, cfg.Files, cfg.SwAResponses)
+	download.RequestType = reflect.TypeOf(DownloadFileMTOMRequest{})
+	download.ResponseType = reflect.TypeOf(DownloadFileMTOMResponse{})
+	download.Faults = []string{"FileNotFoundFault"}
+	download.FaultTypes = []reflect.Type{reflect.TypeOf(FileNotFoundFault{})}
+	if err := reg.Register(download); err != nil {
+		return err
+	}
+
+	archive := cfg.operation("DownloadArchive")
+	archive.Handler = DownloadArchive(cfg.Blobs, cfg.Files, cfg.SwAResponses)
+	archive.RequestType = reflect.TypeOf(DownloadArchiveRequest{})
+	archive.ResponseType = reflect.TypeOf(DownloadArchiveResponse{})
+	archive.Faults = download.Faults
+	archive.FaultTypes = download.FaultTypes
+	if err := reg.Register(archive); err != nil {
+		return err
+	}
+
+	getFileMetadata := cfg.operation("GetFileMetadata")
+	getFileMetadata.Faults = download.Faults
+	getFileMetadata.FaultTypes = download.FaultTypes
+	if err := reg.RegisterFunc(getFileMetadata, GetFileMetadata(cfg.Files)); err != nil {
+		return err
+	}
+
+	renameFile := cfg.operation("RenameFile")
+	renameFile.Faults = []string{"FileNotFoundFault", "FileTypeNotAllowedFault"}
+	renameFile.FaultTypes = []reflect.Type{reflect.TypeOf(FileNotFoundFault{}), reflect.TypeOf(FileTypeNotAllowedFault{})}
+	if err := reg.RegisterFunc(renameFile, RenameFile(cfg.Files, cfg.FileTypes)); err != nil {
+		return err
+	}
+
+	createDownloadLink := cfg.operation("CreateDownloadLink")
+	createDownloadLink.Faults = download.Faults
+	createDownloadLink.FaultTypes = download.FaultTypes
+	if err := reg.RegisterFunc(createDownloadLink, CreateDownloadLink(cfg.DownloadLinks, cfg.Files)); err != nil {
+		return err
+	}
+
+	if err := reg.RegisterFunc(cfg.operation("GetQuotaUsage"), GetQuotaUsage(cfg.Files, cfg.Quotas)); err != nil {
+		return err
+	}
+
+	deleteFile := cfg.operation("DeleteFile")
+	deleteFile.Faults = download.Faults
+	deleteFile.FaultTypes = download.FaultTypes
+
+	return reg.RegisterFunc(deleteFile, DeleteFile(cfg.Blobs, cfg.Files))
+}
+
+// Versioned returns the config of a contract version: the namespace and
+// SOAPAction base get the version (e.g. "v2") as an additional path segment
+func (cfg Config) Versioned(version string) Config {
+	cfg = cfg.withDefaults()
+	cfg.Namespace += "/" + version
+	cfg.SOAPActionBase += "/" + version
+	return cfg
+}
+
+// operation describes an operation of the user service following the
+// <Name>Request/<Name>Response element and <SOAPActionBase>/<Name> action conventions
+func (cfg Config) operation(name string) soap.Operation {
+	return soap.Operation{
+		Name:            name,
+		Namespace:       cfg.Namespace,
+		SOAPAction:      cfg.SOAPActionBase + "/" + name,
+		RequestElement:  name + "Request",
+		ResponseElement: name + "Response",
+	}
+}
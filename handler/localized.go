@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"soap-server/soap"
+	"sort"
+
+	"golang.org/x/text/language"
+)
+
+// LocalizedString is a string element with an xml:lang attribute naming its
+// language. The attribute is omitted for the default value, whose language
+// is not recorded.
+type LocalizedString struct {
+	Lang  string `xml:"http://www.w3.org/XML/1998/namespace lang,attr,omitempty"`
+	Value string `xml:",chardata"`
+}
+
+// MarshalJSON writes the value as a plain JSON string
+func (s LocalizedString) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Value)
+}
+
+// UnmarshalJSON reads a plain JSON string as the default value
+func (s *LocalizedString) UnmarshalJSON(data []byte) error {
+	*s = LocalizedString{}
+	return json.Unmarshal(data, &s.Value)
+}
+
+// localize returns the localized value that best matches the languages
+// requested by the client, or the default value if none matches or no
+// language was requested. values maps language tags to values.
+func localize(ctx context.Context, def string, values map[string]string) LocalizedString {
+	requested := soap.LanguagesFromContext(ctx)
+	if len(requested) == 0 || len(values) == 0 {
+		return LocalizedString{Value: def}
+	}
+
+	// The default value comes first, the matcher falls back to it
+	langs := make([]string, 0, len(values))
+	for lang := range values {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	tags := []language.Tag{language.Und}
+	for _, lang := range langs {
+		tags = append(tags, language.Make(lang))
+	}
+
+	_, i, confidence := language.NewMatcher(tags).Match(requested...)
+	if i == 0 || confidence == language.No {
+		return LocalizedString{Value: def}
+	}
+	return LocalizedString{Lang: langs[i-1], Value: values[langs[i-1]]}
+}
+
+// LocalizedName returns the name of the user in the language requested by
+// the client
+func (u User) LocalizedName(ctx context.Context) LocalizedString {
+	return localize(ctx, u.Name, u.Names)
+}
+
+// SetName sets the default name of the user or, if the name has a language,
+// its localized name in that language
+func (u *User) SetName(name LocalizedString) {
+	if name.Lang == "" {
+		u.Name = name.Value
+		return
+	}
+	// Copied as stores may share the map with other readers
+	names := make(map[string]string, len(u.Names)+1)
+	for lang, value := range u.Names {
+		names[lang] = value
+	}
+	names[language.Make(name.Lang).String()] = name.Value
+	u.Names = names
+}
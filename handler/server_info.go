@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"context"
+	"encoding/xml"
+	"soap-server/buildinfo"
+	"time"
+)
+
+// startTime is when the server process started
+var startTime = time.Now()
+
+// GetServerInfoRequest represents the SOAP request for the build of the
+// running server
+type GetServerInfoRequest struct {
+	XMLName xml.Name `xml:"GetServerInfoRequest"`
+}
+
+// GetServerInfoResponse represents the SOAP response with the build of the
+// running server. Commit and build date are omitted if unknown.
+type GetServerInfoResponse struct {
+	XMLName   xml.Name  `xml:"GetServerInfoResponse"`
+	Version   string    `xml:"version"`
+	Commit    string    `xml:"commit,omitempty"`
+	BuildDate string    `xml:"buildDate,omitempty"`
+	Modified  bool      `xml:"modified"`
+	GoVersion string    `xml:"goVersion"`
+	StartedAt time.Time `xml:"startedAt"`
+}
+
+// GetServerInfo handles the GetServerInfo SOAP operation, which tells
+// which build is deployed
+func GetServerInfo() func(context.Context, GetServerInfoRequest) (GetServerInfoResponse, error) {
+	return func(ctx context.Context, req GetServerInfoRequest) (GetServerInfoResponse, error) {
+		build := buildinfo.Get()
+		return GetServerInfoResponse{
+			Version:   build.Version,
+			Commit:    build.Commit,
+			BuildDate: build.Date,
+			Modified:  build.Modified,
+			GoVersion: build.GoVersion,
+			StartedAt: startTime.UTC(),
+		}, nil
+	}
+}
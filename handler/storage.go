@@ -0,0 +1,384 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"soap-server/soap"
+	"soap-server/soapfault"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DefaultChecksumAlgorithm is the hash of the upload checksums unless
+// configured otherwise
+const DefaultChecksumAlgorithm = "sha256"
+
+// checksumAlgorithms are the supported checksum hashes by name
+var checksumAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha384": sha512.New384,
+	"sha512": sha512.New,
+}
+
+// newChecksum returns a hash of the named checksum algorithm
+func newChecksum(algorithm string) (hash.Hash, error) {
+	newHash, ok := checksumAlgorithms[algorithm]
+	if !ok {
+		return nil, fmt.Errorf("unknown checksum algorithm %q", algorithm)
+	}
+	return newHash(), nil
+}
+
+// uploadOptions are the settings of an upload request
+type uploadOptions struct {
+	Expected expectedChecksum
+	TTL      time.Duration // Lifetime of the file if positive, instead of the configured FileTTL
+}
+
+// expectedChecksum is the checksum of an upload computed by the client. An
+// empty Value is not verified; an empty Algorithm means the configured one.
+type expectedChecksum struct {
+	Algorithm string
+	Value     string
+}
+
+// validateChecksum checks that an expected checksum is hex-encoded. Empty
+// checksums are not verified.
+func validateChecksum(checksum string) error {
+	var errs soap.FieldErrors
+	if _, err := hex.DecodeString(checksum); err != nil {
+		errs.Add("checksum", "must be hex-encoded")
+	}
+	return errs.Err()
+}
+
+// storeUpload saves the content of an uploaded file in the blob store as
+// <fileId>_<name> and records it in the catalog. The content is streamed to
+// the store and its checksum computed on the way. If the client sent an
+// expected checksum that does not match, the blob is removed and a
+// ChecksumMismatchFault returned. Files rejected by the file type policy or
+// the malware scanner, or exceeding the size limit or the quota of the
+// client, are not stored. When deduplicating, content already stored is
+// discarded and the upload gets a new file of its own sharing the stored
+// content, rather than the existing file. Errors are SOAP faults.
+func storeUpload(ctx context.Context, cfg Config, name string, content io.Reader, opts uploadOptions) (FileRecord, error) {
+	blobs, files, algorithm, expected := cfg.Blobs, cfg.Files, cfg.ChecksumAlgorithm, opts.Expected
+	hash, err := newChecksum(algorithm)
+	if err != nil {
+		return FileRecord{}, soapfault.Server("Internal error", err.Error())
+	}
+
+	// The content type is detected from the leading bytes before anything
+	// is stored
+	buffered := bufio.NewReaderSize(content, sniffLen)
+	head, err := buffered.Peek(sniffLen)
+	if err != nil && err != io.EOF {
+		return FileRecord{}, soapfault.Server("Internal error", "Failed to read file: "+err.Error())
+	}
+	detectedType := detectContentType(head)
+	if err := cfg.FileTypes.check(name, detectedType); err != nil {
+		return FileRecord{}, err
+	}
+	content = buffered
+	if content, err = limitUpload(ctx, cfg, content); err != nil {
+		return FileRecord{}, err
+	}
+	if cfg.MaxFileSize > 0 {
+		content = &quotaReader{r: content, remaining: cfg.MaxFileSize, fault: soapfault.Client("File too large",
+			fmt.Sprintf("Files may not exceed %d bytes", cfg.MaxFileSize)).WithHTTPStatus(http.StatusRequestEntityTooLarge)}
+	}
+
+	// Sanitize filename and create the blob key
+	now := time.Now().UTC()
+	fileID := uuid.New().String()
+	storedName := blobKey(cfg.FileLayout, fileID, sanitizeFileName(name), now)
+
+	if cfg.Scanner != nil {
+		scanned, err := scanUpload(ctx, cfg, name, storedName, content)
+		if err != nil {
+			return FileRecord{}, err
+		}
+		defer scanned.Close()
+		content = scanned
+	}
+
+	// The expected checksum may use another algorithm than the recorded one
+	verify := hash
+	if expected.Algorithm == "" {
+		expected.Algorithm = algorithm
+	}
+	if expected.Value != "" && expected.Algorithm != algorithm {
+		if verify, err = newChecksum(expected.Algorithm); err != nil {
+			return FileRecord{}, soapfault.Client("Invalid checksum", err.Error())
+		}
+		content = io.TeeReader(content, verify)
+	}
+
+	size, err := blobs.Put(ctx, storedName, io.TeeReader(content, hash))
+	if err != nil {
+		return FileRecord{}, uploadFault(err)
+	}
+
+	if expected.Value != "" {
+		actual := hex.EncodeToString(verify.Sum(nil))
+		if !strings.EqualFold(actual, expected.Value) {
+			removeBlob(ctx, blobs, storedName)
+			return FileRecord{}, soapfault.Client("Checksum mismatch", ChecksumMismatchFault{
+				FileName:  name,
+				Algorithm: expected.Algorithm,
+				Expected:  strings.ToLower(expected.Value),
+				Actual:    actual,
+			})
+		}
+	}
+
+	record := FileRecord{
+		ID:                fileID,
+		Name:              name,
+		StoredName:        storedName,
+		Size:              size,
+		ContentType:       contentTypeOf(name, detectedType),
+		DetectedType:      detectedType,
+		Checksum:          hex.EncodeToString(hash.Sum(nil)),
+		ChecksumAlgorithm: algorithm,
+		UploadedAt:        now.Format(time.RFC3339),
+	}
+	ttl := cfg.FileTTL
+	if opts.TTL > 0 {
+		ttl = opts.TTL
+	}
+	if ttl > 0 {
+		record.ExpiresAt = now.Add(ttl).Format(time.RFC3339)
+	}
+	if principal, ok := soap.PrincipalFromContext(ctx); ok {
+		record.Uploader = principal.Name
+	}
+
+	if cfg.Deduplicate {
+		shared, err := shareDuplicate(ctx, files, record)
+		if err != nil {
+			removeBlob(ctx, blobs, storedName)
+			return FileRecord{}, soapfault.Server("Internal error", "File catalog failed: "+err.Error())
+		}
+		if shared.ID != "" {
+			removeBlob(ctx, blobs, storedName)
+			return shared, nil
+		}
+	}
+
+	if err := files.Add(ctx, record); err != nil {
+		// Files without a record could not be listed or deleted
+		removeBlob(ctx, blobs, storedName)
+		return FileRecord{}, soapfault.Server("Internal error", "File catalog failed: "+err.Error())
+	}
+	return record, nil
+}
+
+// shareDuplicate adds the record of an upload with the blob of a stored file
+// with the same content, if there is one, and returns it. The record keeps
+// the ID, name and uploader of the upload; only the content is shared. The
+// new blob of the upload is then no longer needed.
+func shareDuplicate(ctx context.Context, files FileCatalog, record FileRecord) (FileRecord, error) {
+	candidates, err := files.List(ctx, FileQuery{Checksum: record.Checksum, ChecksumAlgorithm: record.ChecksumAlgorithm})
+	if err != nil {
+		return FileRecord{}, err
+	}
+	for _, candidate := range candidates {
+		if candidate.Size != record.Size {
+			continue
+		}
+		shared := record
+		shared.StoredName = candidate.StoredName
+		if err := files.Add(ctx, shared); err != nil {
+			return FileRecord{}, err
+		}
+		// A delete of the candidate releases the blob after removing its
+		// record, so the blob is kept for the record added before the
+		// candidate is found. Otherwise the upload keeps its own blob.
+		if _, err := files.Get(ctx, candidate.ID); err == nil {
+			return shared, nil
+		} else if !errors.Is(err, ErrFileNotFound) {
+			files.Delete(context.WithoutCancel(ctx), shared.ID)
+			return FileRecord{}, err
+		}
+		if err := files.Delete(ctx, shared.ID); err != nil && !errors.Is(err, ErrFileNotFound) {
+			return FileRecord{}, err
+		}
+	}
+	return FileRecord{}, nil
+}
+
+// releaseBlob deletes the blob of a deleted file record unless another
+// record still shares it
+func releaseBlob(ctx context.Context, blobs BlobStore, files FileCatalog, record FileRecord) error {
+	sharing, err := files.List(ctx, FileQuery{Checksum: record.Checksum, ChecksumAlgorithm: record.ChecksumAlgorithm})
+	if err != nil {
+		return err
+	}
+	for _, f := range sharing {
+		if f.StoredName == record.StoredName && f.ID != record.ID {
+			return nil
+		}
+	}
+	// A file already missing from the store is not an error
+	return blobs.Delete(ctx, record.StoredName)
+}
+
+// sharesBlob reports whether the file is stored in the blob of another
+// upload with the same content
+func (f FileRecord) sharesBlob() bool {
+	id, ok := uploadID(f.StoredName)
+	return ok && id != f.ID
+}
+
+// uploadKey returns the key of the upload path of the file: its blob key,
+// or the key of its own ID in the flat layout if it shares a blob, so that
+// the path does not name the other upload
+func (f FileRecord) uploadKey() string {
+	if !f.sharesBlob() {
+		return f.StoredName
+	}
+	return blobKey(LayoutFlat, f.ID, sanitizeFileName(f.Name), time.Time{})
+}
+
+// removeBlob deletes a blob that must not be kept, even if the request was
+// cancelled. Failures are logged.
+func removeBlob(ctx context.Context, blobs BlobStore, key string) {
+	if err := blobs.Delete(context.WithoutCancel(ctx), key); err != nil {
+		soap.Error(ctx, "Failed to remove blob", "key", key, soap.LogKeyError, err)
+	}
+}
+
+// Layouts of the blob keys of the uploaded files
+const (
+	LayoutFlat = "flat" // <fileId>_<name>
+	LayoutDate = "date" // YYYY/MM/DD/<fileId>_<name>, by upload date (UTC)
+	LayoutHash = "hash" // ab/cd/<fileId>_<name>, by the first hex digits of the ID
+)
+
+// validateLayout checks that a file layout is known
+func validateLayout(layout string) error {
+	switch layout {
+	case "", LayoutFlat, LayoutDate, LayoutHash:
+		return nil
+	}
+	return fmt.Errorf("unknown file layout %q, expected %s, %s or %s", layout, LayoutFlat, LayoutDate, LayoutHash)
+}
+
+// blobKey returns the key of a file uploaded at now in the layout. Keys of
+// sharded layouts are relative paths with "/" separators.
+func blobKey(layout, id, name string, now time.Time) string {
+	key := fmt.Sprintf("%s_%s", id, name)
+	switch layout {
+	case LayoutDate:
+		return now.Format("2006/01/02/") + key
+	case LayoutHash:
+		return id[:2] + "/" + id[2:4] + "/" + key
+	}
+	return key
+}
+
+// uploadID returns the file ID of a blob key named <fileId>_<name>, in a
+// shard directory or not
+func uploadID(key string) (string, bool) {
+	id, _, ok := strings.Cut(path.Base(key), "_")
+	if !ok {
+		return "", false
+	}
+	if _, err := uuid.Parse(id); err != nil {
+		return "", false
+	}
+	return id, true
+}
+
+// contentTypeOf returns the media type of a file by its extension, or the
+// type detected from its content if the extension is unknown
+func contentTypeOf(name, detectedType string) string {
+	if t := mime.TypeByExtension(filepath.Ext(name)); t != "" {
+		return t
+	}
+	if detectedType != "" {
+		return detectedType
+	}
+	return "application/octet-stream"
+}
+
+// inspectBlob returns the hex-encoded digest of the blob content with the
+// named checksum algorithm, the content type detected from it and its size.
+// The size is counted as stores may list the size of what they store, such
+// as encrypted content.
+func inspectBlob(ctx context.Context, blobs BlobStore, key, algorithm string) (checksum, detectedType string, size int64, err error) {
+	hash, err := newChecksum(algorithm)
+	if err != nil {
+		return "", "", 0, err
+	}
+	blob, _, err := blobs.Open(ctx, key)
+	if err != nil {
+		return "", "", 0, err
+	}
+	defer blob.Close()
+
+	buffered := bufio.NewReaderSize(contextReader{ctx: ctx, r: blob}, sniffLen)
+	head, err := buffered.Peek(sniffLen)
+	if err != nil && err != io.EOF {
+		return "", "", 0, err
+	}
+	size, err = io.Copy(hash, buffered)
+	if err != nil {
+		return "", "", 0, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), detectContentType(head), size, nil
+}
+
+// saveFile copies r to path and returns the number of bytes written,
+// checking ctx between reads. If the request is cancelled (client
+// disconnect or timeout) or the copy fails, the partial file is removed.
+func saveFile(ctx context.Context, path string, r io.Reader) (int64, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := io.Copy(file, contextReader{ctx: ctx, r: r})
+	if err == nil {
+		err = file.Close()
+	} else {
+		file.Close()
+	}
+	if err != nil {
+		os.Remove(path)
+		return 0, err
+	}
+	return n, nil
+}
+
+// contextReader fails reads once its context is done
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"io"
+	"soap-server/soap"
+	"strings"
+	"testing"
+)
+
+func uploadAs(t *testing.T, cfg Config, uploader, name, content string) FileRecord {
+	t.Helper()
+	ctx := soap.WithPrincipal(context.Background(), soap.Principal{Name: uploader})
+	record, err := storeUpload(ctx, cfg, name, strings.NewReader(content), uploadOptions{})
+	if err != nil {
+		t.Fatalf("upload of %s by %s: %v", name, uploader, err)
+	}
+	return record
+}
+
+func TestDeduplicatedUploadIsOwnedByUploader(t *testing.T) {
+	ctx := context.Background()
+	cfg := Config{
+		Blobs:             NewDiskBlobStore(t.TempDir()),
+		Files:             NewMemoryFileCatalog(),
+		ChecksumAlgorithm: "sha256",
+		Deduplicate:       true,
+	}
+
+	first := uploadAs(t, cfg, "alice", "report.txt", "same content")
+	second := uploadAs(t, cfg, "bob", "copy.txt", "same content")
+
+	if second.ID == first.ID {
+		t.Fatalf("duplicate upload returned the file %s of another uploader", first.ID)
+	}
+	if second.Uploader != "bob" || second.Name != "copy.txt" {
+		t.Errorf("duplicate upload recorded as %s by %s, want copy.txt by bob", second.Name, second.Uploader)
+	}
+	if second.StoredName != first.StoredName || !second.sharesBlob() {
+		t.Errorf("duplicate upload stored under %s, want the blob %s", second.StoredName, first.StoredName)
+	}
+	if strings.Contains(uploadPath(second.uploadKey()), first.ID) {
+		t.Errorf("path %s of the duplicate names the first upload", uploadPath(second.uploadKey()))
+	}
+	for _, client := range []string{"alice", "bob"} {
+		if _, used, err := quotaUsage(ctx, cfg.Files, client); err != nil || used != int64(len("same content")) {
+			t.Errorf("quota usage of %s = %d, %v; want %d", client, used, err, len("same content"))
+		}
+	}
+
+	// The content stays until the last file sharing it is deleted
+	if err := deleteFile(ctx, cfg.Blobs, cfg.Files, first); err != nil {
+		t.Fatal(err)
+	}
+	content, _, err := cfg.Blobs.Open(ctx, second.StoredName)
+	if err != nil {
+		t.Fatalf("content removed with the first file: %v", err)
+	}
+	data, _ := io.ReadAll(content)
+	content.Close()
+	if string(data) != "same content" {
+		t.Errorf("content = %q", data)
+	}
+	if err := deleteFile(ctx, cfg.Blobs, cfg.Files, second); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := cfg.Blobs.Open(ctx, second.StoredName); !errors.Is(err, ErrBlobNotFound) {
+		t.Errorf("content kept after the last file was deleted: %v", err)
+	}
+}
+
+func TestSyncKeepsSharedBlobOfDeletedUpload(t *testing.T) {
+	ctx := context.Background()
+	cfg := Config{
+		Blobs:             NewDiskBlobStore(t.TempDir()),
+		Files:             NewMemoryFileCatalog(),
+		ChecksumAlgorithm: "sha256",
+		Deduplicate:       true,
+	}
+	first := uploadAs(t, cfg, "alice", "a.txt", "shared")
+	second := uploadAs(t, cfg, "bob", "b.txt", "shared")
+	if err := deleteFile(ctx, cfg.Blobs, cfg.Files, first); err != nil {
+		t.Fatal(err)
+	}
+
+	added, removed, err := SyncFileCatalog(ctx, cfg.Files, cfg.Blobs, "sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 0 || removed != 0 {
+		t.Errorf("sync added %d and removed %d records, want none", added, removed)
+	}
+	if _, err := cfg.Files.Get(ctx, first.ID); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("deleted file %s restored from the shared blob: %v", first.ID, err)
+	}
+	if _, err := cfg.Files.Get(ctx, second.ID); err != nil {
+		t.Errorf("sharing file removed: %v", err)
+	}
+}
@@ -1,134 +1,277 @@
 package handler
 
 import (
+	"context"
 	"encoding/xml"
-	"fmt"
-	"net/http"
-	"strings"
+	"errors"
+	"soap-server/soap"
+	"soap-server/soapfault"
+
+	"golang.org/x/text/language"
 )
 
-// User represents a user in the system
+// User represents a user in the system. Deleted users keep their record
+// with DeletedAt set until they are restored.
 type User struct {
-	ID        string `json:"id"`
-	Name      string `json:"name"`
-	Email     string `json:"email"`
-	CreatedAt string `json:"createdAt"`
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	Email     string   `json:"email"`
+	CreatedAt string   `json:"createdAt"`
+	UpdatedAt string   `json:"updatedAt,omitempty"` // RFC 3339 time of the last change
+	DeletedAt string   `json:"deletedAt,omitempty"` // RFC 3339 time of the deletion
+	Roles     []string `json:"roles,omitempty"`     // Roles granted to the user
+
+	// Localized names by language tag, e.g. {"en": "Gildong Hong"}. Name is
+	// the default returned when none matches the requested languages.
+	Names map[string]string `json:"names,omitempty"`
 }
 
-// Mock user database
-var userDB = map[string]User{
-	"1": {ID: "1", Name: "홍길동", Email: "hong@example.com", CreatedAt: "2024-01-01"},
-	"2": {ID: "2", Name: "김철수", Email: "kim@example.com", CreatedAt: "2024-01-15"},
-	"3": {ID: "3", Name: "이영희", Email: "lee@example.com", CreatedAt: "2024-02-01"},
+// Deleted reports whether the user has been soft deleted
+func (u User) Deleted() bool {
+	return u.DeletedAt != ""
 }
 
 // GetUserRequest represents the SOAP request for getting a user
 type GetUserRequest struct {
-	XMLName xml.Name `xml:"http://example.com/soap/user GetUserRequest"`
-	ID      string   `xml:"id"`
+	XMLName xml.Name `xml:"GetUserRequest"`
+	ID      string   `xml:"id" validate:"required"`
 }
 
 // GetUserResponse represents the SOAP response for getting a user
 type GetUserResponse struct {
-	XMLName   xml.Name `xml:"http://example.com/soap/user GetUserResponse"`
-	ID        string   `xml:"id"`
-	Name      string   `xml:"name"`
-	Email     string   `xml:"email"`
-	CreatedAt string   `xml:"createdAt"`
+	XMLName   xml.Name        `xml:"GetUserResponse" json:"-"`
+	ID        string          `xml:"id" json:"id"`
+	Name      LocalizedString `xml:"name" json:"name"`
+	Email     string          `xml:"email" json:"email"`
+	CreatedAt string          `xml:"createdAt" json:"createdAt"`
+	UpdatedAt string          `xml:"updatedAt,omitempty" json:"updatedAt,omitempty"`
+}
+
+// UserNotFoundFault is the fault detail returned when no user has the
+// requested ID
+type UserNotFoundFault struct {
+	XMLName xml.Name `xml:"UserNotFoundFault"`
+	UserID  string   `xml:"userId"`
 }
 
 // GetUser handles the GetUser SOAP operation
-func GetUser(w http.ResponseWriter, r *http.Request) {
-	// Read and parse the SOAP request body
-	var soapEnvelope struct {
-		XMLName xml.Name `xml:"http://schemas.xmlsoap.org/soap/envelope/ Envelope"`
-		Body    struct {
-			XMLName xml.Name        `xml:"http://schemas.xmlsoap.org/soap/envelope/ Body"`
-			Request GetUserRequest  `xml:"GetUserRequest"`
+func GetUser(users UserStore) func(context.Context, GetUserRequest) (GetUserResponse, error) {
+	return func(ctx context.Context, req GetUserRequest) (GetUserResponse, error) {
+		// Look up the user
+		user, err := users.Get(ctx, req.ID)
+		if err != nil {
+			return GetUserResponse{}, userError(req.ID, err)
+		}
+
+		// Create SOAP response
+		response := GetUserResponse{
+			ID:        user.ID,
+			Name:      user.LocalizedName(ctx),
+			Email:     user.Email,
+			CreatedAt: user.CreatedAt,
+			UpdatedAt: user.UpdatedAt,
 		}
+
+		return response, nil
 	}
+}
 
-	if err := xml.NewDecoder(r.Body).Decode(&soapEnvelope); err != nil {
-		sendSOAPError(w, "Client", "Invalid XML format", err.Error())
-		return
+// userError maps a store error to a SOAP fault
+func userError(id string, err error) error {
+	if errors.Is(err, ErrUserNotFound) {
+		return soapfault.Client("User not found", UserNotFoundFault{UserID: id})
 	}
+	return soapfault.Server("Internal error", "User store failed: "+err.Error())
+}
+
+// GetUsersRequest represents the SOAP request for getting several users
+type GetUsersRequest struct {
+	XMLName xml.Name `xml:"GetUsersRequest"`
+	IDs     []string `xml:"id" validate:"required,max=1000"`
+}
+
+// GetUsersResponse represents the SOAP response with the users found and
+// the requested IDs that do not exist
+type GetUsersResponse struct {
+	XMLName    xml.Name     `xml:"GetUsersResponse" json:"-"`
+	Users      []UserRecord `xml:"user" json:"users"`
+	MissingIDs []string     `xml:"missingId" json:"missingIds"`
+}
 
-	userID := soapEnvelope.Body.Request.ID
+// GetUsers handles the GetUsers SOAP operation. Users are returned in the
+// order of the request; duplicate IDs are looked up once.
+func GetUsers(users UserStore) func(context.Context, GetUsersRequest) (GetUsersResponse, error) {
+	return func(ctx context.Context, req GetUsersRequest) (GetUsersResponse, error) {
+		var response GetUsersResponse
+		seen := make(map[string]bool, len(req.IDs))
+		for _, id := range req.IDs {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
 
-	// Look up the user
-	user, exists := userDB[userID]
-	if !exists {
-		sendSOAPError(w, "Client", "User not found", fmt.Sprintf("User with ID %s not found", userID))
-		return
+			user, err := users.Get(ctx, id)
+			switch {
+			case errors.Is(err, ErrUserNotFound):
+				response.MissingIDs = append(response.MissingIDs, id)
+			case err != nil:
+				return GetUsersResponse{}, userError(id, err)
+			default:
+				response.Users = append(response.Users, userRecord(ctx, user))
+			}
+		}
+		return response, nil
 	}
+}
 
-	// Create SOAP response
-	response := GetUserResponse{
-		ID:        user.ID,
-		Name:      user.Name,
-		Email:     user.Email,
-		CreatedAt: user.CreatedAt,
+// UpdateUserRequest represents the SOAP request for updating a user. Only
+// the fields present in the request are changed; a name with xml:lang sets
+// the localized name of that language.
+type UpdateUserRequest struct {
+	XMLName xml.Name         `xml:"UpdateUserRequest" json:"-"`
+	ID      string           `xml:"id" json:"-" validate:"required"`
+	Name    *LocalizedString `xml:"name,omitempty" json:"name,omitempty" validate:"min=1,max=100"`
+	Email   *string          `xml:"email,omitempty" json:"email,omitempty" validate:"email"`
+}
+
+// Validate checks the language of the name
+func (req UpdateUserRequest) Validate() error {
+	var errs soap.FieldErrors
+	if req.Name != nil && req.Name.Lang != "" {
+		if _, err := language.Parse(req.Name.Lang); err != nil {
+			errs.Add("name", "xml:lang must be a BCP 47 language tag")
+		}
 	}
+	return errs.Err()
+}
+
+// UpdateUserResponse represents the SOAP response with the updated user
+type UpdateUserResponse struct {
+	XMLName   xml.Name        `xml:"UpdateUserResponse" json:"-"`
+	ID        string          `xml:"id" json:"id"`
+	Name      LocalizedString `xml:"name" json:"name"`
+	Email     string          `xml:"email" json:"email"`
+	CreatedAt string          `xml:"createdAt" json:"createdAt"`
+	UpdatedAt string          `xml:"updatedAt,omitempty" json:"updatedAt,omitempty"`
+}
 
-	sendSOAPResponse(w, "GetUserResponse", response)
-}
-
-// sendSOAPResponse sends a SOAP response
-func sendSOAPResponse(w http.ResponseWriter, elementName string, body interface{}) {
-	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
-
-	envelope := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
-<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/">
-    <soap:Body>
-        <%s xmlns="http://example.com/soap/user">
-%s
-        </%s>
-    </soap:Body>
-</soap:Envelope>`, elementName, marshalXML(body), elementName)
-
-	w.Write([]byte(envelope))
-}
-
-// marshalXML converts a struct to XML elements
-func marshalXML(v interface{}) string {
-	var result strings.Builder
-
-	// Manually build XML based on struct type
-	switch t := v.(type) {
-	case GetUserResponse:
-		result.WriteString(fmt.Sprintf("<id>%s</id>\n        ", t.ID))
-		result.WriteString(fmt.Sprintf("<name>%s</name>\n        ", t.Name))
-		result.WriteString(fmt.Sprintf("<email>%s</email>\n        ", t.Email))
-		result.WriteString(fmt.Sprintf("<createdAt>%s</createdAt>", t.CreatedAt))
-	case UploadFileResponse:
-		result.WriteString(fmt.Sprintf("<fileId>%s</fileId>\n        ", t.FileID))
-		result.WriteString(fmt.Sprintf("<fileName>%s</fileName>\n        ", t.FileName))
-		result.WriteString(fmt.Sprintf("<size>%d</size>\n        ", t.Size))
-		result.WriteString(fmt.Sprintf("<path>%s</path>", t.Path))
-	case UploadFileMTOMResponse:
-		result.WriteString(fmt.Sprintf("<fileId>%s</fileId>\n        ", t.FileID))
-		result.WriteString(fmt.Sprintf("<fileName>%s</fileName>\n        ", t.FileName))
-		result.WriteString(fmt.Sprintf("<size>%d</size>\n        ", t.Size))
-		result.WriteString(fmt.Sprintf("<path>%s</path>", t.Path))
+// UpdateUser handles the UpdateUser SOAP operation
+func UpdateUser(users UserStore) func(context.Context, UpdateUserRequest) (UpdateUserResponse, error) {
+	return func(ctx context.Context, req UpdateUserRequest) (UpdateUserResponse, error) {
+		// Apply the provided fields only
+		user, err := users.Update(ctx, req.ID, func(u *User) error {
+			if req.Name != nil {
+				u.SetName(*req.Name)
+			}
+			if req.Email != nil {
+				u.Email = *req.Email
+			}
+			return nil
+		})
+		if err != nil {
+			return UpdateUserResponse{}, userError(req.ID, err)
+		}
+
+		return UpdateUserResponse{
+			ID:        user.ID,
+			Name:      user.LocalizedName(ctx),
+			Email:     user.Email,
+			CreatedAt: user.CreatedAt,
+			UpdatedAt: user.UpdatedAt,
+		}, nil
 	}
+}
 
-	return result.String()
+// DeleteUserRequest represents the SOAP request for deleting a user
+type DeleteUserRequest struct {
+	XMLName xml.Name `xml:"DeleteUserRequest"`
+	ID      string   `xml:"id" validate:"required"`
 }
 
-// sendSOAPError sends a SOAP fault response
-func sendSOAPError(w http.ResponseWriter, faultCode, faultString, detail string) {
-	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+// DeleteUserResponse confirms the deletion of a user
+type DeleteUserResponse struct {
+	XMLName xml.Name `xml:"DeleteUserResponse" json:"-"`
+	ID      string   `xml:"id" json:"id"`
+	Deleted bool     `xml:"deleted" json:"deleted"`
+}
+
+// DeleteUser handles the DeleteUser SOAP operation. Users are soft deleted
+// and can be brought back with RestoreUser.
+func DeleteUser(users UserStore) func(context.Context, DeleteUserRequest) (DeleteUserResponse, error) {
+	return func(ctx context.Context, req DeleteUserRequest) (DeleteUserResponse, error) {
+		if err := users.Delete(ctx, req.ID); err != nil {
+			return DeleteUserResponse{}, userError(req.ID, err)
+		}
+
+		return DeleteUserResponse{ID: req.ID, Deleted: true}, nil
+	}
+}
 
-	fault := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
-<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/">
-    <soap:Body>
-        <soap:Fault>
-            <faultcode>%s</faultcode>
-            <faultstring>%s</faultstring>
-            <detail>%s</detail>
-        </soap:Fault>
-    </soap:Body>
-</soap:Envelope>`, faultCode, faultString, detail)
+// RestoreUserRequest represents the SOAP request for restoring a deleted user
+type RestoreUserRequest struct {
+	XMLName xml.Name `xml:"RestoreUserRequest"`
+	ID      string   `xml:"id" validate:"required"`
+}
+
+// RestoreUserResponse represents the SOAP response with the restored user
+type RestoreUserResponse struct {
+	XMLName   xml.Name        `xml:"RestoreUserResponse" json:"-"`
+	ID        string          `xml:"id" json:"id"`
+	Name      LocalizedString `xml:"name" json:"name"`
+	Email     string          `xml:"email" json:"email"`
+	CreatedAt string          `xml:"createdAt" json:"createdAt"`
+	UpdatedAt string          `xml:"updatedAt,omitempty" json:"updatedAt,omitempty"`
+}
 
-	w.Write([]byte(fault))
+// RestoreUser handles the RestoreUser SOAP operation. Restoring a user that
+// is not deleted returns it unchanged.
+func RestoreUser(users UserStore) func(context.Context, RestoreUserRequest) (RestoreUserResponse, error) {
+	return func(ctx context.Context, req RestoreUserRequest) (RestoreUserResponse, error) {
+		user, err := users.Restore(ctx, req.ID)
+		if err != nil {
+			return RestoreUserResponse{}, userError(req.ID, err)
+		}
+
+		return RestoreUserResponse{
+			ID:        user.ID,
+			Name:      user.LocalizedName(ctx),
+			Email:     user.Email,
+			CreatedAt: user.CreatedAt,
+			UpdatedAt: user.UpdatedAt,
+		}, nil
+	}
+}
+
+// UserRecord is the user element of list and version 2 responses
+type UserRecord struct {
+	ID        string          `xml:"id" json:"id"`
+	Name      LocalizedString `xml:"name" json:"name"`
+	Email     string          `xml:"email" json:"email"`
+	CreatedAt string          `xml:"createdAt" json:"createdAt"`
+	UpdatedAt string          `xml:"updatedAt,omitempty" json:"updatedAt,omitempty"`
+}
+
+// userRecord returns the user element of a user, with the name in the
+// language requested by the client
+func userRecord(ctx context.Context, u User) UserRecord {
+	return UserRecord{ID: u.ID, Name: u.LocalizedName(ctx), Email: u.Email, CreatedAt: u.CreatedAt, UpdatedAt: u.UpdatedAt}
+}
+
+// GetUserResponseV2 is the version 2 GetUser response, which wraps the user
+// fields in a user element
+type GetUserResponseV2 struct {
+	XMLName xml.Name   `xml:"GetUserResponse"`
+	User    UserRecord `xml:"user"`
+}
+
+// GetUserV2 handles version 2 of the GetUser SOAP operation
+func GetUserV2(users UserStore) func(context.Context, GetUserRequest) (GetUserResponseV2, error) {
+	return func(ctx context.Context, req GetUserRequest) (GetUserResponseV2, error) {
+		user, err := users.Get(ctx, req.ID)
+		if err != nil {
+			return GetUserResponseV2{}, userError(req.ID, err)
+		}
+
+		return GetUserResponseV2{User: userRecord(ctx, user)}, nil
+	}
 }
@@ -0,0 +1,304 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"soap-server/soap"
+	"soap-server/soapfault"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// maxImportRows bounds the number of users in one import
+const maxImportRows = 10000
+
+// ImportUsersRequest represents the SOAP request for importing users in bulk
+type ImportUsersRequest struct {
+	XMLName xml.Name   `xml:"ImportUsersRequest"`
+	Format  string     `xml:"format" xsd:"enum=csv|xml" validate:"required,oneof=csv|xml"`
+	Data    BinaryData `xml:"data"`
+}
+
+// ImportUsersResponse represents the SOAP response with the result of every
+// imported row
+type ImportUsersResponse struct {
+	XMLName  xml.Name       `xml:"ImportUsersResponse"`
+	Total    int            `xml:"total"`
+	Imported int            `xml:"imported"`
+	Failed   int            `xml:"failed"`
+	Results  []ImportResult `xml:"result"`
+}
+
+// ImportResult is the outcome of one row of an import. Rows are numbered
+// from 1, not counting the CSV header.
+type ImportResult struct {
+	Row    int    `xml:"row"`
+	ID     string `xml:"id,omitempty"`
+	Status string `xml:"status"` // "created" or "failed"
+	Error  string `xml:"error,omitempty"`
+}
+
+// importUser is a user of an import payload. A CSV payload has a header row
+// naming the columns id, name, email, createdAt and roles (separated by
+// "|"); an XML payload is a users element with a user element per user.
+type importUser struct {
+	ID        string   `xml:"id"`
+	Name      string   `xml:"name" validate:"required,max=100"`
+	Email     string   `xml:"email" validate:"required,email"`
+	CreatedAt string   `xml:"createdAt"`
+	Roles     []string `xml:"role"`
+
+	err error // Set when the row could not be read
+}
+
+// Validate checks the createdAt date and the roles
+func (u importUser) Validate() error {
+	var errs soap.FieldErrors
+	if _, err := time.Parse(dateLayout, u.CreatedAt); u.CreatedAt != "" && err != nil {
+		errs.Add("createdAt", "must be a date in the format YYYY-MM-DD")
+	}
+	for _, role := range u.Roles {
+		if role != RoleAdmin && role != RoleEditor && role != RoleViewer {
+			errs.Add("role", "must be one of %s, %s, %s", RoleAdmin, RoleEditor, RoleViewer)
+		}
+	}
+	return errs.Err()
+}
+
+// ImportUsers handles the ImportUsers SOAP operation. The payload is sent
+// base64 encoded or, by MTOM clients, as an attachment; it uses a plain
+// handler to read multipart requests. Every row is created on its own, so
+// failed rows do not stop the import.
+func ImportUsers(users UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		req, data, err := parseImportRequest(r)
+		if err != nil {
+			soap.WriteError(w, r, err)
+			return
+		}
+
+		var rows []importUser
+		if req.Format == "csv" {
+			rows, err = parseUsersCSV(data)
+		} else {
+			rows, err = parseUsersXML(ctx, data)
+		}
+		if err != nil {
+			soap.WriteFault(w, r, soapfault.Client("Invalid import data", err.Error()))
+			return
+		}
+		if len(rows) > maxImportRows {
+			soap.WriteFault(w, r, soapfault.Client("Invalid import data",
+				fmt.Sprintf("Import contains %d users, at most %d are allowed", len(rows), maxImportRows)))
+			return
+		}
+
+		response := ImportUsersResponse{Total: len(rows)}
+		for i, row := range rows {
+			result := importRow(ctx, users, row)
+			result.Row = i + 1
+			if result.Status == "created" {
+				response.Imported++
+			} else {
+				response.Failed++
+			}
+			response.Results = append(response.Results, result)
+		}
+
+		if err := soap.WriteResponse(w, r, response); err != nil {
+			soap.WriteFault(w, r, soapfault.Server("Internal error", "Failed to encode response: "+err.Error()))
+			return
+		}
+
+		soap.Info(ctx, "Users imported",
+			"format", req.Format, "total", response.Total, "imported", response.Imported, "failed", response.Failed)
+	}
+}
+
+// importRow validates and creates the user of a row
+func importRow(ctx context.Context, users UserStore, row importUser) ImportResult {
+	result := ImportResult{ID: row.ID, Status: "failed"}
+	if row.err != nil {
+		result.Error = row.err.Error()
+		return result
+	}
+	if err := soap.Validate(row); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	user := User{ID: row.ID, Name: row.Name, Email: row.Email, CreatedAt: row.CreatedAt, Roles: row.Roles}
+	if user.ID == "" {
+		user.ID = uuid.New().String()
+	}
+	if user.CreatedAt == "" {
+		user.CreatedAt = time.Now().Format(dateLayout)
+	}
+	result.ID = user.ID
+
+	if err := users.Create(ctx, user); err != nil {
+		if errors.Is(err, ErrUserExists) {
+			result.Error = fmt.Sprintf("user %s already exists", user.ID)
+		} else {
+			result.Error = "User store failed: " + err.Error()
+		}
+		return result
+	}
+	result.Status = "created"
+	return result
+}
+
+// parseImportRequest decodes the request element and returns it with the
+// decoded payload
+func parseImportRequest(r *http.Request) (ImportUsersRequest, []byte, error) {
+	var envelopeData []byte
+	var parts []MultipartPart
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/related") {
+		soapPart, attachments, err := readMTOMParts(r)
+		if err != nil {
+			return ImportUsersRequest{}, nil, mtomFault(err)
+		}
+		defer removeParts(attachments)
+		envelopeData, parts = []byte(soapPart), attachments
+	} else {
+		data, err := io.ReadAll(contextReader{ctx: r.Context(), r: r.Body})
+		if err != nil {
+			if fault, ok := soap.LimitFault(err); ok {
+				return ImportUsersRequest{}, nil, fault
+			}
+			return ImportUsersRequest{}, nil, soapfault.Client("Failed to read request", err.Error())
+		}
+		envelopeData = data
+	}
+
+	var req ImportUsersRequest
+	if err := soap.DecodeEnvelope(r, bytes.NewReader(envelopeData), &req); err != nil {
+		return ImportUsersRequest{}, nil, err
+	}
+	if err := soap.Validate(req); err != nil {
+		var errs *soap.FieldErrors
+		if errors.As(err, &errs) {
+			return ImportUsersRequest{}, nil, soapfault.Client("Invalid input: "+errs.Error(), *errs)
+		}
+		return ImportUsersRequest{}, nil, soapfault.Client("Invalid input", err.Error())
+	}
+
+	// An xop:Include or SwA href refers to an attachment
+	if err := checkIncludes(r.Context(), envelopeData, parts); err != nil {
+		return ImportUsersRequest{}, nil, soapfault.Client("Invalid MTOM request", err.Error())
+	}
+	if href := req.Data.ref(); href != "" {
+		part, ok := findPart(parts, href)
+		if !ok {
+			return ImportUsersRequest{}, nil, soapfault.Client("Invalid MTOM request", "Attachment reference not found: "+href)
+		}
+		// Imports are parsed in memory and bounded by maxImportRows
+		data, err := os.ReadFile(part.Path)
+		if err != nil {
+			return ImportUsersRequest{}, nil, soapfault.Server("Internal error", "Failed to read attachment: "+err.Error())
+		}
+		return req, data, nil
+	}
+
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(req.Data.Base64))
+	if err != nil {
+		return ImportUsersRequest{}, nil, soapfault.Client("Invalid import data", "Failed to decode base64 data: "+err.Error())
+	}
+	if len(data) == 0 {
+		return ImportUsersRequest{}, nil, soapfault.Client("Invalid input", "Import data is required")
+	}
+	return req, data, nil
+}
+
+// parseUsersCSV reads the users of a CSV payload. Rows with the wrong number
+// of columns are returned with their error.
+func parseUsersCSV(data []byte) ([]importUser, error) {
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\ufeff"))))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("CSV data has no header row")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+		switch strings.ToLower(name) {
+		case "id", "name", "email", "createdat", "roles":
+			columns[strings.ToLower(name)] = i
+		default:
+			return nil, fmt.Errorf("unknown CSV column %q; expected id, name, email, createdAt and roles", name)
+		}
+	}
+	for _, required := range []string{"name", "email"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("CSV header is missing the %s column", required)
+		}
+	}
+
+	var rows []importUser
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if len(record) != len(header) {
+			rows = append(rows, importUser{err: fmt.Errorf("row has %d columns, the header %d", len(record), len(header))})
+			continue
+		}
+		cell := func(name string) string {
+			if i, ok := columns[name]; ok {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		row := importUser{ID: cell("id"), Name: cell("name"), Email: cell("email"), CreatedAt: cell("createdat")}
+		for _, role := range strings.Split(cell("roles"), "|") {
+			if role = strings.TrimSpace(role); role != "" {
+				row.Roles = append(row.Roles, role)
+			}
+		}
+		rows = append(rows, row)
+	}
+}
+
+// parseUsersXML reads the users of an XML payload
+func parseUsersXML(ctx context.Context, data []byte) ([]importUser, error) {
+	var payload struct {
+		XMLName xml.Name     `xml:"users"`
+		Users   []importUser `xml:"user"`
+	}
+	if err := soap.NewDecoder(ctx, bytes.NewReader(data)).Decode(&payload); err != nil {
+		return nil, err
+	}
+
+	for i := range payload.Users {
+		u := &payload.Users[i]
+		u.ID = strings.TrimSpace(u.ID)
+		u.Name = strings.TrimSpace(u.Name)
+		u.Email = strings.TrimSpace(u.Email)
+		u.CreatedAt = strings.TrimSpace(u.CreatedAt)
+	}
+	return payload.Users, nil
+}
@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"context"
+	"encoding/xml"
+)
+
+// Roles that can be granted to users
+const (
+	RoleAdmin  = "admin"  // Manages users and files
+	RoleEditor = "editor" // Changes user details
+	RoleViewer = "viewer" // Reads only
+)
+
+// HasRole reports whether the user has been granted the role
+func (u User) HasRole(role string) bool {
+	return hasRole(u.Roles, role)
+}
+
+// hasRole reports whether role is one of the roles
+func hasRole(roles []string, role string) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// AssignRoleRequest represents the SOAP request for granting a role to a user
+type AssignRoleRequest struct {
+	XMLName xml.Name `xml:"AssignRoleRequest" json:"-"`
+	ID      string   `xml:"id" json:"-" validate:"required"`
+	Role    string   `xml:"role" json:"role" xsd:"enum=admin|editor|viewer" validate:"required,oneof=admin|editor|viewer"`
+}
+
+// AssignRoleResponse represents the SOAP response with the roles of the user
+type AssignRoleResponse struct {
+	XMLName xml.Name `xml:"AssignRoleResponse" json:"-"`
+	ID      string   `xml:"id" json:"id"`
+	Roles   []string `xml:"role" json:"roles"`
+}
+
+// AssignRole handles the AssignRole SOAP operation. Assigning a role the
+// user already has leaves the roles unchanged.
+func AssignRole(users UserStore) func(context.Context, AssignRoleRequest) (AssignRoleResponse, error) {
+	return func(ctx context.Context, req AssignRoleRequest) (AssignRoleResponse, error) {
+		user, err := users.Update(ctx, req.ID, func(u *User) error {
+			if !u.HasRole(req.Role) {
+				u.Roles = append(append([]string(nil), u.Roles...), req.Role)
+			}
+			return nil
+		})
+		if err != nil {
+			return AssignRoleResponse{}, userError(req.ID, err)
+		}
+
+		return AssignRoleResponse{ID: user.ID, Roles: user.Roles}, nil
+	}
+}
+
+// GetUserRolesRequest represents the SOAP request for the roles of a user
+type GetUserRolesRequest struct {
+	XMLName xml.Name `xml:"GetUserRolesRequest"`
+	ID      string   `xml:"id" validate:"required"`
+}
+
+// GetUserRolesResponse represents the SOAP response with the roles of a user
+type GetUserRolesResponse struct {
+	XMLName xml.Name `xml:"GetUserRolesResponse" json:"-"`
+	ID      string   `xml:"id" json:"id"`
+	Roles   []string `xml:"role" json:"roles"`
+}
+
+// GetUserRoles handles the GetUserRoles SOAP operation
+func GetUserRoles(users UserStore) func(context.Context, GetUserRolesRequest) (GetUserRolesResponse, error) {
+	return func(ctx context.Context, req GetUserRolesRequest) (GetUserRolesResponse, error) {
+		user, err := users.Get(ctx, req.ID)
+		if err != nil {
+			return GetUserRolesResponse{}, userError(req.ID, err)
+		}
+
+		return GetUserRolesResponse{ID: user.ID, Roles: user.Roles}, nil
+	}
+}
@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"context"
+	"encoding/xml"
+	"soap-server/soap"
+	"soap-server/soapfault"
+	"sort"
+	"strings"
+	"time"
+)
+
+// dateLayout is the format of the createdAt dates
+const dateLayout = "2006-01-02"
+
+// UserQuery selects and orders users. Empty fields do not filter.
+type UserQuery struct {
+	NameContains string // Case-insensitive substring of the name
+	EmailDomain  string // Domain part of the email address
+	CreatedFrom  string // Earliest createdAt date (inclusive)
+	CreatedTo    string // Latest createdAt date (inclusive)
+	SortBy       string // id, name, email or createdAt; defaults to id
+	Descending   bool
+}
+
+// Match reports whether the user satisfies the filters of the query
+func (q UserQuery) Match(u User) bool {
+	if q.NameContains != "" && !strings.Contains(strings.ToLower(u.Name), strings.ToLower(q.NameContains)) {
+		return false
+	}
+	if q.EmailDomain != "" {
+		_, domain, _ := strings.Cut(u.Email, "@")
+		if !strings.EqualFold(domain, strings.TrimPrefix(q.EmailDomain, "@")) {
+			return false
+		}
+	}
+	if q.CreatedFrom != "" && u.CreatedAt < q.CreatedFrom {
+		return false
+	}
+	if q.CreatedTo != "" && u.CreatedAt > q.CreatedTo {
+		return false
+	}
+	return true
+}
+
+// Sort orders users by the sort field of the query
+func (q UserQuery) Sort(users []User) {
+	key := func(u User) string {
+		switch q.SortBy {
+		case "name":
+			return u.Name
+		case "email":
+			return u.Email
+		case "createdAt":
+			return u.CreatedAt
+		}
+		return u.ID
+	}
+	sort.SliceStable(users, func(i, j int) bool {
+		a, b := key(users[i]), key(users[j])
+		if a == b {
+			// Ties are broken by ID so results are deterministic
+			a, b = users[i].ID, users[j].ID
+		}
+		if q.Descending {
+			return a > b
+		}
+		return a < b
+	})
+}
+
+// SearchUsersRequest represents the SOAP request for searching users
+type SearchUsersRequest struct {
+	XMLName     xml.Name `xml:"SearchUsersRequest"`
+	Name        string   `xml:"name,omitempty"`
+	EmailDomain string   `xml:"emailDomain,omitempty"`
+	CreatedFrom string   `xml:"createdFrom,omitempty"`
+	CreatedTo   string   `xml:"createdTo,omitempty"`
+	SortBy      string   `xml:"sortBy,omitempty" xsd:"enum=id|name|email|createdAt" validate:"oneof=id|name|email|createdAt"`
+	SortOrder   string   `xml:"sortOrder,omitempty" xsd:"enum=asc|desc" validate:"oneof=asc|desc"`
+}
+
+// Validate checks the format of the createdAt range
+func (req SearchUsersRequest) Validate() error {
+	var errs soap.FieldErrors
+	for _, f := range []struct{ name, value string }{
+		{"createdFrom", req.CreatedFrom},
+		{"createdTo", req.CreatedTo},
+	} {
+		date := strings.TrimSpace(f.value)
+		if _, err := time.Parse(dateLayout, date); date != "" && err != nil {
+			errs.Add(f.name, "must be a date in the format YYYY-MM-DD")
+		}
+	}
+	return errs.Err()
+}
+
+// SearchUsersResponse represents the SOAP response with the matching users
+type SearchUsersResponse struct {
+	XMLName xml.Name     `xml:"SearchUsersResponse" json:"-"`
+	Total   int          `xml:"total" json:"total"`
+	Users   []UserRecord `xml:"user" json:"users"`
+}
+
+// SearchUsers handles the SearchUsers SOAP operation
+func SearchUsers(users UserStore) func(context.Context, SearchUsersRequest) (SearchUsersResponse, error) {
+	return func(ctx context.Context, req SearchUsersRequest) (SearchUsersResponse, error) {
+		query := UserQuery{
+			NameContains: strings.TrimSpace(req.Name),
+			EmailDomain:  strings.TrimSpace(req.EmailDomain),
+			CreatedFrom:  strings.TrimSpace(req.CreatedFrom),
+			CreatedTo:    strings.TrimSpace(req.CreatedTo),
+			SortBy:       req.SortBy,
+			Descending:   req.SortOrder == "desc",
+		}
+
+		found, err := users.Search(ctx, query)
+		if err != nil {
+			return SearchUsersResponse{}, soapfault.Server("Internal error", "User store failed: "+err.Error())
+		}
+
+		response := SearchUsersResponse{Total: len(found)}
+		for _, u := range found {
+			response.Users = append(response.Users, userRecord(ctx, u))
+		}
+		return response, nil
+	}
+}
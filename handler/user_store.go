@@ -0,0 +1,183 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrUserNotFound is returned by a UserStore when no user has the given ID
+var ErrUserNotFound = errors.New("user not found")
+
+// ErrUserExists is returned by UserStore.Create when the ID is taken
+var ErrUserExists = errors.New("user already exists")
+
+// UserStore persists the users of the user service
+type UserStore interface {
+	// Get returns the user with the given ID or ErrUserNotFound. Deleted
+	// users are not found by Get, Update, Delete, List and Search.
+	Get(ctx context.Context, id string) (User, error)
+
+	// Put creates the user or replaces the user with the same ID
+	Put(ctx context.Context, user User) error
+
+	// Create adds a new user or returns ErrUserExists if a user, deleted or
+	// not, has the same ID
+	Create(ctx context.Context, user User) error
+
+	// Update applies fn to the user with the given ID and stores the result
+	// atomically, so concurrent updates are not lost. UpdatedAt is set to the
+	// current time. It returns the updated user, ErrUserNotFound, or the
+	// error returned by fn.
+	Update(ctx context.Context, id string, fn func(*User) error) (User, error)
+
+	// Delete marks the user with the given ID deleted or returns
+	// ErrUserNotFound
+	Delete(ctx context.Context, id string) error
+
+	// Restore undeletes the user with the given ID and returns it, or
+	// returns ErrUserNotFound if no user, deleted or not, has the ID
+	Restore(ctx context.Context, id string) (User, error)
+
+	// List returns all users ordered by ID
+	List(ctx context.Context) ([]User, error)
+
+	// Search returns the users matching the query in the requested order
+	Search(ctx context.Context, q UserQuery) ([]User, error)
+}
+
+// MemoryUserStore is a UserStore keeping the users in a map. It is safe for
+// concurrent use: users are copied in and out, so the roles and names of a
+// stored user are never shared with callers. Its contents are lost when the
+// process exits.
+type MemoryUserStore struct {
+	mu    sync.RWMutex
+	users map[string]User
+}
+
+// NewMemoryUserStore creates an in-memory store holding the given users
+func NewMemoryUserStore(users ...User) *MemoryUserStore {
+	s := &MemoryUserStore{users: make(map[string]User, len(users))}
+	for _, u := range users {
+		s.users[u.ID] = u.clone()
+	}
+	return s
+}
+
+func (s *MemoryUserStore) Get(ctx context.Context, id string) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[id]
+	if !ok || user.Deleted() {
+		return User{}, ErrUserNotFound
+	}
+	return user.clone(), nil
+}
+
+func (s *MemoryUserStore) Put(ctx context.Context, user User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.users[user.ID] = user.clone()
+	return nil
+}
+
+func (s *MemoryUserStore) Create(ctx context.Context, user User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[user.ID]; ok {
+		return ErrUserExists
+	}
+	s.users[user.ID] = user.clone()
+	return nil
+}
+
+func (s *MemoryUserStore) Update(ctx context.Context, id string, fn func(*User) error) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.users[id]
+	if !ok || stored.Deleted() {
+		return User{}, ErrUserNotFound
+	}
+	// fn changes a copy, leaving the stored user intact if it fails
+	user := stored.clone()
+	if err := fn(&user); err != nil {
+		return User{}, err
+	}
+	user.ID = id
+	user.UpdatedAt = timestamp()
+	s.users[id] = user
+	return user.clone(), nil
+}
+
+func (s *MemoryUserStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok || user.Deleted() {
+		return ErrUserNotFound
+	}
+	user.DeletedAt = timestamp()
+	user.UpdatedAt = user.DeletedAt
+	s.users[id] = user
+	return nil
+}
+
+func (s *MemoryUserStore) Restore(ctx context.Context, id string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return User{}, ErrUserNotFound
+	}
+	if user.Deleted() {
+		user.DeletedAt = ""
+		user.UpdatedAt = timestamp()
+		s.users[id] = user
+	}
+	return user.clone(), nil
+}
+
+func (s *MemoryUserStore) List(ctx context.Context) ([]User, error) {
+	return s.Search(ctx, UserQuery{})
+}
+
+func (s *MemoryUserStore) Search(ctx context.Context, q UserQuery) ([]User, error) {
+	s.mu.RLock()
+	var users []User
+	for _, u := range s.users {
+		if !u.Deleted() && q.Match(u) {
+			users = append(users, u.clone())
+		}
+	}
+	s.mu.RUnlock()
+
+	q.Sort(users)
+	return users, nil
+}
+
+// clone returns a copy of the user that shares no roles or names with it
+func (u User) clone() User {
+	if u.Roles != nil {
+		u.Roles = append([]string(nil), u.Roles...)
+	}
+	if u.Names != nil {
+		names := make(map[string]string, len(u.Names))
+		for lang, name := range u.Names {
+			names[lang] = name
+		}
+		u.Names = names
+	}
+	return u
+}
+
+// timestamp returns the current time in the format of the audit fields
+func timestamp() string {
+	return time.Now().UTC().Format(time.RFC3339)
+}
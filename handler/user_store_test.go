@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestMemoryUserStoreConcurrentCreate(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryUserStore()
+
+	const workers = 16
+	const perWorker = 50
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				id := fmt.Sprintf("u%d-%d", w, i)
+				if err := store.Create(ctx, User{ID: id, Name: id, Email: id + "@example.com"}); err != nil {
+					t.Errorf("Create(%s): %v", id, err)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	users, err := store.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != workers*perWorker {
+		t.Errorf("got %d users, want %d", len(users), workers*perWorker)
+	}
+}
+
+func TestMemoryUserStoreConcurrentCreateSameID(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryUserStore()
+
+	const workers = 32
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	created := 0
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			err := store.Create(ctx, User{ID: "same", Name: fmt.Sprint(w)})
+			switch {
+			case err == nil:
+				mu.Lock()
+				created++
+				mu.Unlock()
+			case !errors.Is(err, ErrUserExists):
+				t.Errorf("Create: %v", err)
+			}
+		}(w)
+	}
+	wg.Wait()
+	if created != 1 {
+		t.Errorf("%d creates of the same ID succeeded, want 1", created)
+	}
+}
+
+func TestMemoryUserStoreConcurrentUpdateGet(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryUserStore(User{ID: "1", Name: "Hong", Names: map[string]string{"en": "Hong"}})
+
+	const workers = 16
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			role := fmt.Sprintf("role%d", w)
+			lang := fmt.Sprintf("x-%d", w)
+			if _, err := store.Update(ctx, "1", func(u *User) error {
+				u.Roles = append(u.Roles, role)
+				if u.Names == nil {
+					u.Names = map[string]string{}
+				}
+				u.Names[lang] = role
+				return nil
+			}); err != nil {
+				t.Errorf("Update: %v", err)
+			}
+		}(w)
+		go func() {
+			defer wg.Done()
+			u, err := store.Get(ctx, "1")
+			if err != nil {
+				t.Errorf("Get: %v", err)
+				return
+			}
+			// Read everything a handler may read while updates run
+			for range u.Roles {
+			}
+			for range u.Names {
+			}
+		}()
+	}
+	wg.Wait()
+
+	u, err := store.Get(ctx, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(u.Roles) != workers {
+		t.Errorf("got %d roles, want %d: updates were lost", len(u.Roles), workers)
+	}
+	if len(u.Names) != workers+1 {
+		t.Errorf("got %d names, want %d: updates were lost", len(u.Names), workers+1)
+	}
+}
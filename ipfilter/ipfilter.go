@@ -0,0 +1,130 @@
+// Package ipfilter restricts the server to client networks given as CIDR
+// allow and deny lists. Behind reverse proxies the client address is taken
+// from the X-Forwarded-For header, as far as the proxies are trusted.
+package ipfilter
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"soap-server/soap"
+	"strings"
+)
+
+// Policy selects the clients that may reach the server
+type Policy struct {
+	// Allow admits only clients in these networks. Empty admits all
+	// clients that are not denied.
+	Allow []*net.IPNet
+
+	// Deny rejects clients in these networks, even if they are allowed
+	Deny []*net.IPNet
+
+	// TrustedProxies are the reverse proxies whose X-Forwarded-For entries
+	// are believed. Without them the header is ignored, as any client can
+	// send it.
+	TrustedProxies []*net.IPNet
+}
+
+// ParseNetworks parses comma-separated CIDR networks; single addresses
+// stand for networks of one address
+func ParseNetworks(s string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q", entry)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// Allows reports whether the client address passes the allow and deny
+// lists
+func (p Policy) Allows(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	if contains(p.Deny, ip) {
+		return false
+	}
+	return len(p.Allow) == 0 || contains(p.Allow, ip)
+}
+
+// ClientIP returns the address of the client of a request: the peer
+// address, or if that is a trusted proxy, the last X-Forwarded-For entry
+// not added by a trusted proxy. Peers on a Unix domain socket are local
+// processes, such as a reverse proxy, and count as a trusted proxy at the
+// loopback address.
+func (p Policy) ClientIP(r *http.Request) net.IP {
+	var ip net.IP
+	if _, ok := r.Context().Value(http.LocalAddrContextKey).(*net.UnixAddr); ok {
+		ip = net.IPv4(127, 0, 0, 1)
+	} else {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		ip = net.ParseIP(host)
+		if ip == nil || !contains(p.TrustedProxies, ip) {
+			return ip
+		}
+	}
+
+	// Each proxy appends the address it received the request from, so the
+	// entries are checked from the right
+	var forwarded []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		forwarded = append(forwarded, strings.Split(header, ",")...)
+	}
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		entry := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if entry == nil {
+			break
+		}
+		ip = entry
+		if !contains(p.TrustedProxies, ip) {
+			break
+		}
+	}
+	return ip
+}
+
+// Handler rejects the requests of clients the policy does not allow with
+// 403 before they reach next
+func Handler(p Policy, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ip := p.ClientIP(r); !p.Allows(ip) {
+			soap.Warn(r.Context(), "Request rejected by IP filter", "remote_ip", ip.String(), "path", r.URL.Path)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func contains(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,147 @@
+// Package listener opens the listeners of the server from an address: a
+// TCP host:port, a Unix domain socket "unix:/path", or a socket inherited
+// through systemd socket activation, "systemd" or "systemd:<name>". With
+// socket activation the socket outlives the process, so connections wait
+// in its backlog during restarts instead of being refused.
+package listener
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Address prefixes of the non-TCP listeners
+const (
+	UnixPrefix    = "unix:"
+	SystemdPrefix = "systemd"
+)
+
+// DefaultSocketMode is the permission of Unix domain sockets: the server's
+// user and group, such as that of a local reverse proxy, may connect
+const DefaultSocketMode os.FileMode = 0660
+
+// First file descriptor passed by systemd, after stdin, stdout and stderr
+const listenFDsStart = 3
+
+// Open returns a listener for the address. Unix domain sockets are created
+// with the given permissions, replacing the socket left by a server that
+// is no longer running, and removed when the listener is closed.
+func Open(addr string, mode os.FileMode) (net.Listener, error) {
+	switch {
+	case strings.HasPrefix(addr, UnixPrefix):
+		return openUnix(strings.TrimPrefix(addr, UnixPrefix), mode)
+	case addr == SystemdPrefix || strings.HasPrefix(addr, SystemdPrefix+":"):
+		return openSystemd(strings.TrimPrefix(strings.TrimPrefix(addr, SystemdPrefix), ":"))
+	}
+	return net.Listen("tcp", addr)
+}
+
+// IsTCP reports whether the listener accepts TCP connections, whose
+// address has a port
+func IsTCP(l net.Listener) bool {
+	_, ok := l.Addr().(*net.TCPAddr)
+	return ok
+}
+
+func openUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if path == "" {
+		return nil, errors.New("unix socket path is empty")
+	}
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		// A socket nobody accepts on is left over from a previous run
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use by another server", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// systemd holds the sockets passed by systemd, read once
+var systemd struct {
+	sync.Mutex
+	loaded bool
+	files  map[string]*os.File // By name, and "" for the first
+	err    error
+	used   map[*os.File]bool
+}
+
+// openSystemd returns the listener of the socket passed by systemd under
+// the name of its FileDescriptorName= setting, or the first socket if name
+// is empty
+func openSystemd(name string) (net.Listener, error) {
+	systemd.Lock()
+	defer systemd.Unlock()
+	if !systemd.loaded {
+		systemd.files, systemd.err = inheritedFiles()
+		systemd.used = map[*os.File]bool{}
+		systemd.loaded = true
+	}
+	if systemd.err != nil {
+		return nil, systemd.err
+	}
+	file, ok := systemd.files[name]
+	if !ok {
+		return nil, fmt.Errorf("systemd passed no socket named %q", name)
+	}
+	if systemd.used[file] {
+		return nil, fmt.Errorf("systemd socket %s is already in use", file.Name())
+	}
+	l, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("systemd socket %s: %w", file.Name(), err)
+	}
+	systemd.used[file] = true
+	file.Close() // The listener has its own descriptor
+	return l, nil
+}
+
+// inheritedFiles returns the file descriptors passed by systemd, as
+// described by the LISTEN_PID, LISTEN_FDS and LISTEN_FDNAMES variables
+func inheritedFiles() (map[string]*os.File, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, errors.New("no sockets passed by systemd (LISTEN_PID is not this process)")
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, errors.New("no sockets passed by systemd (LISTEN_FDS)")
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	files := map[string]*os.File{}
+	for i := 0; i < n; i++ {
+		fd := listenFDsStart + i
+		name := "fd " + strconv.Itoa(fd)
+		if i < len(names) && names[i] != "" {
+			name += " (" + names[i] + ")"
+		}
+		file := os.NewFile(uintptr(fd), name)
+		if i < len(names) && names[i] != "" {
+			files[names[i]] = file
+		}
+		if i == 0 {
+			files[""] = file
+		}
+	}
+	return files, nil
+}
@@ -1,152 +1,1598 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"expvar"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
+	"math"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"soap-server/accesslog"
+	"soap-server/admin"
+	"soap-server/auth"
+	"soap-server/avscan"
+	"soap-server/blobcrypt"
+	"soap-server/buildinfo"
+	"soap-server/config"
 	"soap-server/handler"
+	"soap-server/ipfilter"
+	"soap-server/listener"
+	"soap-server/rest"
+	"soap-server/s3store"
+	"soap-server/seed"
+	"soap-server/soap"
+	"soap-server/sqlstore"
+	"soap-server/tlsconfig"
+	"soap-server/tracing"
+	"soap-server/webhook"
+	"soap-server/wsdl"
+	"soap-server/wssec"
+	"soap-server/xsd"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 )
 
 func main() {
-	// Get upload directory from environment or use default
+	// Settings from the command line flags, the environment or the
+	// configuration file, in this order
+	conf, err := config.Parse(os.Args[0], os.Args[1:])
+	if err != nil {
+		log.Fatal("Invalid configuration:", err)
+	}
+
+	// Log records as text or JSON lines on stderr. Messages of the log
+	// package, the fatal errors and those of the HTTP server, are errors.
+	logHandler, err := newLogHandler(os.Stderr, conf.Get("SOAP_LOG_FORMAT"), conf.Get("SOAP_LOG_LEVEL"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	slog.SetDefault(slog.New(logHandler))
+	log.SetFlags(0)
+	log.SetOutput(slog.NewLogLogger(logHandler, slog.LevelError).Writer())
+
+	// Tracing: spans of the requests, the storage and the outbound calls,
+	// exported to an OpenTelemetry collector over OTLP/HTTP
+	var tracer *tracing.Tracer
+	if endpoint := conf.First("SOAP_OTLP_ENDPOINT", "OTEL_EXPORTER_OTLP_ENDPOINT"); endpoint != "" {
+		headers, err := tracing.ParseHeaders(conf.First("SOAP_OTLP_HEADERS", "OTEL_EXPORTER_OTLP_HEADERS"))
+		if err != nil {
+			log.Fatal("Invalid SOAP_OTLP_HEADERS:", err)
+		}
+		ratio := 1.0
+		if v := conf.Get("SOAP_TRACE_SAMPLE_RATIO"); v != "" {
+			if ratio, err = strconv.ParseFloat(v, 64); err != nil || ratio < 0 || ratio > 1 {
+				log.Fatal("Invalid SOAP_TRACE_SAMPLE_RATIO:", v)
+			}
+		}
+		tracer, err = tracing.New(tracing.Config{
+			Endpoint:    endpoint,
+			Headers:     headers,
+			ServiceName: conf.First("SOAP_SERVICE_NAME", "OTEL_SERVICE_NAME"),
+			SampleRatio: ratio,
+		})
+		if err != nil {
+			log.Fatal("Invalid SOAP_OTLP_ENDPOINT:", err)
+		}
+		tracing.SetDefault(tracer)
+		defer tracer.Close()
+	}
+
 	uploadDir := "./uploads"
+	if v := conf.Get("SOAP_UPLOAD_DIR"); v != "" {
+		uploadDir = v
+	}
+
+	// Dependencies verified by the readiness probe: the temporary directory
+	// and the stores below, added as they are opened
+	health := healthChecks{{"tempDir", pingTempDir}}
+
+	// User storage: in-memory sample users or a database shared by all
+	// endpoints
+	userStore := userStoreConfig{Kind: conf.Get("SOAP_USER_STORE"), DSN: conf.Get("SOAP_USER_DB")}
+	if v := conf.Get("SOAP_USER_DB_MAX_CONNS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			log.Fatal("Invalid SOAP_USER_DB_MAX_CONNS:", err)
+		}
+		userStore.Pool.MaxOpenConns = n
+	}
+	if v := conf.Get("SOAP_USER_DB_CONN_LIFETIME"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatal("Invalid SOAP_USER_DB_CONN_LIFETIME:", err)
+		}
+		userStore.Pool.ConnMaxLifetime = d
+	}
+	users, err := userStore.open(context.Background())
+	if err != nil {
+		log.Fatal("Failed to open user store:", err)
+	}
+	defer closeStore("user store", users)
+	health.add("userStore", users)
+
+	// Initial users from a seed file or directory. Without one the
+	// in-memory store starts with the sample users.
+	seedPath := conf.Get("SOAP_SEED")
+	seedSource := seedPath
+	var seedData seed.Data
+	switch {
+	case seedPath != "":
+		if seedData, err = seed.Load(seedPath); err != nil {
+			log.Fatal("Failed to load seed data:", err)
+		}
+	case userStore.Kind == "" || userStore.Kind == "memory":
+		seedData, seedSource = seed.Sample(), "sample users"
+	}
+	seeded, err := seedData.Apply(context.Background(), users)
+	if err != nil {
+		log.Fatal("Failed to seed users:", err)
+	}
+
+	// File storage: the content of uploads is kept in the upload directory
+	// or in an S3-compatible bucket shared by all instances
+	blobStore := blobStoreConfig{Kind: conf.Get("SOAP_BLOB_STORE"), UploadDir: uploadDir, S3: s3store.Config{
+		Endpoint:        conf.Get("SOAP_S3_ENDPOINT"),
+		Region:          conf.First("SOAP_S3_REGION", "AWS_REGION"),
+		Bucket:          conf.Get("SOAP_S3_BUCKET"),
+		Prefix:          conf.Get("SOAP_S3_PREFIX"),
+		AccessKeyID:     conf.First("SOAP_S3_ACCESS_KEY_ID", "AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: conf.First("SOAP_S3_SECRET_ACCESS_KEY", "AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    conf.First("SOAP_S3_SESSION_TOKEN", "AWS_SESSION_TOKEN"),
+		PathStyle:       conf.Get("SOAP_S3_PATH_STYLE") == "true",
+		Client:          &http.Client{Transport: tracing.Transport(nil)},
+	}}
+	blobs, err := blobStore.open()
+	if err != nil {
+		log.Fatal("Failed to open file storage:", err)
+	}
+	health.add("blobStore", blobs)
+	if tracer != nil {
+		blobs = handler.TraceBlobs(blobs, blobStore.system())
+	}
+
+	// Encryption at rest: each file is encrypted under its own data key,
+	// wrapped by a master key from the environment or by a Vault transit key
+	encryption := ""
+	switch masterKey, vaultURL := conf.Get("SOAP_ENCRYPTION_KEY"), conf.Get("SOAP_ENCRYPTION_VAULT_URL"); {
+	case masterKey != "" && vaultURL != "":
+		log.Fatal("SOAP_ENCRYPTION_KEY and SOAP_ENCRYPTION_VAULT_URL are mutually exclusive")
+	case masterKey != "":
+		keys, err := masterKeys(masterKey, conf.Get("SOAP_ENCRYPTION_PREVIOUS_KEYS"))
+		if err != nil {
+			log.Fatal("Invalid SOAP_ENCRYPTION_KEY or SOAP_ENCRYPTION_PREVIOUS_KEYS:", err)
+		}
+		blobs, encryption = blobcrypt.New(blobs, keys), "master key "+keys.ID()
+	case vaultURL != "":
+		transit, err := blobcrypt.NewVaultTransit(vaultURL, conf.First("SOAP_ENCRYPTION_VAULT_TOKEN", "VAULT_TOKEN"))
+		if err != nil {
+			log.Fatal("Invalid SOAP_ENCRYPTION_VAULT_URL:", err)
+		}
+		transit.Client.Transport = tracing.Transport(nil)
+		blobs, encryption = blobcrypt.New(blobs, transit), transit.String()
+	}
+
+	// File catalog: uploads are recorded in memory or in a database, and
+	// reconciled with the file storage so files uploaded before the catalog
+	// was kept, or before a restart of the memory catalog, are found
+	fileCatalog := fileCatalogConfig{Kind: conf.Get("SOAP_FILE_CATALOG"), DSN: conf.Get("SOAP_FILE_DB")}
+	checksumAlgorithm := conf.Get("SOAP_CHECKSUM_ALGORITHM")
+	if checksumAlgorithm == "" {
+		checksumAlgorithm = handler.DefaultChecksumAlgorithm
+	}
+	files, err := fileCatalog.open(context.Background())
+	if err != nil {
+		log.Fatal("Failed to open file catalog:", err)
+	}
+	health.add("fileCatalog", files)
+	defer closeStore("file catalog", files)
+	filesAdded, filesRemoved, err := handler.SyncFileCatalog(context.Background(), files, blobs, checksumAlgorithm)
+	if err != nil {
+		log.Fatal("Failed to sync file catalog:", err)
+	}
+	// Operations the authorization policy limits to the caller's own files
+	// only see those through the catalog
+	files = handler.AuthorizeFiles(files)
+
+	// Expiration of uploads: a default lifetime, which upload requests can
+	// override, and a janitor deleting expired files
+	var fileTTL time.Duration
+	if v := conf.Get("SOAP_FILE_TTL"); v != "" {
+		if fileTTL, err = time.ParseDuration(v); err != nil || fileTTL < 0 {
+			log.Fatal("Invalid SOAP_FILE_TTL:", v)
+		}
+	}
+	cleanupInterval := time.Hour
+	if v := conf.Get("SOAP_FILE_CLEANUP_INTERVAL"); v != "" {
+		if cleanupInterval, err = time.ParseDuration(v); err != nil || cleanupInterval <= 0 {
+			log.Fatal("Invalid SOAP_FILE_CLEANUP_INTERVAL:", v)
+		}
+	}
+	// Size limit of each uploaded file, whatever the size of the request
+	var maxUploadSize int64
+	if v := conf.Get("SOAP_MAX_UPLOAD_SIZE"); v != "" {
+		if maxUploadSize, err = parseByteSize(v); err != nil {
+			log.Fatal("Invalid SOAP_MAX_UPLOAD_SIZE:", err)
+		}
+	}
+	janitor := handler.StartFileJanitor(files, blobs, cleanupInterval)
+	defer janitor.Close()
+
+	// Accepted upload types, by media type detected from the content or by
+	// file name extension
+	fileTypes := handler.FileTypePolicy{
+		Allow: splitList(conf.Get("SOAP_FILE_ALLOW_TYPES")),
+		Deny:  splitList(conf.Get("SOAP_FILE_DENY_TYPES")),
+	}
+
+	// Malware scanning of uploads by a ClamAV daemon or an ICAP service.
+	// Uploads that cannot be scanned are rejected unless failing open.
+	var scanner handler.Scanner
+	var quarantine handler.BlobStore
+	scannerURL := conf.Get("SOAP_SCANNER")
+	if scannerURL != "" {
+		var scanTimeout time.Duration
+		if v := conf.Get("SOAP_SCAN_TIMEOUT"); v != "" {
+			if scanTimeout, err = time.ParseDuration(v); err != nil || scanTimeout <= 0 {
+				log.Fatal("Invalid SOAP_SCAN_TIMEOUT:", v)
+			}
+		}
+		if scanner, err = avscan.New(scannerURL, scanTimeout); err != nil {
+			log.Fatal("Invalid SOAP_SCANNER:", err)
+		}
+		if dir := conf.Get("SOAP_SCAN_QUARANTINE_DIR"); dir != "" {
+			quarantine = handler.NewDiskBlobStore(dir)
+		}
+	}
+
+	// Storage quotas of the authenticated clients, by total size of their
+	// uploads
+	quotas, err := parseQuotas(conf.Get("SOAP_QUOTA_DEFAULT"), conf.Get("SOAP_QUOTAS"))
+	if err != nil {
+		log.Fatal("Invalid SOAP_QUOTA_DEFAULT or SOAP_QUOTAS:", err)
+	}
+
+	// Change notifications to webhook endpoints. Seeded users are not
+	// notified.
+	serviceUsers := users
+	webhookURLs := splitList(conf.Get("SOAP_WEBHOOK_URLS"))
+	if len(webhookURLs) > 0 {
+		webhooks := webhook.Config{URLs: webhookURLs, Secret: conf.Get("SOAP_WEBHOOK_SECRET")}
+		if v := conf.Get("SOAP_WEBHOOK_MAX_ATTEMPTS"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				log.Fatal("Invalid SOAP_WEBHOOK_MAX_ATTEMPTS:", err)
+			}
+			webhooks.MaxAttempts = n
+		}
+		notifier := webhook.New(webhooks)
+		defer notifier.Close()
+		serviceUsers = notifier.Store(users)
+	}
+
+	// Audit log of the operation invocations in a file or a database. The
+	// files touched by the operations are recorded through the catalog.
+	auditLog := auditLogConfig{Kind: conf.Get("SOAP_AUDIT_LOG"), DSN: conf.Get("SOAP_AUDIT_DB")}
+	audit, err := auditLog.open(context.Background())
+	if err != nil {
+		log.Fatal("Failed to open audit log:", err)
+	}
+	health.add("auditLog", audit)
+	defer closeStore("audit log", audit)
+	serviceFiles := files
+	if audit != nil {
+		serviceFiles = handler.AuditFiles(files)
+	}
+
+	// Uploads processed in the background, reported by GetUploadStatus. The
+	// job registry is shared by the mounted services.
+	uploadWorkers := 0
+	if v := conf.Get("SOAP_UPLOAD_WORKERS"); v != "" {
+		if uploadWorkers, err = strconv.Atoi(v); err != nil || uploadWorkers <= 0 {
+			log.Fatal("Invalid SOAP_UPLOAD_WORKERS:", v)
+		}
+	}
+
+	// Pre-signed download links returned by CreateDownloadLink, served
+	// without authentication until they expire. The key signs the links and
+	// must be kept secret; changing it invalidates the links handed out.
+	var downloadLinks *handler.DownloadLinks
+	if key := conf.Get("SOAP_DOWNLOAD_LINK_KEY"); key != "" {
+		if len(key) < 32 {
+			log.Fatal("SOAP_DOWNLOAD_LINK_KEY must be at least 32 characters")
+		}
+		downloadLinks = handler.NewDownloadLinks([]byte(key))
+		downloadLinks.BaseURL = conf.Get("SOAP_EXTERNAL_URL")
+		downloadLinks.MaxTTL = handler.DefaultLinkMaxTTL
+		if v := conf.Get("SOAP_DOWNLOAD_LINK_MAX_TTL"); v != "" {
+			if downloadLinks.MaxTTL, err = time.ParseDuration(v); err != nil || downloadLinks.MaxTTL <= 0 {
+				log.Fatal("Invalid SOAP_DOWNLOAD_LINK_MAX_TTL:", v)
+			}
+		}
+	}
+
+	// Service namespace and SOAPAction base can be overridden at startup
+	serviceConfig := handler.Config{
+		Namespace:         conf.Get("SOAP_NAMESPACE"),
+		SOAPActionBase:    conf.Get("SOAP_ACTION_BASE"),
+		UploadDir:         uploadDir,
+		Blobs:             blobs,
+		Files:             serviceFiles,
+		ChecksumAlgorithm: checksumAlgorithm,
+		FileTTL:           fileTTL,
+		MaxFileSize:       maxUploadSize,
+		FileLayout:        conf.Get("SOAP_FILE_LAYOUT"),
+		Deduplicate:       conf.Get("SOAP_FILE_DEDUP") == "true",
+		FileTypes:         fileTypes,
+		Scanner:           scanner,
+		ScanFailOpen:      conf.Get("SOAP_SCAN_FAIL_OPEN") == "true",
+		Quarantine:        quarantine,
+		AsyncUploads:      conf.Get("SOAP_ASYNC_UPLOADS") == "true",
+		Jobs:              handler.NewUploadJobs(uploadWorkers),
+		Quotas:            quotas,
+		SwAResponses:      conf.Get("SOAP_SWA_RESPONSES") == "true",
+		Users:             serviceUsers,
+		Audit:             audit,
+		DownloadLinks:     downloadLinks,
+	}
+	if serviceConfig.Namespace == "" {
+		serviceConfig.Namespace = handler.DefaultNamespace
+	}
+
+	// Public base URL advertised in the WSDL soap:address when the server
+	// runs behind a reverse proxy; derived from each request when empty
+	externalURL := conf.Get("SOAP_EXTERNAL_URL")
+
+	// Generated service WSDLs reference their schemas with xsd:import
+	// instead of inlining them
+	importSchemas := conf.Get("SOAP_WSDL_IMPORT_SCHEMAS") == "true"
+
+	// Generated service WSDLs derive their types from the Go request and
+	// response structs instead of the bundled XSD files
+	derivedTypes := conf.Get("SOAP_WSDL_DERIVED_TYPES") == "true"
+
+	// WS-Policy assertions advertised in the service WSDLs for the enabled
+	// security features
+	policy, err := wsdl.ParsePolicy(conf.Get("SOAP_WSDL_POLICY"))
+	if err != nil {
+		log.Fatal("Invalid SOAP_WSDL_POLICY:", err)
+	}
+
+	// Each mounted service can use its own namespace
+	userConfig := serviceConfig
+	if ns := conf.Get("SOAP_USER_NAMESPACE"); ns != "" {
+		userConfig.Namespace = ns
+	}
+	fileConfig := serviceConfig
+	if ns := conf.Get("SOAP_FILE_NAMESPACE"); ns != "" {
+		fileConfig.Namespace = ns
+	}
+
+	// Strict checking of the request element content, enabled for all
+	// endpoints or per service
+	strict := conf.Get("SOAP_STRICT") == "true"
+	userStrict, fileStrict := strict, strict
+	if v := conf.Get("SOAP_USER_STRICT"); v != "" {
+		userStrict = v == "true"
+	}
+	if v := conf.Get("SOAP_FILE_STRICT"); v != "" {
+		fileStrict = v == "true"
+	}
+
+	// WS-Security processing of the Security header of SOAP requests,
+	// before any other middleware. Timestamps are validated when present.
+	security := wssec.Config{RequireTimestamp: conf.Get("SOAP_WSS_REQUIRE_TIMESTAMP") == "true"}
+	if v := conf.Get("SOAP_WSS_CLOCK_SKEW"); v != "" {
+		if security.ClockSkew, err = time.ParseDuration(v); err != nil || security.ClockSkew <= 0 {
+			log.Fatal("Invalid SOAP_WSS_CLOCK_SKEW:", v)
+		}
+	}
+	if v := conf.Get("SOAP_WSS_MAX_AGE"); v != "" {
+		if security.MaxAge, err = time.ParseDuration(v); err != nil || security.MaxAge < 0 {
+			log.Fatal("Invalid SOAP_WSS_MAX_AGE:", v)
+		}
+	}
+	// XML signatures are verified against the certificates of a PEM trust
+	// store, and required if so configured
+	if v := conf.Get("SOAP_WSS_TRUST_STORE"); v != "" {
+		if security.TrustedCerts, err = wssec.LoadCertificates(v); err != nil {
+			log.Fatal("Invalid SOAP_WSS_TRUST_STORE:", err)
+		}
+	}
+	security.RequireSignature = conf.Get("SOAP_WSS_REQUIRE_SIGNATURE") == "true"
+	if security.RequireSignature && len(security.TrustedCerts) == 0 {
+		log.Fatal("SOAP_WSS_REQUIRE_SIGNATURE requires SOAP_WSS_TRUST_STORE")
+	}
+	// Encrypted data in the Body is decrypted with the server key before
+	// the signature is verified
+	if v := conf.Get("SOAP_WSS_PRIVATE_KEY"); v != "" {
+		if security.PrivateKey, err = wssec.LoadPrivateKey(v); err != nil {
+			log.Fatal("Invalid SOAP_WSS_PRIVATE_KEY:", err)
+		}
+	}
+	// SAML 2.0 bearer assertions of an identity provider, signed with a
+	// certificate of its own trust store, authenticate the caller
+	if v := conf.Get("SOAP_WSS_SAML_TRUST_STORE"); v != "" {
+		if security.SAMLTrustedCerts, err = wssec.LoadCertificates(v); err != nil {
+			log.Fatal("Invalid SOAP_WSS_SAML_TRUST_STORE:", err)
+		}
+		security.SAMLIssuer = conf.Get("SOAP_WSS_SAML_ISSUER")
+		security.SAMLAudience = conf.Get("SOAP_WSS_SAML_AUDIENCE")
+		security.SAMLNameAttribute = conf.Get("SOAP_WSS_SAML_NAME_ATTRIBUTE")
+		security.SAMLRoleAttribute = conf.Get("SOAP_WSS_SAML_ROLE_ATTRIBUTE")
+	}
+	wsSecurity := wssec.Middleware(security)
+	var securityHooks []soap.RequestHook
+	if security.PrivateKey != nil {
+		securityHooks = append(securityHooks, wssec.DecryptBody(security))
+	}
+	if len(security.TrustedCerts) > 0 {
+		securityHooks = append(securityHooks, wssec.VerifySignature(security))
+	}
+	if len(security.SAMLTrustedCerts) > 0 {
+		securityHooks = append(securityHooks, wssec.VerifyAssertions(security))
+	}
+
+	// Authentication of the callers of the SOAP endpoints and the file
+	// downloads with API keys, HTTP Basic credentials from the environment
+	// or files, or JWT bearer tokens, and of the SOAP endpoints with SAML
+	// assertions. Once a method is configured, requests must be
+	// authenticated by one of them.
+	var middleware []soap.Middleware
+	var challenges []string
+	realm := conf.Get("SOAP_BASIC_AUTH_REALM")
+	apiKeys := auth.APIKeys{}
+	if v := conf.Get("SOAP_API_KEYS"); v != "" {
+		keys, err := auth.ParseAPIKeys(v)
+		if err != nil {
+			log.Fatal("Invalid SOAP_API_KEYS:", err)
+		}
+		for hash, key := range keys {
+			apiKeys[hash] = key
+		}
+	}
+	if v := conf.Get("SOAP_API_KEYS_FILE"); v != "" {
+		keys, err := auth.LoadAPIKeys(v)
+		if err != nil {
+			log.Fatal("Invalid SOAP_API_KEYS_FILE:", err)
+		}
+		for hash, key := range keys {
+			apiKeys[hash] = key
+		}
+	}
+	if len(apiKeys) > 0 {
+		header := conf.Get("SOAP_API_KEY_HEADER")
+		middleware = append(middleware, auth.APIKeyAuth(header, apiKeys))
+		challenges = append(challenges, auth.APIKeyChallenge(header))
+	}
+	basicCredentials := auth.Credentials{}
+	if v := conf.Get("SOAP_BASIC_AUTH_USERS"); v != "" {
+		creds, err := auth.ParseCredentials(v)
+		if err != nil {
+			log.Fatal("Invalid SOAP_BASIC_AUTH_USERS:", err)
+		}
+		for user, password := range creds {
+			basicCredentials[user] = password
+		}
+	}
+	if v := conf.Get("SOAP_BASIC_AUTH_FILE"); v != "" {
+		creds, err := auth.LoadHtpasswd(v)
+		if err != nil {
+			log.Fatal("Invalid SOAP_BASIC_AUTH_FILE:", err)
+		}
+		for user, password := range creds {
+			basicCredentials[user] = password
+		}
+	}
+	if len(basicCredentials) > 0 {
+		middleware = append(middleware, auth.Basic(realm, basicCredentials))
+		challenges = append(challenges, auth.BasicChallenge(realm))
+	}
+	// Bearer tokens are verified with the keys of a JWKS URL, such as the
+	// one of the API gateway issuing them, or of a PEM or JWKS file
+	jwtConfig := auth.JWTConfig{
+		Issuer:     conf.Get("SOAP_JWT_ISSUER"),
+		Audience:   conf.Get("SOAP_JWT_AUDIENCE"),
+		NameClaim:  conf.Get("SOAP_JWT_NAME_CLAIM"),
+		RolesClaim: conf.Get("SOAP_JWT_ROLES_CLAIM"),
+	}
+	if v := conf.Get("SOAP_JWT_CLOCK_SKEW"); v != "" {
+		if jwtConfig.ClockSkew, err = time.ParseDuration(v); err != nil || jwtConfig.ClockSkew <= 0 {
+			log.Fatal("Invalid SOAP_JWT_CLOCK_SKEW:", v)
+		}
+	}
+	jwksURL, jwtKeysFile := conf.Get("SOAP_JWT_JWKS_URL"), conf.Get("SOAP_JWT_KEYS_FILE")
+	switch {
+	case jwksURL != "" && jwtKeysFile != "":
+		log.Fatal("SOAP_JWT_JWKS_URL and SOAP_JWT_KEYS_FILE are mutually exclusive")
+	case jwksURL != "":
+		var refresh time.Duration
+		if v := conf.Get("SOAP_JWT_JWKS_REFRESH"); v != "" {
+			if refresh, err = time.ParseDuration(v); err != nil || refresh <= 0 {
+				log.Fatal("Invalid SOAP_JWT_JWKS_REFRESH:", v)
+			}
+		}
+		jwtConfig.Keys = auth.NewJWKS(jwksURL, refresh)
+	case jwtKeysFile != "":
+		if jwtConfig.Keys, err = auth.LoadKeys(jwtKeysFile); err != nil {
+			log.Fatal("Invalid SOAP_JWT_KEYS_FILE:", err)
+		}
+	}
+	if jwtConfig.Keys != nil {
+		middleware = append(middleware, auth.JWT(realm, jwtConfig))
+		challenges = append(challenges, auth.BearerChallenge(realm))
+	}
+	if len(challenges) > 0 || len(security.SAMLTrustedCerts) > 0 {
+		middleware = append(middleware, auth.Require(challenges...))
+	}
+
+	// Replayed SOAP requests, with a UsernameToken nonce or wsa:MessageID
+	// seen within the window, are rejected once the caller is authenticated
+	var replayCache *wssec.ReplayCache
+	if v := conf.Get("SOAP_WSS_REPLAY_WINDOW"); v != "" {
+		window, err := time.ParseDuration(v)
+		if err != nil || window <= 0 {
+			log.Fatal("Invalid SOAP_WSS_REPLAY_WINDOW:", v)
+		}
+		replayCache = wssec.NewReplayCache(window)
+		middleware = append(middleware, wssec.DetectReplay(replayCache, conf.Get("SOAP_WSS_REQUIRE_NONCE") == "true"))
+	} else if conf.Get("SOAP_WSS_REQUIRE_NONCE") == "true" {
+		log.Fatal("SOAP_WSS_REQUIRE_NONCE requires SOAP_WSS_REPLAY_WINDOW")
+	}
+
+	// Authorization of the operations in the policy by role or client,
+	// enforced before the operations are dispatched. Callers are identified
+	// by the principal set by the authentication middleware.
+	var authzPolicy handler.Policy
+	if conf.Get("SOAP_AUTHZ") == "true" {
+		authzPolicy = handler.DefaultPolicy()
+		entries, policyFile := conf.Get("SOAP_AUTHZ_POLICY"), conf.Get("SOAP_AUTHZ_POLICY_FILE")
+		switch {
+		case entries != "" && policyFile != "":
+			log.Fatal("SOAP_AUTHZ_POLICY and SOAP_AUTHZ_POLICY_FILE are mutually exclusive")
+		case entries != "":
+			if authzPolicy, err = handler.ParsePolicy(entries); err != nil {
+				log.Fatal("Invalid SOAP_AUTHZ_POLICY:", err)
+			}
+		case policyFile != "":
+			if authzPolicy, err = handler.LoadPolicy(policyFile); err != nil {
+				log.Fatal("Invalid SOAP_AUTHZ_POLICY_FILE:", err)
+			}
+		}
+		middleware = append(middleware, handler.Authorize(users, authzPolicy))
+	}
+
+	// Register SOAP operations; the registry routes requests by SOAPAction
+	// header or by the request element found in the body
+	registry := soap.NewOperationRegistry()
+	if err := handler.RegisterOperations(registry, serviceConfig); err != nil {
+		log.Fatal("Failed to register SOAP operations:", err)
+	}
 
 	// Create a new ServeMux for routing SOAP operations
 	soapMux := http.NewServeMux()
 
-	// SOAP endpoint that routes to different operations based on SOAPAction
-	soapMux.HandleFunc("/soap", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed. Use POST.", http.StatusMethodNotAllowed)
-			return
-		}
-
-		// Check SOAPAction header to determine the operation
-		soapAction := r.Header.Get("SOAPAction")
-
-		// Also try to determine operation from the request body
-		contentType := r.Header.Get("Content-Type")
-
-		fmt.Printf("[%s] SOAP Request - Method: %s, SOAPAction: %s, ContentType: %s\n",
-			getCurrentTime(), r.Method, soapAction, contentType)
-
-		// Route based on SOAPAction header or parse body to determine operation
-		if soapAction != "" {
-			// Remove quotes from SOAPAction if present
-			soapAction = stripQuotes(soapAction)
-			switch soapAction {
-			case "http://example.com/soap/user/GetUser":
-				handler.GetUser(w, r)
-				return
-			case "http://example.com/soap/user/UploadFile":
-				handler.UploadFile(uploadDir)(w, r)
-				return
-			case "http://example.com/soap/user/UploadFileMTOM":
-				handler.UploadFileMTOM(uploadDir)(w, r)
-				return
-			}
+	// SOAP endpoint that routes to the registered operations through the
+	// server middleware chain
+	soapServer := soap.NewServer(registry)
+	soapServer.Timeout = 10 * time.Minute
+	soapServer.StrictSOAPAction = conf.Get("SOAP_STRICT_ACTION") == "true"
+	soapServer.RPCEncoded = conf.Get("SOAP_RPC_ENCODED") == "true"
+	soapServer.Strict = strict
+	// Binary response fields from this size on are sent as MTOM attachments
+	mtomThreshold := int64(1 << 10)
+	if size := conf.Get("SOAP_MTOM_THRESHOLD"); size != "" {
+		if mtomThreshold, err = parseByteSize(size); err != nil {
+			log.Fatal("Invalid SOAP_MTOM_THRESHOLD:", err)
+		}
+	}
+	soapServer.MTOMThreshold = int(mtomThreshold)
+	// Limits of the XML documents of requests, on top of the rejected
+	// document type declarations
+	if v := conf.Get("SOAP_XML_MAX_DEPTH"); v != "" {
+		if soapServer.XMLLimits.MaxDepth, err = strconv.Atoi(v); err != nil || soapServer.XMLLimits.MaxDepth <= 0 {
+			log.Fatal("Invalid SOAP_XML_MAX_DEPTH:", v)
+		}
+	}
+	if v := conf.Get("SOAP_XML_MAX_ATTRIBUTES"); v != "" {
+		if soapServer.XMLLimits.MaxAttributes, err = strconv.Atoi(v); err != nil || soapServer.XMLLimits.MaxAttributes <= 0 {
+			log.Fatal("Invalid SOAP_XML_MAX_ATTRIBUTES:", v)
+		}
+	}
+	if v := conf.Get("SOAP_XML_MAX_SIZE"); v != "" {
+		if soapServer.XMLLimits.MaxSize, err = parseByteSize(v); err != nil {
+			log.Fatal("Invalid SOAP_XML_MAX_SIZE:", err)
+		}
+	}
+	// Limits of the request bodies and of the MIME parts of MTOM and SwA
+	// requests, checked while they are read
+	soapServer.Limits = soap.RequestLimits{MaxParts: 1000, MaxPartHeaderSize: 8 << 10}
+	if v := conf.Get("SOAP_MAX_REQUEST_SIZE"); v != "" {
+		if soapServer.Limits.MaxBodySize, err = parseByteSize(v); err != nil {
+			log.Fatal("Invalid SOAP_MAX_REQUEST_SIZE:", err)
+		}
+	}
+	if v := conf.Get("SOAP_MAX_PARTS"); v != "" {
+		if soapServer.Limits.MaxParts, err = strconv.Atoi(v); err != nil || soapServer.Limits.MaxParts < 0 {
+			log.Fatal("Invalid SOAP_MAX_PARTS:", v)
+		}
+	}
+	if v := conf.Get("SOAP_MAX_PART_SIZE"); v != "" {
+		if soapServer.Limits.MaxPartSize, err = parseByteSize(v); err != nil {
+			log.Fatal("Invalid SOAP_MAX_PART_SIZE:", err)
 		}
+	}
+	if v := conf.Get("SOAP_MAX_PART_HEADER_SIZE"); v != "" {
+		size, err := parseByteSize(v)
+		if err != nil {
+			log.Fatal("Invalid SOAP_MAX_PART_HEADER_SIZE:", err)
+		}
+		soapServer.Limits.MaxPartHeaderSize = int(size)
+	}
+	// Requests served at once by all SOAP endpoints, and uploads among
+	// them, which wait briefly for a slot and are then answered with a
+	// Server busy fault
+	concurrencyTimeout := durationSetting(conf, "SOAP_CONCURRENCY_QUEUE_TIMEOUT", 2*time.Second)
+	if v := conf.Get("SOAP_MAX_CONCURRENT_REQUESTS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			log.Fatal("Invalid SOAP_MAX_CONCURRENT_REQUESTS:", v)
+		}
+		soapServer.Concurrency = soap.NewLimiter("requests", n, concurrencyTimeout)
+	}
+	var uploadLimiter *soap.Limiter
+	var uploadLimit []soap.Middleware
+	if v := conf.Get("SOAP_MAX_CONCURRENT_UPLOADS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			log.Fatal("Invalid SOAP_MAX_CONCURRENT_UPLOADS:", v)
+		}
+		uploadLimiter = soap.NewLimiter("uploads", n, concurrencyTimeout)
+		uploadLimit = append(uploadLimit, soap.Limit(uploadLimiter, handler.UploadOperations...))
+	}
+	for _, hook := range securityHooks {
+		soapServer.OnRequest(hook)
+	}
+	// Invocations are audited ahead of WS-Security and authentication, so
+	// rejected requests are recorded too
+	if audit != nil {
+		soapServer.Use(handler.Audit(audit))
+	}
+	soapServer.Use(wsSecurity)
+	soapServer.Use(middleware...)
+	soapServer.Use(uploadLimit...)
+	soapServer.Contract = wsdl.QueryHandler(wsdl.Handler(registry, serviceConfig.Namespace, externalURL),
+		[]string{"user.xsd", "file.xsd"}, serviceConfig.Namespace, externalURL)
 
-		// Fallback: try to parse the body to determine operation
-		body := r.Body
-		defer body.Close()
-
-		// Read first 512 bytes to peek at the content
-		buf := make([]byte, 512)
-		n, _ := body.Read(buf)
-		bufStr := string(buf[:n])
-
-		// Route based on content
-		if strings.Contains(bufStr, "GetUserRequest") {
-			// Reset body for the handler
-			r.Body = newReadCloser(bufStr)
-			handler.GetUser(w, r)
-		} else if strings.Contains(bufStr, "UploadFileMTOMRequest") {
-			// Reset body for the handler
-			r.Body = newReadCloser(bufStr)
-			handler.UploadFileMTOM(uploadDir)(w, r)
-		} else if strings.Contains(bufStr, "UploadFileRequest") {
-			// Reset body for the handler
-			r.Body = newReadCloser(bufStr)
-			handler.UploadFile(uploadDir)(w, r)
-		} else {
-			sendSOAPError(w, "Client", "Unknown operation", "Could not determine SOAP operation from request")
+	// Schema validation of requests and, during development, of responses
+	// ("log" or "fail")
+	validation := validationConfig{
+		Requests:  conf.Get("SOAP_VALIDATE_REQUESTS") == "true",
+		Responses: conf.Get("SOAP_VALIDATE_RESPONSES"),
+	}
+	if err := validation.apply(soapServer, []contract{{Namespace: serviceConfig.Namespace, Schemas: []string{"user.xsd", "file.xsd"}}}); err != nil {
+		log.Fatal("Failed to load schemas:", err)
+	}
+	soapMux.Handle("/soap", soapServer)
+
+	// Independent service endpoints, each with its own operation set,
+	// namespace and WSDL document
+	port := ":8080"
+	if v := conf.Get("SOAP_PORT"); v != "" {
+		if n, err := strconv.Atoi(v); err != nil || n <= 0 || n > 65535 {
+			log.Fatal("Invalid SOAP_PORT:", v)
+		}
+		port = ":" + v
+	}
+	services := []serviceEndpoint{
+		{Name: "UserService", Path: "/soap/user", Schemas: []string{"user.xsd"}, Config: userConfig, Register: handler.RegisterUserOperations,
+			Versions: []serviceVersion{{Version: "v2", Schemas: []string{"user_v2.xsd"}}}, Strict: userStrict},
+		{Name: "FileService", Path: "/soap/file", Schemas: []string{"file.xsd"}, Config: fileConfig, Register: handler.RegisterFileOperations,
+			Strict: fileStrict},
+	}
+	for _, svc := range services {
+		servers, err := mountService(soapMux, svc, wsdlOptions{ExternalURL: externalURL, ImportSchemas: importSchemas, DerivedTypes: derivedTypes, Policy: policy})
+		if err != nil {
+			log.Fatal("Failed to mount service:", err)
 		}
-	})
+		for _, server := range servers {
+			server.Timeout = soapServer.Timeout
+			server.StrictSOAPAction = soapServer.StrictSOAPAction
+			server.RPCEncoded = soapServer.RPCEncoded
+			server.MTOMThreshold = soapServer.MTOMThreshold
+			server.XMLLimits = soapServer.XMLLimits
+			server.Limits = soapServer.Limits
+			server.Concurrency = soapServer.Concurrency
+			server.Strict = svc.Strict
+			for _, hook := range securityHooks {
+				server.OnRequest(hook)
+			}
+			if audit != nil {
+				server.Use(handler.Audit(audit))
+			}
+			server.Use(wsSecurity)
+			server.Use(middleware...)
+			server.Use(uploadLimit...)
+			if err := validation.apply(server, svc.contracts()); err != nil {
+				log.Fatal("Failed to load schemas:", err)
+			}
+		}
+	}
+
+	// JSON API of the user operations, sharing the store, validation,
+	// authentication, authorization and audit log with the SOAP endpoints
+	api := rest.Handler(serviceUsers, authzPolicy, audit, middleware...)
+	soapMux.Handle(rest.Prefix, api)
+	soapMux.Handle(rest.Prefix+"/", api)
 
-	// Health check endpoint
-	soapMux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		w.Write([]byte(`{"status":"healthy","service":"SOAP Server"}`))
-	})
+	// Stored files at the paths returned by the uploads, for the callers
+	// the middleware of the SOAP endpoints lets through
+	uploads := handler.ServeUploads(blobs, files, users, authzPolicy, challenges...)
+	soapMux.Handle(handler.UploadsPrefix, http.HandlerFunc(soap.Chain(uploads.ServeHTTP, middleware...)))
+
+	// Files of the pre-signed download links, authorized by the signature of
+	// the link instead of the middleware
+	if downloadLinks != nil {
+		soapMux.Handle(handler.DownloadLinksPrefix, handler.ServeDownloadLinks(downloadLinks, blobs, files))
+	}
+
+	// Liveness and readiness probes; /health is kept for existing probes
+	// and reports readiness
+	soapMux.HandleFunc("/health/live", liveHandler)
+	soapMux.HandleFunc("/health/ready", health.handler)
+	soapMux.HandleFunc("/health", health.handler)
+
+	// Skeleton request envelopes for integrators
+	soapMux.HandleFunc("/soap/operations/", soap.SampleHandler(registry, "/soap/operations/"))
 
 	// WSDL endpoint
-	soapMux.HandleFunc("/wsdl", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/xml")
-		http.ServeFile(w, r, "wsdl/user.wsdl")
-	})
+	soapMux.HandleFunc("/wsdl", wsdl.Handler(registry, serviceConfig.Namespace, externalURL))
 
-	// Start server
-	port := ":8080"
-	fmt.Printf("===========================================\n")
-	fmt.Printf("SOAP Server Starting\n")
-	fmt.Printf("===========================================\n")
-	fmt.Printf("Server running on: http://localhost%s\n", port)
-	fmt.Printf("SOAP endpoint:    http://localhost%s/soap\n", port)
-	fmt.Printf("WSDL endpoint:    http://localhost%s/wsdl\n", port)
-	fmt.Printf("Health endpoint:  http://localhost%s/health\n", port)
-	fmt.Printf("Upload directory: %s\n", uploadDir)
-	fmt.Printf("===========================================\n")
-	fmt.Printf("Available Operations:\n")
-	fmt.Printf("  - GetUser:        Retrieve user information by ID\n")
-	fmt.Printf("  - UploadFile:     Upload base64 encoded file\n")
-	fmt.Printf("  - UploadFileMTOM: Upload file using MTOM (optimized binary transfer)\n")
-	fmt.Printf("===========================================\n\n")
-
-	if err := http.ListenAndServe(port, soapMux); err != nil {
+	// Client networks that may reach the server, checked before requests are
+	// dispatched
+	var ipPolicy ipfilter.Policy
+	if v := conf.Get("SOAP_IP_ALLOW"); v != "" {
+		if ipPolicy.Allow, err = ipfilter.ParseNetworks(v); err != nil {
+			log.Fatal("Invalid SOAP_IP_ALLOW:", err)
+		}
+	}
+	if v := conf.Get("SOAP_IP_DENY"); v != "" {
+		if ipPolicy.Deny, err = ipfilter.ParseNetworks(v); err != nil {
+			log.Fatal("Invalid SOAP_IP_DENY:", err)
+		}
+	}
+	if v := conf.Get("SOAP_TRUSTED_PROXIES"); v != "" {
+		if ipPolicy.TrustedProxies, err = ipfilter.ParseNetworks(v); err != nil {
+			log.Fatal("Invalid SOAP_TRUSTED_PROXIES:", err)
+		}
+	}
+	var rootHandler http.Handler = soapMux
+	if len(ipPolicy.Allow) > 0 || len(ipPolicy.Deny) > 0 {
+		rootHandler = ipfilter.Handler(ipPolicy, soapMux)
+	}
+
+	// Panics of any endpoint are logged and answered with a Server fault
+	// instead of dropping the connection
+	rootHandler = soap.RecoverHandler(rootHandler)
+
+	// Request IDs for every endpoint, accepted from X-Request-ID and
+	// echoed in the response, and one log record per request, including
+	// those rejected by the filter
+	rootHandler = soap.RequestIDHandler(rootHandler)
+	if conf.Get("SOAP_ACCESS_LOG") != "false" {
+		rootHandler = accesslog.Handler(rootHandler)
+	}
+	rootHandler = tracing.Handler(rootHandler)
+
+	// Permissions of the Unix domain sockets listened on
+	socketMode := listener.DefaultSocketMode
+	if v := conf.Get("SOAP_UNIX_SOCKET_MODE"); v != "" {
+		mode, err := strconv.ParseUint(v, 8, 32)
+		if err != nil || mode > 0777 {
+			log.Fatal("Invalid SOAP_UNIX_SOCKET_MODE:", v)
+		}
+		socketMode = os.FileMode(mode)
+	}
+
+	// Diagnostics on a separate admin listener, which only operators should
+	// reach: pprof profiles, expvar variables and goroutine and heap dumps
+	adminAddr := conf.Get("SOAP_ADMIN_ADDR")
+	var adminServer *http.Server
+	var adminListener net.Listener
+	if adminAddr != "" {
+		dumpDir := conf.Get("SOAP_ADMIN_DUMP_DIR")
+		if dumpDir == "" {
+			dumpDir = os.TempDir()
+		}
+		var err error
+		if adminListener, err = listener.Open(adminAddr, socketMode); err != nil {
+			log.Fatal("Failed to open admin listener:", err)
+		}
+		adminServer = &http.Server{Handler: admin.Handler(dumpDir), ReadHeaderTimeout: 10 * time.Second}
+	}
+
+	// Health probes and expvar variables on a plaintext listener of their
+	// own, e.g. for probes and scrapers on the internal network while the
+	// SOAP endpoints are served over HTTPS
+	internalAddr := conf.Get("SOAP_INTERNAL_ADDR")
+	var internalServer *http.Server
+	var internalListener net.Listener
+	if internalAddr != "" {
+		var err error
+		if internalListener, err = listener.Open(internalAddr, socketMode); err != nil {
+			log.Fatal("Failed to open internal listener:", err)
+		}
+		internalMux := http.NewServeMux()
+		internalMux.HandleFunc("/health/live", liveHandler)
+		internalMux.HandleFunc("/health/ready", health.handler)
+		internalMux.HandleFunc("/health", health.handler)
+		internalMux.Handle(admin.VarsPath, expvar.Handler())
+		internalServer = &http.Server{Handler: soap.RecoverHandler(internalMux), ReadHeaderTimeout: 10 * time.Second}
+	}
+	if adminServer != nil || internalServer != nil {
+		rootHandler = admin.CountRequests(rootHandler)
+		expvar.Publish("upload_jobs", expvar.Func(func() interface{} { return serviceConfig.Jobs.Counts() }))
+	}
+
+	// HTTPS with the certificate and key from PEM files, reloaded when the
+	// files change if a reload interval is configured
+	scheme := "http"
+	var tlsConfig *tls.Config
+	certFile, keyFile := conf.Get("SOAP_TLS_CERT"), conf.Get("SOAP_TLS_KEY")
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			log.Fatal("SOAP_TLS_CERT and SOAP_TLS_KEY must be set together")
+		}
+		keypair, err := tlsconfig.LoadKeypair(certFile, keyFile)
+		if err != nil {
+			log.Fatal("Failed to load TLS certificate:", err)
+		}
+		var minVersion uint16
+		if v := conf.Get("SOAP_TLS_MIN_VERSION"); v != "" {
+			if minVersion, err = tlsconfig.ParseVersion(v); err != nil {
+				log.Fatal("Invalid SOAP_TLS_MIN_VERSION:", err)
+			}
+		}
+		if v := conf.Get("SOAP_TLS_RELOAD_INTERVAL"); v != "" {
+			interval, err := time.ParseDuration(v)
+			if err != nil || interval <= 0 {
+				log.Fatal("Invalid SOAP_TLS_RELOAD_INTERVAL:", v)
+			}
+			keypair.Watch(interval)
+			defer keypair.Close()
+		}
+		tlsConfig = tlsconfig.ServerConfig(keypair, minVersion)
+		scheme = "https"
+	}
+
+	// The port, or a TCP address, Unix domain socket or systemd socket
+	// given by SOAP_LISTEN, such as the socket of a local reverse proxy
+	listenAddr := port
+	if v := conf.Get("SOAP_LISTEN"); v != "" {
+		listenAddr = v
+	}
+	serverListener, err := listener.Open(listenAddr, socketMode)
+	if err != nil {
+		log.Fatal("Failed to listen:", err)
+	}
+	baseURL := listenerURL(scheme, serverListener)
+
+	// Start server. The banner is left out of JSON logs, which get the
+	// address in the record of the start instead.
+	banner := io.Writer(os.Stdout)
+	if conf.Get("SOAP_LOG_FORMAT") == "json" {
+		banner = io.Discard
+	}
+	fmt.Fprintf(banner, "===========================================\n")
+	fmt.Fprintf(banner, "SOAP Server Starting\n")
+	fmt.Fprintf(banner, "===========================================\n")
+	fmt.Fprintf(banner, "Version:          %s\n", buildinfo.Get())
+	fmt.Fprintf(banner, "Server running on: %s%s\n", baseURL, socketInfo(serverListener))
+	fmt.Fprintf(banner, "SOAP endpoint:    %s/soap\n", baseURL)
+	fmt.Fprintf(banner, "WSDL endpoint:    %s/wsdl\n", baseURL)
+	for _, svc := range services {
+		for _, c := range svc.contracts() {
+			fmt.Fprintf(banner, "%-17s %s%s (WSDL: %s/wsdl)\n", svc.Name+":", baseURL, c.Path, c.Path)
+		}
+	}
+	fmt.Fprintf(banner, "REST endpoint:    %s%s\n", baseURL, rest.Prefix)
+	fmt.Fprintf(banner, "Health endpoint:  %s/health (/health/live, /health/ready)\n", baseURL)
+	if adminListener != nil {
+		fmt.Fprintf(banner, "Admin endpoint:   %s%s%s\n", listenerURL("http", adminListener), admin.PprofPrefix, socketInfo(adminListener))
+		fmt.Fprintf(banner, "Diagnostic dumps: POST %s%s\n", listenerURL("http", adminListener), admin.DumpPath)
+	}
+	if internalListener != nil {
+		fmt.Fprintf(banner, "Internal health:  %s/health (/health/live, /health/ready)%s\n", listenerURL("http", internalListener), socketInfo(internalListener))
+		fmt.Fprintf(banner, "Internal metrics: %s%s\n", listenerURL("http", internalListener), admin.VarsPath)
+	}
+	fmt.Fprintf(banner, "File downloads:   %s%s{fileId}_{name}\n", baseURL, handler.UploadsPrefix)
+	if downloadLinks != nil {
+		fmt.Fprintf(banner, "Download links:   %s%s{fileId} (max TTL %s)\n", baseURL, handler.DownloadLinksPrefix, downloadLinks.MaxTTL)
+	}
+	fmt.Fprintf(banner, "File storage:     %s\n", blobStore)
+	if encryption != "" {
+		fmt.Fprintf(banner, "Encryption:       AES-256-GCM (%s)\n", encryption)
+	}
+	fmt.Fprintf(banner, "User store:       %s\n", userStore)
+	if tracer != nil {
+		fmt.Fprintf(banner, "Tracing:          %s\n", tracer)
+	}
+	fmt.Fprintf(banner, "File catalog:     %s (%d added, %d removed on sync)\n", fileCatalog, filesAdded, filesRemoved)
+	if fileTTL > 0 {
+		fmt.Fprintf(banner, "File TTL:         %s (cleanup every %s)\n", fileTTL, cleanupInterval)
+	} else {
+		fmt.Fprintf(banner, "File TTL:         none (cleanup every %s)\n", cleanupInterval)
+	}
+	if quotas.Default > 0 || len(quotas.Clients) > 0 {
+		fmt.Fprintf(banner, "Upload quotas:    %d bytes per client (%d with their own quota)\n", quotas.Default, len(quotas.Clients))
+	}
+	if scanner != nil {
+		// Only the kind is shown as the URL may contain credentials
+		kind, _, _ := strings.Cut(scannerURL, ":")
+		fmt.Fprintf(banner, "Virus scanner:    %s (fail-open: %t)\n", kind, serviceConfig.ScanFailOpen)
+	}
+	if seedSource != "" {
+		fmt.Fprintf(banner, "Seed data:        %s (%d users created)\n", seedSource, seeded)
+	}
+	if len(webhookURLs) > 0 {
+		// The URLs are not shown as they may contain tokens
+		fmt.Fprintf(banner, "Webhooks:         %d endpoint(s)\n", len(webhookURLs))
+	}
+	if audit != nil {
+		fmt.Fprintf(banner, "Audit log:        %s\n", auditLog)
+	}
+	if soapServer.Concurrency != nil {
+		fmt.Fprintf(banner, "Max requests:     %d at once (queue timeout %s)\n", soapServer.Concurrency.Max(), concurrencyTimeout)
+	}
+	if uploadLimiter != nil {
+		fmt.Fprintf(banner, "Max uploads:      %d at once (queue timeout %s)\n", uploadLimiter.Max(), concurrencyTimeout)
+	}
+	if replayCache != nil {
+		fmt.Fprintf(banner, "Replay window:    %s\n", replayCache.Window())
+	}
+	if len(ipPolicy.Allow) > 0 || len(ipPolicy.Deny) > 0 {
+		fmt.Fprintf(banner, "IP filter:        %d allowed, %d denied network(s)\n", len(ipPolicy.Allow), len(ipPolicy.Deny))
+	}
+	if conf.Path() != "" {
+		fmt.Fprintf(banner, "Config file:      %s\n", conf.Path())
+	}
+	fmt.Fprintf(banner, "Namespace:        %s\n", serviceConfig.Namespace)
+	fmt.Fprintf(banner, "===========================================\n")
+	fmt.Fprintf(banner, "Available Operations:\n")
+	for _, op := range registry.Operations() {
+		fmt.Fprintf(banner, "  - %-15s %s\n", op.Name+":", op.SOAPAction)
+	}
+	fmt.Fprintf(banner, "===========================================\n\n")
+
+	// On SIGINT or SIGTERM the server stops accepting connections and lets
+	// the requests in progress, such as uploads, and the background uploads
+	// finish within the drain timeout. The stores and logs are closed when
+	// main returns. A second signal stops the server at once.
+	drainTimeout := durationSetting(conf, "SOAP_SHUTDOWN_TIMEOUT", 30*time.Second)
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+
+	// Slow clients may not hold connections indefinitely: headers must
+	// arrive within the header timeout, and the reads of a request and the
+	// writes of its response are bounded by the read and write timeouts,
+	// which must leave room for the largest uploads and downloads. A
+	// timeout of 0 disables it.
+	server := &http.Server{
+		Addr:              port,
+		Handler:           rootHandler,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: durationSetting(conf, "SOAP_HTTP_READ_HEADER_TIMEOUT", 10*time.Second),
+		ReadTimeout:       durationSetting(conf, "SOAP_HTTP_READ_TIMEOUT", 15*time.Minute),
+		WriteTimeout:      durationSetting(conf, "SOAP_HTTP_WRITE_TIMEOUT", 15*time.Minute),
+		IdleTimeout:       durationSetting(conf, "SOAP_HTTP_IDLE_TIMEOUT", 2*time.Minute),
+		MaxHeaderBytes:    http.DefaultMaxHeaderBytes,
+	}
+	if v := conf.Get("SOAP_HTTP_MAX_HEADER_BYTES"); v != "" {
+		size, err := parseByteSize(v)
+		if err != nil || size <= 0 || size > math.MaxInt32 {
+			log.Fatal("Invalid SOAP_HTTP_MAX_HEADER_BYTES:", v)
+		}
+		server.MaxHeaderBytes = int(size)
+	}
+	build := buildinfo.Get()
+	slog.Info("Server starting", "address", serverListener.Addr().String(), "network", serverListener.Addr().Network(), "scheme", scheme, "operations", len(registry.Operations()),
+		"version", build.Version, "commit", build.Commit, "buildDate", build.Date)
+	if adminServer != nil {
+		// Kept open while draining to diagnose requests that do not finish
+		go adminServer.Serve(adminListener)
+		defer adminServer.Close()
+		slog.Info("Admin listener started", "address", adminListener.Addr().String())
+	}
+	if internalServer != nil {
+		go internalServer.Serve(internalListener)
+		defer internalServer.Close()
+		slog.Info("Internal listener started", "address", internalListener.Addr().String())
+	}
+	served := make(chan error, 1)
+	go func() {
+		if tlsConfig != nil {
+			served <- server.ServeTLS(serverListener, "", "")
+		} else {
+			served <- server.Serve(serverListener)
+		}
+	}()
+	select {
+	case err := <-served:
 		log.Fatal("Server failed to start:", err)
+	case sig := <-stop:
+		slog.Info("Shutting down, draining requests", "signal", sig.String(), "timeout", drainTimeout.String())
+	}
+	signal.Stop(stop)
+
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Warn("Requests still in progress after the drain timeout, closing their connections")
+		server.Close()
+	}
+	jobsDone := make(chan struct{})
+	go func() {
+		serviceConfig.Jobs.Wait()
+		close(jobsDone)
+	}()
+	select {
+	case <-jobsDone:
+	case <-ctx.Done():
+		slog.Warn("Background uploads still in progress after the drain timeout, abandoning them")
 	}
+	slog.Info("Server stopped")
 }
 
-func getCurrentTime() string {
-	return fmt.Sprint(time.Now().Format("2006-01-02 15:04:05"))
+// serviceEndpoint describes a SOAP service mounted at its own path
+type serviceEndpoint struct {
+	Name     string
+	Path     string
+	Schemas  []string
+	Config   handler.Config
+	Register func(*soap.OperationRegistry, handler.Config) error
+	Versions []serviceVersion
+	Strict   bool // Reject unexpected and misplaced request elements
 }
 
-func stripQuotes(s string) string {
-	if len(s) >= 2 && (s[0] == '"' && s[len(s)-1] == '"') {
-		return s[1 : len(s)-1]
-	}
-	return s
+// serviceVersion is an additional contract version of a service. Its
+// operations live in the service namespace with the version appended and
+// are routed by the namespace of the request element.
+type serviceVersion struct {
+	Version string   // Path segment and namespace suffix (e.g. "v2")
+	Schemas []string // Bundled XSD documents of the version
 }
 
-func sendSOAPError(w http.ResponseWriter, faultCode, faultString, detail string) {
-	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+// contract is the WSDL contract of one service version
+type contract struct {
+	Path      string
+	Namespace string
+	Schemas   []string
+}
 
-	fault := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
-<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/">
-    <soap:Body>
-        <soap:Fault>
-            <faultcode>%s</faultcode>
-            <faultstring>%s</faultstring>
-            <detail>%s</detail>
-        </soap:Fault>
-    </soap:Body>
-</soap:Envelope>`, faultCode, faultString, detail)
+// contracts returns the contract of the service followed by those of its
+// additional versions
+func (svc serviceEndpoint) contracts() []contract {
+	contracts := []contract{{Path: svc.Path, Namespace: svc.Config.Namespace, Schemas: svc.Schemas}}
+	for _, v := range svc.Versions {
+		contracts = append(contracts, contract{
+			Path:      svc.Path + "/" + v.Version,
+			Namespace: svc.Config.Versioned(v.Version).Namespace,
+			Schemas:   v.Schemas,
+		})
+	}
+	return contracts
+}
 
-	w.Write([]byte(fault))
+// wsdlOptions controls the generated service WSDLs
+type wsdlOptions struct {
+	ExternalURL   string
+	ImportSchemas bool
+	DerivedTypes  bool
+	Policy        wsdl.Policy
 }
 
-// readCloser wraps a string to implement io.ReadCloser
-type readCloser struct {
-	*strings.Reader
+// mountService registers the operations of a service and mounts a server for
+// each contract version at its path, with the WSDL at path + "/wsdl". The
+// servers share the registry, so every path accepts all versions.
+func mountService(mux *http.ServeMux, svc serviceEndpoint, opts wsdlOptions) ([]*soap.Server, error) {
+	registry := soap.NewOperationRegistry()
+	if err := svc.Register(registry, svc.Config); err != nil {
+		return nil, fmt.Errorf("%s: %w", svc.Name, err)
+	}
+
+	var servers []*soap.Server
+	for _, c := range svc.contracts() {
+		def := wsdl.Definition{
+			Name:       svc.Name,
+			Namespace:  c.Namespace,
+			Address:    c.Path,
+			BaseURL:    opts.ExternalURL,
+			Schemas:    c.Schemas,
+			Import:     opts.ImportSchemas,
+			Policy:     opts.Policy,
+			Operations: registry.OperationsIn(c.Namespace),
+		}
+		if opts.DerivedTypes {
+			def.Schemas = nil
+		}
+		contract := wsdl.ServiceHandler(def)
+
+		server := soap.NewServer(registry)
+		server.Contract = wsdl.QueryHandler(contract, c.Schemas, c.Namespace, opts.ExternalURL)
+		mux.Handle(c.Path, server)
+		mux.Handle(c.Path+"/wsdl", contract)
+		servers = append(servers, server)
+	}
+
+	return servers, nil
 }
 
-func newReadCloser(s string) *readCloser {
-	return &readCloser{strings.NewReader(s)}
+// validationConfig selects the schema validation performed by a server
+type validationConfig struct {
+	Requests  bool   // Validate request bodies
+	Responses string // Validate responses: "" (off), "log" or "fail"
 }
 
-func (rc *readCloser) Close() error {
+// apply loads the schemas of the contracts and enables the configured
+// validation on server
+func (v validationConfig) apply(server *soap.Server, contracts []contract) error {
+	if !v.Requests && v.Responses == "" {
+		return nil
+	}
+
+	var docs [][]byte
+	for _, c := range contracts {
+		for _, name := range c.Schemas {
+			data, err := wsdl.Schema(name, c.Namespace)
+			if err != nil {
+				return err
+			}
+			docs = append(docs, data)
+		}
+	}
+	schema, err := xsd.Parse(docs...)
+	if err != nil {
+		return err
+	}
+	if v.Requests {
+		server.Schema = schema
+	}
+	if v.Responses != "" {
+		server.ResponseSchema = schema
+		server.FailInvalidResponses = v.Responses == "fail"
+	}
 	return nil
 }
+
+// userStoreConfig selects the user store
+type userStoreConfig struct {
+	Kind string              // "memory" (default), "sqlite" or "postgres"
+	DSN  string              // Database file or connection string
+	Pool sqlstore.PoolConfig // Connection pool of the postgres store
+}
+
+// open creates the configured user store
+func (c userStoreConfig) open(ctx context.Context) (handler.UserStore, error) {
+	switch c.Kind {
+	case "", "memory":
+		return handler.NewMemoryUserStore(), nil
+	case "sqlite":
+		return sqlstore.OpenSQLite(ctx, c.dsn())
+	case "postgres":
+		if c.DSN == "" {
+			return nil, fmt.Errorf("postgres user store requires SOAP_USER_DB")
+		}
+		return sqlstore.OpenPostgres(ctx, c.DSN, c.Pool)
+	}
+	return nil, fmt.Errorf("unknown user store %q", c.Kind)
+}
+
+// dsn returns the configured DSN or the default of the store kind
+func (c userStoreConfig) dsn() string {
+	if c.DSN == "" && c.Kind == "sqlite" {
+		return "./users.db"
+	}
+	return c.DSN
+}
+
+// String describes the store for the startup banner. Connection strings
+// are not shown as they may contain credentials.
+func (c userStoreConfig) String() string {
+	switch c.Kind {
+	case "", "memory":
+		return "memory"
+	case "sqlite":
+		return c.Kind + " (" + c.dsn() + ")"
+	}
+	return c.Kind
+}
+
+// blobStoreConfig selects the storage of the uploaded file content
+type blobStoreConfig struct {
+	Kind      string // "disk" (default) or "s3"
+	UploadDir string
+	S3        s3store.Config
+}
+
+// system names the kind of store, e.g. in traces
+func (c blobStoreConfig) system() string {
+	if c.Kind == "" {
+		return "disk"
+	}
+	return c.Kind
+}
+
+// open creates the configured blob store
+func (c blobStoreConfig) open() (handler.BlobStore, error) {
+	switch c.Kind {
+	case "", "disk":
+		return handler.NewDiskBlobStore(c.UploadDir), nil
+	case "s3":
+		return s3store.New(c.S3)
+	}
+	return nil, fmt.Errorf("unknown blob store %q", c.Kind)
+}
+
+// String describes the blob store for the startup banner, without
+// credentials
+func (c blobStoreConfig) String() string {
+	if c.Kind == "s3" {
+		endpoint := c.S3.Endpoint
+		if endpoint == "" {
+			endpoint = "AWS"
+		}
+		return fmt.Sprintf("s3 (bucket %s, prefix %q, %s)", c.S3.Bucket, c.S3.Prefix, endpoint)
+	}
+	return "disk (" + c.UploadDir + ")"
+}
+
+// masterKeys parses the current master key and the comma-separated
+// previous ones, which only decrypt the files stored before a rotation
+func masterKeys(current, previous string) (*blobcrypt.MasterKeys, error) {
+	key, err := blobcrypt.ParseMasterKey(current)
+	if err != nil {
+		return nil, err
+	}
+	var keys [][]byte
+	for _, v := range splitList(previous) {
+		old, err := blobcrypt.ParseMasterKey(v)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, old)
+	}
+	return blobcrypt.NewMasterKeys(key, keys...)
+}
+
+// newLogHandler returns the handler of the log records in the format, text
+// (the default) or json, dropping those below the level, info by default
+func newLogHandler(w io.Writer, format, level string) (slog.Handler, error) {
+	opts := &slog.HandlerOptions{}
+	if level != "" {
+		var l slog.Level
+		if err := l.UnmarshalText([]byte(level)); err != nil {
+			return nil, fmt.Errorf("invalid SOAP_LOG_LEVEL %q", level)
+		}
+		opts.Level = l
+	}
+	switch format {
+	case "", "text":
+		return slog.NewTextHandler(w, opts), nil
+	case "json":
+		return slog.NewJSONHandler(w, opts), nil
+	}
+	return nil, fmt.Errorf("invalid SOAP_LOG_FORMAT %q: want text or json", format)
+}
+
+// durationSetting returns the duration of a setting, def if it is not set
+func durationSetting(conf *config.Config, name string, def time.Duration) time.Duration {
+	v := conf.Get(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		log.Fatal("Invalid "+name+":", v)
+	}
+	return d
+}
+
+// closeStore closes a store or log holding resources, such as a database
+// connection or a file, on shutdown
+func closeStore(name string, store interface{}) {
+	if c, ok := store.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			slog.Error("Failed to close "+name, soap.LogKeyError, err)
+		}
+	}
+}
+
+// splitList returns the non-empty elements of a comma-separated list
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// parseQuotas parses the default quota and the comma-separated
+// client=size quotas of individual clients
+func parseQuotas(defaultQuota, clients string) (handler.QuotaPolicy, error) {
+	var quotas handler.QuotaPolicy
+	if defaultQuota != "" {
+		limit, err := parseByteSize(defaultQuota)
+		if err != nil {
+			return quotas, err
+		}
+		quotas.Default = limit
+	}
+	for _, entry := range splitList(clients) {
+		client, size, ok := strings.Cut(entry, "=")
+		if !ok || strings.TrimSpace(client) == "" {
+			return quotas, fmt.Errorf("invalid quota %q, expected client=size", entry)
+		}
+		limit, err := parseByteSize(size)
+		if err != nil {
+			return quotas, err
+		}
+		if quotas.Clients == nil {
+			quotas.Clients = map[string]int64{}
+		}
+		quotas.Clients[strings.TrimSpace(client)] = limit
+	}
+	return quotas, nil
+}
+
+// parseByteSize parses a number of bytes with an optional binary unit
+// suffix: KB, MB, GB or TB, e.g. "500MB"
+func parseByteSize(size string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(size))
+	multiplier := int64(1)
+	for i, unit := range []string{"KB", "MB", "GB", "TB"} {
+		if strings.HasSuffix(s, unit) {
+			s, multiplier = strings.TrimSuffix(s, unit), int64(1)<<(10*(i+1))
+			break
+		}
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(strings.TrimSuffix(s, "B")), 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	return n * multiplier, nil
+}
+
+// fileCatalogConfig selects the file catalog
+type fileCatalogConfig struct {
+	Kind string // "memory" (default), "sqlite" or "postgres"
+	DSN  string // Database file or connection string
+}
+
+// open creates the configured file catalog
+func (c fileCatalogConfig) open(ctx context.Context) (handler.FileCatalog, error) {
+	switch c.Kind {
+	case "", "memory":
+		return handler.NewMemoryFileCatalog(), nil
+	case "sqlite":
+		store, err := sqlstore.OpenSQLite(ctx, c.dsn())
+		if err != nil {
+			return nil, err
+		}
+		return store.Files(), nil
+	case "postgres":
+		if c.DSN == "" {
+			return nil, fmt.Errorf("postgres file catalog requires SOAP_FILE_DB")
+		}
+		store, err := sqlstore.OpenPostgres(ctx, c.DSN, sqlstore.PoolConfig{})
+		if err != nil {
+			return nil, err
+		}
+		return store.Files(), nil
+	}
+	return nil, fmt.Errorf("unknown file catalog %q", c.Kind)
+}
+
+// dsn returns the configured DSN or the default of the catalog kind
+func (c fileCatalogConfig) dsn() string {
+	if c.DSN == "" && c.Kind == "sqlite" {
+		return "./files.db"
+	}
+	return c.DSN
+}
+
+// String describes the catalog for the startup banner, without connection
+// strings
+func (c fileCatalogConfig) String() string {
+	switch c.Kind {
+	case "", "memory":
+		return "memory"
+	case "sqlite":
+		return c.Kind + " (" + c.dsn() + ")"
+	}
+	return c.Kind
+}
+
+// auditLogConfig selects the audit log
+type auditLogConfig struct {
+	Kind string // "" (disabled), "file", "sqlite" or "postgres"
+	DSN  string // Log file, database file or connection string
+}
+
+// open creates the configured audit log, or returns nil if it is disabled
+func (c auditLogConfig) open(ctx context.Context) (handler.AuditLog, error) {
+	switch c.Kind {
+	case "", "none":
+		return nil, nil
+	case "file":
+		return handler.OpenFileAuditLog(c.dsn())
+	case "sqlite":
+		store, err := sqlstore.OpenSQLite(ctx, c.dsn())
+		if err != nil {
+			return nil, err
+		}
+		return store.Audit(), nil
+	case "postgres":
+		if c.DSN == "" {
+			return nil, fmt.Errorf("postgres audit log requires SOAP_AUDIT_DB")
+		}
+		store, err := sqlstore.OpenPostgres(ctx, c.DSN, sqlstore.PoolConfig{})
+		if err != nil {
+			return nil, err
+		}
+		return store.Audit(), nil
+	}
+	return nil, fmt.Errorf("unknown audit log %q", c.Kind)
+}
+
+// dsn returns the configured DSN or the default of the log kind
+func (c auditLogConfig) dsn() string {
+	if c.DSN == "" {
+		switch c.Kind {
+		case "file":
+			return "./audit.log"
+		case "sqlite":
+			return "./audit.db"
+		}
+	}
+	return c.DSN
+}
+
+// String describes the audit log for the startup banner, without
+// connection strings
+func (c auditLogConfig) String() string {
+	if c.Kind == "postgres" {
+		return c.Kind
+	}
+	return c.Kind + " (" + c.dsn() + ")"
+}
+
+// listenerURL returns the base URL of the server on a listener for the
+// banner; on a Unix domain socket, the URL clients such as
+// curl --unix-socket request
+func listenerURL(scheme string, l net.Listener) string {
+	if addr, ok := l.Addr().(*net.TCPAddr); ok {
+		return fmt.Sprintf("%s://localhost:%d", scheme, addr.Port)
+	}
+	return scheme + "://localhost"
+}
+
+// socketInfo names the socket of a listener for the banner, unless it is
+// a TCP socket shown by its URL
+func socketInfo(l net.Listener) string {
+	if listener.IsTCP(l) {
+		return ""
+	}
+	return " (" + l.Addr().Network() + " socket " + l.Addr().String() + ")"
+}
+
+// healthTimeout bounds each readiness check
+const healthTimeout = 2 * time.Second
+
+// healthStatus is the body of the health responses
+type healthStatus struct {
+	Status  string                 `json:"status"` // "healthy" or "unhealthy"
+	Service string                 `json:"service"`
+	Build   buildinfo.Info         `json:"build"`
+	Checks  map[string]checkResult `json:"checks,omitempty"`
+}
+
+// checkResult is the outcome of a readiness check. The error of a failed
+// check is only logged, as the probe is not authenticated and errors may
+// name files or database addresses.
+type checkResult struct {
+	Status     string  `json:"status"` // "ok" or "error"
+	DurationMs float64 `json:"durationMs"`
+	err        error
+}
+
+// healthCheck is a dependency verified by the readiness probe
+type healthCheck struct {
+	Name string
+	Ping func(context.Context) error
+}
+
+// healthChecks are the dependencies the server needs to serve requests
+type healthChecks []healthCheck
+
+// add checks a store if it can be pinged, such as a database or a blob
+// store; others, such as memory stores, are always available
+func (checks *healthChecks) add(name string, store interface{}) {
+	if p, ok := store.(interface{ Ping(context.Context) error }); ok {
+		*checks = append(*checks, healthCheck{name, p.Ping})
+	}
+}
+
+// handler reports readiness: the checks run concurrently, and any failure
+// makes the server unhealthy (503) so load balancers stop sending requests
+func (checks healthChecks) handler(w http.ResponseWriter, r *http.Request) {
+	status := healthStatus{Status: "healthy", Service: "SOAP Server", Build: buildinfo.Get(), Checks: map[string]checkResult{}}
+	results := make([]checkResult, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check healthCheck) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(r.Context(), healthTimeout)
+			defer cancel()
+			start := time.Now()
+			err := check.Ping(ctx)
+			results[i] = checkResult{Status: "ok", DurationMs: float64(time.Since(start).Microseconds()) / 1000}
+			if err != nil {
+				results[i].Status, results[i].err = "error", err
+			}
+		}(i, check)
+	}
+	wg.Wait()
+
+	code := http.StatusOK
+	for i, check := range checks {
+		status.Checks[check.Name] = results[i]
+		if results[i].Status != "ok" {
+			status.Status = "unhealthy"
+			code = http.StatusServiceUnavailable
+			slog.Warn("Readiness check failed", "check", check.Name, soap.LogKeyError, results[i].err)
+		}
+	}
+	writeHealth(w, code, status)
+}
+
+// liveHandler reports that the process is serving requests, without
+// checking its dependencies, so that it is only restarted when stuck
+func liveHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, http.StatusOK, healthStatus{Status: "healthy", Service: "SOAP Server", Build: buildinfo.Get()})
+}
+
+func writeHealth(w http.ResponseWriter, code int, status healthStatus) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+}
+
+// pingTempDir checks that the temporary directory, where MTOM attachments
+// and uploads being scanned are spooled, is writable
+func pingTempDir(ctx context.Context) error {
+	file, err := os.CreateTemp("", "health-*")
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteString("ok")
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if removeErr := os.Remove(file.Name()); err == nil {
+		err = removeErr
+	}
+	return err
+}
@@ -0,0 +1,271 @@
+// Package rest exposes the user operations as a JSON API. Requests are
+// validated with the rules of the SOAP requests and served by the same
+// handler functions and user store, so both interfaces behave alike:
+//
+//	GET    /api/users                 SearchUsers (name, emailDomain, createdFrom,
+//	                                  createdTo, sortBy, sortOrder) or, with
+//	                                  id parameters, GetUsers
+//	GET    /api/users/{id}            GetUser
+//	PATCH  /api/users/{id}            UpdateUser ({"name": ..., "email": ...}); a
+//	                                  Content-Language sets the localized name
+//	DELETE /api/users/{id}            DeleteUser
+//	POST   /api/users/{id}/restore    RestoreUser
+//	GET    /api/users/{id}/roles      GetUserRoles
+//	POST   /api/users/{id}/roles      AssignRole ({"role": ...})
+//
+// Names are returned in the language of the Accept-Language header, as in
+// the SOAP responses. Faults are returned as {"error": reason} with 400 for Client faults, 404
+// for unknown users, 401/403 for rejected callers and 500 for Server faults.
+// Validation failures list the fields in "fields".
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"soap-server/handler"
+	"soap-server/soap"
+	"soap-server/soapfault"
+	"strings"
+)
+
+// maxBodySize limits the JSON request bodies
+const maxBodySize = 1 << 20
+
+// Prefix is the path of the user collection
+const Prefix = "/api/users"
+
+// Handler returns the JSON API of the users, to be mounted at Prefix and
+// Prefix + "/". Callers are authenticated by the middleware of the SOAP
+// endpoints and operations authorized with the policy as there; a nil
+// policy allows every caller. The invocations are recorded in the audit
+// log, if not nil, ahead of the middleware so that rejected callers are
+// recorded too.
+func Handler(users handler.UserStore, policy handler.Policy, audit handler.AuditLog, middleware ...soap.Middleware) http.Handler {
+	a := &api{users: users, policy: policy}
+	if audit != nil {
+		middleware = append([]soap.Middleware{handler.AuditRequests(audit, operation)}, middleware...)
+	}
+	return http.HandlerFunc(soap.Chain(a.ServeHTTP, middleware...))
+}
+
+// operations are the operations of the API by the path below a user,
+// "" for the user itself, and the method
+var operations = map[string]map[string]string{
+	"":        {http.MethodGet: "GetUser", http.MethodPatch: "UpdateUser", http.MethodDelete: "DeleteUser"},
+	"restore": {http.MethodPost: "RestoreUser"},
+	"roles":   {http.MethodGet: "GetUserRoles", http.MethodPost: "AssignRole"},
+}
+
+// operation returns the name of the operation the request invokes, or ""
+// if its path and method name none
+func operation(r *http.Request) string {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, Prefix), "/")
+	if path == "" {
+		if r.Method != http.MethodGet {
+			return ""
+		}
+		if len(r.URL.Query()["id"]) > 0 {
+			return "GetUsers"
+		}
+		return "SearchUsers"
+	}
+	_, sub, _ := strings.Cut(path, "/")
+	return operations[sub][r.Method]
+}
+
+type api struct {
+	users  handler.UserStore
+	policy handler.Policy
+}
+
+func (a *api) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r = r.WithContext(soap.WithAcceptLanguage(r.Context(), r.Header.Get("Accept-Language")))
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, Prefix), "/")
+	if path == "" {
+		if !allow(w, r, http.MethodGet) {
+			return
+		}
+		a.list(w, r)
+		return
+	}
+
+	id, sub, _ := strings.Cut(path, "/")
+	id, err := url.PathUnescape(id)
+	if err != nil {
+		writeError(w, r, soapfault.Client("Invalid user ID", err.Error()))
+		return
+	}
+
+	switch sub {
+	case "":
+		if !allow(w, r, http.MethodGet, http.MethodPatch, http.MethodDelete) {
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			serve(w, r, a, "GetUser", handler.GetUserRequest{ID: id}, handler.GetUser(a.users))
+		case http.MethodPatch:
+			req := handler.UpdateUserRequest{ID: id}
+			if decodeBody(w, r, &req) {
+				if req.Name != nil {
+					req.Name.Lang = r.Header.Get("Content-Language")
+				}
+				serve(w, r, a, "UpdateUser", req, handler.UpdateUser(a.users))
+			}
+		case http.MethodDelete:
+			serve(w, r, a, "DeleteUser", handler.DeleteUserRequest{ID: id}, handler.DeleteUser(a.users))
+		}
+	case "restore":
+		if allow(w, r, http.MethodPost) {
+			serve(w, r, a, "RestoreUser", handler.RestoreUserRequest{ID: id}, handler.RestoreUser(a.users))
+		}
+	case "roles":
+		if !allow(w, r, http.MethodGet, http.MethodPost) {
+			return
+		}
+		if r.Method == http.MethodGet {
+			serve(w, r, a, "GetUserRoles", handler.GetUserRolesRequest{ID: id}, handler.GetUserRoles(a.users))
+			return
+		}
+		req := handler.AssignRoleRequest{ID: id}
+		if decodeBody(w, r, &req) {
+			serve(w, r, a, "AssignRole", req, handler.AssignRole(a.users))
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// list serves the collection: GetUsers when IDs are given, SearchUsers
+// otherwise
+func (a *api) list(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if ids := query["id"]; len(ids) > 0 {
+		serve(w, r, a, "GetUsers", handler.GetUsersRequest{IDs: ids}, handler.GetUsers(a.users))
+		return
+	}
+	req := handler.SearchUsersRequest{
+		Name:        query.Get("name"),
+		EmailDomain: query.Get("emailDomain"),
+		CreatedFrom: query.Get("createdFrom"),
+		CreatedTo:   query.Get("createdTo"),
+		SortBy:      query.Get("sortBy"),
+		SortOrder:   query.Get("sortOrder"),
+	}
+	serve(w, r, a, "SearchUsers", req, handler.SearchUsers(a.users))
+}
+
+// serve validates and authorizes the request, calls the handler function of
+// the operation, a func(context.Context, Req) (Resp, error) as registered
+// with the SOAP registry, and writes its response
+func serve(w http.ResponseWriter, r *http.Request, a *api, operation string, req interface{}, fn interface{}) {
+	soap.ObserveInvocation(r.Context())
+	if err := soap.Validate(req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if principal, ok := soap.PrincipalFromContext(r.Context()); ok && !principal.Allows(operation) {
+		writeError(w, r, soapfault.Client("Access denied",
+			"The credentials do not allow operation "+operation).WithSubcode("", "Authorization"))
+		return
+	}
+	if err := a.policy.Check(r.Context(), a.users, operation); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	out := reflect.ValueOf(fn).Call([]reflect.Value{reflect.ValueOf(r.Context()), reflect.ValueOf(req)})
+	if err, _ := out[1].Interface().(error); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, out[0].Interface())
+}
+
+// allow reports whether the request method is one of the methods, and
+// responds 405 otherwise
+func allow(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, m := range methods {
+		if r.Method == m {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeJSON(w, http.StatusMethodNotAllowed, errorBody{Error: "Method not allowed"})
+	return false
+}
+
+// decodeBody decodes the JSON request body into v, responding 400 if it is
+// malformed
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = errors.New("request body is empty")
+		}
+		writeError(w, r, soapfault.Client("Invalid JSON", err.Error()))
+		return false
+	}
+	return true
+}
+
+// errorBody is the response of failed requests
+type errorBody struct {
+	Error  string            `json:"error"`
+	Detail string            `json:"detail,omitempty"`
+	Fields []soap.FieldError `json:"fields,omitempty"`
+}
+
+// writeError maps an error of the validation layer or a handler to a
+// status code and error body, and records it as the fault of the
+// invocation
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var fieldErrs *soap.FieldErrors
+	if errors.As(err, &fieldErrs) {
+		soap.RecordFault(r.Context(), soapfault.Client("Invalid input", fieldErrs))
+		writeJSON(w, http.StatusBadRequest, errorBody{Error: "Invalid input", Fields: fieldErrs.Errors})
+		return
+	}
+
+	fault, ok := soapfault.As(err)
+	if !ok {
+		// Validate methods may return plain errors
+		soap.RecordFault(r.Context(), soapfault.Client("Invalid input", err.Error()))
+		writeJSON(w, http.StatusBadRequest, errorBody{Error: "Invalid input", Detail: err.Error()})
+		return
+	}
+	soap.RecordFault(r.Context(), fault)
+
+	body := errorBody{Error: fault.Reason}
+	body.Detail, _ = fault.Detail.(string)
+	status := http.StatusInternalServerError
+	switch {
+	case isUserNotFound(fault):
+		status = http.StatusNotFound
+	case fault.Subcode.Local == "Authentication":
+		status = http.StatusUnauthorized
+	case fault.Subcode.Local == "Authorization":
+		status = http.StatusForbidden
+	case fault.Code == soapfault.CodeClient:
+		status = http.StatusBadRequest
+	}
+	writeJSON(w, status, body)
+}
+
+// isUserNotFound reports whether the fault is the UserNotFoundFault of a
+// handler
+func isUserNotFound(fault *soapfault.Fault) bool {
+	_, ok := fault.Detail.(handler.UserNotFoundFault)
+	return ok
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
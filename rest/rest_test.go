@@ -0,0 +1,151 @@
+package rest_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"soap-server/auth"
+	"soap-server/handler"
+	"soap-server/rest"
+	"soap-server/soap"
+	"strings"
+	"testing"
+)
+
+// newAPI returns the JSON API behind the authentication middleware and
+// policy of the SOAP endpoints, with an admin, a caller without roles and a
+// user to act on
+func newAPI(t *testing.T) http.Handler {
+	return newAuditedAPI(t, nil)
+}
+
+// newAuditedAPI returns the API of newAPI recording its invocations in the
+// audit log
+func newAuditedAPI(t *testing.T, audit handler.AuditLog) http.Handler {
+	t.Helper()
+	users := handler.NewMemoryUserStore(
+		handler.User{ID: "alice", Name: "Alice", Email: "alice@example.com", Roles: []string{handler.RoleAdmin}},
+		handler.User{ID: "bob", Name: "Bob", Email: "bob@example.com"},
+		handler.User{ID: "1", Name: "Hong Gildong", Email: "hong@example.com"},
+	)
+	creds, err := auth.ParseCredentials("alice:alice-secret,bob:bob-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := auth.ParseAPIKeys("reader-key=reader:GetUser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := handler.DefaultPolicy()
+	middleware := []soap.Middleware{
+		auth.APIKeyAuth("", keys),
+		auth.Basic("test", creds),
+		auth.Require(auth.APIKeyChallenge(""), auth.BasicChallenge("test")),
+		handler.Authorize(users, policy),
+	}
+	return rest.Handler(users, policy, audit, middleware...)
+}
+
+func TestAuthentication(t *testing.T) {
+	api := newAPI(t)
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		user   string // Basic credentials user:password
+		apiKey string
+		status int
+	}{
+		{"get without credentials", http.MethodGet, "/api/users/1", "", "", http.StatusUnauthorized},
+		{"search without credentials", http.MethodGet, "/api/users?name=Hong", "", "", http.StatusUnauthorized},
+		{"delete without credentials", http.MethodDelete, "/api/users/1", "", "", http.StatusUnauthorized},
+		{"assign role without credentials", http.MethodPost, "/api/users/1/roles", "", "", http.StatusUnauthorized},
+		{"wrong password", http.MethodGet, "/api/users/1", "alice:wrong", "", http.StatusUnauthorized},
+		{"unknown API key", http.MethodGet, "/api/users/1", "", "other-key", http.StatusUnauthorized},
+		{"get with credentials", http.MethodGet, "/api/users/1", "bob:bob-secret", "", http.StatusOK},
+		{"delete without the admin role", http.MethodDelete, "/api/users/1", "bob:bob-secret", "", http.StatusForbidden},
+		{"get with API key", http.MethodGet, "/api/users/1", "", "reader-key", http.StatusOK},
+		{"operation not allowed for API key", http.MethodGet, "/api/users/1/roles", "", "reader-key", http.StatusForbidden},
+		{"delete as admin", http.MethodDelete, "/api/users/1", "alice:alice-secret", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := ""
+			if tt.method == http.MethodPost {
+				body = `{"role": "editor"}`
+			}
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(body))
+			if user, password, ok := strings.Cut(tt.user, ":"); ok {
+				req.SetBasicAuth(user, password)
+			}
+			if tt.apiKey != "" {
+				req.Header.Set(auth.DefaultAPIKeyHeader, tt.apiKey)
+			}
+			rec := httptest.NewRecorder()
+			api.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Errorf("%s %s: status %d, want %d (%s)", tt.method, tt.path, rec.Code, tt.status, strings.TrimSpace(rec.Body.String()))
+			}
+		})
+	}
+}
+
+func TestUnauthenticatedRequestDoesNotChangeUser(t *testing.T) {
+	api := newAPI(t)
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/users/1", nil)
+	api.ServeHTTP(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest(http.MethodGet, "/api/users/1", nil)
+	req.SetBasicAuth("bob", "bob-secret")
+	rec := httptest.NewRecorder()
+	api.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("user deleted by an unauthenticated request: status %d (%s)", rec.Code, rec.Body.String())
+	}
+}
+
+func TestInvocationsAreAudited(t *testing.T) {
+	audit, err := handler.OpenFileAuditLog(filepath.Join(t.TempDir(), "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer audit.Close()
+	api := newAuditedAPI(t, audit)
+
+	requests := []struct {
+		user string
+		path string
+	}{
+		{"", "/api/users/1"},
+		{"bob:bob-secret", "/api/users/1"},
+		{"alice:alice-secret", "/api/users/1"},
+	}
+	for _, r := range requests {
+		req := httptest.NewRequest(http.MethodDelete, r.path, nil)
+		if user, password, ok := strings.Cut(r.user, ":"); ok {
+			req.SetBasicAuth(user, password)
+		}
+		api.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	resp, err := handler.ExportAuditLog(audit)(context.Background(), handler.ExportAuditLogRequest{Operation: "DeleteUser"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Caller, authentication method, outcome and fault code of each request
+	want := [][4]string{
+		{"", "", handler.AuditFault, "Client.Authentication"},
+		{"bob", "basic", handler.AuditFault, "Client.Authorization"},
+		{"alice", "basic", handler.AuditSuccess, ""},
+	}
+	if len(resp.Records) != len(want) {
+		t.Fatalf("%d DeleteUser records exported, want %d: %+v", len(resp.Records), len(want), resp.Records)
+	}
+	for i, rec := range resp.Records {
+		if got := [4]string{rec.Caller, rec.AuthMethod, rec.Outcome, rec.FaultCode}; got != want[i] {
+			t.Errorf("record %d = %q, want %q", i, got, want[i])
+		}
+	}
+}
@@ -0,0 +1,348 @@
+// Package s3store implements the blob store of the uploaded files on top of
+// an S3-compatible object storage service such as Amazon S3 or MinIO, so
+// several server instances can share the uploads. Requests are signed with
+// AWS Signature Version 4.
+package s3store
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"soap-server/handler"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultPartSize is the size of the parts of multipart uploads unless
+// configured otherwise
+const DefaultPartSize = 8 << 20
+
+// minPartSize is the smallest part S3 accepts, except for the last one
+const minPartSize = 5 << 20
+
+// Config configures the bucket and the credentials
+type Config struct {
+	Endpoint        string // Service URL, e.g. http://minio:9000; defaults to Amazon S3 in Region
+	Region          string // Default us-east-1
+	Bucket          string
+	Prefix          string // Prepended to the keys of the blobs, e.g. "uploads/"
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string // Only for temporary credentials
+	PathStyle       bool   // Address the bucket in the URL path instead of the host name, as MinIO needs
+	PartSize        int64  // Size of the parts of multipart uploads, default DefaultPartSize; buffered in memory
+	Client          *http.Client
+}
+
+// withDefaults returns a copy of the config with empty fields defaulted
+func (c Config) withDefaults() Config {
+	if c.Region == "" {
+		c.Region = "us-east-1"
+	}
+	if c.Endpoint == "" {
+		c.Endpoint = "https://s3." + c.Region + ".amazonaws.com"
+	}
+	if c.PartSize == 0 {
+		c.PartSize = DefaultPartSize
+	}
+	if c.Client == nil {
+		c.Client = http.DefaultClient
+	}
+	return c
+}
+
+// Store is a handler.BlobStore keeping the blobs as objects in a bucket
+type Store struct {
+	cfg      Config
+	endpoint *url.URL
+}
+
+// New creates a store of the configured bucket. The bucket must exist.
+func New(cfg Config) (*Store, error) {
+	cfg = cfg.withDefaults()
+	if cfg.Bucket == "" {
+		return nil, errors.New("s3 bucket is required")
+	}
+	if cfg.AccessKeyID == "" || cfg.SecretAccessKey == "" {
+		return nil, errors.New("s3 credentials are required")
+	}
+	if cfg.PartSize < minPartSize {
+		return nil, fmt.Errorf("s3 part size must be at least %d bytes", minPartSize)
+	}
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid s3 endpoint: %w", err)
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint %q", cfg.Endpoint)
+	}
+	return &Store{cfg: cfg, endpoint: endpoint}, nil
+}
+
+// Error is an error response of the storage service
+type Error struct {
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("s3: HTTP %d", e.StatusCode)
+	}
+	return fmt.Sprintf("s3: %s: %s", e.Code, e.Message)
+}
+
+// Put uploads the blob in a single request if it fits in one part, and as a
+// multipart upload otherwise
+func (s *Store) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	part := make([]byte, s.cfg.PartSize)
+	n, err := io.ReadFull(r, part)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		resp, err := s.do(ctx, http.MethodPut, key, nil, part[:n])
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return int64(n), nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return s.putMultipart(ctx, key, r, part)
+}
+
+// putMultipart uploads a blob in parts, starting with the full first part
+func (s *Store) putMultipart(ctx context.Context, key string, r io.Reader, part []byte) (int64, error) {
+	var created struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := s.doXML(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, nil, &created); err != nil {
+		return 0, err
+	}
+
+	type completedPart struct {
+		PartNumber int
+		ETag       string
+	}
+	var complete struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}
+	size := int64(0)
+	n := len(part)
+	for {
+		number := len(complete.Parts) + 1
+		query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {created.UploadID}}
+		resp, err := s.do(ctx, http.MethodPut, key, query, part[:n])
+		if err != nil {
+			s.abort(ctx, key, created.UploadID)
+			return 0, err
+		}
+		resp.Body.Close()
+		complete.Parts = append(complete.Parts, completedPart{PartNumber: number, ETag: resp.Header.Get("ETag")})
+		size += int64(n)
+
+		var readErr error
+		n, readErr = io.ReadFull(r, part)
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil && readErr != io.ErrUnexpectedEOF {
+			s.abort(ctx, key, created.UploadID)
+			return 0, readErr
+		}
+		// A short read is the last part and is uploaded on the next pass
+	}
+
+	body, err := xml.Marshal(complete)
+	if err != nil {
+		s.abort(ctx, key, created.UploadID)
+		return 0, err
+	}
+	// Completion can fail after the 200 status line; the error is then in
+	// the body
+	var completed struct {
+		XMLName xml.Name
+		Error
+	}
+	if err := s.doXML(ctx, http.MethodPost, key, url.Values{"uploadId": {created.UploadID}}, body, &completed); err != nil {
+		s.abort(ctx, key, created.UploadID)
+		return 0, err
+	}
+	if completed.XMLName.Local == "Error" {
+		s.abort(ctx, key, created.UploadID)
+		return 0, &completed.Error
+	}
+	return size, nil
+}
+
+// abort discards the parts of a failed multipart upload, even if the
+// request was cancelled
+func (s *Store) abort(ctx context.Context, key, uploadID string) {
+	resp, err := s.do(context.WithoutCancel(ctx), http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil)
+	if err == nil {
+		resp.Body.Close()
+	}
+}
+
+func (s *Store) Open(ctx context.Context, key string) (io.ReadCloser, int64, error) {
+	resp, err := s.get(ctx, key, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	return resp.Body, resp.ContentLength, nil
+}
+
+// OpenRange reads the object from offset with a ranged GET
+func (s *Store) OpenRange(ctx context.Context, key string, offset int64) (io.ReadCloser, error) {
+	resp, err := s.get(ctx, key, http.Header{"Range": {fmt.Sprintf("bytes=%d-", offset)}})
+	var serviceErr *Error
+	if errors.As(err, &serviceErr) && serviceErr.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		// Nothing is left to read past the end
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// get downloads an object, mapping a missing object to handler.ErrBlobNotFound
+func (s *Store) get(ctx context.Context, key string, header http.Header) (*http.Response, error) {
+	resp, err := s.doHeader(ctx, http.MethodGet, key, nil, header, nil)
+	var serviceErr *Error
+	if errors.As(err, &serviceErr) && serviceErr.StatusCode == http.StatusNotFound {
+		return nil, handler.ErrBlobNotFound
+	}
+	return resp, err
+}
+
+// Delete removes the object. S3 does not report missing objects.
+func (s *Store) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Ping checks that the bucket exists and can be accessed with the
+// credentials
+func (s *Store) Ping(ctx context.Context) error {
+	resp, err := s.do(ctx, http.MethodHead, "", nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// List returns the objects under the prefix, with the prefix removed from
+// their keys
+func (s *Store) List(ctx context.Context) ([]handler.BlobInfo, error) {
+	var blobs []handler.BlobInfo
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {s.cfg.Prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		var page struct {
+			Contents []struct {
+				Key          string    `xml:"Key"`
+				Size         int64     `xml:"Size"`
+				LastModified time.Time `xml:"LastModified"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		if err := s.doXML(ctx, http.MethodGet, "", query, nil, &page); err != nil {
+			return nil, err
+		}
+		for _, object := range page.Contents {
+			blobs = append(blobs, handler.BlobInfo{
+				Key:     strings.TrimPrefix(object.Key, s.cfg.Prefix),
+				Size:    object.Size,
+				ModTime: object.LastModified,
+			})
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return blobs, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// doXML sends a request and decodes the XML response body into v
+func (s *Store) doXML(ctx context.Context, method, key string, query url.Values, body []byte, v interface{}) error {
+	resp, err := s.do(ctx, method, key, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := xml.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("s3: invalid response: %w", err)
+	}
+	return nil
+}
+
+// do sends a signed request for the object with the given key, or for the
+// bucket if the key is empty. Responses other than 2xx are returned as an
+// *Error with the response body closed.
+func (s *Store) do(ctx context.Context, method, key string, query url.Values, body []byte) (*http.Response, error) {
+	return s.doHeader(ctx, method, key, query, nil, body)
+}
+
+// doHeader sends a request like do with additional, unsigned headers
+func (s *Store) doHeader(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	u := *s.endpoint
+	path := strings.TrimSuffix(u.Path, "/")
+	if s.cfg.PathStyle {
+		path += "/" + s.cfg.Bucket
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+	}
+	path += "/"
+	if key != "" {
+		path += s.cfg.Prefix + key
+	}
+	u.Path = path
+	u.RawPath = uriEncode(path, false)
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.ContentLength = int64(len(body))
+	payloadHash := emptyPayloadHash
+	if len(body) > 0 {
+		payloadHash = sha256Hex(body)
+	}
+	s.sign(req, payloadHash, time.Now())
+
+	resp, err := s.cfg.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		serviceErr := &Error{StatusCode: resp.StatusCode}
+		// HEAD responses and some proxies have no error document
+		xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(serviceErr)
+		serviceErr.StatusCode = resp.StatusCode
+		return nil, serviceErr
+	}
+	return resp, nil
+}
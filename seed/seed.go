@@ -0,0 +1,170 @@
+// Package seed loads the initial data of the server from JSON or YAML seed
+// files. A seed document has the form
+//
+//	users:
+//	  - id: "1"
+//	    name: 홍길동
+//	    email: hong@example.com
+//	    createdAt: "2024-01-01"
+//	    roles: [admin]
+//
+// with the fields of handler.User. The sample data the in-memory store
+// starts with is embedded from sample.json.
+package seed
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"soap-server/handler"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed sample.json
+var sample []byte
+
+// Data is the content of one or more seed documents
+type Data struct {
+	Users []handler.User `json:"users"`
+}
+
+// Sample returns the sample users
+func Sample() Data {
+	data, err := decode("sample.json", sample)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// Load reads a seed file, or all .json, .yaml and .yml files of a directory
+// in name order
+func Load(path string) (Data, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return Data{}, err
+	}
+
+	files := []string{path}
+	if info.IsDir() {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return Data{}, err
+		}
+		files = nil
+		for _, entry := range entries {
+			if !entry.IsDir() && isSeedFile(entry.Name()) {
+				files = append(files, filepath.Join(path, entry.Name()))
+			}
+		}
+		sort.Strings(files)
+	}
+
+	var all Data
+	ids := map[string]string{}
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return Data{}, err
+		}
+		data, err := decode(file, content)
+		if err != nil {
+			return Data{}, err
+		}
+		for _, u := range data.Users {
+			if other, ok := ids[u.ID]; ok {
+				return Data{}, fmt.Errorf("%s: user %s is already defined in %s", file, u.ID, other)
+			}
+			ids[u.ID] = file
+		}
+		all.Users = append(all.Users, data.Users...)
+	}
+	return all, nil
+}
+
+// Apply creates the seeded users that do not exist yet, so seeding a
+// persistent store again keeps the changes made since. It returns the
+// number of users created.
+func (d Data) Apply(ctx context.Context, users handler.UserStore) (int, error) {
+	created := 0
+	for _, u := range d.Users {
+		err := users.Create(ctx, u)
+		if errors.Is(err, handler.ErrUserExists) {
+			continue
+		}
+		if err != nil {
+			return created, fmt.Errorf("user %s: %w", u.ID, err)
+		}
+		created++
+	}
+	return created, nil
+}
+
+// isSeedFile reports whether the file name has a seed file extension
+func isSeedFile(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json", ".yaml", ".yml":
+		return true
+	}
+	return false
+}
+
+// decode parses a JSON or YAML seed document. YAML documents are converted
+// to JSON so both formats share the json field names and the rejection of
+// unknown fields.
+func decode(name string, content []byte) (Data, error) {
+	if ext := strings.ToLower(filepath.Ext(name)); ext == ".yaml" || ext == ".yml" {
+		var doc interface{}
+		if err := yaml.Unmarshal(content, &doc); err != nil {
+			return Data{}, fmt.Errorf("%s: %w", name, err)
+		}
+		converted, err := json.Marshal(yamlDates(doc))
+		if err != nil {
+			return Data{}, fmt.Errorf("%s: %w", name, err)
+		}
+		content = converted
+	}
+
+	var data Data
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&data); err != nil {
+		return Data{}, fmt.Errorf("%s: %w", name, err)
+	}
+	for i, u := range data.Users {
+		if strings.TrimSpace(u.ID) == "" {
+			return Data{}, fmt.Errorf("%s: user %d has no id", name, i+1)
+		}
+	}
+	return data, nil
+}
+
+// yamlDates turns the timestamps of a decoded YAML document back into
+// strings: unquoted dates such as 2024-01-01 are decoded as time.Time
+func yamlDates(v interface{}) interface{} {
+	switch v := v.(type) {
+	case time.Time:
+		if v.Equal(v.Truncate(24 * time.Hour)) {
+			return v.Format("2006-01-02")
+		}
+		return v.Format(time.RFC3339)
+	case map[string]interface{}:
+		for key, value := range v {
+			v[key] = yamlDates(value)
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = yamlDates(value)
+		}
+	}
+	return v
+}
@@ -0,0 +1,152 @@
+package soap
+
+import (
+	"context"
+	"encoding/xml"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// WS-Addressing namespaces (W3C 1.0 and the 2004/08 member submission)
+const (
+	AddressingNamespace     = "http://www.w3.org/2005/08/addressing"
+	AddressingNamespace2004 = "http://schemas.xmlsoap.org/ws/2004/08/addressing"
+)
+
+// Anonymous reply addresses meaning "respond on the HTTP back-channel"
+const (
+	anonymousAddress     = "http://www.w3.org/2005/08/addressing/anonymous"
+	anonymousAddress2004 = "http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous"
+	noneAddress          = "http://www.w3.org/2005/08/addressing/none"
+)
+
+// Addressing holds the WS-Addressing message addressing properties of a request
+type Addressing struct {
+	Namespace string // WS-Addressing namespace used by the client
+	To        string // wsa:To destination URI
+	Action    string // wsa:Action URI identifying the operation
+	MessageID string // wsa:MessageID of the request
+	ReplyTo   string // Address of the wsa:ReplyTo endpoint reference
+	FaultTo   string // Address of the wsa:FaultTo endpoint reference
+}
+
+// endpointReference is the XML shape of wsa:ReplyTo and wsa:FaultTo
+type endpointReference struct {
+	Address string `xml:"Address"`
+}
+
+// parseAddressing extracts the addressing headers from the request header.
+// The consumed blocks are marked understood. It returns nil if the request
+// carries no WS-Addressing headers.
+func parseAddressing(h *Header) *Addressing {
+	var a *Addressing
+
+	for _, block := range h.Blocks {
+		ns := block.Name.Space
+		if ns != AddressingNamespace && ns != AddressingNamespace2004 {
+			continue
+		}
+		if a == nil {
+			a = &Addressing{Namespace: ns}
+		}
+
+		switch block.Name.Local {
+		case "To":
+			a.To = blockText(block)
+		case "Action":
+			a.Action = blockText(block)
+		case "MessageID":
+			a.MessageID = blockText(block)
+		case "ReplyTo", "FaultTo":
+			var epr endpointReference
+			if err := block.Decode(&epr); err != nil {
+				continue
+			}
+			if block.Name.Local == "ReplyTo" {
+				a.ReplyTo = strings.TrimSpace(epr.Address)
+			} else {
+				a.FaultTo = strings.TrimSpace(epr.Address)
+			}
+		default:
+			continue
+		}
+		block.MarkUnderstood()
+	}
+
+	return a
+}
+
+// anonymousReply reports whether the response can be sent on the HTTP
+// back-channel. Asynchronous replies to other addresses are not supported.
+func (a *Addressing) anonymousReply() bool {
+	for _, addr := range []string{a.ReplyTo, a.FaultTo} {
+		switch addr {
+		case "", anonymousAddress, anonymousAddress2004, noneAddress:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// responseHeaders renders the addressing headers of a response to the request
+func (a *Addressing) responseHeaders(fault bool) []string {
+	action := ""
+	if fault {
+		action = a.Namespace + "/fault"
+		if a.Namespace == AddressingNamespace {
+			action = AddressingNamespace + "/soap/fault"
+		}
+	} else if a.Action != "" {
+		action = a.Action + "Response"
+	}
+
+	headers := []string{
+		addressingElement(a.Namespace, "MessageID", "urn:uuid:"+uuid.New().String()),
+	}
+	if action != "" {
+		headers = append(headers, addressingElement(a.Namespace, "Action", action))
+	}
+	if a.MessageID != "" {
+		headers = append(headers, addressingElement(a.Namespace, "RelatesTo", a.MessageID))
+	}
+	if a.Namespace == AddressingNamespace {
+		headers = append(headers, addressingElement(a.Namespace, "To", anonymousAddress))
+	}
+
+	return headers
+}
+
+// addressingElement marshals a simple text addressing header
+func addressingElement(namespace, local, value string) string {
+	element := struct {
+		XMLName xml.Name
+		Value   string `xml:",chardata"`
+	}{XMLName: xml.Name{Space: namespace, Local: local}, Value: value}
+
+	data, _ := xml.Marshal(element)
+	return string(data)
+}
+
+// blockText returns the trimmed character data of a header block
+func blockText(block *HeaderBlock) string {
+	var text struct {
+		Value string `xml:",chardata"`
+	}
+	block.Decode(&text)
+	return strings.TrimSpace(text.Value)
+}
+
+type addressingKey struct{}
+
+// WithAddressing returns a copy of ctx carrying the addressing properties
+func WithAddressing(ctx context.Context, a *Addressing) context.Context {
+	return context.WithValue(ctx, addressingKey{}, a)
+}
+
+// AddressingFromContext returns the WS-Addressing properties of the request
+func AddressingFromContext(ctx context.Context) (*Addressing, bool) {
+	a, ok := ctx.Value(addressingKey{}).(*Addressing)
+	return a, ok && a != nil
+}
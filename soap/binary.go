@@ -0,0 +1,92 @@
+package soap
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// BinaryField is binary content of a request or response element, base64
+// encoded in the XML. Responses to clients accepting MTOM carry values of at
+// least the MTOMThreshold of the server as attachments instead, referenced
+// by an xop:Include (or an href attribute for SwA clients), so operations
+// get optimized binary transfer without writing multipart responses
+// themselves. Plain []byte fields are written as text by encoding/xml and
+// are never externalized.
+type BinaryField []byte
+
+// binaryEncoders maps the encoders of responses collecting attachments to
+// their binaryParts. MarshalXML only receives the encoder, which is unique
+// per response.
+var binaryEncoders sync.Map
+
+// binaryParts collects the BinaryField values of a response sent as
+// attachments
+type binaryParts struct {
+	threshold   int
+	xop         bool // MTOM; SwA otherwise
+	attachments []Attachment
+}
+
+// newBinaryParts returns the attachment collector of a response to r, or
+// nil if the server does not externalize binary content or the client does
+// not accept multipart responses
+func newBinaryParts(r *http.Request) *binaryParts {
+	threshold, _ := r.Context().Value(mtomThresholdKey{}).(int)
+	if threshold <= 0 || !AcceptsMTOM(r) {
+		return nil
+	}
+	return &binaryParts{threshold: threshold, xop: !IsSwA(r)}
+}
+
+type mtomThresholdKey struct{}
+
+// withMTOMThreshold returns a copy of ctx carrying the size from which
+// BinaryField values are sent as attachments
+func withMTOMThreshold(ctx context.Context, threshold int) context.Context {
+	return context.WithValue(ctx, mtomThresholdKey{}, threshold)
+}
+
+// MarshalXML writes the content as base64, or as a reference to a new
+// attachment when the response collects them and the content is large
+// enough
+func (b BinaryField) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	value, ok := binaryEncoders.Load(e)
+	if !ok || len(b) < value.(*binaryParts).threshold {
+		return e.EncodeElement(base64.StdEncoding.EncodeToString(b), start)
+	}
+	parts := value.(*binaryParts)
+	contentID := uuid.New().String() + "@soap-server"
+	parts.attachments = append(parts.attachments, Attachment{ContentID: contentID, Body: bytes.NewReader(b)})
+
+	if !parts.xop {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "href"}, Value: "cid:" + contentID})
+		return e.EncodeElement("", start)
+	}
+	// XOP requires the xop:Include to be the only child of its element, so
+	// it is written without indentation
+	include := struct {
+		XML string `xml:",innerxml"`
+	}{`<xop:Include xmlns:xop="http://www.w3.org/2004/08/xop/include" href="cid:` + contentID + `"/>`}
+	return e.EncodeElement(include, start)
+}
+
+// UnmarshalXML decodes base64 content
+func (b *BinaryField) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var text string
+	if err := d.DecodeElement(&text, &start); err != nil {
+		return err
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(text))
+	if err != nil {
+		return err
+	}
+	*b = data
+	return nil
+}
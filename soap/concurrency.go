@@ -0,0 +1,112 @@
+package soap
+
+import (
+	"context"
+	"net/http"
+	"soap-server/soapfault"
+	"strconv"
+	"time"
+)
+
+// Limiter bounds the number of requests served at once. Requests over the
+// limit wait for a slot up to the queue timeout and are then rejected with
+// a Server busy fault, so a burst of large requests cannot exhaust the
+// memory of the process. A Limiter may be shared by several servers.
+type Limiter struct {
+	name    string // What is limited, for the logs, e.g. "uploads"
+	slots   chan struct{}
+	timeout time.Duration
+}
+
+// NewLimiter returns a limiter of max concurrent requests, which wait at
+// most timeout for a slot
+func NewLimiter(name string, max int, timeout time.Duration) *Limiter {
+	return &Limiter{name: name, slots: make(chan struct{}, max), timeout: timeout}
+}
+
+// Max returns the number of requests served at once
+func (l *Limiter) Max() int {
+	return cap(l.slots)
+}
+
+// InFlight returns the number of requests being served
+func (l *Limiter) InFlight() int {
+	return len(l.slots)
+}
+
+// acquire waits for a slot until the queue timeout expires or ctx is done
+// and reports whether it got one
+func (l *Limiter) acquire(ctx context.Context) bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+	if l.timeout <= 0 {
+		return false
+	}
+	timer := time.NewTimer(l.timeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (l *Limiter) release() {
+	<-l.slots
+}
+
+// reject answers a request that got no slot with a Server fault sent with
+// 503 Service Unavailable and a Retry-After header
+func (l *Limiter) reject(w http.ResponseWriter, r *http.Request) {
+	Warn(r.Context(), "Server busy, request rejected", "limit", l.name, "max", l.Max())
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter(l.timeout)))
+	WriteFault(w, r, soapfault.Server("Server busy",
+		"Too many concurrent "+l.name+", retry later").WithHTTPStatus(http.StatusServiceUnavailable))
+}
+
+// retryAfter returns the delay clients are asked to wait, in whole seconds
+func retryAfter(timeout time.Duration) int {
+	if seconds := int((timeout + time.Second - 1) / time.Second); seconds > 1 {
+		return seconds
+	}
+	return 1
+}
+
+// Limit returns middleware serving the named operations, or all of them
+// without names, within the limiter. Requests of other operations pass
+// through.
+func Limit(l *Limiter, operations ...string) Middleware {
+	return func(next SOAPHandler) SOAPHandler {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if op, ok := OperationFromContext(r.Context()); !ok || !limited(op.Name, operations) {
+				next(w, r)
+				return
+			}
+			if !l.acquire(r.Context()) {
+				l.reject(w, r)
+				return
+			}
+			defer l.release()
+			next(w, r)
+		}
+	}
+}
+
+// limited reports whether an operation is among the limited ones
+func limited(name string, operations []string) bool {
+	if len(operations) == 0 {
+		return true
+	}
+	for _, op := range operations {
+		if op == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,214 @@
+package soap
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/transform"
+)
+
+// Media types accepted for SOAP envelopes
+const (
+	mediaTypeSOAP11 = "text/xml"
+	mediaTypeSOAP12 = "application/soap+xml"
+	mediaTypeXML    = "application/xml"
+	mediaTypeXOP    = "application/xop+xml"
+	mediaTypeMTOM   = "multipart/related"
+)
+
+// contentInfo describes the Content-Type of a SOAP request
+type contentInfo struct {
+	MediaType string  // Top level media type of the request
+	RootType  string  // Media type of the envelope (root part for multipart requests)
+	Version   Version // SOAP version implied by the media type
+	Action    string  // action parameter (SOAP 1.2)
+	Charset   string  // Declared charset of the envelope, empty if none
+
+	// VersionFromEnvelope is set when the media type does not imply a SOAP
+	// version (application/xml) and the envelope namespace decides
+	VersionFromEnvelope bool
+}
+
+// unsupportedMediaTypeError is returned for requests the server cannot parse
+type unsupportedMediaTypeError struct {
+	mediaType string
+}
+
+func (e *unsupportedMediaTypeError) Error() string {
+	return fmt.Sprintf("unsupported media type %q; use text/xml, application/soap+xml, application/xml or multipart/related", e.mediaType)
+}
+
+// parseContentType determines the SOAP version, action and charset from a
+// request Content-Type. For multipart/related (MTOM) requests the envelope
+// type is taken from the type and start-info parameters. A missing
+// Content-Type is treated as text/xml.
+func parseContentType(contentType string) (contentInfo, error) {
+	if strings.TrimSpace(contentType) == "" {
+		return contentInfo{MediaType: mediaTypeSOAP11, RootType: mediaTypeSOAP11, Version: SOAP11}, nil
+	}
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return contentInfo{}, &unsupportedMediaTypeError{mediaType: contentType}
+	}
+
+	info := contentInfo{
+		MediaType: mediaType,
+		RootType:  mediaType,
+		Action:    params["action"],
+		Charset:   params["charset"],
+	}
+
+	if mediaType == mediaTypeMTOM {
+		info.RootType = params["type"]
+		info.Charset = ""
+		if info.RootType == mediaTypeXOP {
+			startInfo, startParams, err := mime.ParseMediaType(params["start-info"])
+			if err == nil {
+				info.RootType = startInfo
+				if info.Action == "" {
+					info.Action = startParams["action"]
+				}
+			}
+		}
+	}
+
+	switch info.RootType {
+	case mediaTypeSOAP11:
+		info.Version = SOAP11
+	case mediaTypeSOAP12:
+		info.Version = SOAP12
+	case mediaTypeXML, "":
+		// Plain XML (or multipart without a declared root type) carries no
+		// version information; the envelope namespace decides
+		info.Version = SOAP11
+		info.VersionFromEnvelope = true
+	default:
+		return contentInfo{}, &unsupportedMediaTypeError{mediaType: mediaType}
+	}
+
+	return info, nil
+}
+
+// responseMediaType chooses the media type of the response. It echoes the
+// request media type when it is valid for the SOAP version and acceptable
+// to the client, and falls back to the canonical type of the version.
+func responseMediaType(v Version, requestType, accept string) string {
+	candidates := []string{v.MediaType(), mediaTypeXML}
+	if requestType == mediaTypeXML {
+		candidates = []string{mediaTypeXML, v.MediaType()}
+	}
+
+	if strings.TrimSpace(accept) == "" {
+		return candidates[0]
+	}
+	for _, candidate := range candidates {
+		if accepts(accept, candidate) {
+			return candidate
+		}
+	}
+	return candidates[0]
+}
+
+// accepts reports whether an Accept header allows the media type
+func accepts(accept, mediaType string) bool {
+	for _, entry := range strings.Split(accept, ",") {
+		accepted, params, err := mime.ParseMediaType(strings.TrimSpace(entry))
+		if err != nil || params["q"] == "0" {
+			continue
+		}
+		if accepted == mediaType || accepted == "*/*" ||
+			(strings.HasSuffix(accepted, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(accepted, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+type mediaTypeKey struct{}
+
+// withResponseMediaType returns a copy of ctx carrying the response media type
+func withResponseMediaType(ctx context.Context, mediaType string) context.Context {
+	return context.WithValue(ctx, mediaTypeKey{}, mediaType)
+}
+
+// ResponseContentType returns the Content-Type to use for a response to the
+// request carried by ctx
+func ResponseContentType(ctx context.Context) string {
+	if mediaType, ok := ctx.Value(mediaTypeKey{}).(string); ok {
+		return mediaType + "; charset=utf-8"
+	}
+	return VersionFromContext(ctx).ContentType()
+}
+
+// xmlDeclEncoding matches the encoding pseudo-attribute of an XML declaration
+var xmlDeclEncoding = regexp.MustCompile(`encoding\s*=\s*["']([^"']*)["']`)
+
+// transcodeBody converts a request body in a non UTF-8 charset to UTF-8 so
+// that every decoder downstream can read it. The charset comes from the
+// Content-Type or, if absent, from the XML declaration. The declaration of
+// the converted body is rewritten to declare UTF-8.
+func transcodeBody(r *http.Request, charset string) error {
+	raw := bufio.NewReader(r.Body)
+
+	if charset == "" {
+		if decl := xmlDeclaration(raw); decl != "" {
+			if m := xmlDeclEncoding.FindStringSubmatch(decl); m != nil {
+				charset = m[1]
+			}
+		}
+	}
+	if isUTF8(charset) {
+		r.Body = &replayBody{Reader: raw, Closer: r.Body}
+		return nil
+	}
+
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return fmt.Errorf("unsupported charset %q", charset)
+	}
+
+	converted := bufio.NewReader(transform.NewReader(raw, enc.NewDecoder()))
+	var src io.Reader = converted
+	if decl := xmlDeclaration(converted); decl != "" {
+		converted.Discard(len(decl))
+		fixed := xmlDeclEncoding.ReplaceAllString(decl, `encoding="UTF-8"`)
+		src = io.MultiReader(strings.NewReader(fixed), converted)
+	}
+
+	r.Body = &replayBody{Reader: src, Closer: r.Body}
+	return nil
+}
+
+// xmlDeclaration returns the XML declaration at the start of the reader
+// without consuming it, or an empty string if there is none
+func xmlDeclaration(br *bufio.Reader) string {
+	head, _ := br.Peek(256)
+	s := string(head)
+	s = strings.TrimPrefix(s, "\ufeff")
+	if !strings.HasPrefix(s, "<?xml") {
+		return ""
+	}
+	end := strings.Index(s, "?>")
+	if end < 0 {
+		return ""
+	}
+	// Include a stripped byte order mark in the length to discard
+	return string(head[:len(head)-len(s)+end+2])
+}
+
+// isUTF8 reports whether a charset label denotes UTF-8 or a subset of it
+func isUTF8(charset string) bool {
+	switch strings.ToLower(strings.TrimSpace(charset)) {
+	case "", "utf-8", "utf8", "us-ascii", "ascii":
+		return true
+	}
+	return false
+}
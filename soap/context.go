@@ -0,0 +1,93 @@
+package soap
+
+import (
+	"context"
+
+	"golang.org/x/text/language"
+)
+
+// Principal identifies the authenticated caller of a request
+type Principal struct {
+	Name   string // Caller identity (user name, client ID, subject)
+	Method string // Authentication method that established the identity
+
+	// Operations limits the caller to the named operations; nil allows all
+	Operations []string
+
+	// Roles are granted by the authentication method itself, e.g. by the
+	// claims of a token, in addition to the roles of the stored user
+	Roles []string
+
+	// Claims are the verified claims of a bearer token or the attributes of
+	// a SAML assertion, if the caller authenticated with one
+	Claims map[string]interface{}
+}
+
+// Allows reports whether the caller may invoke the named operation
+func (p Principal) Allows(operation string) bool {
+	if p.Operations == nil {
+		return true
+	}
+	for _, allowed := range p.Operations {
+		if allowed == operation || allowed == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+type requestIDKey struct{}
+type principalKey struct{}
+type tenantKey struct{}
+type languagesKey struct{}
+
+// WithRequestID returns a copy of ctx carrying the request ID
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request ID stored in ctx
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// WithPrincipal returns a copy of ctx carrying the authenticated caller
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext returns the authenticated caller stored in ctx
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// WithTenant returns a copy of ctx carrying the tenant of the request
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFromContext returns the tenant stored in ctx
+func TenantFromContext(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
+
+// WithAcceptLanguage returns a copy of ctx carrying the languages of an
+// Accept-Language header, in order of preference. Malformed headers are
+// ignored.
+func WithAcceptLanguage(ctx context.Context, header string) context.Context {
+	tags, _, err := language.ParseAcceptLanguage(header)
+	if err != nil || len(tags) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, languagesKey{}, tags)
+}
+
+// LanguagesFromContext returns the languages requested by the client, most
+// preferred first
+func LanguagesFromContext(ctx context.Context) []language.Tag {
+	tags, _ := ctx.Value(languagesKey{}).([]language.Tag)
+	return tags
+}
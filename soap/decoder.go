@@ -0,0 +1,201 @@
+package soap
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Defaults of the XML limits
+const (
+	DefaultMaxXMLDepth      = 100
+	DefaultMaxXMLAttributes = 100
+)
+
+// XMLLimits bound the XML documents read from requests, so a crafted
+// document cannot exhaust the server while it is parsed. Document type
+// declarations are always rejected: without them no entities other than the
+// predefined ones can be referenced, which encoding/xml expands to shorter
+// text, and no external entities are ever resolved.
+type XMLLimits struct {
+	MaxDepth      int   // Nesting depth of elements, DefaultMaxXMLDepth if zero
+	MaxAttributes int   // Attributes per element including namespace declarations, DefaultMaxXMLAttributes if zero
+	MaxSize       int64 // Bytes read per document; zero means no limit
+}
+
+// XMLLimitError reports a document rejected by the XML limits
+type XMLLimitError struct {
+	Reason string
+}
+
+func (e *XMLLimitError) Error() string {
+	return "xml: " + e.Reason
+}
+
+type xmlLimitsKey struct{}
+
+// WithXMLLimits returns a copy of ctx carrying the XML limits of the request
+func WithXMLLimits(ctx context.Context, limits XMLLimits) context.Context {
+	return context.WithValue(ctx, xmlLimitsKey{}, limits)
+}
+
+// XMLLimitsFromContext returns the XML limits stored in ctx, with the
+// defaults filled in
+func XMLLimitsFromContext(ctx context.Context) XMLLimits {
+	limits, _ := ctx.Value(xmlLimitsKey{}).(XMLLimits)
+	if limits.MaxDepth <= 0 {
+		limits.MaxDepth = DefaultMaxXMLDepth
+	}
+	if limits.MaxAttributes <= 0 {
+		limits.MaxAttributes = DefaultMaxXMLAttributes
+	}
+	return limits
+}
+
+// NewDecoder returns a strict XML decoder reading src within the XML limits
+// of the request. Every document taken from a request must be decoded with
+// it rather than xml.NewDecoder or xml.Unmarshal. Violations are reported by
+// the decoder as *XMLLimitError as soon as they are read, before the
+// offending token is parsed.
+func NewDecoder(ctx context.Context, src io.Reader) *xml.Decoder {
+	decoder := xml.NewDecoder(&guardReader{r: src, limits: XMLLimitsFromContext(ctx)})
+	decoder.Strict = true
+	return decoder
+}
+
+// Lexical states of the guardReader
+const (
+	guardText        = iota
+	guardMarkup      // After "<"
+	guardStartTag    // In a start tag, outside attribute values
+	guardValue       // In an attribute value
+	guardSlash       // After "/" in a start tag
+	guardEndTag      // In an end tag
+	guardDeclaration // After "<!"
+	guardComment     // In a comment
+	guardCDATA       // In a CDATA section
+	guardInstruction // In a processing instruction
+)
+
+// guardReader passes a document through while lexing just enough of it to
+// enforce the XML limits. Malformed markup is left for the decoder to
+// report.
+type guardReader struct {
+	r      io.Reader
+	limits XMLLimits
+	err    error
+
+	size       int64
+	state      int
+	quote      byte   // Delimiter of the attribute value
+	depth      int    // Open elements
+	attributes int    // Attributes of the start tag
+	match      string // Terminator of the comment, CDATA section or instruction
+	matched    int    // Bytes of match seen
+	keyword    []byte // Start of the declaration after "<!"
+}
+
+func (g *guardReader) Read(p []byte) (int, error) {
+	if g.err != nil {
+		return 0, g.err
+	}
+	n, err := g.r.Read(p)
+	for i := 0; i < n; i++ {
+		if g.err = g.scan(p[i]); g.err != nil {
+			// The bytes before the violation are still decoded, which
+			// reports syntax errors ahead of it
+			return i, g.err
+		}
+	}
+	g.size += int64(n)
+	if g.limits.MaxSize > 0 && g.size > g.limits.MaxSize {
+		g.err = &XMLLimitError{Reason: fmt.Sprintf("document exceeds %d bytes", g.limits.MaxSize)}
+		return 0, g.err
+	}
+	return n, err
+}
+
+// scan advances the lexer over the next byte of the document
+func (g *guardReader) scan(c byte) error {
+	switch g.state {
+	case guardText:
+		if c == '<' {
+			g.state = guardMarkup
+		}
+	case guardMarkup:
+		switch c {
+		case '/':
+			g.state = guardEndTag
+		case '?':
+			g.state, g.match, g.matched = guardInstruction, "?>", 0
+		case '!':
+			g.state, g.keyword = guardDeclaration, g.keyword[:0]
+		default:
+			g.depth++
+			if g.depth > g.limits.MaxDepth {
+				return &XMLLimitError{Reason: fmt.Sprintf("elements nested deeper than %d levels", g.limits.MaxDepth)}
+			}
+			g.state, g.attributes = guardStartTag, 0
+		}
+	case guardStartTag, guardSlash:
+		switch c {
+		case '"', '\'':
+			g.state, g.quote = guardValue, c
+		case '=':
+			// Names cannot contain "=", so every one outside values
+			// belongs to an attribute
+			g.attributes++
+			if g.attributes > g.limits.MaxAttributes {
+				return &XMLLimitError{Reason: fmt.Sprintf("element with more than %d attributes", g.limits.MaxAttributes)}
+			}
+			g.state = guardStartTag
+		case '>':
+			if g.state == guardSlash {
+				g.depth--
+			}
+			g.state = guardText
+		case '/':
+			g.state = guardSlash
+		default:
+			g.state = guardStartTag
+		}
+	case guardValue:
+		if c == g.quote {
+			g.state = guardStartTag
+		}
+	case guardEndTag:
+		if c == '>' {
+			g.depth--
+			g.state = guardText
+		}
+	case guardDeclaration:
+		// Only comments and CDATA sections may follow "<!"
+		g.keyword = append(g.keyword, c)
+		switch keyword := string(g.keyword); {
+		case keyword == "--":
+			g.state, g.match, g.matched = guardComment, "-->", 0
+		case keyword == "[CDATA[":
+			g.state, g.match, g.matched = guardCDATA, "]]>", 0
+		case !strings.HasPrefix("--", keyword) && !strings.HasPrefix("[CDATA[", keyword):
+			return &XMLLimitError{Reason: "document type and entity declarations are not allowed"}
+		}
+	case guardComment, guardCDATA, guardInstruction:
+		switch {
+		case c == g.match[g.matched]:
+			g.matched++
+		case c == g.match[0]:
+			// A repeated first byte keeps "]]" and "--" matched
+			if g.match[0] != g.match[1] {
+				g.matched = 1
+			}
+		default:
+			g.matched = 0
+		}
+		if g.matched == len(g.match) {
+			g.state = guardText
+		}
+	}
+	return nil
+}
@@ -0,0 +1,322 @@
+package soap
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"soap-server/soapfault"
+	"strings"
+)
+
+// ServeHTTP routes a SOAP request to the registered operation based on the
+// SOAP action or, as a fallback, the first element inside the Body. SOAP 1.1
+// requests carry the action in the SOAPAction header, SOAP 1.2 requests in
+// the action parameter of the application/soap+xml Content-Type. GET
+// requests with a query are answered by the Contract handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet && s.Contract != nil && r.URL.RawQuery != "" {
+		s.Contract.ServeHTTP(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed. Use POST.", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Determine the SOAP version, action and charset from the Content-Type
+	contentType := r.Header.Get("Content-Type")
+	content, err := parseContentType(contentType)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+	version, soapAction := content.Version, content.Action
+
+	// Build the request context. It is cancelled when the client disconnects
+	// or the server timeout expires.
+	ctx := WithVersion(r.Context(), version)
+	ctx = withResponseMediaType(ctx, responseMediaType(version, content.RootType, r.Header.Get("Accept")))
+	ctx = assignRequestID(ctx, w, r)
+	if tenant := r.Header.Get("X-Tenant-ID"); tenant != "" {
+		ctx = WithTenant(ctx, tenant)
+	}
+	ctx = WithAcceptLanguage(ctx, r.Header.Get("Accept-Language"))
+	ctx = WithXMLLimits(ctx, s.XMLLimits)
+	ctx = WithRequestLimits(ctx, s.Limits)
+	if s.MTOMThreshold > 0 {
+		ctx = withMTOMThreshold(ctx, s.MTOMThreshold)
+	}
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+	r = r.WithContext(ctx)
+
+	// SOAP 1.1 clients send the action in the SOAPAction header
+	if header := r.Header.Get("SOAPAction"); soapAction == "" && header != "" {
+		soapAction = header
+	}
+
+	Debug(ctx, "SOAP request", "version", version.String(), "method", r.Method, "soap_action", stripQuotes(soapAction),
+		"content_type", contentType)
+
+	if s.Concurrency != nil {
+		if !s.Concurrency.acquire(ctx) {
+			s.Concurrency.reject(w, r)
+			return
+		}
+		defer s.Concurrency.release()
+	}
+
+	// Requests declaring a larger body are rejected before anything is
+	// read, others once they exceed the limit
+	if max := s.Limits.MaxBodySize; max > 0 {
+		if r.ContentLength > max {
+			WriteFault(w, r, requestTooLarge(fmt.Sprintf("The request body exceeds %d bytes", max)))
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, max)
+	}
+
+	// Convert envelopes in other charsets to UTF-8. MTOM root parts declare
+	// their charset per part and are left as is.
+	if content.MediaType != mediaTypeMTOM {
+		if err := transcodeBody(r, content.Charset); err != nil {
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+			return
+		}
+	}
+
+	// Interceptors need the complete raw request and response
+	requestHooks, responseHooks := s.hooks()
+	if s.ResponseSchema != nil {
+		responseHooks = append(responseHooks[:len(responseHooks):len(responseHooks)],
+			responseValidator(s.ResponseSchema, s.FailInvalidResponses))
+	}
+	if len(responseHooks) > 0 {
+		buffered := &bufferedResponse{w: w}
+		defer buffered.flush(r, responseHooks)
+		w = buffered
+	}
+	if len(requestHooks) > 0 {
+		ctx, err = runRequestHooks(r, requestHooks)
+		r = r.WithContext(ctx)
+		if err != nil {
+			WriteError(w, r, err)
+			return
+		}
+	}
+
+	// Parse the envelope up to the first Body child element. The bytes
+	// consumed while peeking are replayed so the handler sees the full body.
+	info := peekEnvelope(r, version)
+	if info.Err != nil {
+		WriteError(w, r, info.Err)
+		return
+	}
+	if detected, ok := VersionFromNamespace(info.Envelope.Space); ok && content.VersionFromEnvelope && detected != version {
+		version = detected
+		ctx = WithVersion(ctx, version)
+		ctx = withResponseMediaType(ctx, responseMediaType(version, content.RootType, r.Header.Get("Accept")))
+		r = r.WithContext(ctx)
+	}
+	if info.Envelope.Local != "" && info.Envelope.Space != version.EnvelopeNamespace() {
+		WriteFault(w, r, soapfault.New(soapfault.CodeVersionMismatch, "Version mismatch",
+			fmt.Sprintf("Envelope namespace %s is not valid for SOAP %s", info.Envelope.Space, version)))
+		return
+	}
+
+	// WS-Addressing: wsa:Action takes precedence over the SOAPAction
+	if addressing := parseAddressing(info.Header); addressing != nil {
+		ctx = WithAddressing(ctx, addressing)
+		ctx = adoptMessageID(ctx, w, r, addressing.MessageID)
+		r = r.WithContext(ctx)
+
+		if !addressing.anonymousReply() {
+			WriteFault(w, r, soapfault.Client("Only anonymous address supported",
+				"Replies can only be sent to the anonymous address"))
+			return
+		}
+		if addressing.Action != "" {
+			soapAction = addressing.Action
+		}
+	}
+
+	// Route based on SOAP action, falling back to the Body element
+	soapAction = stripQuotes(soapAction)
+	exchangeFromContext(ctx).setAction(soapAction)
+	var op *Operation
+	if soapAction != "" {
+		op, _ = s.registry.LookupAction(soapAction)
+	}
+	var bodyOp *Operation
+	if info.BodyElement.Local != "" {
+		bodyOp, _ = s.registry.LookupElement(info.BodyElement.Space, info.BodyElement.Local)
+		if bodyOp == nil && s.RPCEncoded {
+			bodyOp, _ = s.rpcOperation(info.BodyElement)
+		}
+	}
+
+	// In strict mode a declared action must name the operation whose
+	// element is in the Body
+	if s.StrictSOAPAction && soapAction != "" && info.BodyElement.Local != "" && op != bodyOp {
+		WriteFault(w, r, soapfault.Client("SOAPAction mismatch",
+			fmt.Sprintf("SOAPAction %s does not match body element {%s}%s",
+				soapAction, info.BodyElement.Space, info.BodyElement.Local)))
+		return
+	}
+
+	if op == nil {
+		op = bodyOp
+	}
+	if op == nil {
+		WriteFault(w, r, soapfault.Client("Unknown operation", "Could not determine SOAP operation from request"))
+		return
+	}
+
+	// rpc/encoded requests wrap the parameters in an element named after
+	// the operation
+	if s.RPCEncoded && info.BodyElement.Local == op.Name && info.BodyElement.Local != op.RequestElement {
+		ctx = withRPC(ctx)
+		r = r.WithContext(ctx)
+	}
+
+	if s.Schema != nil && content.MediaType != mediaTypeMTOM {
+		if err := validateBody(r, s.Schema); err != nil {
+			WriteError(w, r, err)
+			return
+		}
+	}
+
+	if s.Strict && op.RequestType != nil && content.MediaType != mediaTypeMTOM && !IsRPC(ctx) {
+		if err := checkStrictBody(r, op.RequestType); err != nil {
+			WriteError(w, r, err)
+			return
+		}
+	}
+
+	// Let the registered processors consume the header blocks
+	ctx = WithHeader(ctx, info.Header)
+	ctx, err = s.processHeaders(ctx, info.Header)
+	if err != nil {
+		WriteError(w, r.WithContext(ctx), err)
+		return
+	}
+
+	s.invoke(op, w, r.WithContext(ctx))
+}
+
+// envelopeInfo describes the start of a SOAP envelope
+type envelopeInfo struct {
+	Envelope    xml.Name // Name of the root element
+	Header      *Header  // Header blocks found before the Body
+	BodyElement xml.Name // Name of the first child element of the Body
+	Err         error    // Fault for a violation of the XML or request limits
+}
+
+// peekEnvelope parses the envelope up to the first child element of the
+// Body without losing any request data. Everything read from the body is
+// captured and r.Body is replaced with a reader that replays the captured
+// bytes followed by the unread remainder. For multipart/related (MTOM, SwA)
+// requests the root part is inspected: the part named by the start
+// parameter, or else the first one.
+func peekEnvelope(r *http.Request, version Version) envelopeInfo {
+	original := r.Body
+	var consumed bytes.Buffer
+	var src io.Reader = io.TeeReader(original, &consumed)
+
+	defer func() {
+		r.Body = &replayBody{Reader: io.MultiReader(&consumed, original), Closer: original}
+	}()
+
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err == nil && mediaType == "multipart/related" {
+		mr := NewPartReader(r.Context(), src, params["boundary"])
+		start := strings.Trim(params["start"], "<>")
+		for {
+			part, content, err := mr.NextPart()
+			if err != nil {
+				info := envelopeInfo{Header: &Header{}}
+				if fault, ok := LimitFault(err); ok {
+					info.Err = fault
+				}
+				return info
+			}
+			if start == "" || strings.Trim(part.Header.Get("Content-ID"), "<>") == start {
+				src = content
+				break
+			}
+		}
+	}
+
+	return scanEnvelope(NewDecoder(r.Context(), src), version)
+}
+
+// scanEnvelope reads the envelope element, the header blocks and the name of
+// the first child element of the Body. Only the Header and Body children of
+// the envelope in its namespace are read. Decoding stops as soon as the
+// Body element is found. Malformed envelopes are left for the operation
+// handler to report.
+func scanEnvelope(decoder *xml.Decoder, version Version) envelopeInfo {
+	info := envelopeInfo{Header: &Header{}}
+	var parent string
+	depth := 0
+
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			var limitErr *XMLLimitError
+			if errors.As(err, &limitErr) {
+				info.Err = soapfault.Client("Invalid XML format", err.Error())
+			} else if fault, ok := LimitFault(err); ok {
+				info.Err = fault
+			}
+			return info
+		}
+
+		var start xml.StartElement
+		switch t := token.(type) {
+		case xml.StartElement:
+			start = t
+			depth++
+		case xml.EndElement:
+			if depth--; depth == 1 {
+				parent = ""
+			}
+			continue
+		default:
+			continue
+		}
+
+		switch {
+		case depth == 1:
+			info.Envelope = start.Name
+		case parent == "Body":
+			info.BodyElement = start.Name
+			return info
+		case parent == "Header":
+			block, err := parseHeaderBlock(decoder, start, version)
+			if err != nil {
+				return info
+			}
+			// The block was read up to its end element
+			depth--
+			info.Header.Blocks = append(info.Header.Blocks, block)
+		case depth == 2 && start.Name.Space == info.Envelope.Space && (start.Name.Local == "Header" || start.Name.Local == "Body"):
+			parent = start.Name.Local
+		}
+	}
+}
+
+// replayBody is a request body that replays buffered bytes before the
+// remaining stream while closing the original body
+type replayBody struct {
+	io.Reader
+	io.Closer
+}
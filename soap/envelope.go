@@ -0,0 +1,109 @@
+package soap
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// envelope is the XML shape of an outgoing SOAP envelope. Element names carry
+// the soap prefix literally so the output matches conventional SOAP messages.
+type envelope struct {
+	XMLName   xml.Name    `xml:"soap:Envelope"`
+	Namespace string      `xml:"xmlns:soap,attr"`
+	Header    *rawElement `xml:"soap:Header,omitempty"`
+	Body      rawElement  `xml:"soap:Body"`
+}
+
+// rawElement holds already marshaled child elements
+type rawElement struct {
+	Content []byte `xml:",innerxml"`
+}
+
+// WriteResponse marshals v with encoding/xml as the response element of the
+// operation the request was dispatched to and writes it in a SOAP envelope
+// of the request's version
+func WriteResponse(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	op, ok := OperationFromContext(r.Context())
+	if !ok {
+		return fmt.Errorf("request has not been dispatched to an operation")
+	}
+
+	parts := newBinaryParts(r)
+	data, err := marshalEnvelope(r, op, v, parts)
+	if err != nil {
+		return err
+	}
+	if parts != nil && len(parts.attachments) > 0 {
+		return writeMultipart(w, r, data, parts.xop, parts.attachments)
+	}
+
+	w.Header().Set("Content-Type", ResponseContentType(r.Context()))
+	w.Write(data)
+	return nil
+}
+
+// marshalEnvelope renders the complete response envelope for v. BinaryField
+// values large enough are added to parts, if given, as attachments.
+func marshalEnvelope(r *http.Request, op *Operation, v interface{}, parts *binaryParts) ([]byte, error) {
+	var body bytes.Buffer
+	encoder := xml.NewEncoder(&body)
+	encoder.Indent("        ", "    ")
+	if parts != nil {
+		binaryEncoders.Store(encoder, parts)
+		defer binaryEncoders.Delete(encoder)
+	}
+
+	// The start element overrides any XMLName tag of the response type so the
+	// element is always qualified with the operation namespace
+	start := xml.StartElement{Name: xml.Name{Space: op.Namespace, Local: op.ResponseElement}}
+	if IsRPC(r.Context()) {
+		start.Attr = append(start.Attr, xml.Attr{
+			Name:  xml.Name{Local: "soap:encodingStyle"},
+			Value: VersionFromContext(r.Context()).EncodingNamespace(),
+		})
+	}
+	if err := encoder.EncodeElement(v, start); err != nil {
+		return nil, err
+	}
+
+	env := envelope{
+		Namespace: VersionFromContext(r.Context()).EnvelopeNamespace(),
+		Body:      rawElement{Content: []byte("\n" + body.String() + "\n    ")},
+	}
+	if blocks := responseHeaderBlocks(r, false); len(blocks) > 0 {
+		env.Header = &rawElement{Content: []byte("\n        " + strings.Join(blocks, "\n        ") + "\n    ")}
+	}
+
+	data, err := xml.MarshalIndent(env, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), data...), nil
+}
+
+// responseHeaderBlocks returns the marshaled header blocks of the response
+func responseHeaderBlocks(r *http.Request, fault bool) []string {
+	var blocks []string
+	if a, ok := AddressingFromContext(r.Context()); ok {
+		blocks = append(blocks, a.responseHeaders(fault)...)
+	}
+	return blocks
+}
+
+// renderResponseHeader renders the response Header element for fault
+// envelopes, or an empty string if the response carries no header blocks
+func renderResponseHeader(r *http.Request, prefix string, fault bool) string {
+	blocks := responseHeaderBlocks(r, fault)
+	if len(blocks) == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf(`
+    <%s:Header>
+        %s
+    </%s:Header>`, prefix, strings.Join(blocks, "\n        "), prefix)
+}
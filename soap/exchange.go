@@ -0,0 +1,99 @@
+package soap
+
+import (
+	"context"
+	"soap-server/soapfault"
+	"sync"
+)
+
+// Exchange collects what a SOAP server made of a request: its ID, the
+// SOAP action, the operation it was dispatched to and the fault sent, if
+// any. HTTP middleware wrapping the servers, such as the access log,
+// creates it with WithExchange and reads it once the request is served.
+type Exchange struct {
+	mu        sync.Mutex
+	requestID string
+	action    string
+	operation string
+	fault     *soapfault.Fault
+}
+
+type exchangeKey struct{}
+
+// WithExchange returns a copy of ctx recording the exchange
+func WithExchange(ctx context.Context) (context.Context, *Exchange) {
+	e := &Exchange{}
+	return context.WithValue(ctx, exchangeKey{}, e), e
+}
+
+// exchangeFromContext returns the exchange recorded for a request, nil if
+// there is none; the setters ignore nil exchanges
+func exchangeFromContext(ctx context.Context) *Exchange {
+	e, _ := ctx.Value(exchangeKey{}).(*Exchange)
+	return e
+}
+
+func (e *Exchange) setRequestID(id string) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.requestID = id
+}
+
+func (e *Exchange) setAction(action string) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.action = action
+}
+
+func (e *Exchange) setOperation(name string) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.operation = name
+}
+
+func (e *Exchange) setFault(f *soapfault.Fault) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.fault = f
+}
+
+// RequestID returns the ID the server assigned to the request
+func (e *Exchange) RequestID() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.requestID
+}
+
+// Action returns the SOAP action of the request, without quotes
+func (e *Exchange) Action() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.action
+}
+
+// Operation returns the name of the operation the request was dispatched
+// to, empty if it was not
+func (e *Exchange) Operation() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.operation
+}
+
+// Fault returns the fault sent for the request, or nil if none was
+func (e *Exchange) Fault() *soapfault.Fault {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.fault
+}
@@ -0,0 +1,110 @@
+package soap
+
+import (
+	"encoding/xml"
+	"net/http"
+	"reflect"
+	"soap-server/soapfault"
+	"soap-server/tracing"
+	"strings"
+)
+
+// WriteFault writes a SOAP fault in the format of the request's SOAP version.
+// A detail value whose element is declared in the Faults of the dispatched
+// operation is qualified with the operation namespace. The ID of the request
+// is added to the detail.
+func WriteFault(w http.ResponseWriter, r *http.Request, f *soapfault.Fault) {
+	soap12 := VersionFromContext(r.Context()) == SOAP12
+
+	if id := RequestIDFromContext(r.Context()); id != "" && f.RequestID == "" {
+		identified := *f
+		identified.RequestID = id
+		f = &identified
+	}
+
+	if op, ok := OperationFromContext(r.Context()); ok && f.Detail != nil {
+		if name := detailElement(f.Detail); name != "" && declaresFault(op, name) {
+			qualified := *f
+			qualified.Detail = typedDetail{Name: xml.Name{Space: op.Namespace, Local: name}, Value: f.Detail}
+			f = &qualified
+		}
+	}
+
+	data, err := f.Render(soap12, renderResponseHeader(r, faultPrefix(soap12), true))
+	if err != nil {
+		f = soapfault.Server("Internal error", err.Error())
+		f.RequestID = RequestIDFromContext(r.Context())
+		data, _ = f.Render(soap12, renderResponseHeader(r, faultPrefix(soap12), true))
+	}
+
+	if inv, ok := InvocationFromContext(r.Context()); ok {
+		inv.recordFault(r.Context(), f)
+	}
+	exchangeFromContext(r.Context()).setFault(f)
+	span := tracing.SpanFromContext(r.Context())
+	span.SetAttributes(tracing.String("soap.fault_code", f.QualifiedCode()))
+	if f.Code == soapfault.CodeServer {
+		span.SetError(f)
+	}
+
+	w.Header().Set("Content-Type", ResponseContentType(r.Context()))
+	w.WriteHeader(f.HTTPStatus(soap12))
+	w.Write(data)
+}
+
+// WriteError reports an error as a SOAP fault. Errors wrapping a
+// *soapfault.Fault are written as is, any other error becomes a Server fault.
+func WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	WriteFault(w, r, soapfault.FromError(err))
+}
+
+// faultPrefix returns the envelope prefix used by fault envelopes
+func faultPrefix(soap12 bool) string {
+	if soap12 {
+		return "env"
+	}
+	return "soap"
+}
+
+// typedDetail marshals a declared fault detail value as a namespaced element
+type typedDetail struct {
+	Name  xml.Name
+	Value interface{}
+}
+
+// MarshalXML implements xml.Marshaler
+func (d typedDetail) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	return e.EncodeElement(d.Value, xml.StartElement{Name: d.Name})
+}
+
+// detailElement returns the element name a struct detail value marshals to:
+// the name in its XMLName tag or else its type name
+func detailElement(v interface{}) string {
+	t := reflect.TypeOf(v)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return ""
+	}
+	if field, ok := t.FieldByName("XMLName"); ok {
+		tag := strings.Split(field.Tag.Get("xml"), ",")[0]
+		if i := strings.LastIndexByte(tag, ' '); i >= 0 {
+			tag = tag[i+1:]
+		}
+		if tag != "" {
+			return tag
+		}
+	}
+	return t.Name()
+}
+
+// declaresFault reports whether op advertises the named fault element
+func declaresFault(op *Operation, name string) bool {
+	for _, fault := range op.Faults {
+		if fault == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,298 @@
+package soap
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"soap-server/soapfault"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Validator is implemented by request types with checks that cannot be
+// expressed as validate tags. Validate is called after the tag rules;
+// returning *FieldErrors adds to the reported field errors.
+type Validator interface {
+	Validate() error
+}
+
+// FieldError is a validation failure of a request field
+type FieldError struct {
+	Field   string `xml:"field,attr" json:"field"`
+	Message string `xml:",chardata" json:"message"`
+}
+
+// FieldErrors collects the validation failures of a request. It is the
+// fault detail of requests rejected by the validation layer.
+type FieldErrors struct {
+	XMLName xml.Name     `xml:"ValidationErrors"`
+	Errors  []FieldError `xml:"error"`
+}
+
+// Add records a failure of the named field
+func (e *FieldErrors) Add(field, format string, args ...interface{}) {
+	e.Errors = append(e.Errors, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// Err returns e if failures were recorded and nil otherwise
+func (e *FieldErrors) Err() error {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e *FieldErrors) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, fe := range e.Errors {
+		msgs[i] = fe.Field + ": " + fe.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+var emailPattern = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+
+// validateRequest applies the validate tag rules of the request fields and
+// the Validate method of the request. All failures are reported together in
+// a single Client fault.
+//
+// Rules are given in a validate struct tag, e.g. `validate:"required,max=100"`:
+//
+//   - required: strings and slices must not be empty, pointers not nil
+//   - min=N, max=N: length of strings (in characters) and slices, value of
+//     numbers
+//   - email: the string must be an email address
+//   - oneof=a|b: the string must be one of the values
+//
+// Empty strings are only checked by required, unless the field is a
+// pointer and present in the request. Rules of struct fields with a
+// chardata field, such as text with an xml:lang attribute, apply to the text. Nested structs are validated with
+// field names joined by dots.
+func validateRequest(v interface{}) error {
+	var errs FieldErrors
+	if err := validateFields(reflect.ValueOf(v), "", &errs); err != nil {
+		return soapfault.Server("Internal error", err.Error())
+	}
+
+	if err := callValidator(v, &errs); err != nil {
+		if _, ok := soapfault.As(err); ok {
+			return err
+		}
+		return soapfault.Client("Invalid input", err.Error())
+	}
+
+	if len(errs.Errors) == 0 {
+		return nil
+	}
+	return soapfault.Client(fmt.Sprintf("Invalid input: %s", errs.Error()), errs)
+}
+
+// Validate applies the validate tag rules and the Validate method of v, for
+// values that are not decoded by a typed handler, such as the rows of a
+// bulk payload. Rule violations are returned together as *FieldErrors.
+func Validate(v interface{}) error {
+	var errs FieldErrors
+	if err := validateFields(reflect.ValueOf(v), "", &errs); err != nil {
+		return err
+	}
+	if err := callValidator(v, &errs); err != nil {
+		return err
+	}
+	return errs.Err()
+}
+
+// callValidator calls the Validate method of v, if any. Field errors are
+// added to errs; other errors are returned.
+func callValidator(v interface{}, errs *FieldErrors) error {
+	validator, ok := v.(Validator)
+	if !ok {
+		return nil
+	}
+	err := validator.Validate()
+	var fieldErrs *FieldErrors
+	if errors.As(err, &fieldErrs) {
+		errs.Errors = append(errs.Errors, fieldErrs.Errors...)
+		return nil
+	}
+	return err
+}
+
+// validateFields checks the fields of the struct v. Errors are returned for
+// malformed rules only; rule violations are added to errs.
+func validateFields(v reflect.Value, prefix string, errs *FieldErrors) error {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" || field.Name == "XMLName" {
+			continue
+		}
+
+		name := fieldName(field)
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+
+		if rules := field.Tag.Get("validate"); rules != "" {
+			if err := checkRules(v.Field(i), name, rules, errs); err != nil {
+				return fmt.Errorf("field %s: %w", field.Name, err)
+			}
+		}
+
+		// Validate nested structs, also in pointers and slices
+		fv := v.Field(i)
+		switch {
+		case fv.Kind() == reflect.Slice:
+			for j := 0; j < fv.Len(); j++ {
+				if err := validateFields(fv.Index(j), fmt.Sprintf("%s[%d]", name, j), errs); err != nil {
+					return err
+				}
+			}
+		case reflect.Indirect(fv).Kind() == reflect.Struct:
+			if err := validateFields(fv, name, errs); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// fieldName returns the element or attribute name of a field
+func fieldName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("xml"), ",")
+	if i := strings.LastIndexAny(name, " >"); i >= 0 {
+		name = name[i+1:]
+	}
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}
+
+// checkRules applies the comma-separated rules to a field value
+func checkRules(v reflect.Value, name, rules string, errs *FieldErrors) error {
+	// A non-nil pointer marks a present field, which is checked even when
+	// its value is empty
+	present := false
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			if strings.Contains(","+rules+",", ",required,") {
+				errs.Add(name, "is required")
+			}
+			return nil
+		}
+		v = v.Elem()
+		present = true
+	}
+	// Rules of elements with attributes apply to their text
+	if text, ok := chardata(v); ok {
+		v = text
+	}
+	skip := v.Kind() == reflect.String && v.Len() == 0 && !present
+
+	for _, rule := range strings.Split(rules, ",") {
+		key, arg, _ := strings.Cut(rule, "=")
+		switch key {
+		case "required":
+			if isEmpty(v) {
+				errs.Add(name, "is required")
+				return nil
+			}
+		case "min", "max":
+			limit, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				return fmt.Errorf("invalid %s rule %q", key, arg)
+			}
+			if !skip {
+				checkLimit(v, name, key, limit, errs)
+			}
+		case "email":
+			if v.Kind() == reflect.String && !skip && !emailPattern.MatchString(v.String()) {
+				errs.Add(name, "must be a valid email address")
+			}
+		case "oneof":
+			if v.Kind() == reflect.String && !skip && !containsValue(strings.Split(arg, "|"), v.String()) {
+				errs.Add(name, "must be one of %s", strings.ReplaceAll(arg, "|", ", "))
+			}
+		default:
+			return fmt.Errorf("unknown validate rule %q", key)
+		}
+	}
+	return nil
+}
+
+// chardata returns the ",chardata" field of a struct value, if any
+func chardata(v reflect.Value) (reflect.Value, bool) {
+	if v.Kind() != reflect.Struct {
+		return reflect.Value{}, false
+	}
+	for i := 0; i < v.NumField(); i++ {
+		if _, options, _ := strings.Cut(v.Type().Field(i).Tag.Get("xml"), ","); options == "chardata" {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// checkLimit checks a min or max rule against the length of strings and
+// slices or the value of numbers
+func checkLimit(v reflect.Value, name, key string, limit float64, errs *FieldErrors) {
+	var n float64
+	what := "length"
+	switch v.Kind() {
+	case reflect.String:
+		n = float64(utf8.RuneCountInString(v.String()))
+	case reflect.Slice:
+		n = float64(v.Len())
+		what = "count"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n = float64(v.Int())
+		what = "value"
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n = float64(v.Uint())
+		what = "value"
+	case reflect.Float32, reflect.Float64:
+		n = v.Float()
+		what = "value"
+	default:
+		return
+	}
+
+	if key == "min" && n < limit {
+		errs.Add(name, "%s must be at least %g", what, limit)
+	}
+	if key == "max" && n > limit {
+		errs.Add(name, "%s must be at most %g", what, limit)
+	}
+}
+
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String:
+		return strings.TrimSpace(v.String()) == ""
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
+
+func containsValue(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
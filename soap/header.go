@@ -0,0 +1,211 @@
+package soap
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"soap-server/soapfault"
+	"strings"
+)
+
+// Actor and role URIs addressing the node that receives the message
+const (
+	actorNext11        = "http://schemas.xmlsoap.org/soap/actor/next"
+	roleNext12         = "http://www.w3.org/2003/05/soap-envelope/role/next"
+	roleUltimateRcvr12 = "http://www.w3.org/2003/05/soap-envelope/role/ultimateReceiver"
+)
+
+// HeaderBlock is a single child element of the SOAP Header
+type HeaderBlock struct {
+	Name           xml.Name   // Qualified name of the header element
+	Attrs          []xml.Attr // Attributes of the header element
+	MustUnderstand bool       // Whether the block carries mustUnderstand="1"
+	Role           string     // SOAP 1.1 actor or SOAP 1.2 role, empty for the default
+
+	tokens     []xml.Token
+	understood bool
+}
+
+// Decode unmarshals the header block into v
+func (b *HeaderBlock) Decode(v interface{}) error {
+	return xml.NewTokenDecoder(&tokenReader{tokens: b.tokens}).Decode(v)
+}
+
+// MarkUnderstood records that the block has been processed. Blocks marked
+// mustUnderstand that are never marked understood cause a MustUnderstand fault.
+func (b *HeaderBlock) MarkUnderstood() {
+	b.understood = true
+}
+
+// Understood reports whether the block has been processed
+func (b *HeaderBlock) Understood() bool {
+	return b.understood
+}
+
+// TargetsUs reports whether the block is addressed to this node: it has no
+// role, or the next or ultimate receiver role
+func (b *HeaderBlock) TargetsUs() bool {
+	switch b.Role {
+	case "", actorNext11, roleNext12, roleUltimateRcvr12:
+		return true
+	}
+	return false
+}
+
+// Header holds the header blocks of a request
+type Header struct {
+	Blocks []*HeaderBlock
+}
+
+// Lookup returns the first header block with the given name
+func (h *Header) Lookup(namespace, local string) *HeaderBlock {
+	if h == nil {
+		return nil
+	}
+	for _, block := range h.Blocks {
+		if block.Name.Space == namespace && block.Name.Local == local {
+			return block
+		}
+	}
+	return nil
+}
+
+// notUnderstood returns the mustUnderstand blocks addressed to this node
+// that no processor has consumed
+func (h *Header) notUnderstood() []*HeaderBlock {
+	if h == nil {
+		return nil
+	}
+	var blocks []*HeaderBlock
+	for _, block := range h.Blocks {
+		if block.MustUnderstand && block.TargetsUs() && !block.understood {
+			blocks = append(blocks, block)
+		}
+	}
+	return blocks
+}
+
+// HeaderProcessor consumes a header block before the operation runs. It may
+// return a derived context (e.g. carrying a correlation ID or a principal).
+// Returning an error aborts the request with a fault.
+type HeaderProcessor func(ctx context.Context, block *HeaderBlock) (context.Context, error)
+
+// HandleHeader registers a processor for header blocks with the given name.
+// Blocks handed to a processor are marked understood.
+func (s *Server) HandleHeader(namespace, local string, p HeaderProcessor) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.headerProcessors == nil {
+		s.headerProcessors = make(map[elementKey]HeaderProcessor)
+	}
+	s.headerProcessors[elementKey{Namespace: namespace, Local: local}] = p
+}
+
+// processHeaders runs the registered header processors over the blocks of
+// the request and returns the resulting context
+func (s *Server) processHeaders(ctx context.Context, header *Header) (context.Context, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, block := range header.Blocks {
+		p, ok := s.headerProcessors[elementKey{Namespace: block.Name.Space, Local: block.Name.Local}]
+		if !ok {
+			continue
+		}
+
+		next, err := p(ctx, block)
+		if err != nil {
+			return ctx, err
+		}
+		if next != nil {
+			ctx = next
+		}
+		block.MarkUnderstood()
+	}
+
+	return ctx, nil
+}
+
+// checkMustUnderstand wraps the operation handler so that unprocessed
+// mustUnderstand headers are rejected after all middleware had the chance
+// to consume them
+func checkMustUnderstand(next SOAPHandler) SOAPHandler {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if blocks := HeaderFromContext(r.Context()).notUnderstood(); len(blocks) > 0 {
+			names := make([]string, len(blocks))
+			for i, block := range blocks {
+				names[i] = fmt.Sprintf("{%s}%s", block.Name.Space, block.Name.Local)
+			}
+			WriteFault(w, r, soapfault.New(soapfault.CodeMustUnderstand, "Header not understood",
+				"Mandatory header blocks were not understood: "+strings.Join(names, ", ")))
+			return
+		}
+		next(w, r)
+	}
+}
+
+// parseHeaderBlock reads a header block starting at start from the decoder
+func parseHeaderBlock(decoder *xml.Decoder, start xml.StartElement, version Version) (*HeaderBlock, error) {
+	block := &HeaderBlock{Name: start.Name, Attrs: start.Attr}
+
+	for _, attr := range start.Attr {
+		if attr.Name.Space != version.EnvelopeNamespace() {
+			continue
+		}
+		switch attr.Name.Local {
+		case "mustUnderstand":
+			block.MustUnderstand = attr.Value == "1" || attr.Value == "true"
+		case "actor", "role":
+			block.Role = attr.Value
+		}
+	}
+
+	// Keep the tokens of the block so it can be decoded later with its
+	// namespaces already resolved
+	block.tokens = append(block.tokens, start.Copy())
+	for depth := 1; depth > 0; {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch token.(type) {
+		case xml.StartElement:
+			depth++
+		case xml.EndElement:
+			depth--
+		}
+		block.tokens = append(block.tokens, xml.CopyToken(token))
+	}
+
+	return block, nil
+}
+
+type headerKey struct{}
+
+// WithHeader returns a copy of ctx carrying the request header blocks
+func WithHeader(ctx context.Context, h *Header) context.Context {
+	return context.WithValue(ctx, headerKey{}, h)
+}
+
+// HeaderFromContext returns the header blocks of the request, or nil
+func HeaderFromContext(ctx context.Context) *Header {
+	h, _ := ctx.Value(headerKey{}).(*Header)
+	return h
+}
+
+// tokenReader replays recorded tokens as an xml.TokenReader
+type tokenReader struct {
+	tokens []xml.Token
+}
+
+func (tr *tokenReader) Token() (xml.Token, error) {
+	if len(tr.tokens) == 0 {
+		return nil, io.EOF
+	}
+	token := tr.tokens[0]
+	tr.tokens = tr.tokens[1:]
+	return token, nil
+}
@@ -0,0 +1,192 @@
+package soap
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"soap-server/soapfault"
+	"strconv"
+	"strings"
+)
+
+// RequestHook inspects or rewrites the raw request before it is parsed. It
+// receives the envelope and returns the envelope to continue with. For
+// multipart/related (MTOM, SwA) requests it receives the root part, which
+// cannot be rewritten, while the attachments are left unread.
+type RequestHook func(r *http.Request, body []byte) ([]byte, error)
+
+// ResponseHook inspects or rewrites the raw response after it has been
+// encoded. It receives the response body (envelope or fault) and returns the
+// body to send to the client.
+type ResponseHook func(r *http.Request, body []byte) ([]byte, error)
+
+// OnRequest registers a hook run on every request body before decoding.
+// Hooks run in registration order.
+func (s *Server) OnRequest(h RequestHook) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requestHooks = append(s.requestHooks, h)
+}
+
+// OnResponse registers a hook run on every response body after encoding.
+// Hooks run in registration order.
+func (s *Server) OnResponse(h ResponseHook) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.responseHooks = append(s.responseHooks, h)
+}
+
+// hooks returns a snapshot of the registered hooks
+func (s *Server) hooks() ([]RequestHook, []ResponseHook) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.requestHooks, s.responseHooks
+}
+
+// hookValues collects the context values set by the request hooks of a
+// request
+type hookValues struct {
+	ctx context.Context
+}
+
+type hookValuesKey struct{}
+
+// SetRequestValue makes value available under key in the context of the
+// request once the request hooks ran, for hooks passing what they found on
+// to middleware and handlers. It has no effect outside request hooks.
+func SetRequestValue(r *http.Request, key, value interface{}) {
+	if values, ok := r.Context().Value(hookValuesKey{}).(*hookValues); ok {
+		values.ctx = context.WithValue(values.ctx, key, value)
+	}
+}
+
+// runRequestHooks reads the envelope of the request, passes it through the
+// request hooks and replaces r.Body with the result. It returns the context
+// of the request with the values set by the hooks.
+func runRequestHooks(r *http.Request, hooks []RequestHook) (context.Context, error) {
+	values := &hookValues{ctx: r.Context()}
+	hr := r.WithContext(context.WithValue(r.Context(), hookValuesKey{}, values))
+	if mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil && mediaType == "multipart/related" {
+		return values.ctx, runRootPartHooks(hr, r, params, hooks)
+	}
+
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return values.ctx, readFault(err)
+	}
+	for _, hook := range hooks {
+		if body, err = hook(hr, body); err != nil {
+			return values.ctx, err
+		}
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	return values.ctx, nil
+}
+
+// runRootPartHooks passes the root part of a multipart/related request
+// through the request hooks. Only the request up to the end of the root
+// part is read, and replayed to the handler before the rest of the body,
+// so attachments after it are still streamed.
+func runRootPartHooks(hr, r *http.Request, params map[string]string, hooks []RequestHook) error {
+	original := r.Body
+	var consumed bytes.Buffer
+	defer func() {
+		r.Body = &replayBody{Reader: io.MultiReader(&consumed, original), Closer: original}
+	}()
+
+	mr := NewPartReader(r.Context(), io.TeeReader(original, &consumed), params["boundary"])
+	start := strings.Trim(params["start"], "<>")
+	var root []byte
+	for first := true; ; first = false {
+		part, content, err := mr.NextPart()
+		if err == io.EOF {
+			return soapfault.Client("Invalid request", "The multipart/related request has no root part")
+		}
+		if err != nil {
+			return readFault(err)
+		}
+		if (start == "" && first) || strings.Trim(part.Header.Get("Content-ID"), "<>") == start {
+			if root, err = io.ReadAll(content); err != nil {
+				return readFault(err)
+			}
+			break
+		}
+	}
+
+	for _, hook := range hooks {
+		body, err := hook(hr, root)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(body, root) {
+			return soapfault.Server("Internal error", "A request hook rewrote the root part of a multipart request")
+		}
+	}
+	return nil
+}
+
+// readFault returns the fault for a request body that could not be read
+func readFault(err error) *soapfault.Fault {
+	if fault, ok := LimitFault(err); ok {
+		return fault
+	}
+	return soapfault.Client("Invalid request", "Failed to read request body: "+err.Error())
+}
+
+// bufferedResponse captures a response so response hooks can rewrite the
+// body before anything is sent to the client
+type bufferedResponse struct {
+	w      http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.w.Header()
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+// flush runs the response hooks and sends the final response
+func (b *bufferedResponse) flush(r *http.Request, hooks []ResponseHook) {
+	body := b.body.Bytes()
+	for _, hook := range hooks {
+		var err error
+		if body, err = hook(r, body); err != nil {
+			b.w.Header().Del("Content-Length")
+			if _, ok := soapfault.As(err); ok {
+				WriteError(b.w, r, err)
+			} else {
+				WriteFault(b.w, r, soapfault.Server("Internal error", fmt.Sprintf("Response hook failed: %v", err)))
+			}
+			return
+		}
+	}
+
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	b.w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	b.w.WriteHeader(b.status)
+	b.w.Write(body)
+}
@@ -0,0 +1,90 @@
+package soap
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// countingReader counts the bytes read from it
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+const hookTestRoot = `<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body/></soap:Envelope>`
+
+// multipartRequest returns an MTOM request with a large attachment after
+// the root part, and the reader of the attachment
+func multipartRequest() (*http.Request, *countingReader, string) {
+	head := "--b\r\nContent-Type: application/xop+xml\r\nContent-ID: <root>\r\n\r\n" + hookTestRoot +
+		"\r\n--b\r\nContent-Type: application/octet-stream\r\nContent-ID: <att>\r\n\r\n"
+	content := bytes.Repeat([]byte("a"), 4<<20)
+	tail := "\r\n--b--\r\n"
+	attachment := &countingReader{r: bytes.NewReader(content)}
+	r := httptest.NewRequest(http.MethodPost, "/soap", io.MultiReader(strings.NewReader(head), attachment, strings.NewReader(tail)))
+	r.Header.Set("Content-Type", `multipart/related; boundary=b; type="application/xop+xml"; start="<root>"`)
+	return r, attachment, head + string(content) + tail
+}
+
+func TestRequestHooksReadOnlyRootPart(t *testing.T) {
+	r, attachment, full := multipartRequest()
+	var seen []byte
+	hook := func(r *http.Request, body []byte) ([]byte, error) {
+		seen = body
+		return body, nil
+	}
+	if _, err := runRequestHooks(r, []RequestHook{hook}); err != nil {
+		t.Fatal(err)
+	}
+	if string(seen) != hookTestRoot {
+		t.Errorf("hook received %q, want the root part", seen)
+	}
+	if attachment.n > 64<<10 {
+		t.Errorf("%d bytes of the attachment read before the handler", attachment.n)
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != full {
+		t.Errorf("handler reads %d bytes, want the %d bytes of the request", len(body), len(full))
+	}
+}
+
+func TestRequestHooksCannotRewriteRootPart(t *testing.T) {
+	r, _, _ := multipartRequest()
+	hook := func(r *http.Request, body []byte) ([]byte, error) {
+		return bytes.Replace(body, []byte("Body"), []byte("Bod"), 1), nil
+	}
+	if _, err := runRequestHooks(r, []RequestHook{hook}); err == nil {
+		t.Error("root part of a multipart request rewritten")
+	}
+}
+
+type testValueKey struct{}
+
+func TestRequestHooksSetValues(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/soap", strings.NewReader(hookTestRoot))
+	hook := func(r *http.Request, body []byte) ([]byte, error) {
+		SetRequestValue(r, testValueKey{}, "verified")
+		return body, nil
+	}
+	ctx, err := runRequestHooks(r, []RequestHook{hook})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := ctx.Value(testValueKey{}).(string); v != "verified" {
+		t.Errorf("value set by the hook = %q, want verified", v)
+	}
+}
@@ -0,0 +1,121 @@
+package soap
+
+import (
+	"context"
+	"net/http"
+	"soap-server/soapfault"
+	"sync"
+)
+
+// Invocation collects what happened while an operation was served, for
+// audit logging: the caller, the fault written, if any, and the files the
+// operation touched. Middleware outside the authentication middleware
+// creates it with WithInvocation and reads it once the operation returns.
+type Invocation struct {
+	mu        sync.Mutex
+	principal *Principal
+	fault     *soapfault.Fault
+	fileIDs   []string
+}
+
+type invocationKey struct{}
+
+// WithInvocation returns a copy of ctx recording the invocation
+func WithInvocation(ctx context.Context) (context.Context, *Invocation) {
+	inv := &Invocation{}
+	return context.WithValue(ctx, invocationKey{}, inv), inv
+}
+
+// InvocationFromContext returns the invocation recorded for a request
+func InvocationFromContext(ctx context.Context) (*Invocation, bool) {
+	inv, ok := ctx.Value(invocationKey{}).(*Invocation)
+	return inv, ok
+}
+
+// TouchFile records that the operation of the request read or changed a
+// file. Without an invocation in ctx it does nothing.
+func TouchFile(ctx context.Context, id string) {
+	inv, ok := InvocationFromContext(ctx)
+	if !ok || id == "" {
+		return
+	}
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	for _, touched := range inv.fileIDs {
+		if touched == id {
+			return
+		}
+	}
+	inv.fileIDs = append(inv.fileIDs, id)
+}
+
+// observe records the principal of a request that reached the operation or
+// a fault, as authentication sets it on contexts the creator of the
+// invocation does not see
+func (inv *Invocation) observe(ctx context.Context) {
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return
+	}
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	inv.principal = &principal
+}
+
+// recordFault records the fault sent for the request
+func (inv *Invocation) recordFault(ctx context.Context, f *soapfault.Fault) {
+	inv.observe(ctx)
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	inv.fault = f
+}
+
+// ObserveInvocation records the principal of ctx in the invocation it
+// carries, for handlers outside the SOAP servers once the authentication
+// middleware has run
+func ObserveInvocation(ctx context.Context) {
+	if inv, ok := InvocationFromContext(ctx); ok {
+		inv.observe(ctx)
+	}
+}
+
+// RecordFault records the fault sent for the request of ctx by a handler
+// outside the SOAP servers, which do not answer with WriteFault
+func RecordFault(ctx context.Context, f *soapfault.Fault) {
+	if inv, ok := InvocationFromContext(ctx); ok {
+		inv.recordFault(ctx, f)
+	}
+}
+
+// Principal returns the authenticated caller
+func (inv *Invocation) Principal() (Principal, bool) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	if inv.principal == nil {
+		return Principal{}, false
+	}
+	return *inv.principal, true
+}
+
+// Fault returns the fault sent for the request, or nil if it succeeded
+func (inv *Invocation) Fault() *soapfault.Fault {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	return inv.fault
+}
+
+// FileIDs returns the IDs of the files touched, in order
+func (inv *Invocation) FileIDs() []string {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	return append([]string(nil), inv.fileIDs...)
+}
+
+// observeInvocation wraps the operation handler so that the invocation
+// learns the principal the authentication middleware established
+func observeInvocation(next SOAPHandler) SOAPHandler {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ObserveInvocation(r.Context())
+		next(w, r)
+	}
+}
@@ -0,0 +1,146 @@
+package soap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"soap-server/soapfault"
+)
+
+// RequestLimits bound the data read from requests before it reaches the
+// parsers, independent of the files the operations accept. Zero fields mean
+// no limit.
+type RequestLimits struct {
+	MaxBodySize       int64 // Bytes of the request body
+	MaxParts          int   // MIME parts of multipart/related requests
+	MaxPartSize       int64 // Bytes of the content of one MIME part
+	MaxPartHeaderSize int   // Bytes of the header fields of one MIME part
+}
+
+// LimitError reports a request exceeding one of the request limits
+type LimitError struct {
+	Reason string
+}
+
+func (e *LimitError) Error() string {
+	return e.Reason
+}
+
+type requestLimitsKey struct{}
+
+// WithRequestLimits returns a copy of ctx carrying the request limits
+func WithRequestLimits(ctx context.Context, limits RequestLimits) context.Context {
+	return context.WithValue(ctx, requestLimitsKey{}, limits)
+}
+
+// RequestLimitsFromContext returns the request limits stored in ctx
+func RequestLimitsFromContext(ctx context.Context) RequestLimits {
+	limits, _ := ctx.Value(requestLimitsKey{}).(RequestLimits)
+	return limits
+}
+
+// LimitFault returns the fault for err if it reports an exceeded request
+// limit: a Client fault sent with 413 Request Entity Too Large
+func LimitFault(err error) (*soapfault.Fault, bool) {
+	var limitErr *LimitError
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &limitErr):
+		return requestTooLarge(limitErr.Reason), true
+	case errors.As(err, &maxBytesErr):
+		return requestTooLarge(fmt.Sprintf("The request body exceeds %d bytes", maxBytesErr.Limit)), true
+	}
+	return nil, false
+}
+
+// decodeFault returns the Client fault for a request that could not be read
+// or decoded, unless a request limit was exceeded
+func decodeFault(reason string, err error) *soapfault.Fault {
+	if fault, ok := LimitFault(err); ok {
+		return fault
+	}
+	return soapfault.Client(reason, err.Error())
+}
+
+func requestTooLarge(detail string) *soapfault.Fault {
+	return soapfault.Client("Request too large", detail).WithHTTPStatus(http.StatusRequestEntityTooLarge)
+}
+
+// PartReader reads the parts of a multipart/related request within the
+// request limits
+type PartReader struct {
+	mr     *multipart.Reader
+	limits RequestLimits
+	parts  int
+}
+
+// NewPartReader returns a reader of the parts of a multipart/related body
+// with the boundary, enforcing the request limits stored in ctx
+func NewPartReader(ctx context.Context, body io.Reader, boundary string) *PartReader {
+	return &PartReader{mr: multipart.NewReader(body, boundary), limits: RequestLimitsFromContext(ctx)}
+}
+
+// NextPart returns the next part, or io.EOF after the last one. Reading the
+// content of the part fails with a *LimitError once it exceeds the part size
+// limit; the returned part must not be read directly.
+func (p *PartReader) NextPart() (*multipart.Part, io.Reader, error) {
+	part, err := p.mr.NextPart()
+	if err != nil {
+		return nil, nil, err
+	}
+	p.parts++
+	if p.limits.MaxParts > 0 && p.parts > p.limits.MaxParts {
+		part.Close()
+		return nil, nil, &LimitError{Reason: fmt.Sprintf("The request has more than %d MIME parts", p.limits.MaxParts)}
+	}
+	if max := p.limits.MaxPartHeaderSize; max > 0 {
+		size := 0
+		for name, values := range part.Header {
+			for _, value := range values {
+				size += len(name) + len(": \r\n") + len(value)
+			}
+		}
+		if size > max {
+			part.Close()
+			return nil, nil, &LimitError{Reason: fmt.Sprintf("The header of MIME part %d exceeds %d bytes", p.parts, max)}
+		}
+	}
+	var content io.Reader = part
+	if p.limits.MaxPartSize > 0 {
+		content = &partLimiter{r: part, part: p.parts, limit: p.limits.MaxPartSize}
+	}
+	return part, content, nil
+}
+
+// partLimiter fails reads beyond the size limit of a part instead of
+// silently truncating the content like io.LimitReader
+type partLimiter struct {
+	r     io.Reader
+	part  int
+	limit int64
+	read  int64
+}
+
+func (l *partLimiter) Read(p []byte) (int, error) {
+	if l.read > l.limit {
+		return 0, l.exceeded()
+	}
+	// Reading one byte more than allowed tells a part of exactly the limit
+	// from a larger one
+	if max := l.limit - l.read + 1; int64(len(p)) > max {
+		p = p[:max]
+	}
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	if l.read > l.limit {
+		return n - int(l.read-l.limit), l.exceeded()
+	}
+	return n, err
+}
+
+func (l *partLimiter) exceeded() error {
+	return &LimitError{Reason: fmt.Sprintf("MIME part %d exceeds %d bytes", l.part, l.limit)}
+}
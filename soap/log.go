@@ -0,0 +1,80 @@
+package soap
+
+import (
+	"context"
+	"log/slog"
+	"soap-server/tracing"
+	"time"
+)
+
+// Keys of the attributes of the log records, shared by all packages so that
+// the records of a request can be correlated and aggregated
+const (
+	LogKeyRequestID = "request_id"
+	LogKeyOperation = "operation"
+	LogKeyCaller    = "caller"
+	LogKeyTenant    = "tenant"
+	LogKeyDuration  = "duration_ms"
+	LogKeyBytes     = "bytes"
+	LogKeyError     = "error"
+	LogKeyTraceID   = "trace_id"
+	LogKeySpanID    = "span_id"
+)
+
+// Log writes a record to the default slog logger with the request ID,
+// operation, caller, tenant and trace carried by ctx followed by args, which are
+// key-value pairs or slog.Attr values as for slog.Log
+func Log(ctx context.Context, level slog.Level, msg string, args ...interface{}) {
+	logger := slog.Default()
+	if !logger.Enabled(ctx, level) {
+		return
+	}
+	logger.Log(ctx, level, msg, append(requestAttrs(ctx), args...)...)
+}
+
+// Debug logs a record at debug level, see Log
+func Debug(ctx context.Context, msg string, args ...interface{}) {
+	Log(ctx, slog.LevelDebug, msg, args...)
+}
+
+// Info logs a record at info level, see Log
+func Info(ctx context.Context, msg string, args ...interface{}) {
+	Log(ctx, slog.LevelInfo, msg, args...)
+}
+
+// Warn logs a record at warning level, see Log
+func Warn(ctx context.Context, msg string, args ...interface{}) {
+	Log(ctx, slog.LevelWarn, msg, args...)
+}
+
+// Error logs a record at error level, see Log
+func Error(ctx context.Context, msg string, args ...interface{}) {
+	Log(ctx, slog.LevelError, msg, args...)
+}
+
+// Duration returns the attribute of the time elapsed since start, in
+// milliseconds
+func Duration(start time.Time) slog.Attr {
+	return slog.Float64(LogKeyDuration, float64(time.Since(start).Microseconds())/1000)
+}
+
+// requestAttrs returns the attributes of the request carried by ctx
+func requestAttrs(ctx context.Context) []interface{} {
+	var attrs []interface{}
+	if id := RequestIDFromContext(ctx); id != "" {
+		attrs = append(attrs, slog.String(LogKeyRequestID, id))
+	}
+	if op, ok := OperationFromContext(ctx); ok {
+		attrs = append(attrs, slog.String(LogKeyOperation, op.Name))
+	}
+	if principal, ok := PrincipalFromContext(ctx); ok {
+		attrs = append(attrs, slog.String(LogKeyCaller, principal.Name))
+	}
+	if tenant := TenantFromContext(ctx); tenant != "" {
+		attrs = append(attrs, slog.String(LogKeyTenant, tenant))
+	}
+	if sc := tracing.SpanContextFromContext(ctx); sc.Valid() {
+		attrs = append(attrs, slog.String(LogKeyTraceID, sc.TraceID.String()), slog.String(LogKeySpanID, sc.SpanID.String()))
+	}
+	return attrs
+}
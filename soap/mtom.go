@@ -0,0 +1,138 @@
+package soap
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+// rootContentID is the Content-ID of the envelope part of MTOM and SwA
+// responses
+const rootContentID = "root.message@soap-server"
+
+// Attachment is a binary part of an MTOM or SwA message. The response
+// element refers to it with an xop:Include (MTOM) or an href attribute
+// (SwA) whose value is "cid:" + ContentID.
+type Attachment struct {
+	ContentID   string    // Without angle brackets
+	ContentType string    // Defaults to application/octet-stream
+	Body        io.Reader // Copied to the response, so files are not buffered
+}
+
+// AcceptsMTOM reports whether the client accepts an MTOM response: it sent
+// an MTOM request or names multipart/related in its Accept header. Wildcards
+// do not count, as clients sending them rarely parse MTOM.
+func AcceptsMTOM(r *http.Request) bool {
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil && mediaType == mediaTypeMTOM {
+		return true
+	}
+	for _, entry := range strings.Split(r.Header.Get("Accept"), ",") {
+		accepted, params, err := mime.ParseMediaType(strings.TrimSpace(entry))
+		if err == nil && accepted == mediaTypeMTOM && params["q"] != "0" {
+			return true
+		}
+	}
+	return false
+}
+
+// IsSwA reports whether the request is a SOAP with Attachments message
+// (RFC 2387): multipart/related with the envelope itself as root part
+// instead of an XOP package, as sent by legacy clients such as Axis1
+func IsSwA(r *http.Request) bool {
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == mediaTypeMTOM && params["type"] != mediaTypeXOP
+}
+
+// WriteMTOMResponse writes the response element v like WriteResponse, as the
+// root part of a multipart/related MTOM message (XOP) followed by the
+// attachments it refers to
+func WriteMTOMResponse(w http.ResponseWriter, r *http.Request, v interface{}, attachments ...Attachment) error {
+	return writeMultipartResponse(w, r, v, true, attachments)
+}
+
+// WriteSwAResponse writes the response element v like WriteMTOMResponse, as
+// a SOAP with Attachments message whose root part is the plain envelope
+func WriteSwAResponse(w http.ResponseWriter, r *http.Request, v interface{}, attachments ...Attachment) error {
+	return writeMultipartResponse(w, r, v, false, attachments)
+}
+
+// writeMultipartResponse writes an MTOM message if xop is set and an SwA
+// message otherwise
+func writeMultipartResponse(w http.ResponseWriter, r *http.Request, v interface{}, xop bool, attachments []Attachment) error {
+	op, ok := OperationFromContext(r.Context())
+	if !ok {
+		return fmt.Errorf("request has not been dispatched to an operation")
+	}
+
+	// BinaryField values are externalized as well, in the same kind of
+	// message
+	parts := newBinaryParts(r)
+	if parts != nil {
+		parts.xop = xop
+	}
+	data, err := marshalEnvelope(r, op, v, parts)
+	if err != nil {
+		return err
+	}
+	if parts != nil {
+		attachments = append(attachments, parts.attachments...)
+	}
+	return writeMultipart(w, r, data, xop, attachments)
+}
+
+// writeMultipart writes a marshaled envelope as the root part of an MTOM or
+// SwA message followed by the attachments
+func writeMultipart(w http.ResponseWriter, r *http.Request, data []byte, xop bool, attachments []Attachment) error {
+
+	// The envelope type of the request version goes into start-info and the
+	// type parameter of the XOP root part, or is the type of the SwA one
+	envelopeType := VersionFromContext(r.Context()).MediaType()
+	mw := multipart.NewWriter(w)
+	params := map[string]string{
+		"type":     envelopeType,
+		"boundary": mw.Boundary(),
+		"start":    "<" + rootContentID + ">",
+	}
+	rootType := mime.FormatMediaType(envelopeType, map[string]string{"charset": "UTF-8"})
+	if xop {
+		params["type"] = mediaTypeXOP
+		params["start-info"] = envelopeType
+		rootType = mime.FormatMediaType(mediaTypeXOP, map[string]string{"charset": "UTF-8", "type": envelopeType})
+	}
+	w.Header().Set("Content-Type", mime.FormatMediaType(mediaTypeMTOM, params))
+
+	root, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {rootType},
+		"Content-Transfer-Encoding": {"8bit"},
+		"Content-ID":                {"<" + rootContentID + ">"},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := root.Write(data); err != nil {
+		return err
+	}
+
+	for _, a := range attachments {
+		contentType := a.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {contentType},
+			"Content-Transfer-Encoding": {"binary"},
+			"Content-ID":                {"<" + a.ContentID + ">"},
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(part, a.Body); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
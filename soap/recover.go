@@ -0,0 +1,101 @@
+package soap
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"soap-server/soapfault"
+)
+
+// RecoverHandler keeps a panic in next from tearing down the connection
+// without a response: the panic is logged with its stack and SOAP requests
+// are answered with a Server fault that does not describe it, other
+// requests with a plain 500. The SOAP servers recover the panics of their
+// operation handlers themselves; this covers the other endpoints, the
+// dispatch and the middleware.
+func RecoverHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := &recoveryWriter{ResponseWriter: w}
+		defer func() {
+			if v := recover(); v != nil {
+				// The request may not have been dispatched yet, so its SOAP
+				// version comes from the Content-Type
+				contentType := r.Header.Get("Content-Type")
+				content, err := parseContentType(contentType)
+				isSOAP := r.Method == http.MethodPost && contentType != "" && err == nil
+				recoverPanic(rw, r.WithContext(WithVersion(r.Context(), content.Version)), v, isSOAP)
+			}
+		}()
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// recoverOperation answers the requests whose operation handler panics
+// with a Server fault, which the middleware sees and records like the
+// faults the handler writes. Panics of the middleware are left to
+// RecoverHandler.
+func recoverOperation(next SOAPHandler) SOAPHandler {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rw := &recoveryWriter{ResponseWriter: w}
+		defer func() {
+			if v := recover(); v != nil {
+				recoverPanic(rw, r, v, true)
+			}
+		}()
+		next(rw, r)
+	}
+}
+
+// recoverPanic logs a recovered panic and answers the request with a
+// generic Server fault, or a plain 500 if it is not a SOAP request. A
+// response already under way cannot be replaced, so its connection is
+// closed instead, leaving the client with a broken rather than a seemingly
+// complete response.
+func recoverPanic(w *recoveryWriter, r *http.Request, v interface{}, isSOAP bool) {
+	if v == http.ErrAbortHandler {
+		panic(v)
+	}
+	Error(r.Context(), "Panic serving request", "panic", fmt.Sprint(v), "stack", string(debug.Stack()))
+	if w.wrote {
+		panic(http.ErrAbortHandler)
+	}
+
+	// Headers the handler set for its own response, such as a
+	// Content-Length, must not reach the fault
+	header := w.Header()
+	id := header.Get(RequestIDHeader)
+	for name := range header {
+		delete(header, name)
+	}
+	if id != "" {
+		header.Set(RequestIDHeader, id)
+	}
+	if !isSOAP {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	WriteFault(w, r, soapfault.Server("Internal server error", nil))
+}
+
+// recoveryWriter records whether a response has been started
+type recoveryWriter struct {
+	http.ResponseWriter
+	wrote bool
+}
+
+func (w *recoveryWriter) WriteHeader(status int) {
+	if status >= 200 {
+		w.wrote = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recoveryWriter) Write(p []byte) (int, error) {
+	w.wrote = true
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController
+func (w *recoveryWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
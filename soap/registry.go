@@ -0,0 +1,153 @@
+package soap
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// Operation describes a SOAP operation that can be dispatched by the registry
+type Operation struct {
+	Name            string           // Operation name as advertised in the WSDL (e.g. "GetUser")
+	Namespace       string           // Target namespace of the request element
+	SOAPAction      string           // SOAPAction URI used for header-based routing
+	RequestElement  string           // Local name of the first child element of soap:Body
+	ResponseElement string           // Local name of the response element (defaults to Name + "Response")
+	Faults          []string         // Local names of the fault detail elements advertised as wsdl:fault
+	Handler         http.HandlerFunc // Handler invoked for matching requests
+
+	// Go types of the request, response and fault detail elements, used to
+	// derive the schema of the operation. RegisterFunc sets the request and
+	// response types; operations with plain handlers may set them.
+	RequestType  reflect.Type
+	ResponseType reflect.Type
+	FaultTypes   []reflect.Type
+}
+
+// OperationRegistry stores registered operations and looks them up by
+// SOAPAction or by request element. Operations are identified by namespace
+// and name, so versions of an operation can be registered side by side under
+// different target namespaces.
+type OperationRegistry struct {
+	mu        sync.RWMutex
+	byName    map[elementKey]*Operation
+	byAction  map[string]*Operation
+	byElement map[elementKey]*Operation
+}
+
+// elementKey identifies a request element by namespace and local name
+type elementKey struct {
+	Namespace string
+	Local     string
+}
+
+// NewOperationRegistry creates an empty operation registry
+func NewOperationRegistry() *OperationRegistry {
+	return &OperationRegistry{
+		byName:    make(map[elementKey]*Operation),
+		byAction:  make(map[string]*Operation),
+		byElement: make(map[elementKey]*Operation),
+	}
+}
+
+// Register adds an operation to the registry
+func (reg *OperationRegistry) Register(op Operation) error {
+	if op.Name == "" {
+		return fmt.Errorf("operation name is required")
+	}
+	if op.RequestElement == "" {
+		return fmt.Errorf("operation %s: request element is required", op.Name)
+	}
+	if op.Handler == nil {
+		return fmt.Errorf("operation %s: handler is required", op.Name)
+	}
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	name := elementKey{Namespace: op.Namespace, Local: op.Name}
+	if _, exists := reg.byName[name]; exists {
+		return fmt.Errorf("operation %s is already registered in namespace %s", op.Name, op.Namespace)
+	}
+	key := elementKey{Namespace: op.Namespace, Local: op.RequestElement}
+	if existing, exists := reg.byElement[key]; exists {
+		return fmt.Errorf("operation %s: request element %s is already used by %s", op.Name, op.RequestElement, existing.Name)
+	}
+	if op.SOAPAction != "" {
+		if existing, exists := reg.byAction[op.SOAPAction]; exists {
+			return fmt.Errorf("operation %s: SOAPAction %s is already used by %s", op.Name, op.SOAPAction, existing.Name)
+		}
+	}
+
+	registered := op
+	if registered.ResponseElement == "" {
+		registered.ResponseElement = op.Name + "Response"
+	}
+	reg.byName[name] = &registered
+	reg.byElement[key] = &registered
+	if op.SOAPAction != "" {
+		reg.byAction[op.SOAPAction] = &registered
+	}
+
+	return nil
+}
+
+// Operations returns all registered operations sorted by name and namespace
+func (reg *OperationRegistry) Operations() []*Operation {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+
+	ops := make([]*Operation, 0, len(reg.byName))
+	for _, op := range reg.byName {
+		ops = append(ops, op)
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		if ops[i].Name != ops[j].Name {
+			return ops[i].Name < ops[j].Name
+		}
+		return ops[i].Namespace < ops[j].Namespace
+	})
+
+	return ops
+}
+
+// OperationsIn returns the registered operations of one target namespace
+// sorted by name
+func (reg *OperationRegistry) OperationsIn(namespace string) []*Operation {
+	var ops []*Operation
+	for _, op := range reg.Operations() {
+		if op.Namespace == namespace {
+			ops = append(ops, op)
+		}
+	}
+	return ops
+}
+
+// Lookup finds an operation by namespace and name
+func (reg *OperationRegistry) Lookup(namespace, name string) (*Operation, bool) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+
+	op, ok := reg.byName[elementKey{Namespace: namespace, Local: name}]
+	return op, ok
+}
+
+// LookupAction finds the operation registered for a SOAPAction URI
+func (reg *OperationRegistry) LookupAction(action string) (*Operation, bool) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+
+	op, ok := reg.byAction[action]
+	return op, ok
+}
+
+// LookupElement finds the operation whose request element matches the given name
+func (reg *OperationRegistry) LookupElement(namespace, local string) (*Operation, bool) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+
+	op, ok := reg.byElement[elementKey{Namespace: namespace, Local: local}]
+	return op, ok
+}
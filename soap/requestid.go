@@ -0,0 +1,67 @@
+package soap
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader carries the request ID between the systems handling a
+// request. The ID of a request is echoed in this response header.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds the request IDs accepted from clients
+const maxRequestIDLength = 128
+
+// RequestIDHandler gives every request handled by next an ID, so that
+// endpoints besides the SOAP servers and middleware wrapping them log it
+// too. SOAP servers assign the IDs themselves if they are not wrapped.
+func RequestIDHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(assignRequestID(r.Context(), w, r)))
+	})
+}
+
+// assignRequestID returns ctx carrying the ID of the request: the one
+// already assigned, else the X-Request-ID of the request if it is valid,
+// else a new UUID. The ID is set in the response header.
+func assignRequestID(ctx context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+	id := RequestIDFromContext(ctx)
+	if id == "" {
+		id = r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.New().String()
+		}
+		ctx = WithRequestID(ctx, id)
+	}
+	w.Header().Set(RequestIDHeader, id)
+	exchangeFromContext(ctx).setRequestID(id)
+	return ctx
+}
+
+// adoptMessageID returns ctx carrying the wsa:MessageID of the request as
+// its ID, unless the client sent an X-Request-ID, which takes precedence
+func adoptMessageID(ctx context.Context, w http.ResponseWriter, r *http.Request, messageID string) context.Context {
+	if validRequestID(r.Header.Get(RequestIDHeader)) || !validRequestID(messageID) {
+		return ctx
+	}
+	ctx = WithRequestID(ctx, messageID)
+	w.Header().Set(RequestIDHeader, messageID)
+	exchangeFromContext(ctx).setRequestID(messageID)
+	return ctx
+}
+
+// validRequestID reports whether a request ID from a client can be used:
+// printable ASCII without spaces, as it is logged and sent back in headers
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
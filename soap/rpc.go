@@ -0,0 +1,231 @@
+package soap
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"soap-server/soapfault"
+	"strings"
+)
+
+// xsiNamespace is the XML Schema instance namespace of xsi:type attributes
+const xsiNamespace = "http://www.w3.org/2001/XMLSchema-instance"
+
+// maxReferenceDepth bounds the href/ref chains followed while resolving
+// multi-reference values, which guards against reference cycles
+const maxReferenceDepth = 32
+
+type rpcKey struct{}
+
+// withRPC marks the request as an rpc/encoded request
+func withRPC(ctx context.Context) context.Context {
+	return context.WithValue(ctx, rpcKey{}, true)
+}
+
+// IsRPC reports whether the request was sent in the rpc/encoded style
+func IsRPC(ctx context.Context) bool {
+	rpc, _ := ctx.Value(rpcKey{}).(bool)
+	return rpc
+}
+
+// rpcOperation returns the operation of an rpc/encoded request, whose Body
+// element is named after the operation instead of its request element
+func (s *Server) rpcOperation(name xml.Name) (*Operation, bool) {
+	op, ok := s.registry.Lookup(name.Space, name.Local)
+	if !ok || op.RequestElement == op.Name {
+		return nil, false
+	}
+	return op, true
+}
+
+// rpcNode is an element of an rpc/encoded Body with its character data and
+// child elements
+type rpcNode struct {
+	start   xml.StartElement
+	content []interface{} // xml.CharData or *rpcNode
+}
+
+// readNode reads the element opened by start up to its end element
+func readNode(decoder *xml.Decoder, start xml.StartElement) (*rpcNode, error) {
+	node := &rpcNode{start: start.Copy()}
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			child, err := readNode(decoder, t)
+			if err != nil {
+				return nil, err
+			}
+			node.content = append(node.content, child)
+		case xml.CharData:
+			node.content = append(node.content, t.Copy())
+		case xml.EndElement:
+			return node, nil
+		}
+	}
+}
+
+// attr returns the value of the attribute with the given local name in one
+// of the namespaces; an empty namespace matches unqualified attributes
+func (n *rpcNode) attr(local string, namespaces ...string) (string, bool) {
+	for _, a := range n.start.Attr {
+		if a.Name.Local != local {
+			continue
+		}
+		for _, ns := range namespaces {
+			if a.Name.Space == ns {
+				return a.Value, true
+			}
+		}
+	}
+	return "", false
+}
+
+// reference returns the id referenced by a SOAP 1.1 href="#id" or SOAP 1.2
+// enc:ref="id" attribute
+func (n *rpcNode) reference() (string, bool) {
+	if href, ok := n.attr("href", ""); ok && strings.HasPrefix(href, "#") {
+		return href[1:], true
+	}
+	return n.attr("ref", EncodingNamespace12)
+}
+
+// isArray reports whether the element is a SOAP encoding array
+func (n *rpcNode) isArray() bool {
+	if _, ok := n.attr("arrayType", EncodingNamespace11); ok {
+		return true
+	}
+	if _, ok := n.attr("itemType", EncodingNamespace12); ok {
+		return true
+	}
+	if _, ok := n.attr("arraySize", EncodingNamespace12); ok {
+		return true
+	}
+	xsiType, _ := n.attr("type", xsiNamespace)
+	return xsiType == "Array" || strings.HasSuffix(xsiType, ":Array")
+}
+
+// rpcDocument rewrites an rpc/encoded operation element into the equivalent
+// document/literal request element: multi-reference values are inlined and
+// encoding arrays become repeated elements, the form encoding/xml decodes
+// into slices.
+type rpcDocument struct {
+	ids    map[string]*rpcNode
+	tokens []xml.Token
+}
+
+// index records the elements carrying an id attribute
+func (d *rpcDocument) index(n *rpcNode) {
+	if id, ok := n.attr("id", "", EncodingNamespace12); ok {
+		d.ids[id] = n
+	}
+	for _, c := range n.content {
+		if child, ok := c.(*rpcNode); ok {
+			d.index(child)
+		}
+	}
+}
+
+// emit appends the tokens of n under the given element name
+func (d *rpcDocument) emit(n *rpcNode, name xml.Name, depth int) error {
+	if id, ok := n.reference(); ok {
+		target, found := d.ids[id]
+		if !found {
+			return fmt.Errorf("element %s references unknown id %q", n.start.Name.Local, id)
+		}
+		if depth >= maxReferenceDepth {
+			return fmt.Errorf("element %s: too many nested references", n.start.Name.Local)
+		}
+		return d.emit(target, name, depth+1)
+	}
+
+	// Array items are renamed to the array element and emitted in its place
+	if n.isArray() {
+		for _, c := range n.content {
+			if item, ok := c.(*rpcNode); ok {
+				if err := d.emit(item, name, depth); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	d.tokens = append(d.tokens, xml.StartElement{Name: name, Attr: n.start.Attr})
+	if err := d.emitContent(n, depth); err != nil {
+		return err
+	}
+	d.tokens = append(d.tokens, xml.EndElement{Name: name})
+	return nil
+}
+
+// emitContent appends the tokens of the character data and child elements
+// of n
+func (d *rpcDocument) emitContent(n *rpcNode, depth int) error {
+	for _, c := range n.content {
+		switch c := c.(type) {
+		case xml.CharData:
+			d.tokens = append(d.tokens, c)
+		case *rpcNode:
+			if err := d.emit(c, c.start.Name, depth); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// decodeRPC decodes the rpc/encoded operation element opened by start, and
+// the multi-reference values that follow it in the Body, into v
+func decodeRPC(r *http.Request, decoder *xml.Decoder, start xml.StartElement, v interface{}) error {
+	op, ok := OperationFromContext(r.Context())
+	if !ok {
+		return fmt.Errorf("request has not been dispatched to an operation")
+	}
+
+	call, err := readNode(decoder, start)
+	if err != nil {
+		return decodeFault("Invalid XML format", err)
+	}
+
+	doc := &rpcDocument{ids: map[string]*rpcNode{}}
+	doc.index(call)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return decodeFault("Invalid XML format", err)
+		}
+		if _, ok := token.(xml.EndElement); ok {
+			break
+		}
+		if t, ok := token.(xml.StartElement); ok {
+			ref, err := readNode(decoder, t)
+			if err != nil {
+				return decodeFault("Invalid XML format", err)
+			}
+			doc.index(ref)
+		}
+	}
+
+	// The parameters become the children of the request element
+	requestStart := xml.StartElement{Name: xml.Name{Space: start.Name.Space, Local: op.RequestElement}}
+	doc.tokens = append(doc.tokens, requestStart)
+	if err := doc.emitContent(call, 0); err != nil {
+		return soapfault.Client("Invalid rpc/encoded request", err.Error())
+	}
+	doc.tokens = append(doc.tokens, requestStart.End())
+
+	if err := xml.NewTokenDecoder(&tokenReader{tokens: doc.tokens}).Decode(v); err != nil {
+		return decodeFault("Invalid XML format", err)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package soap
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// SampleRequest renders a skeleton request envelope for the operation. The
+// request element is generated from the operation RequestType with "?" for
+// strings, zero values for other simple types and one entry per slice, so
+// integrators see every element they can send.
+func SampleRequest(op *Operation, version Version) ([]byte, error) {
+	if op.RequestType == nil {
+		return nil, fmt.Errorf("operation %s has no request type", op.Name)
+	}
+
+	sample := reflect.New(op.RequestType).Elem()
+	fillSample(sample, 0)
+
+	var body bytes.Buffer
+	encoder := xml.NewEncoder(&body)
+	encoder.Indent("        ", "    ")
+	start := xml.StartElement{Name: xml.Name{Space: op.Namespace, Local: op.RequestElement}}
+	if err := encoder.EncodeElement(sample.Interface(), start); err != nil {
+		return nil, err
+	}
+
+	data, err := xml.MarshalIndent(envelope{
+		Namespace: version.EnvelopeNamespace(),
+		Body:      rawElement{Content: []byte("\n" + body.String() + "\n    ")},
+	}, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+
+	action := fmt.Sprintf("<!-- SOAPAction: %s -->\n", op.SOAPAction)
+	if version == SOAP12 {
+		action = fmt.Sprintf("<!-- Content-Type: %s; action=%q -->\n", version.MediaType(), op.SOAPAction)
+	}
+	return append([]byte(xml.Header+action), data...), nil
+}
+
+// SampleHandler serves the sample request of an operation at
+// GET <prefix><operation>/sample. The query parameter version=1.2 selects a
+// SOAP 1.2 envelope and namespace selects one of several versions of the
+// operation; by default the first registered namespace in sort order is used.
+func SampleHandler(registry *OperationRegistry, prefix string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed. Use GET.", http.StatusMethodNotAllowed)
+			return
+		}
+
+		name, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, prefix), "/sample")
+		if !ok || name == "" || strings.Contains(name, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		var op *Operation
+		namespace := r.URL.Query().Get("namespace")
+		for _, candidate := range registry.Operations() {
+			if candidate.Name == name && (namespace == "" || candidate.Namespace == namespace) {
+				op = candidate
+				break
+			}
+		}
+		if op == nil {
+			http.Error(w, "Unknown operation "+name, http.StatusNotFound)
+			return
+		}
+
+		version := SOAP11
+		if r.URL.Query().Get("version") == "1.2" {
+			version = SOAP12
+		}
+
+		data, err := SampleRequest(op, version)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write(data)
+	}
+}
+
+// maxSampleDepth bounds the expansion of recursive types
+const maxSampleDepth = 8
+
+var sampleTimeType = reflect.TypeOf(time.Time{})
+
+// fillSample sets placeholder values on v and its nested fields
+func fillSample(v reflect.Value, depth int) {
+	if depth > maxSampleDepth {
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString("?")
+	case reflect.Ptr:
+		v.Set(reflect.New(v.Type().Elem()))
+		fillSample(v.Elem(), depth+1)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			v.SetBytes([]byte("?"))
+			return
+		}
+		v.Set(reflect.MakeSlice(v.Type(), 1, 1))
+		fillSample(v.Index(0), depth+1)
+	case reflect.Struct:
+		if v.Type() == sampleTimeType {
+			return
+		}
+		for i := 0; i < v.NumField(); i++ {
+			if field := v.Type().Field(i); field.PkgPath == "" && field.Name != "XMLName" {
+				fillSample(v.Field(i), depth+1)
+			}
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package soap
+
+import (
+	"context"
+	"net/http"
+	"soap-server/tracing"
+	"soap-server/xsd"
+	"sync"
+	"time"
+)
+
+// SOAPHandler serves a request that has been dispatched to an operation.
+// The operation is available through OperationFromContext.
+type SOAPHandler func(w http.ResponseWriter, r *http.Request)
+
+// Middleware wraps a SOAPHandler with cross-cutting behaviour such as
+// authentication, logging, metrics or panic recovery
+type Middleware func(next SOAPHandler) SOAPHandler
+
+// Server is the SOAP endpoint. It dispatches requests to the operations of a
+// registry and runs every operation through the registered middleware chain.
+type Server struct {
+	// Timeout bounds the time an operation may run. The request context is
+	// cancelled when it expires; zero means no limit.
+	Timeout time.Duration
+
+	// StrictSOAPAction rejects requests whose SOAP action (SOAPAction header,
+	// action parameter or wsa:Action) does not belong to the operation
+	// element found in the Body
+	StrictSOAPAction bool
+
+	// RPCEncoded additionally accepts rpc/encoded requests, whose Body
+	// element is named after the operation and wraps the parameters. SOAP
+	// encoding arrays and multi-reference values (href/id) are rewritten to
+	// the document/literal form before decoding, and responses carry the
+	// encodingStyle attribute.
+	RPCEncoded bool
+
+	// Contract, if set, serves GET requests for the service description
+	// (the ?wsdl and ?xsd=N query conventions)
+	Contract http.Handler
+
+	// Schema, if set, validates the request element in the Body before the
+	// operation is invoked. Violations are answered with a Client fault that
+	// reports the line and column. Elements not declared in the schema and
+	// MTOM requests are not validated.
+	Schema *xsd.Schema
+
+	// Strict rejects requests whose request element contains elements that
+	// are not fields of the operation request type, or fields out of
+	// declaration order, which encoding/xml would otherwise silently accept.
+	// Violations are answered like schema violations. rpc/encoded and MTOM
+	// requests are not checked.
+	Strict bool
+
+	// ResponseSchema, if set, validates the response element of every
+	// successful response against the schema, a development aid to catch
+	// handlers producing invalid XML. Violations are logged; with
+	// FailInvalidResponses they are also replaced by a Server fault.
+	ResponseSchema       *xsd.Schema
+	FailInvalidResponses bool
+
+	// MTOMThreshold, if positive, is the size from which BinaryField values
+	// of responses are sent as MTOM attachments to clients accepting
+	// multipart/related; smaller values and other clients get base64 text
+	MTOMThreshold int
+
+	// XMLLimits bound the depth, attribute counts and size of the XML
+	// documents of requests, which are decoded with NewDecoder
+	XMLLimits XMLLimits
+
+	// Limits bound the size of request bodies and the number, size and
+	// header size of the MIME parts of multipart/related requests
+	Limits RequestLimits
+
+	// Concurrency, if set, bounds the requests served at once. It is
+	// acquired before the request body is read, so requests waiting for a
+	// slot hold no more than their headers.
+	Concurrency *Limiter
+
+	registry *OperationRegistry
+
+	mu               sync.RWMutex
+	middleware       []Middleware
+	headerProcessors map[elementKey]HeaderProcessor
+	requestHooks     []RequestHook
+	responseHooks    []ResponseHook
+}
+
+// NewServer creates a SOAP server dispatching to the given registry
+func NewServer(registry *OperationRegistry) *Server {
+	return &Server{registry: registry}
+}
+
+// Registry returns the operation registry used by the server
+func (s *Server) Registry() *OperationRegistry {
+	return s.registry
+}
+
+// Use appends middleware to the chain applied to every operation. The first
+// middleware registered is the outermost one.
+func (s *Server) Use(mw ...Middleware) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.middleware = append(s.middleware, mw...)
+}
+
+// invoke runs the operation handler wrapped in the middleware chain
+func (s *Server) invoke(op *Operation, w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	h := Chain(checkMustUnderstand(observeInvocation(recoverOperation(SOAPHandler(op.Handler)))), s.middleware...)
+	s.mu.RUnlock()
+
+	ctx := WithOperation(r.Context(), op)
+	exchangeFromContext(ctx).setOperation(op.Name)
+	ctx, span := tracing.Start(ctx, "SOAP "+op.Name, tracing.KindInternal,
+		tracing.String("rpc.system", "soap"),
+		tracing.String("rpc.method", op.Name),
+		tracing.String("soap.action", op.SOAPAction),
+		tracing.String("soap.request_id", RequestIDFromContext(ctx)),
+	)
+	defer span.End()
+	start := time.Now()
+	h(w, r.WithContext(ctx))
+	Debug(ctx, "Operation completed", Duration(start))
+}
+
+// Chain wraps a handler in middleware; the first middleware runs first. It
+// lets plain HTTP endpoints share the middleware of the SOAP servers.
+func Chain(h SOAPHandler, mw ...Middleware) SOAPHandler {
+	for i := len(mw) - 1; i >= 0; i-- {
+		h = mw[i](h)
+	}
+	return h
+}
+
+type operationKey struct{}
+
+// WithOperation returns a copy of ctx carrying the dispatched operation
+func WithOperation(ctx context.Context, op *Operation) context.Context {
+	return context.WithValue(ctx, operationKey{}, op)
+}
+
+// OperationFromContext returns the operation a request was dispatched to
+func OperationFromContext(ctx context.Context) (*Operation, bool) {
+	op, ok := ctx.Value(operationKey{}).(*Operation)
+	return op, ok
+}
@@ -0,0 +1,198 @@
+package soap
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"soap-server/xsd"
+	"strings"
+	"time"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// checkStrictBody checks the request element in the Body against the
+// content model of the operation request type. The body is buffered and
+// replayed for the operation handler.
+func checkStrictBody(r *http.Request, t reflect.Type) error {
+	data, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(data))
+	if err != nil {
+		return decodeFault("Failed to read request", err)
+	}
+
+	decoder := NewDecoder(r.Context(), bytes.NewReader(data))
+	depth := 0
+	inBody := false
+	var envelope string
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			// Malformed envelopes are reported by the decoding handler
+			return nil
+		}
+
+		switch tok := token.(type) {
+		case xml.StartElement:
+			depth++
+			if depth == 1 {
+				envelope = tok.Name.Space
+			} else if depth == 2 && tok.Name.Space == envelope && tok.Name.Local == "Body" {
+				inBody = true
+			} else if inBody {
+				return validationFault(checkStrict(decoder, tok, t))
+			}
+		case xml.EndElement:
+			depth--
+			if inBody {
+				return nil
+			}
+		}
+	}
+}
+
+// strictField is an element of a struct content model
+type strictField struct {
+	name     xml.Name // Element name; an empty Space matches any namespace
+	typ      reflect.Type
+	repeated bool
+}
+
+// checkStrict checks that the children of the element opened by start are
+// elements of t, in field order. Unlike encoding/xml, which ignores
+// unknown elements and accepts any order, it reports unexpected, misplaced
+// and repeated elements. Structs with an ",any" or ",innerxml" field accept
+// any content.
+func checkStrict(decoder *xml.Decoder, start xml.StartElement, t reflect.Type) error {
+	fields, open := strictFields(derefType(t))
+	counts := make([]int, len(fields))
+	current := 0
+
+	for {
+		line, column := decoder.InputPos()
+		token, err := decoder.Token()
+		if err != nil {
+			return nil
+		}
+
+		switch tok := token.(type) {
+		case xml.EndElement:
+			return nil
+		case xml.StartElement:
+			index := findField(fields, tok.Name)
+			switch {
+			case index < 0 && open:
+				if err := decoder.Skip(); err != nil {
+					return nil
+				}
+				continue
+			case index < 0:
+				return strictError(line, column, tok, "unexpected element %s in %s", tok.Name.Local, start.Name.Local)
+			case index < current:
+				return strictError(line, column, tok, "element %s must appear before %s in %s",
+					tok.Name.Local, fields[current].name.Local, start.Name.Local)
+			case counts[index] > 0 && !fields[index].repeated:
+				return strictError(line, column, tok, "element %s occurs more than once in %s", tok.Name.Local, start.Name.Local)
+			}
+			counts[index]++
+			current = index
+
+			field := fields[index]
+			if field.typ != nil && field.typ.Kind() == reflect.Struct && field.typ != timeType {
+				if err := checkStrict(decoder, tok, field.typ); err != nil {
+					return err
+				}
+			} else if err := decoder.Skip(); err != nil {
+				return nil
+			}
+		}
+	}
+}
+
+// strictFields returns the element fields of struct type t in declaration
+// order, with embedded structs promoted, and whether t accepts any content
+func strictFields(t reflect.Type) ([]strictField, bool) {
+	if t.Kind() != reflect.Struct {
+		return nil, true
+	}
+
+	var fields []strictField
+	open := false
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+		if f.Name == "XMLName" {
+			continue
+		}
+
+		tag := f.Tag.Get("xml")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		switch {
+		case strings.Contains(","+opts+",", ",any,") || strings.Contains(","+opts+",", ",innerxml,"):
+			open = true
+			continue
+		case opts != "" && opts != "omitempty":
+			// attr, chardata, cdata and comment fields carry no elements
+			continue
+		}
+
+		ft := derefType(f.Type)
+		if f.Anonymous && tag == "" && ft.Kind() == reflect.Struct {
+			embedded, embeddedOpen := strictFields(ft)
+			fields = append(fields, embedded...)
+			open = open || embeddedOpen
+			continue
+		}
+
+		field := strictField{typ: ft}
+		if ft.Kind() == reflect.Slice && ft.Elem().Kind() != reflect.Uint8 {
+			field.repeated = true
+			field.typ = derefType(ft.Elem())
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if space, local, ok := strings.Cut(name, " "); ok {
+			field.name = xml.Name{Space: space, Local: local}
+		} else {
+			field.name.Local = name
+		}
+		// Nested paths such as "a>b" are only checked at their first element
+		if first, _, nested := strings.Cut(field.name.Local, ">"); nested {
+			field.name.Local = first
+			field.typ = nil
+			field.repeated = true
+		}
+		fields = append(fields, field)
+	}
+	return fields, open
+}
+
+// findField returns the index of the field matching the element name, or -1
+func findField(fields []strictField, name xml.Name) int {
+	for i, f := range fields {
+		if f.name.Local == name.Local && (f.name.Space == "" || f.name.Space == name.Space) {
+			return i
+		}
+	}
+	return -1
+}
+
+// strictError reports a content model violation at the given position
+func strictError(line, column int, el xml.StartElement, format string, args ...interface{}) error {
+	return &xsd.ValidationError{
+		Line:    line,
+		Column:  column,
+		Element: el.Name.Local,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
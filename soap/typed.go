@@ -0,0 +1,178 @@
+package soap
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"soap-server/soapfault"
+)
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// RegisterFunc registers an operation implemented by a typed function of the
+// form
+//
+//	func(ctx context.Context, req RequestType) (ResponseType, error)
+//
+// where the request and response types are structs or pointers to structs.
+// The framework decodes the request element from the envelope, validates it
+// against its validate tags and Validator method, encodes the response element and maps returned
+// errors to SOAP faults. The Handler,
+// RequestType and ResponseType fields of op are set from fn.
+func (reg *OperationRegistry) RegisterFunc(op Operation, fn interface{}) error {
+	handler, err := typedHandler(fn)
+	if err != nil {
+		return fmt.Errorf("operation %s: %w", op.Name, err)
+	}
+
+	// Reject malformed validate rules at registration
+	ft := reflect.TypeOf(fn)
+	if err := validateFields(reflect.New(derefType(ft.In(1))), "", &FieldErrors{}); err != nil {
+		return fmt.Errorf("operation %s: %w", op.Name, err)
+	}
+
+	op.Handler = handler
+	op.RequestType = derefType(ft.In(1))
+	op.ResponseType = derefType(ft.Out(0))
+	return reg.Register(op)
+}
+
+// typedHandler validates fn and wraps it in an http.HandlerFunc
+func typedHandler(fn interface{}) (http.HandlerFunc, error) {
+	fv := reflect.ValueOf(fn)
+	ft := fv.Type()
+
+	if ft.Kind() != reflect.Func {
+		return nil, fmt.Errorf("handler must be a function, got %s", ft)
+	}
+	if ft.NumIn() != 2 || ft.In(0) != contextType || !isStructType(ft.In(1)) {
+		return nil, fmt.Errorf("handler must accept (context.Context, request struct), got %s", ft)
+	}
+	if ft.NumOut() != 2 || !isStructType(ft.Out(0)) || ft.Out(1) != errorType {
+		return nil, fmt.Errorf("handler must return (response struct, error), got %s", ft)
+	}
+
+	reqType := ft.In(1)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Decode the request element into a new request value
+		req := reflect.New(derefType(reqType))
+		if err := decodeBody(r, req.Interface()); err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		if err := validateRequest(req.Interface()); err != nil {
+			WriteError(w, r, err)
+			return
+		}
+		if reqType.Kind() != reflect.Ptr {
+			req = req.Elem()
+		}
+
+		results := fv.Call([]reflect.Value{reflect.ValueOf(r.Context()), req})
+		if err, _ := results[1].Interface().(error); err != nil {
+			WriteError(w, r, err)
+			return
+		}
+
+		resp := results[0]
+		if resp.Kind() == reflect.Ptr && resp.IsNil() {
+			WriteFault(w, r, soapfault.Server("Internal error", "Operation returned no response"))
+			return
+		}
+
+		if err := WriteResponse(w, r, resp.Interface()); err != nil {
+			WriteFault(w, r, soapfault.Server("Internal error", "Failed to encode response: "+err.Error()))
+		}
+	}, nil
+}
+
+// DecodeRequest decodes the request element of the SOAP Body into v and
+// validates it as typed handlers do, for plain handlers that need to write
+// the response themselves. Errors are SOAP faults.
+func DecodeRequest(r *http.Request, v interface{}) error {
+	if err := decodeBody(r, v); err != nil {
+		return err
+	}
+	return validateRequest(v)
+}
+
+// ValidateRequest applies the validate tag rules and the Validate method of
+// a request decoded by a plain handler, like typed handlers do. Violations
+// are returned as a Client fault.
+func ValidateRequest(v interface{}) error {
+	return validateRequest(v)
+}
+
+// decodeBody decodes the first child element of the SOAP Body into v
+func decodeBody(r *http.Request, v interface{}) error {
+	return DecodeEnvelope(r, r.Body, v)
+}
+
+// DecodeEnvelope decodes the first child element of the Body of the
+// envelope read from src into v, for handlers reading the envelope from a
+// part of a multipart request. Only the Body of the envelope namespace is
+// read. Errors are SOAP faults.
+func DecodeEnvelope(r *http.Request, src io.Reader, v interface{}) error {
+	decoder := NewDecoder(r.Context(), src)
+	inBody := false
+	var envelope xml.Name
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return soapfault.Client("Invalid SOAP request", "SOAP Body does not contain a request element")
+		}
+		if err != nil {
+			return decodeFault("Invalid XML format", err)
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		// The first element must be the envelope of the negotiated version
+		if envelope.Local == "" {
+			if err := CheckEnvelope(r, start.Name); err != nil {
+				return soapfault.New(soapfault.CodeVersionMismatch, "Version mismatch", err.Error())
+			}
+			envelope = start.Name
+			continue
+		}
+
+		if inBody {
+			if IsRPC(r.Context()) {
+				return decodeRPC(r, decoder, start, v)
+			}
+			if err := decoder.DecodeElement(v, &start); err != nil {
+				return decodeFault("Invalid XML format", err)
+			}
+			return nil
+		}
+
+		// Elements named Body in other namespaces are not the Body
+		if start.Name.Space == envelope.Space && start.Name.Local == "Body" {
+			inBody = true
+		} else if err := decoder.Skip(); err != nil {
+			return decodeFault("Invalid XML format", err)
+		}
+	}
+}
+
+func isStructType(t reflect.Type) bool {
+	return derefType(t).Kind() == reflect.Struct
+}
+
+func derefType(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Ptr {
+		return t.Elem()
+	}
+	return t
+}
@@ -0,0 +1,109 @@
+package soap
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"soap-server/soapfault"
+	"soap-server/xsd"
+)
+
+// ValidationDetail is the fault detail of a request that violates the schema
+type ValidationDetail struct {
+	XMLName xml.Name `xml:"ValidationError"`
+	Element string   `xml:"element,omitempty"`
+	Line    int      `xml:"line"`
+	Column  int      `xml:"column"`
+	Message string   `xml:"message"`
+}
+
+// validateBody validates the first Body child of the request against the
+// schema. The body is buffered and replayed for the operation handler.
+func validateBody(r *http.Request, schema *xsd.Schema) error {
+	data, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(data))
+	if err != nil {
+		return decodeFault("Failed to read request", err)
+	}
+
+	return validationFault(validateEnvelope(NewDecoder(r.Context(), bytes.NewReader(data)), schema))
+}
+
+// validateEnvelope validates the first Body child of an envelope against
+// the schema. Elements not declared in the schema, such as faults, and
+// malformed documents are not validated. The decoder reads the whole
+// envelope so line and column numbers refer to the document.
+func validateEnvelope(decoder *xml.Decoder, schema *xsd.Schema) error {
+	depth := 0
+	inBody := false
+	var envelope string
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			if depth == 1 {
+				envelope = t.Name.Space
+			}
+			if depth == 2 && t.Name.Space == envelope && t.Name.Local == "Body" {
+				inBody = true
+				continue
+			}
+			if !inBody {
+				continue
+			}
+			if _, ok := schema.Element(t.Name.Space, t.Name.Local); !ok {
+				return nil
+			}
+			return schema.ValidateElement(decoder, t)
+		case xml.EndElement:
+			depth--
+			if inBody {
+				return nil
+			}
+		}
+	}
+}
+
+// responseValidator returns a response hook validating the response element
+// against the schema. Violations are logged and, if fail is set, replaced by
+// a Server fault.
+func responseValidator(schema *xsd.Schema, fail bool) ResponseHook {
+	return func(r *http.Request, body []byte) ([]byte, error) {
+		err := validateEnvelope(xml.NewDecoder(bytes.NewReader(body)), schema)
+		if err == nil {
+			return body, nil
+		}
+
+		Warn(r.Context(), "Invalid response", LogKeyError, err)
+		if fail {
+			return nil, soapfault.Server("Invalid response", "Response does not match the service schema: "+err.Error())
+		}
+		return body, nil
+	}
+}
+
+// validationFault converts a schema violation into a Client fault
+func validationFault(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var verr *xsd.ValidationError
+	if !errors.As(err, &verr) {
+		return soapfault.Client("Schema validation failed", err.Error())
+	}
+	return soapfault.Client("Schema validation failed: "+verr.Error(), ValidationDetail{
+		Element: verr.Element,
+		Line:    verr.Line,
+		Column:  verr.Column,
+		Message: verr.Message,
+	})
+}
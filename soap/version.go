@@ -0,0 +1,113 @@
+package soap
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Version identifies the SOAP protocol version of a message
+type Version int
+
+const (
+	// SOAP11 is SOAP 1.1 (text/xml, SOAPAction header)
+	SOAP11 Version = iota + 1
+	// SOAP12 is SOAP 1.2 (application/soap+xml with an action parameter)
+	SOAP12
+)
+
+// Envelope namespaces for the supported SOAP versions
+const (
+	EnvelopeNamespace11 = "http://schemas.xmlsoap.org/soap/envelope/"
+	EnvelopeNamespace12 = "http://www.w3.org/2003/05/soap-envelope"
+)
+
+// SOAP encoding namespaces used by rpc/encoded messages
+const (
+	EncodingNamespace11 = "http://schemas.xmlsoap.org/soap/encoding/"
+	EncodingNamespace12 = "http://www.w3.org/2003/05/soap-encoding"
+)
+
+// String returns the version number as text
+func (v Version) String() string {
+	if v == SOAP12 {
+		return "1.2"
+	}
+	return "1.1"
+}
+
+// EnvelopeNamespace returns the envelope namespace URI for the version
+func (v Version) EnvelopeNamespace() string {
+	if v == SOAP12 {
+		return EnvelopeNamespace12
+	}
+	return EnvelopeNamespace11
+}
+
+// EncodingNamespace returns the SOAP encoding namespace URI for the version
+func (v Version) EncodingNamespace() string {
+	if v == SOAP12 {
+		return EncodingNamespace12
+	}
+	return EncodingNamespace11
+}
+
+// MediaType returns the media type used for messages of this version
+func (v Version) MediaType() string {
+	if v == SOAP12 {
+		return "application/soap+xml"
+	}
+	return "text/xml"
+}
+
+// ContentType returns the Content-Type header value for responses of this version
+func (v Version) ContentType() string {
+	return v.MediaType() + "; charset=utf-8"
+}
+
+// VersionFromNamespace returns the SOAP version identified by an envelope namespace
+func VersionFromNamespace(namespace string) (Version, bool) {
+	switch namespace {
+	case EnvelopeNamespace11:
+		return SOAP11, true
+	case EnvelopeNamespace12:
+		return SOAP12, true
+	}
+	return 0, false
+}
+
+type versionKey struct{}
+
+// WithVersion returns a copy of ctx carrying the SOAP version of the request
+func WithVersion(ctx context.Context, v Version) context.Context {
+	return context.WithValue(ctx, versionKey{}, v)
+}
+
+// VersionFromContext returns the SOAP version stored in ctx, defaulting to SOAP 1.1
+func VersionFromContext(ctx context.Context) Version {
+	if v, ok := ctx.Value(versionKey{}).(Version); ok {
+		return v
+	}
+	return SOAP11
+}
+
+// CheckEnvelope verifies that a decoded envelope element uses the namespace
+// of the SOAP version negotiated for the request
+func CheckEnvelope(r *http.Request, envelope xml.Name) error {
+	v := VersionFromContext(r.Context())
+	if envelope.Space != v.EnvelopeNamespace() {
+		return fmt.Errorf("expected SOAP %s envelope namespace %s, got %q", v, v.EnvelopeNamespace(), envelope.Space)
+	}
+	return nil
+}
+
+// stripQuotes removes surrounding double quotes from a header value
+func stripQuotes(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) >= 2 && (s[0] == '"' && s[len(s)-1] == '"') {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
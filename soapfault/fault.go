@@ -0,0 +1,227 @@
+// Package soapfault provides typed SOAP faults that render as SOAP 1.1 or
+// SOAP 1.2 fault elements. Handlers return *Fault values (possibly wrapped)
+// as errors; the server maps them to fault responses with errors.As.
+package soapfault
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Code is a SOAP fault code. Codes use the SOAP 1.1 names and are translated
+// to their SOAP 1.2 equivalents when rendered for a 1.2 request.
+type Code string
+
+// Standard fault codes
+const (
+	CodeVersionMismatch     Code = "VersionMismatch"
+	CodeMustUnderstand      Code = "MustUnderstand"
+	CodeDataEncodingUnknown Code = "DataEncodingUnknown"
+	CodeClient              Code = "Client" // Sender in SOAP 1.2
+	CodeServer              Code = "Server" // Receiver in SOAP 1.2
+)
+
+// Envelope namespaces of the supported SOAP versions
+const (
+	envelopeNamespace11 = "http://schemas.xmlsoap.org/soap/envelope/"
+	envelopeNamespace12 = "http://www.w3.org/2003/05/soap-envelope"
+)
+
+// Fault is an error reported to the client as a SOAP fault
+type Fault struct {
+	Code    Code        // Top level fault code
+	Subcode xml.Name    // Optional application specific subcode
+	Reason  string      // Human readable explanation
+	Detail  interface{} // Text or a struct marshaled into the detail element
+	Status  int         // HTTP status overriding the one of the code, if set
+
+	// RequestID is added to the detail as a RequestID entry following the
+	// detail content, if set
+	RequestID string
+}
+
+// New creates a fault with the given code, reason and detail
+func New(code Code, reason string, detail interface{}) *Fault {
+	return &Fault{Code: code, Reason: reason, Detail: detail}
+}
+
+// Client creates a fault caused by the request content (Sender in SOAP 1.2)
+func Client(reason string, detail interface{}) *Fault {
+	return New(CodeClient, reason, detail)
+}
+
+// Server creates a fault caused by a server side failure (Receiver in SOAP 1.2)
+func Server(reason string, detail interface{}) *Fault {
+	return New(CodeServer, reason, detail)
+}
+
+// WithSubcode sets an application specific subcode on the fault
+func (f *Fault) WithSubcode(namespace, local string) *Fault {
+	f.Subcode = xml.Name{Space: namespace, Local: local}
+	return f
+}
+
+// WithHTTPStatus sets the HTTP status the fault is sent with, for faults
+// the HTTP layer must understand (e.g. 401 with a challenge)
+func (f *Fault) WithHTTPStatus(status int) *Fault {
+	f.Status = status
+	return f
+}
+
+// Error implements the error interface
+func (f *Fault) Error() string {
+	msg := string(f.Code) + ": " + f.Reason
+	if text, ok := f.Detail.(string); ok && text != "" {
+		msg += ": " + text
+	}
+	return msg
+}
+
+// QualifiedCode returns the code of the fault with its subcode, if any, e.g.
+// "Client.Authorization"
+func (f *Fault) QualifiedCode() string {
+	if f.Subcode.Local != "" {
+		return string(f.Code) + "." + f.Subcode.Local
+	}
+	return string(f.Code)
+}
+
+// As returns the fault wrapped in err, if any
+func As(err error) (*Fault, bool) {
+	var fault *Fault
+	if errors.As(err, &fault) {
+		return fault, true
+	}
+	return nil, false
+}
+
+// FromError converts any error to a fault. Errors that do not wrap a fault
+// become Server faults.
+func FromError(err error) *Fault {
+	if fault, ok := As(err); ok {
+		return fault
+	}
+	return Server("Internal error", err.Error())
+}
+
+// HTTPStatus returns the HTTP status code for the fault. SOAP 1.1 faults are
+// sent with 500 (SOAP 1.1 section 6.2, WS-I Basic Profile R1126); SOAP 1.2
+// follows the HTTP binding (400 for Sender faults, 500 otherwise). A status
+// set with WithHTTPStatus takes precedence.
+func (f *Fault) HTTPStatus(soap12 bool) int {
+	if f.Status != 0 {
+		return f.Status
+	}
+	if soap12 && f.Code == CodeClient {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// Render returns the complete fault envelope. header is inserted verbatim
+// after the envelope start tag and may be empty.
+func (f *Fault) Render(soap12 bool, header string) ([]byte, error) {
+	detail, err := f.renderDetail()
+	if err != nil {
+		return nil, err
+	}
+
+	if soap12 {
+		return []byte(fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<env:Envelope xmlns:env="%s">%s
+    <env:Body>
+        <env:Fault>
+            <env:Code>
+                <env:Value>env:%s</env:Value>%s
+            </env:Code>
+            <env:Reason>
+                <env:Text xml:lang="en">%s</env:Text>
+            </env:Reason>%s
+        </env:Fault>
+    </env:Body>
+</env:Envelope>`, envelopeNamespace12, header, code12(f.Code), f.subcode12(), escape(f.Reason), wrapDetail("env:Detail", detail))), nil
+	}
+
+	return []byte(fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<soap:Envelope xmlns:soap="%s">%s
+    <soap:Body>
+        <soap:Fault>
+            %s
+            <faultstring>%s</faultstring>%s
+        </soap:Fault>
+    </soap:Body>
+</soap:Envelope>`, envelopeNamespace11, header, f.faultcode11(), escape(f.Reason), wrapDetail("detail", detail))), nil
+}
+
+// faultcode11 renders the SOAP 1.1 faultcode element. Subcodes use the dotted
+// notation (e.g. soap:Client.Authentication).
+func (f *Fault) faultcode11() string {
+	if f.Subcode.Local == "" {
+		return fmt.Sprintf("<faultcode>soap:%s</faultcode>", f.Code)
+	}
+	return fmt.Sprintf("<faultcode>soap:%s.%s</faultcode>", f.Code, escape(f.Subcode.Local))
+}
+
+// subcode12 renders the SOAP 1.2 Subcode element
+func (f *Fault) subcode12() string {
+	if f.Subcode.Local == "" {
+		return ""
+	}
+	if f.Subcode.Space == "" {
+		return fmt.Sprintf(`
+                <env:Subcode><env:Value>%s</env:Value></env:Subcode>`, escape(f.Subcode.Local))
+	}
+	return fmt.Sprintf(`
+                <env:Subcode><env:Value xmlns:sc="%s">sc:%s</env:Value></env:Subcode>`,
+		escape(f.Subcode.Space), escape(f.Subcode.Local))
+}
+
+// renderDetail renders the detail content: text is escaped, other values
+// are marshaled with encoding/xml. The request ID follows the content.
+func (f *Fault) renderDetail() (string, error) {
+	var detail string
+	switch d := f.Detail.(type) {
+	case nil:
+	case string:
+		detail = escape(d)
+	default:
+		data, err := xml.Marshal(d)
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal fault detail: %w", err)
+		}
+		detail = string(data)
+	}
+	if f.RequestID != "" {
+		detail += "<RequestID>" + escape(f.RequestID) + "</RequestID>"
+	}
+	return detail, nil
+}
+
+// wrapDetail wraps rendered detail content in the detail element
+func wrapDetail(element, detail string) string {
+	if detail == "" {
+		return ""
+	}
+	return fmt.Sprintf("\n            <%s>%s</%s>", element, detail, element)
+}
+
+// code12 maps a fault code to its SOAP 1.2 name
+func code12(code Code) string {
+	switch code {
+	case CodeClient:
+		return "Sender"
+	case CodeServer:
+		return "Receiver"
+	}
+	return string(code)
+}
+
+// escape escapes text for use in XML character data and attributes
+func escape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
@@ -0,0 +1,27 @@
+package soapfault
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestHTTPStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		fault  *Fault
+		soap12 bool
+		status int
+	}{
+		{"SOAP 1.1 Client", Client("Invalid input", nil), false, http.StatusInternalServerError},
+		{"SOAP 1.1 Server", Server("Internal error", nil), false, http.StatusInternalServerError},
+		{"SOAP 1.2 Sender", Client("Invalid input", nil), true, http.StatusBadRequest},
+		{"SOAP 1.2 Receiver", Server("Internal error", nil), true, http.StatusInternalServerError},
+		{"SOAP 1.1 override", Server("Server busy", nil).WithHTTPStatus(http.StatusServiceUnavailable), false, http.StatusServiceUnavailable},
+		{"SOAP 1.2 override", Client("Authentication required", nil).WithHTTPStatus(http.StatusUnauthorized), true, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		if status := tt.fault.HTTPStatus(tt.soap12); status != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, status, tt.status)
+		}
+	}
+}
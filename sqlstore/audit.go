@@ -0,0 +1,89 @@
+package sqlstore
+
+import (
+	"context"
+	"soap-server/handler"
+	"strings"
+	"time"
+)
+
+// auditTimeLayout formats the audit times in UTC with a fixed width, so they
+// compare as strings in the order of the times
+const auditTimeLayout = "2006-01-02T15:04:05.000000000Z"
+
+// AuditLog is a handler.AuditLog kept in the database of a store. Records
+// are only ever inserted.
+type AuditLog struct {
+	s *Store
+}
+
+// Audit returns the audit log kept in the database of the store
+func (s *Store) Audit() *AuditLog {
+	return &AuditLog{s: s}
+}
+
+// Ping checks that the database is reachable
+func (l *AuditLog) Ping(ctx context.Context) error {
+	return l.s.Ping(ctx)
+}
+
+// auditColumns are the columns of the audit records, in insertion order
+const auditColumns = "logged_at, request_id, message_id, caller, auth_method, tenant, operation, file_ids, outcome, fault_code"
+
+func (l *AuditLog) Append(ctx context.Context, rec handler.AuditRecord) error {
+	p := l.s.dialect.placeholder
+	_, err := l.s.db.ExecContext(ctx,
+		"INSERT INTO audit_log ("+auditColumns+") VALUES ("+p(1)+", "+p(2)+", "+p(3)+", "+p(4)+", "+p(5)+", "+p(6)+", "+p(7)+", "+p(8)+", "+p(9)+", "+p(10)+")",
+		rec.Time.UTC().Format(auditTimeLayout), rec.RequestID, rec.MessageID, rec.Caller, rec.AuthMethod, rec.Tenant,
+		rec.Operation, strings.Join(rec.FileIDs, ","), rec.Outcome, rec.FaultCode)
+	return err
+}
+
+func (l *AuditLog) Query(ctx context.Context, q handler.AuditQuery) ([]handler.AuditRecord, error) {
+	var conditions []string
+	var args []interface{}
+	if !q.From.IsZero() {
+		args = append(args, q.From.UTC().Format(auditTimeLayout))
+		conditions = append(conditions, "logged_at >= "+l.s.dialect.placeholder(len(args)))
+	}
+	if !q.To.IsZero() {
+		args = append(args, q.To.UTC().Format(auditTimeLayout))
+		conditions = append(conditions, "logged_at <= "+l.s.dialect.placeholder(len(args)))
+	}
+	if q.Caller != "" {
+		args = append(args, q.Caller)
+		conditions = append(conditions, "caller = "+l.s.dialect.placeholder(len(args)))
+	}
+	if q.Operation != "" {
+		args = append(args, q.Operation)
+		conditions = append(conditions, "operation = "+l.s.dialect.placeholder(len(args)))
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	rows, err := l.s.db.QueryContext(ctx, "SELECT "+auditColumns+" FROM audit_log"+where+" ORDER BY logged_at", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []handler.AuditRecord
+	for rows.Next() {
+		var rec handler.AuditRecord
+		var at, fileIDs string
+		if err := rows.Scan(&at, &rec.RequestID, &rec.MessageID, &rec.Caller, &rec.AuthMethod, &rec.Tenant,
+			&rec.Operation, &fileIDs, &rec.Outcome, &rec.FaultCode); err != nil {
+			return nil, err
+		}
+		if rec.Time, err = time.Parse(auditTimeLayout, at); err != nil {
+			return nil, err
+		}
+		if fileIDs != "" {
+			rec.FileIDs = strings.Split(fileIDs, ",")
+		}
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
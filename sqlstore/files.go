@@ -0,0 +1,157 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"soap-server/handler"
+	"strings"
+	"time"
+)
+
+// FileCatalog is a handler.FileCatalog recording the uploaded files in the
+// database of a store
+type FileCatalog struct {
+	s *Store
+}
+
+// Files returns the file catalog kept in the database of the store
+func (s *Store) Files() *FileCatalog {
+	return &FileCatalog{s: s}
+}
+
+// Ping checks that the database is reachable
+func (c *FileCatalog) Ping(ctx context.Context) error {
+	return c.s.Ping(ctx)
+}
+
+// fileSortColumns maps the sort fields of a file query to columns
+var fileSortColumns = map[string]string{
+	"uploadedAt": "uploaded_at",
+	"size":       "size",
+	"name":       "name",
+}
+
+// fileColumns are the columns scanned by scanFile
+const fileColumns = "id, name, stored_name, size, content_type, checksum, checksum_algorithm, uploader, uploaded_at, expires_at, detected_type"
+
+// scanFile reads a row of fileColumns
+func scanFile(row interface{ Scan(...interface{}) error }) (handler.FileRecord, error) {
+	var f handler.FileRecord
+	err := row.Scan(&f.ID, &f.Name, &f.StoredName, &f.Size, &f.ContentType, &f.Checksum, &f.ChecksumAlgorithm, &f.Uploader, &f.UploadedAt, &f.ExpiresAt, &f.DetectedType)
+	if errors.Is(err, sql.ErrNoRows) {
+		return handler.FileRecord{}, handler.ErrFileNotFound
+	}
+	return f, err
+}
+
+func (c *FileCatalog) Add(ctx context.Context, f handler.FileRecord) error {
+	p := c.s.dialect.placeholder
+	_, err := c.s.db.ExecContext(ctx,
+		"INSERT INTO files ("+fileColumns+") VALUES ("+p(1)+", "+p(2)+", "+p(3)+", "+p(4)+", "+p(5)+", "+p(6)+", "+p(7)+", "+p(8)+", "+p(9)+", "+p(10)+", "+p(11)+")"+
+			" ON CONFLICT (id) DO UPDATE SET name = excluded.name, stored_name = excluded.stored_name, size = excluded.size,"+
+			" content_type = excluded.content_type, checksum = excluded.checksum, checksum_algorithm = excluded.checksum_algorithm,"+
+			" uploader = excluded.uploader, uploaded_at = excluded.uploaded_at, expires_at = excluded.expires_at,"+
+			" detected_type = excluded.detected_type",
+		f.ID, f.Name, f.StoredName, f.Size, f.ContentType, f.Checksum, f.ChecksumAlgorithm, f.Uploader, f.UploadedAt, f.ExpiresAt, f.DetectedType)
+	return err
+}
+
+func (c *FileCatalog) Get(ctx context.Context, id string) (handler.FileRecord, error) {
+	return scanFile(c.s.db.QueryRowContext(ctx,
+		"SELECT "+fileColumns+" FROM files WHERE id = "+c.s.dialect.placeholder(1), id))
+}
+
+func (c *FileCatalog) Update(ctx context.Context, id string, fn func(*handler.FileRecord) error) (handler.FileRecord, error) {
+	tx, err := c.s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return handler.FileRecord{}, err
+	}
+	defer tx.Rollback()
+
+	// Postgres locks the row; SQLite serializes transactions on its single
+	// connection
+	p := c.s.dialect.placeholder
+	query := "SELECT " + fileColumns + " FROM files WHERE id = " + p(1)
+	if c.s.dialect == Postgres {
+		query += " FOR UPDATE"
+	}
+	f, err := scanFile(tx.QueryRowContext(ctx, query, id))
+	if err != nil {
+		return handler.FileRecord{}, err
+	}
+
+	if err := fn(&f); err != nil {
+		return handler.FileRecord{}, err
+	}
+	f.ID = id
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE files SET name = "+p(1)+", stored_name = "+p(2)+", size = "+p(3)+", content_type = "+p(4)+", checksum = "+p(5)+
+			", checksum_algorithm = "+p(6)+", uploader = "+p(7)+", uploaded_at = "+p(8)+", expires_at = "+p(9)+
+			", detected_type = "+p(10)+" WHERE id = "+p(11),
+		f.Name, f.StoredName, f.Size, f.ContentType, f.Checksum, f.ChecksumAlgorithm, f.Uploader, f.UploadedAt, f.ExpiresAt, f.DetectedType, id); err != nil {
+		return handler.FileRecord{}, err
+	}
+	return f, tx.Commit()
+}
+
+func (c *FileCatalog) Delete(ctx context.Context, id string) error {
+	result, err := c.s.db.ExecContext(ctx, "DELETE FROM files WHERE id = "+c.s.dialect.placeholder(1), id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return handler.ErrFileNotFound
+	}
+	return err
+}
+
+func (c *FileCatalog) List(ctx context.Context, q handler.FileQuery) ([]handler.FileRecord, error) {
+	column, ok := fileSortColumns[q.SortBy]
+	if !ok {
+		column = "uploaded_at"
+	}
+	direction := "ASC"
+	if q.Descending {
+		direction = "DESC"
+	}
+
+	var conditions []string
+	var args []interface{}
+	if !q.ExpiredBy.IsZero() {
+		// Expiration times are RFC 3339 in UTC and compare as strings
+		args = append(args, q.ExpiredBy.UTC().Format(time.RFC3339))
+		conditions = append(conditions, "expires_at <> '' AND expires_at <= "+c.s.dialect.placeholder(len(args)))
+	}
+	if q.Uploader != "" {
+		args = append(args, q.Uploader)
+		conditions = append(conditions, "uploader = "+c.s.dialect.placeholder(len(args)))
+	}
+	if q.Checksum != "" {
+		args = append(args, q.Checksum, q.ChecksumAlgorithm)
+		conditions = append(conditions, "checksum = "+c.s.dialect.placeholder(len(args)-1)+
+			" AND checksum_algorithm = "+c.s.dialect.placeholder(len(args)))
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	rows, err := c.s.db.QueryContext(ctx,
+		fmt.Sprintf("SELECT %s FROM files%s ORDER BY %s %s, id %s", fileColumns, where, column, direction, direction), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []handler.FileRecord
+	for rows.Next() {
+		f, err := scanFile(rows)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
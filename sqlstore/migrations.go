@@ -0,0 +1,102 @@
+package sqlstore
+
+import (
+	"context"
+	"fmt"
+)
+
+// migrations upgrade the schema one version at a time. Applied migrations
+// are recorded in schema_migrations; new migrations are only ever appended.
+var migrations = []string{
+	// 1: users table
+	`CREATE TABLE users (
+		id         TEXT PRIMARY KEY,
+		name       TEXT NOT NULL,
+		email      TEXT NOT NULL,
+		created_at TEXT NOT NULL
+	)`,
+	// 2, 3: audit and soft delete timestamps
+	`ALTER TABLE users ADD COLUMN updated_at TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE users ADD COLUMN deleted_at TEXT NOT NULL DEFAULT ''`,
+	// 4: roles, comma-separated
+	`ALTER TABLE users ADD COLUMN roles TEXT NOT NULL DEFAULT ''`,
+	// 5: localized names, a JSON object by language tag
+	`ALTER TABLE users ADD COLUMN names TEXT NOT NULL DEFAULT ''`,
+	// 6: catalog of the uploaded files
+	`CREATE TABLE files (
+		id           TEXT PRIMARY KEY,
+		name         TEXT NOT NULL,
+		stored_name  TEXT NOT NULL,
+		size         BIGINT NOT NULL,
+		content_type TEXT NOT NULL,
+		checksum     TEXT NOT NULL,
+		uploader     TEXT NOT NULL DEFAULT '',
+		uploaded_at  TEXT NOT NULL
+	)`,
+	// 7: hash of the file checksums, recorded per file
+	`ALTER TABLE files ADD COLUMN checksum_algorithm TEXT NOT NULL DEFAULT 'sha256'`,
+	// 8: expiration time of the files, empty if they are kept
+	`ALTER TABLE files ADD COLUMN expires_at TEXT NOT NULL DEFAULT ''`,
+	// 9: reference counts of deduplicated files, unused since each upload has
+	// its own record
+	`ALTER TABLE files ADD COLUMN refs INTEGER NOT NULL DEFAULT 1`,
+	// 10: lookup of files by content
+	`CREATE INDEX files_checksum ON files (checksum)`,
+	// 11: media type detected from the file content
+	`ALTER TABLE files ADD COLUMN detected_type TEXT NOT NULL DEFAULT ''`,
+	// 12: storage usage per client for quotas
+	`CREATE INDEX files_uploader ON files (uploader)`,
+	// 13: audit log of the operation invocations, file IDs comma-separated
+	`CREATE TABLE audit_log (
+		logged_at   TEXT NOT NULL,
+		request_id  TEXT NOT NULL,
+		message_id  TEXT NOT NULL DEFAULT '',
+		caller      TEXT NOT NULL DEFAULT '',
+		auth_method TEXT NOT NULL DEFAULT '',
+		tenant      TEXT NOT NULL DEFAULT '',
+		operation   TEXT NOT NULL,
+		file_ids    TEXT NOT NULL DEFAULT '',
+		outcome     TEXT NOT NULL,
+		fault_code  TEXT NOT NULL DEFAULT ''
+	)`,
+	// 14: export of the audit log by time range
+	`CREATE INDEX audit_log_logged_at ON audit_log (logged_at)`,
+}
+
+// migrate applies the migrations that have not been applied yet, each in
+// its own transaction
+func (s *Store) migrate(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx,
+		"CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)"); err != nil {
+		return err
+	}
+
+	var current int
+	if err := s.db.QueryRowContext(ctx,
+		"SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&current); err != nil {
+		return err
+	}
+	if current > len(migrations) {
+		return fmt.Errorf("database schema version %d is newer than this server (%d)", current, len(migrations))
+	}
+
+	for version := current + 1; version <= len(migrations); version++ {
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, migrations[version-1]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", version, err)
+		}
+		if _, err := tx.ExecContext(ctx,
+			"INSERT INTO schema_migrations (version) VALUES ("+s.dialect.placeholder(1)+")", version); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", version, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("migration %d: %w", version, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// PoolConfig sizes the connection pool of a database store. Zero values
+// keep the defaults.
+type PoolConfig struct {
+	MaxOpenConns    int           // Defaults to 10
+	MaxIdleConns    int           // Defaults to MaxOpenConns / 2
+	ConnMaxLifetime time.Duration // Defaults to 30 minutes
+}
+
+// withDefaults returns a copy of the config with empty fields defaulted
+func (c PoolConfig) withDefaults() PoolConfig {
+	if c.MaxOpenConns <= 0 {
+		c.MaxOpenConns = 10
+	}
+	if c.MaxIdleConns <= 0 {
+		c.MaxIdleConns = c.MaxOpenConns / 2
+	}
+	if c.ConnMaxLifetime <= 0 {
+		c.ConnMaxLifetime = 30 * time.Minute
+	}
+	return c
+}
+
+// OpenPostgres connects to the PostgreSQL database identified by dsn (a
+// postgres:// URL or key=value connection string) and returns a store on it
+func OpenPostgres(ctx context.Context, dsn string, pool PoolConfig) (*Store, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	pool = pool.withDefaults()
+	db.SetMaxOpenConns(pool.MaxOpenConns)
+	db.SetMaxIdleConns(pool.MaxIdleConns)
+	db.SetConnMaxLifetime(pool.ConnMaxLifetime)
+
+	// Fail at startup rather than on the first request
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	store, err := New(ctx, db, Postgres)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
@@ -0,0 +1,29 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"net/url"
+
+	_ "modernc.org/sqlite"
+)
+
+// OpenSQLite opens or creates the SQLite database file at path and returns
+// a store on it
+func OpenSQLite(ctx context.Context, path string) (*Store, error) {
+	// Writers wait for locks instead of failing with SQLITE_BUSY
+	dsn := "file:" + (&url.URL{Path: path}).EscapedPath() + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer; one connection serializes access
+	db.SetMaxOpenConns(1)
+
+	store, err := New(ctx, db, SQLite)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
@@ -0,0 +1,252 @@
+// Package sqlstore implements the user store of the user service and the
+// catalog of uploaded files on top of database/sql. The schema is created and upgraded by the migrations in
+// migrations.go when a store is opened.
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"soap-server/handler"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Dialect selects the SQL flavour of the database
+type Dialect int
+
+const (
+	// SQLite is the dialect of SQLite databases
+	SQLite Dialect = iota + 1
+	// Postgres is the dialect of PostgreSQL databases
+	Postgres
+)
+
+// placeholder returns the bind parameter for the n-th argument (1-based)
+func (d Dialect) placeholder(n int) string {
+	if d == Postgres {
+		return "$" + strconv.Itoa(n)
+	}
+	return "?"
+}
+
+// Store is a handler.UserStore persisting users in a SQL database
+type Store struct {
+	db      *sql.DB
+	dialect Dialect
+}
+
+// New creates a store on an open database and applies pending migrations
+func New(ctx context.Context, db *sql.DB, dialect Dialect) (*Store, error) {
+	s := &Store{db: db, dialect: dialect}
+	if err := s.migrate(ctx); err != nil {
+		return nil, fmt.Errorf("migrate user store: %w", err)
+	}
+	return s, nil
+}
+
+// Ping checks that the database is reachable
+func (s *Store) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// Close closes the underlying database
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// sortColumns maps the sort fields of a query to columns
+var sortColumns = map[string]string{
+	"id":        "id",
+	"name":      "name",
+	"email":     "email",
+	"createdAt": "created_at",
+}
+
+// userColumns are the columns scanned by scanUser
+const userColumns = "id, name, email, created_at, updated_at, deleted_at, roles, names"
+
+// scanUser reads a row of userColumns
+func scanUser(row interface{ Scan(...interface{}) error }) (handler.User, error) {
+	var u handler.User
+	var roles, names string
+	err := row.Scan(&u.ID, &u.Name, &u.Email, &u.CreatedAt, &u.UpdatedAt, &u.DeletedAt, &roles, &names)
+	if errors.Is(err, sql.ErrNoRows) {
+		return handler.User{}, handler.ErrUserNotFound
+	}
+	if err != nil {
+		return u, err
+	}
+	if roles != "" {
+		u.Roles = strings.Split(roles, ",")
+	}
+	if names != "" {
+		if err := json.Unmarshal([]byte(names), &u.Names); err != nil {
+			return u, fmt.Errorf("user %s: invalid names: %w", u.ID, err)
+		}
+	}
+	return u, nil
+}
+
+// encodeNames returns the names column of localized names
+func encodeNames(names map[string]string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(names)
+	return string(data)
+}
+
+func (s *Store) Get(ctx context.Context, id string) (handler.User, error) {
+	return scanUser(s.db.QueryRowContext(ctx,
+		"SELECT "+userColumns+" FROM users WHERE id = "+s.dialect.placeholder(1)+" AND deleted_at = ''", id))
+}
+
+func (s *Store) Put(ctx context.Context, u handler.User) error {
+	p := s.dialect.placeholder
+	_, err := s.db.ExecContext(ctx,
+		"INSERT INTO users ("+userColumns+") VALUES ("+p(1)+", "+p(2)+", "+p(3)+", "+p(4)+", "+p(5)+", "+p(6)+", "+p(7)+", "+p(8)+")"+
+			" ON CONFLICT (id) DO UPDATE SET name = excluded.name, email = excluded.email, created_at = excluded.created_at,"+
+			" updated_at = excluded.updated_at, deleted_at = excluded.deleted_at, roles = excluded.roles, names = excluded.names",
+		u.ID, u.Name, u.Email, u.CreatedAt, u.UpdatedAt, u.DeletedAt, strings.Join(u.Roles, ","), encodeNames(u.Names))
+	return err
+}
+
+func (s *Store) Create(ctx context.Context, u handler.User) error {
+	p := s.dialect.placeholder
+	result, err := s.db.ExecContext(ctx,
+		"INSERT INTO users ("+userColumns+") VALUES ("+p(1)+", "+p(2)+", "+p(3)+", "+p(4)+", "+p(5)+", "+p(6)+", "+p(7)+", "+p(8)+")"+
+			" ON CONFLICT (id) DO NOTHING",
+		u.ID, u.Name, u.Email, u.CreatedAt, u.UpdatedAt, u.DeletedAt, strings.Join(u.Roles, ","), encodeNames(u.Names))
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return handler.ErrUserExists
+	}
+	return err
+}
+
+func (s *Store) Update(ctx context.Context, id string, fn func(*handler.User) error) (handler.User, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return handler.User{}, err
+	}
+	defer tx.Rollback()
+
+	// Postgres locks the row; SQLite serializes transactions on its single
+	// connection
+	query := "SELECT " + userColumns + " FROM users WHERE id = " + s.dialect.placeholder(1) + " AND deleted_at = ''"
+	if s.dialect == Postgres {
+		query += " FOR UPDATE"
+	}
+	u, err := scanUser(tx.QueryRowContext(ctx, query, id))
+	if err != nil {
+		return handler.User{}, err
+	}
+
+	if err := fn(&u); err != nil {
+		return handler.User{}, err
+	}
+	u.ID = id
+	u.UpdatedAt = timestamp()
+	p := s.dialect.placeholder
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE users SET name = "+p(1)+", email = "+p(2)+", created_at = "+p(3)+", updated_at = "+p(4)+", roles = "+p(5)+
+			", names = "+p(6)+" WHERE id = "+p(7),
+		u.Name, u.Email, u.CreatedAt, u.UpdatedAt, strings.Join(u.Roles, ","), encodeNames(u.Names), id); err != nil {
+		return handler.User{}, err
+	}
+	return u, tx.Commit()
+}
+
+func (s *Store) Delete(ctx context.Context, id string) error {
+	p := s.dialect.placeholder
+	now := timestamp()
+	result, err := s.db.ExecContext(ctx,
+		"UPDATE users SET deleted_at = "+p(1)+", updated_at = "+p(2)+" WHERE id = "+p(3)+" AND deleted_at = ''",
+		now, now, id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return handler.ErrUserNotFound
+	}
+	return err
+}
+
+func (s *Store) Restore(ctx context.Context, id string) (handler.User, error) {
+	p := s.dialect.placeholder
+	if _, err := s.db.ExecContext(ctx,
+		"UPDATE users SET deleted_at = '', updated_at = "+p(1)+" WHERE id = "+p(2)+" AND deleted_at <> ''",
+		timestamp(), id); err != nil {
+		return handler.User{}, err
+	}
+	return s.Get(ctx, id)
+}
+
+func (s *Store) List(ctx context.Context) ([]handler.User, error) {
+	return s.Search(ctx, handler.UserQuery{})
+}
+
+func (s *Store) Search(ctx context.Context, q handler.UserQuery) ([]handler.User, error) {
+	var where []string
+	var args []interface{}
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, strings.ReplaceAll(condition, "?", s.dialect.placeholder(len(args))))
+	}
+
+	if q.NameContains != "" {
+		add(`lower(name) LIKE ? ESCAPE '\'`, "%"+escapeLike(strings.ToLower(q.NameContains))+"%")
+	}
+	if q.EmailDomain != "" {
+		add(`lower(email) LIKE ? ESCAPE '\'`, "%@"+escapeLike(strings.ToLower(strings.TrimPrefix(q.EmailDomain, "@"))))
+	}
+	if q.CreatedFrom != "" {
+		add("created_at >= ?", q.CreatedFrom)
+	}
+	if q.CreatedTo != "" {
+		add("created_at <= ?", q.CreatedTo)
+	}
+
+	query := "SELECT " + userColumns + " FROM users WHERE " + strings.Join(append(where, "deleted_at = ''"), " AND ")
+	column, ok := sortColumns[q.SortBy]
+	if !ok {
+		column = "id"
+	}
+	direction := "ASC"
+	if q.Descending {
+		direction = "DESC"
+	}
+	query += fmt.Sprintf(" ORDER BY %s %s, id %s", column, direction, direction)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []handler.User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// escapeLike escapes the LIKE wildcards in s
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// timestamp returns the current time in the format of the audit fields
+func timestamp() string {
+	return time.Now().UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,127 @@
+package sqlstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"soap-server/handler"
+	"sync"
+	"testing"
+)
+
+func openTestStore(t *testing.T) *Store {
+	t.Helper()
+	store, err := OpenSQLite(context.Background(), filepath.Join(t.TempDir(), "users.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestSQLiteConcurrentCreate(t *testing.T) {
+	ctx := context.Background()
+	store := openTestStore(t)
+
+	const workers = 8
+	const perWorker = 25
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				id := fmt.Sprintf("u%d-%d", w, i)
+				if err := store.Create(ctx, handler.User{ID: id, Name: id, Email: id + "@example.com"}); err != nil {
+					t.Errorf("Create(%s): %v", id, err)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	users, err := store.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != workers*perWorker {
+		t.Errorf("got %d users, want %d", len(users), workers*perWorker)
+	}
+}
+
+func TestSQLiteConcurrentCreateSameID(t *testing.T) {
+	ctx := context.Background()
+	store := openTestStore(t)
+
+	const workers = 16
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	created := 0
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			err := store.Create(ctx, handler.User{ID: "same", Name: fmt.Sprint(w)})
+			switch {
+			case err == nil:
+				mu.Lock()
+				created++
+				mu.Unlock()
+			case !errors.Is(err, handler.ErrUserExists):
+				t.Errorf("Create: %v", err)
+			}
+		}(w)
+	}
+	wg.Wait()
+	if created != 1 {
+		t.Errorf("%d creates of the same ID succeeded, want 1", created)
+	}
+}
+
+func TestSQLiteConcurrentUpdateGet(t *testing.T) {
+	ctx := context.Background()
+	store := openTestStore(t)
+	if err := store.Create(ctx, handler.User{ID: "1", Name: "Hong", Names: map[string]string{"en": "Hong"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	const workers = 8
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			role := fmt.Sprintf("role%d", w)
+			lang := fmt.Sprintf("x-%d", w)
+			if _, err := store.Update(ctx, "1", func(u *handler.User) error {
+				u.Roles = append(u.Roles, role)
+				if u.Names == nil {
+					u.Names = map[string]string{}
+				}
+				u.Names[lang] = role
+				return nil
+			}); err != nil {
+				t.Errorf("Update: %v", err)
+			}
+		}(w)
+		go func() {
+			defer wg.Done()
+			if _, err := store.Get(ctx, "1"); err != nil {
+				t.Errorf("Get: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	u, err := store.Get(ctx, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(u.Roles) != workers {
+		t.Errorf("got %d roles, want %d: updates were lost", len(u.Roles), workers)
+	}
+	if len(u.Names) != workers+1 {
+		t.Errorf("got %d names, want %d: updates were lost", len(u.Names), workers+1)
+	}
+}
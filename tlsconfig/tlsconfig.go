@@ -0,0 +1,153 @@
+// Package tlsconfig serves HTTPS with a certificate and key from PEM files,
+// optionally reloaded when the files change so renewed certificates are
+// picked up without a restart.
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"os"
+	"soap-server/soap"
+	"sync"
+	"time"
+)
+
+// CipherSuites are the TLS 1.2 cipher suites offered: ECDHE key exchange
+// with AEAD ciphers only. TLS 1.3 suites are not configurable.
+var CipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+}
+
+// ParseVersion parses a minimum TLS version, "1.2" or "1.3"
+func ParseVersion(s string) (uint16, error) {
+	switch s {
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unsupported TLS version %q, expected 1.2 or 1.3", s)
+}
+
+// Keypair is the server certificate loaded from a certificate and a key
+// file
+type Keypair struct {
+	certFile, keyFile string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime [2]time.Time // Of the certificate and the key file when loaded
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// LoadKeypair loads the certificate (followed by its intermediates) and the
+// private key from PEM files
+func LoadKeypair(certFile, keyFile string) (*Keypair, error) {
+	k := &Keypair{certFile: certFile, keyFile: keyFile}
+	if _, err := k.Reload(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Reload loads the files again if either changed since they were loaded
+// and reports whether it did. The current certificate is kept on errors,
+// e.g. while only one of the files has been replaced.
+func (k *Keypair) Reload() (bool, error) {
+	modTime, err := k.modTimes()
+	if err != nil {
+		return false, err
+	}
+	k.mu.RLock()
+	unchanged := k.cert != nil && modTime == k.modTime
+	k.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+	cert, err := tls.LoadX509KeyPair(k.certFile, k.keyFile)
+	if err != nil {
+		return false, err
+	}
+	k.mu.Lock()
+	k.cert, k.modTime = &cert, modTime
+	k.mu.Unlock()
+	return true, nil
+}
+
+func (k *Keypair) modTimes() ([2]time.Time, error) {
+	var modTime [2]time.Time
+	for i, path := range []string{k.certFile, k.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return modTime, err
+		}
+		modTime[i] = info.ModTime()
+	}
+	return modTime, nil
+}
+
+// GetCertificate returns the current certificate, for tls.Config
+func (k *Keypair) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.cert, nil
+}
+
+// Watch starts checking the files for changes every interval until Close
+func (k *Keypair) Watch(interval time.Duration) {
+	k.stop = make(chan struct{})
+	k.wg.Add(1)
+	go k.watch(interval)
+}
+
+// Close stops watching the files
+func (k *Keypair) Close() {
+	if k.stop != nil {
+		close(k.stop)
+		k.wg.Wait()
+	}
+}
+
+func (k *Keypair) watch(interval time.Duration) {
+	defer k.wg.Done()
+
+	ctx := context.Background()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-k.stop:
+			return
+		case <-ticker.C:
+		}
+		reloaded, err := k.Reload()
+		if err != nil {
+			soap.Error(ctx, "TLS certificate reload failed", soap.LogKeyError, err)
+		} else if reloaded {
+			soap.Info(ctx, "TLS certificate reloaded", "file", k.certFile)
+		}
+	}
+}
+
+// ServerConfig returns the TLS configuration of the server with the
+// certificate of the keypair, accepting minVersion (TLS 1.2 if zero) and
+// later with the modern cipher suites only
+func ServerConfig(k *Keypair, minVersion uint16) *tls.Config {
+	if minVersion == 0 {
+		minVersion = tls.VersionTLS12
+	}
+	return &tls.Config{
+		MinVersion:       minVersion,
+		CipherSuites:     CipherSuites,
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384},
+		GetCertificate:   k.GetCertificate,
+	}
+}
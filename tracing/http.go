@@ -0,0 +1,107 @@
+package tracing
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// Handler records a server span for each request served by next,
+// continuing the trace of the traceparent header of the request
+func Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if Default() == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, span := Start(Extract(r.Context(), r.Header), r.Method, KindServer,
+			String("http.request.method", r.Method),
+			String("url.path", r.URL.Path),
+			String("client.address", r.RemoteAddr),
+			String("user_agent.original", r.UserAgent()),
+		)
+		defer span.End()
+
+		rw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(rw, r.WithContext(ctx))
+		if rw.status == 0 {
+			rw.status = http.StatusOK
+		}
+		span.SetAttributes(Int64("http.response.status_code", int64(rw.status)))
+		if rw.status >= http.StatusInternalServerError {
+			span.SetError(httpError(rw.status))
+		}
+	})
+}
+
+// statusWriter records the status of a response
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Transport returns an http.RoundTripper recording a client span for each
+// request sent by base, http.DefaultTransport if nil, and propagating the
+// trace in the traceparent header. The span ends once the response headers
+// are received.
+func Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{base: base}
+}
+
+type transport struct {
+	base http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := Start(req.Context(), req.Method, KindClient,
+		String("http.request.method", req.Method),
+		String("server.address", req.URL.Host),
+		String("url.full", req.URL.Scheme+"://"+req.URL.Host+req.URL.EscapedPath()),
+	)
+	if span == nil {
+		return t.base.RoundTrip(req)
+	}
+	defer span.End()
+
+	// A RoundTripper must not modify the request
+	req = req.Clone(ctx)
+	Inject(ctx, req.Header)
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		span.SetError(err)
+		return nil, err
+	}
+	span.SetAttributes(Int64("http.response.status_code", int64(resp.StatusCode)))
+	if resp.StatusCode >= http.StatusBadRequest {
+		span.SetError(httpError(resp.StatusCode))
+	}
+	return resp, nil
+}
+
+// httpError is the error of a span ended by an HTTP error status
+type httpError int
+
+func (e httpError) Error() string {
+	return "HTTP " + strconv.Itoa(int(e)) + " " + http.StatusText(int(e))
+}
@@ -0,0 +1,77 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+const (
+	testTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	testSpanID  = "00f067aa0ba902b7"
+)
+
+func TestExtractTraceparent(t *testing.T) {
+	tests := []struct {
+		name        string
+		traceparent string
+		valid       bool
+		sampled     bool
+	}{
+		{"sampled", "00-" + testTraceID + "-" + testSpanID + "-01", true, true},
+		{"not sampled", "00-" + testTraceID + "-" + testSpanID + "-00", true, false},
+		{"later version with more fields", "cc-" + testTraceID + "-" + testSpanID + "-01-future", true, true},
+		{"version 00 with more fields", "00-" + testTraceID + "-" + testSpanID + "-01-future", false, false},
+		{"version ff", "ff-" + testTraceID + "-" + testSpanID + "-01", false, false},
+		{"uppercase", "00-4BF92F3577B34DA6A3CE929D0E0E4736-" + testSpanID + "-01", false, false},
+		{"short trace ID", "00-" + testTraceID[2:] + "-" + testSpanID + "-01", false, false},
+		{"short span ID", "00-" + testTraceID + "-" + testSpanID[2:] + "-01", false, false},
+		{"zero trace ID", "00-00000000000000000000000000000000-" + testSpanID + "-01", false, false},
+		{"zero span ID", "00-" + testTraceID + "-0000000000000000-01", false, false},
+		{"not hex", "00-" + testTraceID[:31] + "g-" + testSpanID + "-01", false, false},
+		{"missing flags", "00-" + testTraceID + "-" + testSpanID, false, false},
+		{"empty", "", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			header.Set("traceparent", tt.traceparent)
+			sc := SpanContextFromContext(Extract(context.Background(), header))
+			if sc.Valid() != tt.valid {
+				t.Fatalf("%q parsed as valid = %v, want %v", tt.traceparent, sc.Valid(), tt.valid)
+			}
+			if !tt.valid {
+				return
+			}
+			if sc.TraceID.String() != testTraceID || sc.SpanID.String() != testSpanID {
+				t.Errorf("%q parsed as trace %s span %s", tt.traceparent, sc.TraceID, sc.SpanID)
+			}
+			if sc.Sampled != tt.sampled {
+				t.Errorf("%q parsed as sampled = %v, want %v", tt.traceparent, sc.Sampled, tt.sampled)
+			}
+		})
+	}
+}
+
+func TestInjectPassesOnTraceContext(t *testing.T) {
+	in := http.Header{}
+	in.Set("traceparent", "00-"+testTraceID+"-"+testSpanID+"-01")
+	in.Set("tracestate", "vendor=value")
+
+	out := http.Header{}
+	Inject(Extract(context.Background(), in), out)
+	if got := out.Get("traceparent"); got != in.Get("traceparent") {
+		t.Errorf("traceparent = %q, want %q", got, in.Get("traceparent"))
+	}
+	if got := out.Get("tracestate"); got != "vendor=value" {
+		t.Errorf("tracestate = %q, want vendor=value", got)
+	}
+}
+
+func TestInjectWithoutSpan(t *testing.T) {
+	header := http.Header{}
+	Inject(context.Background(), header)
+	if len(header) != 0 {
+		t.Errorf("headers set without a span: %v", header)
+	}
+}
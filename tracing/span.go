@@ -0,0 +1,177 @@
+// Package tracing records OpenTelemetry spans of the requests and of the
+// calls made while serving them, and exports them to a collector with the
+// OTLP/HTTP protocol of the OpenTelemetry SDK. Trace context is propagated
+// in W3C traceparent headers. Without a default Tracer, spans are not
+// recorded and the functions of the package do nothing.
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Kind is the role of a span in a trace, as numbered by OTLP
+type Kind int
+
+// Span kinds
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+)
+
+// TraceID identifies a trace
+type TraceID [16]byte
+
+// SpanID identifies a span within a trace
+type SpanID [8]byte
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+func (id SpanID) String() string  { return hex.EncodeToString(id[:]) }
+
+// SpanContext is the part of a span propagated to its children, within the
+// process or to other services
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Sampled    bool   // The trace is recorded
+	TraceState string // Vendor specific tracestate header, passed on as is
+}
+
+// Valid reports whether the span context identifies a span
+func (sc SpanContext) Valid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// fromOTel converts the span context of the SDK
+func fromOTel(sc trace.SpanContext) SpanContext {
+	return SpanContext{
+		TraceID:    TraceID(sc.TraceID()),
+		SpanID:     SpanID(sc.SpanID()),
+		Sampled:    sc.IsSampled(),
+		TraceState: sc.TraceState().String(),
+	}
+}
+
+// otel converts the span context to the one of the SDK. An invalid
+// tracestate is dropped.
+func (sc SpanContext) otel() trace.SpanContext {
+	state, _ := trace.ParseTraceState(sc.TraceState)
+	var flags trace.TraceFlags
+	if sc.Sampled {
+		flags = trace.FlagsSampled
+	}
+	return trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID(sc.TraceID),
+		SpanID:     trace.SpanID(sc.SpanID),
+		TraceFlags: flags,
+		TraceState: state,
+	})
+}
+
+// Attr is an attribute of a span
+type Attr = attribute.KeyValue
+
+// String returns a string attribute
+func String(key, value string) Attr { return attribute.String(key, value) }
+
+// Int64 returns an integer attribute
+func Int64(key string, value int64) Attr { return attribute.Int64(key, value) }
+
+// Bool returns a boolean attribute
+func Bool(key string, value bool) Attr { return attribute.Bool(key, value) }
+
+// Span is an operation of a trace. A nil Span, as returned without a
+// default Tracer, ignores all calls.
+type Span struct {
+	span trace.Span
+}
+
+var defaultTracer atomic.Value // *Tracer
+
+// SetDefault makes t the tracer of the spans started by Start. A nil
+// tracer disables tracing.
+func SetDefault(t *Tracer) {
+	defaultTracer.Store(t)
+}
+
+// Default returns the tracer set by SetDefault, or nil
+func Default() *Tracer {
+	t, _ := defaultTracer.Load().(*Tracer)
+	return t
+}
+
+// Start starts a span as a child of the span in ctx, or of the remote span
+// context in ctx, or else as the root of a new trace. The returned context
+// carries the span; End must be called once it is done.
+func Start(ctx context.Context, name string, kind Kind, attrs ...Attr) (context.Context, *Span) {
+	t := Default()
+	if t == nil {
+		return ctx, nil
+	}
+	ctx, span := t.tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKind(kind)), trace.WithAttributes(attrs...))
+	return ctx, &Span{span: span}
+}
+
+// SpanFromContext returns the span recorded for ctx, or nil
+func SpanFromContext(ctx context.Context) *Span {
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return nil
+	}
+	return &Span{span: span}
+}
+
+// SpanContextFromContext returns the context of the span carried by ctx,
+// or of the remote span it was given by ContextWithSpanContext
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	return fromOTel(trace.SpanContextFromContext(ctx))
+}
+
+// ContextWithSpanContext returns a copy of ctx whose spans are children of
+// the span context, e.g. one received from another service or one saved
+// for work done after the request
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	if !sc.Valid() {
+		return ctx
+	}
+	return trace.ContextWithSpanContext(ctx, sc.otel())
+}
+
+// SpanContext returns the propagated context of the span
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return fromOTel(s.span.SpanContext())
+}
+
+// SetAttributes adds attributes to the span
+func (s *Span) SetAttributes(attrs ...Attr) {
+	if s == nil {
+		return
+	}
+	s.span.SetAttributes(attrs...)
+}
+
+// SetError marks the span as failed with the error
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+// End ends the span and queues it for export if its trace is sampled.
+// Calls after the first are ignored.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.span.End()
+}
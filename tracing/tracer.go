@@ -0,0 +1,148 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Defaults of the export
+const (
+	DefaultServiceName = "soap-server"
+	DefaultBatchSize   = 512
+	DefaultInterval    = 5 * time.Second
+	queueSize          = 4096
+	exportTimeout      = 10 * time.Second
+)
+
+// Config configures the tracer and its OTLP exporter
+type Config struct {
+	// Endpoint is the base URL of the OTLP/HTTP receiver, e.g.
+	// http://otel-collector:4318; spans are posted to /v1/traces below it
+	// unless the URL has a path
+	Endpoint string
+
+	Headers     map[string]string // Sent with every export, e.g. for authentication
+	ServiceName string            // service.name of the resource, default DefaultServiceName
+
+	// SampleRatio is the share of new traces recorded, between 0 and 1.
+	// Traces continued from other services follow their sampling decision.
+	SampleRatio float64
+
+	BatchSize int           // Spans per export, default DefaultBatchSize
+	Interval  time.Duration // Longest delay of a span, default DefaultInterval
+}
+
+// Tracer records the spans of sampled traces with the OpenTelemetry SDK,
+// which exports them in batches. Spans are dropped if the queue is full, so
+// a slow collector does not slow down the requests.
+type Tracer struct {
+	cfg      Config
+	endpoint string
+	provider *sdktrace.TracerProvider
+	tracer   trace.Tracer
+}
+
+// New returns a tracer exporting to the configured endpoint and starts its
+// exporter
+func New(cfg Config) (*Tracer, error) {
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q", cfg.Endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/traces"
+	}
+	if cfg.SampleRatio < 0 || cfg.SampleRatio > 1 {
+		return nil, errors.New("sample ratio must be between 0 and 1")
+	}
+	if cfg.ServiceName == "" {
+		cfg.ServiceName = DefaultServiceName
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultBatchSize
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultInterval
+	}
+
+	exporter, err := otlptracehttp.New(context.Background(),
+		otlptracehttp.WithEndpointURL(u.String()),
+		otlptracehttp.WithHeaders(cfg.Headers),
+		otlptracehttp.WithTimeout(exportTimeout),
+	)
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter,
+			sdktrace.WithMaxQueueSize(queueSize),
+			sdktrace.WithMaxExportBatchSize(cfg.BatchSize),
+			sdktrace.WithBatchTimeout(cfg.Interval),
+			sdktrace.WithExportTimeout(exportTimeout),
+		),
+		sdktrace.WithSampler(sampler(cfg.SampleRatio)),
+		sdktrace.WithResource(resource.NewSchemaless(String("service.name", cfg.ServiceName))),
+	)
+	// The SDK reports failed exports to the global handler
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		slog.Warn("Failed to export spans", "error", err)
+	}))
+	return &Tracer{
+		cfg:      cfg,
+		endpoint: u.String(),
+		provider: provider,
+		tracer:   provider.Tracer(DefaultServiceName),
+	}, nil
+}
+
+// sampler samples the given ratio of new traces and follows the decision
+// of the parent span otherwise
+func sampler(ratio float64) sdktrace.Sampler {
+	return sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))
+}
+
+// ParseHeaders parses headers given as comma-separated key=value pairs, as
+// in OTEL_EXPORTER_OTLP_HEADERS. Values may be URL-encoded.
+func ParseHeaders(s string) (map[string]string, error) {
+	headers := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("invalid header %q, want key=value", pair)
+		}
+		if v, err := url.QueryUnescape(strings.TrimSpace(value)); err == nil {
+			value = v
+		}
+		headers[strings.TrimSpace(key)] = value
+	}
+	return headers, nil
+}
+
+// String describes the exporter
+func (t *Tracer) String() string {
+	return fmt.Sprintf("OTLP %s (service %s, sample ratio %g)", t.endpoint, t.cfg.ServiceName, t.cfg.SampleRatio)
+}
+
+// Close exports the queued spans and stops the exporter. Spans ended
+// afterwards are dropped.
+func (t *Tracer) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+	if err := t.provider.Shutdown(ctx); err != nil {
+		slog.Warn("Failed to export spans", "error", err)
+	}
+}
@@ -0,0 +1,122 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// sampledShare returns the share of n new traces sampled at the ratio
+func sampledShare(t *testing.T, ctx context.Context, ratio float64, n int) float64 {
+	t.Helper()
+	s := sampler(ratio)
+	sampled := 0
+	for i := 0; i < n; i++ {
+		var id trace.TraceID
+		rand.Read(id[:])
+		params := sdktrace.SamplingParameters{ParentContext: ctx, TraceID: id, Name: "test"}
+		if s.ShouldSample(params).Decision == sdktrace.RecordAndSample {
+			sampled++
+		}
+	}
+	return float64(sampled) / float64(n)
+}
+
+func TestSampleRatio(t *testing.T) {
+	ctx := context.Background()
+	if share := sampledShare(t, ctx, 0, 1000); share != 0 {
+		t.Errorf("ratio 0 sampled %g of the traces", share)
+	}
+	if share := sampledShare(t, ctx, 1, 1000); share != 1 {
+		t.Errorf("ratio 1 sampled %g of the traces", share)
+	}
+	if share := sampledShare(t, ctx, 0.25, 20000); share < 0.2 || share > 0.3 {
+		t.Errorf("ratio 0.25 sampled %g of the traces", share)
+	}
+}
+
+func TestSamplingFollowsParent(t *testing.T) {
+	for _, flags := range []string{"00", "01"} {
+		header := http.Header{}
+		header.Set("traceparent", "00-"+testTraceID+"-"+testSpanID+"-"+flags)
+		ctx := Extract(context.Background(), header)
+		want := 0.0
+		if flags == "01" {
+			want = 1
+		}
+		for _, ratio := range []float64{0, 1} {
+			if share := sampledShare(t, ctx, ratio, 100); share != want {
+				t.Errorf("flags %s at ratio %g sampled %g of the spans, want %g", flags, ratio, share, want)
+			}
+		}
+	}
+}
+
+func TestTracerExportsSpans(t *testing.T) {
+	var mu sync.Mutex
+	var requests []*http.Request
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		mu.Lock()
+		requests = append(requests, r)
+		mu.Unlock()
+	}))
+	defer collector.Close()
+
+	tracer, err := New(Config{Endpoint: collector.URL, Headers: map[string]string{"Authorization": "Bearer token"}, SampleRatio: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetDefault(tracer)
+	defer SetDefault(nil)
+
+	header := http.Header{}
+	header.Set("traceparent", "00-"+testTraceID+"-"+testSpanID+"-01")
+	ctx, span := Start(Extract(context.Background(), header), "test", KindServer, String("test.key", "value"))
+	if sc := span.SpanContext(); sc.TraceID.String() != testTraceID || !sc.Sampled {
+		t.Errorf("span continues trace %s (sampled %v), want %s", sc.TraceID, sc.Sampled, testTraceID)
+	}
+	if SpanFromContext(ctx) == nil {
+		t.Error("no span recorded for the context")
+	}
+	span.End()
+	tracer.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requests) != 1 {
+		t.Fatalf("%d exports, want 1", len(requests))
+	}
+	if r := requests[0]; r.URL.Path != "/v1/traces" || r.Header.Get("Authorization") != "Bearer token" {
+		t.Errorf("spans posted to %s with Authorization %q", r.URL.Path, r.Header.Get("Authorization"))
+	}
+}
+
+func TestStartWithoutTracer(t *testing.T) {
+	ctx, span := Start(context.Background(), "test", KindInternal)
+	if span != nil || SpanFromContext(ctx) != nil {
+		t.Error("span recorded without a tracer")
+	}
+	span.SetAttributes(Bool("ignored", true))
+	span.End()
+}
+
+func TestParseHeaders(t *testing.T) {
+	headers, err := ParseHeaders("Authorization=Bearer%20token, X-Tenant = a=b ,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if headers["Authorization"] != "Bearer token" || headers["X-Tenant"] != "a=b" || len(headers) != 2 {
+		t.Errorf("headers = %v", headers)
+	}
+	if _, err := ParseHeaders("missing-value"); err == nil {
+		t.Error("header without value accepted")
+	}
+}
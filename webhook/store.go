@@ -0,0 +1,64 @@
+package webhook
+
+import (
+	"context"
+	"soap-server/handler"
+)
+
+// Store wraps a user store so that successful changes are notified. Reads
+// pass through to the wrapped store.
+func (n *Notifier) Store(users handler.UserStore) handler.UserStore {
+	return &notifyingStore{UserStore: users, notifier: n}
+}
+
+type notifyingStore struct {
+	handler.UserStore
+	notifier *Notifier
+}
+
+func (s *notifyingStore) Put(ctx context.Context, user handler.User) error {
+	// Put creates or replaces; the event tells which
+	eventType := UserCreated
+	if _, err := s.UserStore.Get(ctx, user.ID); err == nil {
+		eventType = UserUpdated
+	}
+	if err := s.UserStore.Put(ctx, user); err != nil {
+		return err
+	}
+	s.notifier.Notify(ctx, eventType, user)
+	return nil
+}
+
+func (s *notifyingStore) Create(ctx context.Context, user handler.User) error {
+	if err := s.UserStore.Create(ctx, user); err != nil {
+		return err
+	}
+	s.notifier.Notify(ctx, UserCreated, user)
+	return nil
+}
+
+func (s *notifyingStore) Update(ctx context.Context, id string, fn func(*handler.User) error) (handler.User, error) {
+	user, err := s.UserStore.Update(ctx, id, fn)
+	if err != nil {
+		return user, err
+	}
+	s.notifier.Notify(ctx, UserUpdated, user)
+	return user, nil
+}
+
+func (s *notifyingStore) Delete(ctx context.Context, id string) error {
+	if err := s.UserStore.Delete(ctx, id); err != nil {
+		return err
+	}
+	s.notifier.Notify(ctx, UserDeleted, handler.User{ID: id})
+	return nil
+}
+
+func (s *notifyingStore) Restore(ctx context.Context, id string) (handler.User, error) {
+	user, err := s.UserStore.Restore(ctx, id)
+	if err != nil {
+		return user, err
+	}
+	s.notifier.Notify(ctx, UserRestored, user)
+	return user, nil
+}
@@ -0,0 +1,200 @@
+// Package webhook notifies HTTP endpoints of changes to the users of the
+// user service. Events are delivered as JSON POST requests in the order
+// they occurred, retried with exponential backoff and, when a secret is
+// configured, signed with HMAC-SHA256 in the X-Webhook-Signature header
+// ("sha256=" followed by the hex encoded HMAC of the request body).
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"soap-server/handler"
+	"soap-server/soap"
+	"soap-server/tracing"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Event types
+const (
+	UserCreated  = "user.created"
+	UserUpdated  = "user.updated"
+	UserDeleted  = "user.deleted" // The user carries the ID only
+	UserRestored = "user.restored"
+)
+
+// Event is the body of a webhook request
+type Event struct {
+	ID   string       `json:"id"`
+	Type string       `json:"type"`
+	Time string       `json:"time"` // RFC 3339
+	User handler.User `json:"user"`
+
+	trace tracing.SpanContext // Span of the change, the parent of the delivery
+}
+
+// Config configures the webhook endpoints and the delivery
+type Config struct {
+	URLs        []string      // Endpoints receiving every event
+	Secret      string        // HMAC key of the signature; unsigned if empty
+	MaxAttempts int           // Deliveries per event and endpoint, default 5
+	Backoff     time.Duration // Delay before the first retry, doubled after each, default 1s
+	Timeout     time.Duration // Timeout of a delivery request, default 10s
+	QueueSize   int           // Events buffered per endpoint, default 1000
+}
+
+// withDefaults returns a copy of the config with empty fields defaulted
+func (c Config) withDefaults() Config {
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = 5
+	}
+	if c.Backoff <= 0 {
+		c.Backoff = time.Second
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 10 * time.Second
+	}
+	if c.QueueSize <= 0 {
+		c.QueueSize = 1000
+	}
+	return c
+}
+
+// Notifier delivers events to the configured endpoints. Each endpoint has
+// its own queue and worker, so a slow endpoint does not delay the others.
+type Notifier struct {
+	cfg    Config
+	client *http.Client
+	queues []chan Event
+	wg     sync.WaitGroup
+}
+
+// New creates a notifier and starts its delivery workers
+func New(cfg Config) *Notifier {
+	cfg = cfg.withDefaults()
+	n := &Notifier{cfg: cfg, client: &http.Client{Timeout: cfg.Timeout, Transport: tracing.Transport(nil)}}
+	for _, url := range cfg.URLs {
+		queue := make(chan Event, cfg.QueueSize)
+		n.queues = append(n.queues, queue)
+		n.wg.Add(1)
+		go n.deliverAll(url, queue)
+	}
+	return n
+}
+
+// Notify queues an event of the given type for delivery. Events are
+// dropped, and logged, when the queue of an endpoint is full. The delivery
+// continues the trace of ctx.
+func (n *Notifier) Notify(ctx context.Context, eventType string, user handler.User) {
+	event := Event{
+		ID:    uuid.New().String(),
+		Type:  eventType,
+		Time:  time.Now().UTC().Format(time.RFC3339),
+		User:  user,
+		trace: tracing.SpanContextFromContext(ctx),
+	}
+	for i, queue := range n.queues {
+		select {
+		case queue <- event:
+		default:
+			soap.Warn(ctx, "Webhook queue full, event dropped", "event_type", event.Type, "event_id", event.ID, "url", n.cfg.URLs[i])
+		}
+	}
+}
+
+// Close stops accepting events and waits until the queued events have been
+// delivered or given up
+func (n *Notifier) Close() {
+	for _, queue := range n.queues {
+		close(queue)
+	}
+	n.wg.Wait()
+}
+
+// deliverAll delivers the events of an endpoint queue in order
+func (n *Notifier) deliverAll(url string, queue <-chan Event) {
+	defer n.wg.Done()
+
+	for event := range queue {
+		n.deliverEvent(url, event)
+	}
+}
+
+// deliverEvent delivers an event to an endpoint, retrying failed deliveries
+func (n *Notifier) deliverEvent(url string, event Event) {
+	ctx := tracing.ContextWithSpanContext(context.Background(), event.trace)
+	ctx, span := tracing.Start(ctx, "webhook "+event.Type, tracing.KindInternal,
+		tracing.String("webhook.event_id", event.ID), tracing.String("webhook.url", url))
+	defer span.End()
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		soap.Error(ctx, "Webhook event could not be encoded", "event_id", event.ID, soap.LogKeyError, err)
+		span.SetError(err)
+		return
+	}
+
+	backoff := n.cfg.Backoff
+	for attempt := 1; ; attempt++ {
+		retry, err := n.deliver(ctx, url, event, body)
+		if err == nil {
+			return
+		}
+		if !retry || attempt == n.cfg.MaxAttempts {
+			soap.Error(ctx, "Webhook delivery failed",
+				"event_type", event.Type, "event_id", event.ID, "url", url, "attempts", attempt, soap.LogKeyError, err)
+			span.SetError(err)
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// deliver sends one event and reports whether a failed delivery should be
+// retried. Network errors, 408, 429 and 5xx responses are retried.
+func (n *Notifier) deliver(ctx context.Context, url string, event Event, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", event.Type)
+	req.Header.Set("X-Webhook-ID", event.ID)
+	if n.cfg.Secret != "" {
+		req.Header.Set("X-Webhook-Signature", Sign(n.cfg.Secret, body))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+		return true, fmt.Errorf("endpoint responded %s", resp.Status)
+	}
+	return false, fmt.Errorf("endpoint responded %s", resp.Status)
+}
+
+// Sign returns the X-Webhook-Signature header value of a request body.
+// Receivers verify a request by computing it over the received body and
+// comparing with hmac.Equal.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
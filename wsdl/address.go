@@ -0,0 +1,52 @@
+package wsdl
+
+import (
+	"net/http"
+	"strings"
+)
+
+// BaseURL returns the scheme and host clients use to reach the server. A
+// configured external base URL takes precedence; otherwise it is derived
+// from the X-Forwarded-Proto and X-Forwarded-Host headers set by reverse
+// proxies, falling back to the request TLS state and Host header.
+func BaseURL(r *http.Request, external string) string {
+	if external != "" {
+		return strings.TrimSuffix(external, "/")
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := firstValue(r.Header.Get("X-Forwarded-Proto")); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+
+	host := r.Host
+	if forwarded := firstValue(r.Header.Get("X-Forwarded-Host")); forwarded != "" {
+		host = forwarded
+	}
+	if host == "" {
+		host = "localhost"
+	}
+
+	return scheme + "://" + host
+}
+
+// resolveAddress returns the endpoint URL for an address that is either
+// absolute or a path relative to the base URL of the request
+func resolveAddress(r *http.Request, address, external string) string {
+	if !strings.HasPrefix(address, "/") {
+		return address
+	}
+	return BaseURL(r, external) + address
+}
+
+// firstValue returns the first entry of a comma separated header value, as
+// sent by chained proxies
+func firstValue(header string) string {
+	if i := strings.IndexByte(header, ','); i >= 0 {
+		header = header[:i]
+	}
+	return strings.ToLower(strings.TrimSpace(header))
+}
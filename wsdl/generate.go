@@ -0,0 +1,275 @@
+package wsdl
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"soap-server/soap"
+	"soap-server/xsd"
+	"strings"
+	"text/template"
+)
+
+//go:embed *.xsd
+var schemas embed.FS
+
+// targetNamespacePattern matches the targetNamespace attribute of a schema
+var targetNamespacePattern = regexp.MustCompile(`targetNamespace="([^"]*)"`)
+
+// Schema returns a bundled XSD document (e.g. "user.xsd") with its target
+// namespace replaced by namespace. Only service namespaces, DefaultNamespace
+// and versions below it such as DefaultNamespace + "/v2", are replaced.
+func Schema(name, namespace string) ([]byte, error) {
+	data, err := schemas.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("schema %s not found: %w", name, err)
+	}
+
+	match := targetNamespacePattern.FindSubmatch(data)
+	if match == nil || namespace == "" {
+		return data, nil
+	}
+	original := string(match[1])
+	if original != namespace && (original == DefaultNamespace || strings.HasPrefix(original, DefaultNamespace+"/")) {
+		data = bytes.ReplaceAll(data, []byte(`"`+original+`"`), []byte(`"`+namespace+`"`))
+	}
+	return data, nil
+}
+
+// Definition describes the contract of one SOAP service endpoint
+type Definition struct {
+	Name       string            // Service name (e.g. "UserService")
+	Namespace  string            // Target namespace of the service
+	Address    string            // Endpoint URL or path advertised in soap:address
+	BaseURL    string            // External base URL for a path Address; derived from the request when empty
+	Schemas    []string          // Bundled XSD documents in <types>; derived from the operation types when empty
+	Import     bool              // Reference the schemas with xsd:import instead of inlining them
+	Policy     Policy            // WS-Policy assertions attached to the binding
+	Operations []*soap.Operation // Operations exposed by the service
+}
+
+// Generate renders the WSDL 1.1 document for the definition
+func Generate(def Definition) ([]byte, error) {
+	var types []string
+	var err error
+	switch {
+	case len(def.Schemas) == 0:
+		if types, err = derivedSchema(def); err != nil {
+			return nil, err
+		}
+	case def.Import:
+		types = []string{importSchema(def)}
+	default:
+		if types, err = inlineSchemas(def); err != nil {
+			return nil, err
+		}
+	}
+
+	// Operations may share fault elements; each needs a single message
+	var faults []string
+	seen := make(map[string]bool)
+	for _, op := range def.Operations {
+		for _, fault := range op.Faults {
+			if !seen[fault] {
+				seen[fault] = true
+				faults = append(faults, fault)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	var policy string
+	if def.Policy.Enabled() {
+		policy = indent(def.Policy.render(def.Name+"SoapBindingPolicy"), "    ")
+	}
+
+	err = definitionTemplate.Execute(&buf, struct {
+		Definition
+		Types         []string
+		FaultMessages []string
+		PolicyElement string
+	}{def, types, faults, policy})
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// inlineSchemas returns the schema elements of the definition schemas
+// without their XML declarations
+func inlineSchemas(def Definition) ([]string, error) {
+	var types []string
+	for _, name := range def.Schemas {
+		data, err := Schema(name, def.Namespace)
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, inlineSchema(data))
+	}
+	return types, nil
+}
+
+// inlineSchema returns the indented schema element of an XSD document
+// without its XML declaration
+func inlineSchema(data []byte) string {
+	schema := string(data)
+	if i := strings.Index(schema, "?>"); i >= 0 {
+		schema = schema[i+2:]
+	}
+	return indent(strings.TrimSpace(schema), "        ")
+}
+
+// DeriveSchema generates an XSD document for the request, response and fault
+// types of the operations
+func DeriveSchema(namespace string, operations []*soap.Operation) ([]byte, error) {
+	var types []reflect.Type
+	for _, op := range operations {
+		if op.RequestType == nil || op.ResponseType == nil {
+			return nil, fmt.Errorf("operation %s has no request or response type", op.Name)
+		}
+		types = append(types, op.RequestType, op.ResponseType)
+		types = append(types, op.FaultTypes...)
+	}
+	return xsd.Generate(namespace, types)
+}
+
+// derivedSchema returns the schema element derived from the operation types
+func derivedSchema(def Definition) ([]string, error) {
+	data, err := DeriveSchema(def.Namespace, def.Operations)
+	if err != nil {
+		return nil, err
+	}
+	return []string{inlineSchema(data)}, nil
+}
+
+// importSchema returns a schema element importing the definition schemas by
+// their relative locations
+func importSchema(def Definition) string {
+	var b strings.Builder
+	b.WriteString("<xsd:schema>")
+	for _, name := range def.Schemas {
+		fmt.Fprintf(&b, "\n            <xsd:import namespace=\"%s\" schemaLocation=\"%s\"/>", def.Namespace, name)
+	}
+	b.WriteString("\n        </xsd:schema>")
+	return b.String()
+}
+
+// ServiceHandler serves the generated WSDL of a service definition. An
+// Address given as a path is resolved against BaseURL or the request, and
+// relative schema locations point at the ?xsd= queries of that address.
+func ServiceHandler(def Definition) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		def := def
+		def.Address = resolveAddress(r, def.Address, def.BaseURL)
+
+		doc, err := Generate(def)
+		if err != nil {
+			http.Error(w, "Failed to generate WSDL: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		serveDocument(w, r, rewriteSchemaLocations(doc, def.Address))
+	}
+}
+
+// indent prefixes every non-empty line of s except the first
+func indent(s, prefix string) string {
+	lines := strings.Split(s, "\n")
+	for i := 1; i < len(lines); i++ {
+		if lines[i] != "" {
+			lines[i] = prefix + lines[i]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+var definitionTemplate = template.Must(template.New("wsdl").Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<definitions xmlns="http://schemas.xmlsoap.org/wsdl/"
+             xmlns:soap="http://schemas.xmlsoap.org/wsdl/soap/"
+             xmlns:tns="{{.Namespace}}"
+             xmlns:xsd="http://www.w3.org/2001/XMLSchema"
+{{- if .PolicyElement}}
+             xmlns:wsp="http://schemas.xmlsoap.org/ws/2004/09/policy"
+             xmlns:sp="http://docs.oasis-open.org/ws-sx/ws-securitypolicy/200702"
+             xmlns:wsu="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-utility-1.0.xsd"
+{{- end}}
+             targetNamespace="{{.Namespace}}">
+{{- if .PolicyElement}}
+
+    <!-- Policy -->
+    {{.PolicyElement}}
+{{- end}}
+
+    <!-- Types -->
+    <types>
+{{- range .Types}}
+        {{.}}
+{{- end}}
+    </types>
+
+    <!-- Messages -->
+{{- range .Operations}}
+
+    <message name="{{.RequestElement}}">
+        <part name="parameters" element="tns:{{.RequestElement}}"/>
+    </message>
+
+    <message name="{{.ResponseElement}}">
+        <part name="parameters" element="tns:{{.ResponseElement}}"/>
+    </message>
+{{- end}}
+{{- range .FaultMessages}}
+
+    <message name="{{.}}">
+        <part name="fault" element="tns:{{.}}"/>
+    </message>
+{{- end}}
+
+    <!-- Port Type -->
+    <portType name="{{.Name}}PortType">
+{{- range .Operations}}
+        <operation name="{{.Name}}">
+            <input message="tns:{{.RequestElement}}"/>
+            <output message="tns:{{.ResponseElement}}"/>
+{{- range .Faults}}
+            <fault name="{{.}}" message="tns:{{.}}"/>
+{{- end}}
+        </operation>
+{{- end}}
+    </portType>
+
+    <!-- Binding -->
+    <binding name="{{.Name}}SoapBinding" type="tns:{{.Name}}PortType">
+{{- if .PolicyElement}}
+        <wsp:PolicyReference URI="#{{.Name}}SoapBindingPolicy"/>
+{{- end}}
+        <soap:binding style="document" transport="http://schemas.xmlsoap.org/soap/http"/>
+{{- range .Operations}}
+        <operation name="{{.Name}}">
+            <soap:operation soapAction="{{.SOAPAction}}"/>
+            <input>
+                <soap:body use="literal"/>
+            </input>
+            <output>
+                <soap:body use="literal"/>
+            </output>
+{{- range .Faults}}
+            <fault name="{{.}}">
+                <soap:fault name="{{.}}" use="literal"/>
+            </fault>
+{{- end}}
+        </operation>
+{{- end}}
+    </binding>
+
+    <!-- Service -->
+    <service name="{{.Name}}">
+        <port name="{{.Name}}Port" binding="tns:{{.Name}}SoapBinding">
+            <soap:address location="{{html .Address}}"/>
+        </port>
+    </service>
+</definitions>
+`))
@@ -0,0 +1,140 @@
+package wsdl
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Policy selects the WS-SecurityPolicy assertions advertised for a service
+// binding, so .NET and CXF clients configure their security stack from the
+// WSDL
+type Policy struct {
+	Transport     bool // Messages are sent over HTTPS (sp:TransportBinding)
+	UsernameToken bool // Requests carry a WS-Security UsernameToken
+	Signing       bool // Requests are signed with an X.509 certificate (sp:AsymmetricBinding)
+}
+
+// Enabled reports whether the policy has any assertion
+func (p Policy) Enabled() bool {
+	return p.Transport || p.UsernameToken || p.Signing
+}
+
+// render returns the wsp:Policy element with the given wsu:Id
+func (p Policy) render(id string) string {
+	var assertions []string
+
+	if p.Transport {
+		assertions = append(assertions, `<sp:TransportBinding>
+    <wsp:Policy>
+        <sp:TransportToken>
+            <wsp:Policy>
+                <sp:HttpsToken/>
+            </wsp:Policy>
+        </sp:TransportToken>
+        <sp:AlgorithmSuite>
+            <wsp:Policy>
+                <sp:Basic256/>
+            </wsp:Policy>
+        </sp:AlgorithmSuite>
+        <sp:Layout>
+            <wsp:Policy>
+                <sp:Lax/>
+            </wsp:Policy>
+        </sp:Layout>
+        <sp:IncludeTimestamp/>
+    </wsp:Policy>
+</sp:TransportBinding>`)
+	}
+
+	if p.Signing {
+		assertions = append(assertions, `<sp:AsymmetricBinding>
+    <wsp:Policy>
+        <sp:InitiatorToken>
+            <wsp:Policy>
+                <sp:X509Token sp:IncludeToken="http://docs.oasis-open.org/ws-sx/ws-securitypolicy/200702/IncludeToken/AlwaysToRecipient">
+                    <wsp:Policy>
+                        <sp:WssX509V3Token10/>
+                    </wsp:Policy>
+                </sp:X509Token>
+            </wsp:Policy>
+        </sp:InitiatorToken>
+        <sp:RecipientToken>
+            <wsp:Policy>
+                <sp:X509Token sp:IncludeToken="http://docs.oasis-open.org/ws-sx/ws-securitypolicy/200702/IncludeToken/Never">
+                    <wsp:Policy>
+                        <sp:WssX509V3Token10/>
+                    </wsp:Policy>
+                </sp:X509Token>
+            </wsp:Policy>
+        </sp:RecipientToken>
+        <sp:AlgorithmSuite>
+            <wsp:Policy>
+                <sp:Basic256Sha256/>
+            </wsp:Policy>
+        </sp:AlgorithmSuite>
+        <sp:Layout>
+            <wsp:Policy>
+                <sp:Lax/>
+            </wsp:Policy>
+        </sp:Layout>
+        <sp:IncludeTimestamp/>
+        <sp:OnlySignEntireHeadersAndBody/>
+    </wsp:Policy>
+</sp:AsymmetricBinding>
+<sp:Wss10>
+    <wsp:Policy>
+        <sp:MustSupportRefKeyIdentifier/>
+        <sp:MustSupportRefIssuerSerial/>
+    </wsp:Policy>
+</sp:Wss10>
+<sp:SignedParts>
+    <sp:Body/>
+</sp:SignedParts>`)
+	}
+
+	if p.UsernameToken {
+		// Over a secured transport or signed messages the token is a signed
+		// supporting token
+		wrapper := "sp:SupportingTokens"
+		if p.Transport || p.Signing {
+			wrapper = "sp:SignedSupportingTokens"
+		}
+		assertions = append(assertions, fmt.Sprintf(`<%s>
+    <wsp:Policy>
+        <sp:UsernameToken sp:IncludeToken="http://docs.oasis-open.org/ws-sx/ws-securitypolicy/200702/IncludeToken/AlwaysToRecipient">
+            <wsp:Policy>
+                <sp:WssUsernameToken10/>
+            </wsp:Policy>
+        </sp:UsernameToken>
+    </wsp:Policy>
+</%s>`, wrapper, wrapper))
+	}
+
+	return fmt.Sprintf(`<wsp:Policy wsu:Id="%s">
+    <wsp:ExactlyOne>
+        <wsp:All>
+            %s
+        </wsp:All>
+    </wsp:ExactlyOne>
+</wsp:Policy>`, id, indent(strings.Join(assertions, "\n"), "            "))
+}
+
+// ParsePolicy reads a comma separated list of policy assertions: "tls",
+// "usernametoken" and "signing"
+func ParsePolicy(list string) (Policy, error) {
+	var p Policy
+	for _, name := range strings.Split(list, ",") {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "":
+		case "tls", "https", "transport":
+			p.Transport = true
+		case "usernametoken":
+			p.UsernameToken = true
+		case "signing", "signature":
+			p.Signing = true
+		default:
+			return Policy{}, fmt.Errorf("unknown policy assertion %q", name)
+		}
+	}
+	return p, nil
+}
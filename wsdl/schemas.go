@@ -0,0 +1,128 @@
+package wsdl
+
+import (
+	"html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// schemaLocationPattern matches the schemaLocation attribute of xsd:import
+// and xsd:include elements
+var schemaLocationPattern = regexp.MustCompile(`schemaLocation="([^"]*)"`)
+
+// schemaReferences returns the bundled schemas referenced by relative
+// schemaLocation attributes in doc
+func schemaReferences(doc []byte) []string {
+	var names []string
+	for _, match := range schemaLocationPattern.FindAllSubmatch(doc, -1) {
+		if name := string(match[1]); isBundledSchema(name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// isBundledSchema reports whether location names a bundled XSD document
+func isBundledSchema(location string) bool {
+	if strings.Contains(location, "/") || strings.Contains(location, ":") {
+		return false
+	}
+	_, err := schemas.Open(location)
+	return err == nil
+}
+
+// rewriteSchemaLocations points relative schemaLocation attributes that
+// name bundled schemas at the ?xsd= query of the given endpoint URL so
+// clients can resolve imports and includes without local copies
+func rewriteSchemaLocations(doc []byte, endpoint string) []byte {
+	return schemaLocationPattern.ReplaceAllFunc(doc, func(attr []byte) []byte {
+		name := string(schemaLocationPattern.FindSubmatch(attr)[1])
+		if !isBundledSchema(name) {
+			return attr
+		}
+		return []byte(`schemaLocation="` + html.EscapeString(endpoint+"?xsd="+url.QueryEscape(name)) + `"`)
+	})
+}
+
+// schemaClosure returns the given schemas followed by every bundled schema
+// they import or include, directly or indirectly
+func schemaClosure(names []string, namespace string) []string {
+	seen := make(map[string]bool)
+	var closure []string
+
+	var visit func(name string)
+	visit = func(name string) {
+		if seen[name] {
+			return
+		}
+		seen[name] = true
+		closure = append(closure, name)
+
+		data, err := Schema(name, namespace)
+		if err != nil {
+			return
+		}
+		for _, ref := range schemaReferences(data) {
+			visit(ref)
+		}
+	}
+
+	for _, name := range names {
+		visit(name)
+	}
+	return closure
+}
+
+// QueryHandler serves the conventional contract queries on a service
+// endpoint: ?wsdl returns the WSDL served by wsdlHandler and ?xsd=N returns
+// the Nth (1-based) of the given schemas. Schemas they import or include are
+// available as ?xsd=<name>, and their schemaLocation attributes are
+// rewritten to those URLs.
+func QueryHandler(wsdlHandler http.Handler, schemas []string, namespace, externalURL string) http.HandlerFunc {
+	closure := schemaClosure(schemas, namespace)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		for key, values := range r.URL.Query() {
+			switch strings.ToLower(key) {
+			case "wsdl":
+				wsdlHandler.ServeHTTP(w, r)
+				return
+			case "xsd":
+				name, ok := lookupSchema(schemas, closure, values[0])
+				if !ok {
+					http.NotFound(w, r)
+					return
+				}
+				data, err := Schema(name, namespace)
+				if err != nil {
+					http.NotFound(w, r)
+					return
+				}
+				endpoint := BaseURL(r, externalURL) + r.URL.Path
+				serveDocument(w, r, rewriteSchemaLocations(data, endpoint))
+				return
+			}
+		}
+		http.NotFound(w, r)
+	}
+}
+
+// lookupSchema resolves an ?xsd= value, either the index of one of the
+// service schemas or the name of any schema in their closure
+func lookupSchema(schemas, closure []string, value string) (string, bool) {
+	if i, err := strconv.Atoi(value); err == nil {
+		if i < 1 || i > len(schemas) {
+			return "", false
+		}
+		return schemas[i-1], true
+	}
+	for _, name := range closure {
+		if name == value {
+			return name, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,78 @@
+package wsdl
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// modTime is the Last-Modified time of the served documents. They are built
+// from embedded files and the registry, so they only change on restart.
+var modTime = time.Now().UTC().Truncate(time.Second)
+
+// serveDocument writes a WSDL or XSD document with validators for caching
+// clients. Requests with a matching If-None-Match or a current
+// If-Modified-Since are answered with 304 Not Modified, and the document is
+// gzip encoded for clients accepting it.
+func serveDocument(w http.ResponseWriter, r *http.Request, doc []byte) {
+	sum := sha256.Sum256(doc)
+	etag := `W/"` + hex.EncodeToString(sum[:16]) + `"`
+
+	header := w.Header()
+	header.Set("ETag", etag)
+	header.Set("Last-Modified", modTime.Format(http.TimeFormat))
+	header.Set("Cache-Control", "no-cache")
+	header.Add("Vary", "Accept-Encoding")
+
+	if notModified(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	header.Set("Content-Type", "application/xml")
+	if r.Method == http.MethodHead {
+		return
+	}
+	if !acceptsGzip(r) {
+		w.Write(doc)
+		return
+	}
+
+	header.Set("Content-Encoding", "gzip")
+	gz := gzip.NewWriter(w)
+	gz.Write(doc)
+	gz.Close()
+}
+
+// notModified evaluates the conditional request headers. If-None-Match takes
+// precedence over If-Modified-Since.
+func notModified(r *http.Request, etag string) bool {
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil {
+		return !modTime.After(since)
+	}
+	return false
+}
+
+// acceptsGzip reports whether the client accepts gzip content coding
+func acceptsGzip(r *http.Request) bool {
+	for _, coding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(coding), ";")
+		if strings.TrimSpace(name) == "gzip" && strings.ReplaceAll(params, " ", "") != "q=0" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+// Package wsdl serves the WSDL contract of the SOAP service
+package wsdl
+
+import (
+	_ "embed"
+	"html"
+	"net/http"
+	"soap-server/soap"
+	"strings"
+)
+
+// DefaultNamespace is the target namespace used in the bundled WSDL document
+const DefaultNamespace = "http://example.com/soap/user"
+
+// bundledAddress is the soap:address location of the bundled WSDL document
+const bundledAddress = "http://localhost:8080/soap"
+
+//go:embed user.wsdl
+var userWSDL string
+
+// Document returns the bundled WSDL with the target namespace replaced and
+// the soapAction of every registered operation set to its configured URI
+func Document(registry *soap.OperationRegistry, namespace string) []byte {
+	doc := userWSDL
+
+	// Operation actions first, while they still use the default namespace
+	for _, op := range registry.Operations() {
+		doc = strings.ReplaceAll(doc,
+			`soapAction="`+DefaultNamespace+`/`+op.Name+`"`,
+			`soapAction="`+op.SOAPAction+`"`)
+	}
+
+	if namespace != "" && namespace != DefaultNamespace {
+		doc = strings.ReplaceAll(doc, `"`+DefaultNamespace+`"`, `"`+namespace+`"`)
+	}
+
+	return []byte(doc)
+}
+
+// Handler serves the WSDL document for the registered operations. The
+// soap:address location is rewritten to the /soap endpoint under the
+// external base URL, or under the URL the request was made to when
+// externalURL is empty (see BaseURL). Relative schema locations are
+// rewritten to the ?xsd= queries of that endpoint.
+func Handler(registry *soap.OperationRegistry, namespace, externalURL string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		address := resolveAddress(r, "/soap", externalURL)
+		doc := strings.Replace(string(Document(registry, namespace)),
+			`location="`+bundledAddress+`"`, `location="`+html.EscapeString(address)+`"`, 1)
+
+		serveDocument(w, r, rewriteSchemaLocations([]byte(doc), address))
+	}
+}
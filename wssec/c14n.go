@@ -0,0 +1,292 @@
+package wssec
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"soap-server/soap"
+	"sort"
+	"strings"
+)
+
+// ExclusiveC14N is the Exclusive XML Canonicalization algorithm (without
+// comments), the one WS-Security uses for signed elements
+const ExclusiveC14N = "http://www.w3.org/2001/10/xml-exc-c14n#"
+
+const xmlNamespace = "http://www.w3.org/XML/1998/namespace"
+
+// element is an element of a parsed message, keeping the prefixes and
+// namespace declarations as written so it can be canonicalized
+type element struct {
+	Prefix, Local string
+	Space         string // Namespace URI of the element
+	Attrs         []attribute
+	Parent        *element
+	Children      []interface{} // *element, text or xml.ProcInst
+	Start, End    int64         // Byte offsets of the element in the document
+
+	ns map[string]string // Namespaces declared on the element, "" for the default
+}
+
+// attribute is an attribute of an element other than a namespace declaration
+type attribute struct {
+	Prefix, Local string
+	Space         string
+	Value         string
+}
+
+// parseDocument parses an XML document into its root element. Comments and
+// anything outside the root element are dropped.
+func parseDocument(ctx context.Context, data []byte) (*element, error) {
+	decoder := soap.NewDecoder(ctx, bytes.NewReader(data))
+	var root, current *element
+	for {
+		offset := decoder.InputOffset()
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			if current == nil && root != nil {
+				return nil, fmt.Errorf("more than one root element")
+			}
+			e := &element{Prefix: t.Name.Space, Local: t.Name.Local, Parent: current, Start: offset}
+			for _, attr := range t.Attr {
+				switch {
+				case attr.Name.Space == "xmlns":
+					e.declare(attr.Name.Local, attr.Value)
+				case attr.Name.Space == "" && attr.Name.Local == "xmlns":
+					e.declare("", attr.Value)
+				default:
+					e.Attrs = append(e.Attrs, attribute{Prefix: attr.Name.Space, Local: attr.Name.Local, Value: attr.Value})
+				}
+			}
+			var ok bool
+			if e.Space, ok = e.lookup(e.Prefix); !ok {
+				return nil, fmt.Errorf("undeclared namespace prefix %q", e.Prefix)
+			}
+			for i, attr := range e.Attrs {
+				if attr.Prefix == "" {
+					continue
+				}
+				if e.Attrs[i].Space, ok = e.lookup(attr.Prefix); !ok {
+					return nil, fmt.Errorf("undeclared namespace prefix %q", attr.Prefix)
+				}
+			}
+			if current != nil {
+				current.Children = append(current.Children, e)
+			} else {
+				root = e
+			}
+			current = e
+		case xml.EndElement:
+			if current == nil || t.Name.Space != current.Prefix || t.Name.Local != current.Local {
+				return nil, fmt.Errorf("unexpected end element %s", t.Name.Local)
+			}
+			current.End = decoder.InputOffset()
+			current = current.Parent
+		case xml.CharData:
+			if current != nil {
+				current.Children = append(current.Children, string(t))
+			}
+		case xml.ProcInst:
+			if current != nil {
+				current.Children = append(current.Children, t.Copy())
+			}
+		}
+	}
+	if root == nil || current != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return root, nil
+}
+
+// declare records a namespace declaration of the element
+func (e *element) declare(prefix, uri string) {
+	if e.ns == nil {
+		e.ns = map[string]string{}
+	}
+	e.ns[prefix] = uri
+}
+
+// lookup returns the namespace bound to prefix in the scope of the element
+func (e *element) lookup(prefix string) (string, bool) {
+	if prefix == "xml" {
+		return xmlNamespace, true
+	}
+	for n := e; n != nil; n = n.Parent {
+		if uri, ok := n.ns[prefix]; ok {
+			return uri, true
+		}
+	}
+	return "", prefix == ""
+}
+
+// attr returns the value of the attribute with the given name
+func (e *element) attr(space, local string) string {
+	for _, attr := range e.Attrs {
+		if attr.Space == space && attr.Local == local {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// children returns the child elements with the given name
+func (e *element) children(space, local string) []*element {
+	var found []*element
+	for _, child := range e.Children {
+		if c, ok := child.(*element); ok && c.Space == space && c.Local == local {
+			found = append(found, c)
+		}
+	}
+	return found
+}
+
+// child returns the first child element with the given name, or nil
+func (e *element) child(space, local string) *element {
+	if found := e.children(space, local); len(found) > 0 {
+		return found[0]
+	}
+	return nil
+}
+
+// text returns the character data directly inside the element
+func (e *element) text() string {
+	var b strings.Builder
+	for _, child := range e.Children {
+		if s, ok := child.(string); ok {
+			b.WriteString(s)
+		}
+	}
+	return b.String()
+}
+
+// canonicalize writes the element in the exclusive canonical form. The
+// namespaces of prefixes in inclusive (from an InclusiveNamespaces
+// PrefixList, "#default" for the default namespace) are rendered like in
+// inclusive canonicalization. The descendant exclude, if not nil, is left
+// out, as by the enveloped signature transform.
+func canonicalize(e *element, inclusive []string, exclude *element) []byte {
+	var buf bytes.Buffer
+	c := canonicalizer{buf: &buf, inclusive: map[string]bool{}, exclude: exclude}
+	for _, prefix := range inclusive {
+		if prefix == "#default" {
+			prefix = ""
+		}
+		c.inclusive[prefix] = true
+	}
+	c.element(e, map[string]string{"": ""})
+	return buf.Bytes()
+}
+
+type canonicalizer struct {
+	buf       *bytes.Buffer
+	inclusive map[string]bool
+	exclude   *element
+}
+
+// element writes e given the namespaces rendered by its output ancestors
+func (c canonicalizer) element(e *element, rendered map[string]string) {
+	// Namespaces visibly utilized by the element and its attributes, and the
+	// inclusive ones in scope
+	used := map[string]bool{e.Prefix: true}
+	for _, attr := range e.Attrs {
+		if attr.Prefix != "" && attr.Prefix != "xml" {
+			used[attr.Prefix] = true
+		}
+	}
+	for prefix := range c.inclusive {
+		if _, ok := e.lookup(prefix); ok {
+			used[prefix] = true
+		}
+	}
+
+	var prefixes []string
+	for prefix := range used {
+		uri, _ := e.lookup(prefix)
+		if current, ok := rendered[prefix]; ok && current == uri {
+			continue
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	if len(prefixes) > 0 {
+		next := make(map[string]string, len(rendered)+len(prefixes))
+		for prefix, uri := range rendered {
+			next[prefix] = uri
+		}
+		for _, prefix := range prefixes {
+			next[prefix], _ = e.lookup(prefix)
+		}
+		rendered = next
+	}
+
+	c.buf.WriteByte('<')
+	c.buf.WriteString(qualifiedName(e.Prefix, e.Local))
+	for _, prefix := range prefixes {
+		if prefix == "" {
+			c.buf.WriteString(` xmlns="`)
+		} else {
+			c.buf.WriteString(` xmlns:` + prefix + `="`)
+		}
+		c.buf.WriteString(escapeAttr(rendered[prefix]))
+		c.buf.WriteByte('"')
+	}
+	attrs := append([]attribute(nil), e.Attrs...)
+	sort.Slice(attrs, func(i, j int) bool {
+		if attrs[i].Space != attrs[j].Space {
+			return attrs[i].Space < attrs[j].Space
+		}
+		return attrs[i].Local < attrs[j].Local
+	})
+	for _, attr := range attrs {
+		c.buf.WriteString(" " + qualifiedName(attr.Prefix, attr.Local) + `="` + escapeAttr(attr.Value) + `"`)
+	}
+	c.buf.WriteByte('>')
+
+	for _, child := range e.Children {
+		switch child := child.(type) {
+		case *element:
+			if child != c.exclude {
+				c.element(child, rendered)
+			}
+		case string:
+			c.buf.WriteString(escapeText(child))
+		case xml.ProcInst:
+			c.buf.WriteString("<?" + child.Target)
+			if len(child.Inst) > 0 {
+				c.buf.WriteByte(' ')
+				c.buf.Write(child.Inst)
+			}
+			c.buf.WriteString("?>")
+		}
+	}
+	c.buf.WriteString("</" + qualifiedName(e.Prefix, e.Local) + ">")
+}
+
+func qualifiedName(prefix, local string) string {
+	if prefix == "" {
+		return local
+	}
+	return prefix + ":" + local
+}
+
+var (
+	textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;")
+	attrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;", "\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+)
+
+func escapeText(s string) string {
+	return textEscaper.Replace(s)
+}
+
+func escapeAttr(s string) string {
+	return attrEscaper.Replace(s)
+}
@@ -0,0 +1,319 @@
+package wssec
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"soap-server/soap"
+	"soap-server/soapfault"
+	"sort"
+	"strings"
+)
+
+// XML Encryption namespaces
+const (
+	EncryptionNamespace   = "http://www.w3.org/2001/04/xmlenc#"
+	encryption11Namespace = "http://www.w3.org/2009/xmlenc11#"
+)
+
+// contentAlgorithm is a supported block encryption algorithm
+type contentAlgorithm struct {
+	KeySize int
+	GCM     bool
+}
+
+var contentAlgorithms = map[string]contentAlgorithm{
+	EncryptionNamespace + "aes128-cbc":   {KeySize: 16},
+	EncryptionNamespace + "aes192-cbc":   {KeySize: 24},
+	EncryptionNamespace + "aes256-cbc":   {KeySize: 32},
+	encryption11Namespace + "aes128-gcm": {KeySize: 16, GCM: true},
+	encryption11Namespace + "aes192-gcm": {KeySize: 24, GCM: true},
+	encryption11Namespace + "aes256-gcm": {KeySize: 32, GCM: true},
+}
+
+var mgfAlgorithms = map[string]crypto.Hash{
+	encryption11Namespace + "mgf1sha1":   crypto.SHA1,
+	encryption11Namespace + "mgf1sha224": crypto.SHA224,
+	encryption11Namespace + "mgf1sha256": crypto.SHA256,
+	encryption11Namespace + "mgf1sha384": crypto.SHA384,
+	encryption11Namespace + "mgf1sha512": crypto.SHA512,
+}
+
+// LoadPrivateKey reads a PEM encoded RSA private key (PKCS #1 or PKCS #8)
+func LoadPrivateKey(path string) (*rsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var block *pem.Block
+		if block, data = pem.Decode(data); block == nil {
+			return nil, fmt.Errorf("%s: no private key found", path)
+		}
+		switch block.Type {
+		case "RSA PRIVATE KEY":
+			return x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "PRIVATE KEY":
+			key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			rsaKey, ok := key.(*rsa.PrivateKey)
+			if !ok {
+				return nil, fmt.Errorf("%s: not an RSA key", path)
+			}
+			return rsaKey, nil
+		}
+	}
+}
+
+// DecryptBody returns a request hook replacing the xenc:EncryptedData in the
+// Body of requests with their plaintext, decrypted with the private key of
+// cfg. The content keys are transported in xenc:EncryptedKey elements,
+// encrypted with RSA-OAEP, either inside the EncryptedData or in the
+// Security header referring to it. Content is encrypted with AES-CBC or
+// AES-GCM. Signatures are verified on the decrypted envelope, so clients
+// sign before encrypting. Encrypted MTOM requests are rejected.
+func DecryptBody(cfg Config) soap.RequestHook {
+	return func(r *http.Request, body []byte) ([]byte, error) {
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/related" {
+			if envelope, err := parseDocument(r.Context(), body); err == nil {
+				if soapBody, _, ok := envelopeParts(envelope); ok && soapBody != nil && len(encryptedData(soapBody)) > 0 {
+					return nil, invalidSecurity("Encrypted multipart/related requests are not supported")
+				}
+			}
+			return body, nil
+		}
+
+		envelope, err := parseDocument(r.Context(), body)
+		if err != nil {
+			// Malformed requests are rejected when the envelope is parsed
+			return body, nil
+		}
+		decrypted, count, fault := cfg.decrypt(envelope, body)
+		if fault != nil {
+			soap.Warn(r.Context(), "WS-Security decryption failed", soap.LogKeyError, fault)
+			return nil, fault
+		}
+		if count > 0 {
+			soap.Debug(r.Context(), "WS-Security elements decrypted", "elements", count)
+		}
+		return decrypted, nil
+	}
+}
+
+// decrypt replaces the encrypted data in the Body of the envelope parsed
+// from data with the plaintext and returns the result and the number of
+// replaced elements
+func (cfg Config) decrypt(envelope *element, data []byte) ([]byte, int, *soapfault.Fault) {
+	body, security, ok := envelopeParts(envelope)
+	if !ok || body == nil {
+		return data, 0, nil
+	}
+	encrypted := encryptedData(body)
+	if len(encrypted) == 0 {
+		return data, 0, nil
+	}
+	ids, err := indexIDs(envelope)
+	if err != nil {
+		return nil, 0, invalidSecurity("Invalid IDs: " + err.Error())
+	}
+
+	// Replace from the end of the document so the offsets of the elements
+	// before stay valid
+	sort.Slice(encrypted, func(i, j int) bool { return encrypted[i].Start > encrypted[j].Start })
+	keys := map[*element][]byte{}
+	for _, e := range encrypted {
+		encryptedKey, fault := encryptedKeyOf(e, security, ids)
+		if fault != nil {
+			return nil, 0, fault
+		}
+		key, ok := keys[encryptedKey]
+		if !ok {
+			if key, fault = cfg.decryptKey(encryptedKey); fault != nil {
+				return nil, 0, fault
+			}
+			keys[encryptedKey] = key
+		}
+		plaintext, fault := decryptData(e, key)
+		if fault != nil {
+			return nil, 0, fault
+		}
+		replaced := make([]byte, 0, len(data)-int(e.End-e.Start)+len(plaintext))
+		replaced = append(replaced, data[:e.Start]...)
+		replaced = append(replaced, plaintext...)
+		data = append(replaced, data[e.End:]...)
+	}
+	return data, len(encrypted), nil
+}
+
+// encryptedKeyOf returns the EncryptedKey holding the key of an
+// EncryptedData: inside its KeyInfo, referenced from it, or referring to it
+// from the Security header
+func encryptedKeyOf(data *element, security *element, ids map[string]*element) (*element, *soapfault.Fault) {
+	if keyInfo := data.child(SignatureNamespace, "KeyInfo"); keyInfo != nil {
+		if encryptedKey := keyInfo.child(EncryptionNamespace, "EncryptedKey"); encryptedKey != nil {
+			return encryptedKey, nil
+		}
+		if reference := keyInfo.child(SecurityNamespace, "SecurityTokenReference"); reference != nil {
+			var uri string
+			if ref := reference.child(SecurityNamespace, "Reference"); ref != nil {
+				uri = ref.attr("", "URI")
+			}
+			token := ids[strings.TrimPrefix(uri, "#")]
+			if !strings.HasPrefix(uri, "#") || token == nil || token.Space != EncryptionNamespace || token.Local != "EncryptedKey" {
+				return nil, tokenUnavailable("Unknown encrypted key " + uri)
+			}
+			return token, nil
+		}
+	}
+
+	if id := elementID(data); id != "" && security != nil {
+		for _, encryptedKey := range security.children(EncryptionNamespace, "EncryptedKey") {
+			list := encryptedKey.child(EncryptionNamespace, "ReferenceList")
+			if list == nil {
+				continue
+			}
+			for _, reference := range list.children(EncryptionNamespace, "DataReference") {
+				if reference.attr("", "URI") == "#"+id {
+					return encryptedKey, nil
+				}
+			}
+		}
+	}
+	return nil, tokenUnavailable("No xenc:EncryptedKey holds the key of the xenc:EncryptedData")
+}
+
+// decryptKey decrypts the content key of an EncryptedKey with RSA-OAEP
+func (cfg Config) decryptKey(encryptedKey *element) ([]byte, *soapfault.Fault) {
+	method := encryptedKey.child(EncryptionNamespace, "EncryptionMethod")
+	if method == nil {
+		return nil, invalidSecurity("The xenc:EncryptedKey has no xenc:EncryptionMethod")
+	}
+	opts := &rsa.OAEPOptions{Hash: crypto.SHA1, MGFHash: crypto.SHA1}
+	switch algorithm := method.attr("", "Algorithm"); algorithm {
+	case EncryptionNamespace + "rsa-oaep-mgf1p":
+	case encryption11Namespace + "rsa-oaep":
+		if mgf := method.child(encryption11Namespace, "MGF"); mgf != nil {
+			hash, ok := mgfAlgorithms[mgf.attr("", "Algorithm")]
+			if !ok {
+				return nil, unsupportedAlgorithm("Unsupported mask generation function " + mgf.attr("", "Algorithm"))
+			}
+			opts.MGFHash = hash
+		}
+	default:
+		return nil, unsupportedAlgorithm("Unsupported key transport " + algorithm)
+	}
+	if digest := method.child(SignatureNamespace, "DigestMethod"); digest != nil {
+		hash, ok := digestAlgorithms[digest.attr("", "Algorithm")]
+		if !ok {
+			return nil, unsupportedAlgorithm("Unsupported digest method " + digest.attr("", "Algorithm"))
+		}
+		opts.Hash = hash
+	}
+	if params := method.child(EncryptionNamespace, "OAEPparams"); params != nil {
+		var err error
+		if opts.Label, err = decodeBase64(params); err != nil {
+			return nil, invalidSecurity("Invalid xenc:OAEPparams: " + err.Error())
+		}
+	}
+
+	ciphertext, fault := cipherValue(encryptedKey)
+	if fault != nil {
+		return nil, fault
+	}
+	key, err := cfg.PrivateKey.Decrypt(nil, ciphertext, opts)
+	if err != nil {
+		return nil, failedCheck("The key could not be decrypted")
+	}
+	return key, nil
+}
+
+// decryptData decrypts the content of an EncryptedData with key
+func decryptData(data *element, key []byte) ([]byte, *soapfault.Fault) {
+	method := data.child(EncryptionNamespace, "EncryptionMethod")
+	if method == nil {
+		return nil, invalidSecurity("The xenc:EncryptedData has no xenc:EncryptionMethod")
+	}
+	algorithm, ok := contentAlgorithms[method.attr("", "Algorithm")]
+	if !ok {
+		return nil, unsupportedAlgorithm("Unsupported data encryption " + method.attr("", "Algorithm"))
+	}
+	ciphertext, fault := cipherValue(data)
+	if fault != nil {
+		return nil, fault
+	}
+	if len(key) != algorithm.KeySize {
+		return nil, failedCheck("The key does not match the data encryption")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, failedCheck("The key does not match the data encryption")
+	}
+
+	// The IV precedes the ciphertext, the GCM tag follows it
+	failed := failedCheck("The data could not be decrypted")
+	if algorithm.GCM {
+		gcm, err := cipher.NewGCM(block)
+		if err != nil || len(ciphertext) < gcm.NonceSize()+gcm.Overhead() {
+			return nil, failed
+		}
+		plaintext, err := gcm.Open(nil, ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():], nil)
+		if err != nil {
+			return nil, failed
+		}
+		return plaintext, nil
+	}
+	if len(ciphertext) < 2*aes.BlockSize || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, failed
+	}
+	plaintext := make([]byte, len(ciphertext)-aes.BlockSize)
+	cipher.NewCBCDecrypter(block, ciphertext[:aes.BlockSize]).CryptBlocks(plaintext, ciphertext[aes.BlockSize:])
+	// ISO 10126 padding: only the last byte, the padding length, is defined
+	padding := int(plaintext[len(plaintext)-1])
+	if padding == 0 || padding > aes.BlockSize {
+		return nil, failed
+	}
+	return plaintext[:len(plaintext)-padding], nil
+}
+
+// cipherValue returns the inline ciphertext of an EncryptedData or
+// EncryptedKey
+func cipherValue(e *element) ([]byte, *soapfault.Fault) {
+	var value *element
+	if data := e.child(EncryptionNamespace, "CipherData"); data != nil {
+		if data.child(EncryptionNamespace, "CipherReference") != nil {
+			return nil, unsupportedAlgorithm("xenc:CipherReference is not supported")
+		}
+		value = data.child(EncryptionNamespace, "CipherValue")
+	}
+	ciphertext, err := decodeBase64(value)
+	if err != nil {
+		return nil, invalidSecurity("Invalid xenc:CipherValue: " + err.Error())
+	}
+	return ciphertext, nil
+}
+
+// encryptedData returns the outermost EncryptedData elements inside e
+func encryptedData(e *element) []*element {
+	var found []*element
+	for _, child := range e.Children {
+		c, ok := child.(*element)
+		if !ok {
+			continue
+		}
+		if c.Space == EncryptionNamespace && c.Local == "EncryptedData" {
+			found = append(found, c)
+		} else {
+			found = append(found, encryptedData(c)...)
+		}
+	}
+	return found
+}
@@ -0,0 +1,127 @@
+package wssec
+
+import (
+	"net/http"
+	"soap-server/soap"
+	"soap-server/soapfault"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultReplayWindow is how long the identifiers of requests are
+// remembered unless configured otherwise
+const DefaultReplayWindow = 5 * time.Minute
+
+// ReplayCache remembers the nonces and message IDs of the requests seen
+// within a window, so that a captured request cannot be sent again.
+// Requests older than the window must be rejected otherwise, e.g. by
+// Config.MaxAge.
+type ReplayCache struct {
+	window time.Duration
+
+	mu    sync.Mutex
+	seen  map[string]time.Time // Identifier to the time it is forgotten
+	swept time.Time
+}
+
+// NewReplayCache returns a cache remembering identifiers for the window
+// (DefaultReplayWindow if zero)
+func NewReplayCache(window time.Duration) *ReplayCache {
+	if window <= 0 {
+		window = DefaultReplayWindow
+	}
+	return &ReplayCache{window: window, seen: map[string]time.Time{}}
+}
+
+// Window returns how long identifiers are remembered
+func (c *ReplayCache) Window() time.Duration {
+	return c.window
+}
+
+// add records the identifier at now and reports whether it was new
+func (c *ReplayCache) add(id string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Forgotten identifiers are removed once per window
+	if now.Sub(c.swept) >= c.window {
+		for seen, until := range c.seen {
+			if !now.Before(until) {
+				delete(c.seen, seen)
+			}
+		}
+		c.swept = now
+	}
+	if until, ok := c.seen[id]; ok && now.Before(until) {
+		return false
+	}
+	c.seen[id] = now.Add(c.window)
+	return true
+}
+
+// usernameTokens is the part of the Security header holding nonces. The
+// tokens themselves are not verified by this package.
+type usernameTokens struct {
+	Tokens []struct {
+		Nonce string `xml:"http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-secext-1.0.xsd Nonce"`
+	} `xml:"http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-secext-1.0.xsd UsernameToken"`
+}
+
+// DetectReplay returns middleware rejecting SOAP requests whose
+// UsernameToken nonce or wsa:MessageID was seen within the window of the
+// cache, with a wsse:InvalidSecurity fault. If require is set, requests
+// without either are rejected too. It should run after the authentication
+// middleware, so that unauthenticated callers cannot fill the cache or
+// claim the identifiers of others; the identifiers should be signed.
+func DetectReplay(cache *ReplayCache, require bool) soap.Middleware {
+	return func(next soap.SOAPHandler) soap.SOAPHandler {
+		return func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			if _, ok := soap.OperationFromContext(ctx); !ok {
+				next(w, r)
+				return
+			}
+
+			var ids []string
+			if block := securityBlock(soap.HeaderFromContext(ctx)); block != nil {
+				var header usernameTokens
+				if err := block.Decode(&header); err != nil {
+					soap.WriteFault(w, r, invalidSecurity("Malformed wsse:Security header: "+err.Error()))
+					return
+				}
+				for _, token := range header.Tokens {
+					if nonce := strings.TrimSpace(token.Nonce); nonce != "" {
+						ids = append(ids, "nonce:"+nonce)
+					}
+				}
+			}
+			if a, ok := soap.AddressingFromContext(ctx); ok && a.MessageID != "" {
+				ids = append(ids, "message:"+a.MessageID)
+			}
+			if len(ids) == 0 && require {
+				soap.WriteFault(w, r, invalidSecurity("The message has neither a wsse:Nonce nor a wsa:MessageID"))
+				return
+			}
+
+			now := time.Now()
+			for _, id := range ids {
+				if !cache.add(id, now) {
+					soap.Warn(ctx, "Replayed request rejected", "message_id", id)
+					soap.WriteFault(w, r, replayed(id))
+					return
+				}
+			}
+			next(w, r)
+		}
+	}
+}
+
+// replayed returns the fault for a request whose identifier was seen before
+func replayed(id string) *soapfault.Fault {
+	kind, value, _ := strings.Cut(id, ":")
+	name := "wsa:MessageID"
+	if kind == "nonce" {
+		name = "wsse:Nonce"
+	}
+	return invalidSecurity("The message was already received (" + name + " " + value + ")")
+}
@@ -0,0 +1,256 @@
+package wssec
+
+import (
+	"crypto/x509"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"soap-server/soap"
+	"soap-server/soapfault"
+	"strings"
+	"time"
+)
+
+// AssertionNamespace is the namespace of SAML 2.0 assertions
+const AssertionNamespace = "urn:oasis:names:tc:SAML:2.0:assertion"
+
+// bearerConfirmation is the subject confirmation method of bearer
+// assertions, the only one supported
+const bearerConfirmation = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+
+// MethodSAML is the Principal.Method of callers authenticated with a SAML
+// assertion
+const MethodSAML = "saml"
+
+// assertion is a saml2:Assertion, with the parts that are checked or mapped
+// to the caller
+type assertion struct {
+	Version string `xml:"Version,attr"`
+	Issuer  string `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+	Subject struct {
+		NameID        string                `xml:"urn:oasis:names:tc:SAML:2.0:assertion NameID"`
+		Confirmations []subjectConfirmation `xml:"urn:oasis:names:tc:SAML:2.0:assertion SubjectConfirmation"`
+	} `xml:"urn:oasis:names:tc:SAML:2.0:assertion Subject"`
+	Conditions *struct {
+		NotBefore            string `xml:"NotBefore,attr"`
+		NotOnOrAfter         string `xml:"NotOnOrAfter,attr"`
+		AudienceRestrictions []struct {
+			Audiences []string `xml:"urn:oasis:names:tc:SAML:2.0:assertion Audience"`
+		} `xml:"urn:oasis:names:tc:SAML:2.0:assertion AudienceRestriction"`
+	} `xml:"urn:oasis:names:tc:SAML:2.0:assertion Conditions"`
+	Statements []struct {
+		Attributes []samlAttribute `xml:"urn:oasis:names:tc:SAML:2.0:assertion Attribute"`
+	} `xml:"urn:oasis:names:tc:SAML:2.0:assertion AttributeStatement"`
+}
+
+// subjectConfirmation is a saml2:SubjectConfirmation
+type subjectConfirmation struct {
+	Method string `xml:"Method,attr"`
+	Data   *struct {
+		NotBefore    string `xml:"NotBefore,attr"`
+		NotOnOrAfter string `xml:"NotOnOrAfter,attr"`
+	} `xml:"urn:oasis:names:tc:SAML:2.0:assertion SubjectConfirmationData"`
+}
+
+// samlAttribute is a saml2:Attribute of an AttributeStatement
+type samlAttribute struct {
+	Name         string   `xml:"Name,attr"`
+	FriendlyName string   `xml:"FriendlyName,attr"`
+	Values       []string `xml:"urn:oasis:names:tc:SAML:2.0:assertion AttributeValue"`
+}
+
+// verifiedAssertionKey is the context key of the assertion verified by the
+// VerifyAssertions hook
+type verifiedAssertionKey struct{}
+
+// VerifyAssertions returns a request hook verifying the signature of a SAML
+// 2.0 assertion in the Security header against cfg.SAMLTrustedCerts. The
+// assertion must carry an enveloped signature covering all of it,
+// canonicalized with Exclusive C14N, and signed with a certificate of its
+// KeyInfo that is trusted. Envelopes that cannot be parsed are rejected.
+// The signed content of the assertion is passed on to the Middleware, which
+// checks its conditions and subject and authenticates the caller.
+func VerifyAssertions(cfg Config) soap.RequestHook {
+	roots := x509.NewCertPool()
+	for _, cert := range cfg.SAMLTrustedCerts {
+		roots.AddCert(cert)
+	}
+	// The certificates referenced by the signatures are looked up in the
+	// SAML trust store
+	cfg.TrustedCerts = cfg.SAMLTrustedCerts
+	return func(r *http.Request, body []byte) ([]byte, error) {
+		envelope, err := parseDocument(r.Context(), body)
+		if err != nil {
+			// The Middleware would otherwise read assertions this hook did
+			// not see
+			return nil, invalidSecurity("Malformed envelope: " + err.Error())
+		}
+		verified, signer, fault := cfg.verifyAssertion(envelope, roots, time.Now())
+		if fault != nil {
+			soap.Warn(r.Context(), "SAML assertion signature check failed", soap.LogKeyError, fault)
+			return nil, fault
+		}
+		if verified != nil {
+			soap.SetRequestValue(r, verifiedAssertionKey{}, *verified)
+			soap.Debug(r.Context(), "SAML assertion signature verified", "subject", signer.Subject.String())
+		}
+		return body, nil
+	}
+}
+
+// verifyAssertion checks the signature of the assertion in the Security
+// header of the envelope at now and returns its signed content and the
+// signing certificate, or nil if there is no assertion
+func (cfg Config) verifyAssertion(envelope *element, roots *x509.CertPool, now time.Time) (*assertion, *x509.Certificate, *soapfault.Fault) {
+	_, security, ok := envelopeParts(envelope)
+	if !ok {
+		return nil, nil, invalidSecurity("The message is not a SOAP envelope with one Header and Body")
+	}
+	if security == nil {
+		return nil, nil, nil
+	}
+	assertions := security.children(AssertionNamespace, "Assertion")
+	switch {
+	case len(assertions) == 0:
+		return nil, nil, nil
+	case len(assertions) > 1:
+		return nil, nil, invalidSecurity("The wsse:Security header has more than one saml2:Assertion")
+	}
+	token := assertions[0]
+	signature := token.child(SignatureNamespace, "Signature")
+	if signature == nil {
+		return nil, nil, invalidToken("The saml2:Assertion is not signed")
+	}
+
+	ids, err := indexIDs(envelope)
+	if err != nil {
+		return nil, nil, invalidSecurity("Invalid IDs: " + err.Error())
+	}
+	signed, cert, fault := cfg.checkSignature(signature, security, ids, roots, now)
+	if fault != nil {
+		return nil, nil, fault
+	}
+	if !signed[token] {
+		return nil, nil, invalidToken("The signature does not cover the saml2:Assertion")
+	}
+
+	// The assertion is read from what was signed, in canonical form
+	var a assertion
+	if err := xml.Unmarshal(canonicalize(token, nil, signature), &a); err != nil {
+		return nil, nil, invalidToken("Malformed saml2:Assertion: " + err.Error())
+	}
+	return &a, cert, nil
+}
+
+// checkAssertion checks the issuer, conditions and subject confirmation of
+// an assertion verified by the VerifyAssertions hook at now and returns the
+// caller it identifies
+func (cfg Config) checkAssertion(a assertion, now time.Time) (soap.Principal, *soapfault.Fault) {
+	if a.Version != "2.0" {
+		return soap.Principal{}, unsupportedToken("Unsupported SAML assertion version " + a.Version)
+	}
+	if issuer := strings.TrimSpace(a.Issuer); cfg.SAMLIssuer != "" && issuer != cfg.SAMLIssuer {
+		return soap.Principal{}, invalidToken(fmt.Sprintf("The saml2:Assertion issuer %q is not accepted", issuer))
+	}
+
+	// The assertion must expire, by its conditions or its bearer
+	// confirmation
+	expires := false
+	if c := a.Conditions; c != nil {
+		if fault := cfg.checkValidity(c.NotBefore, c.NotOnOrAfter, now); fault != nil {
+			return soap.Principal{}, fault
+		}
+		expires = c.NotOnOrAfter != ""
+		if cfg.SAMLAudience != "" && len(c.AudienceRestrictions) == 0 {
+			return soap.Principal{}, invalidToken("The saml2:Assertion has no saml2:AudienceRestriction")
+		}
+		for _, restriction := range c.AudienceRestrictions {
+			if cfg.SAMLAudience != "" && !containsTrimmed(restriction.Audiences, cfg.SAMLAudience) {
+				return soap.Principal{}, invalidToken("The saml2:Assertion is not issued for audience " + cfg.SAMLAudience)
+			}
+		}
+	} else if cfg.SAMLAudience != "" {
+		return soap.Principal{}, invalidToken("The saml2:Assertion has no saml2:AudienceRestriction")
+	}
+
+	bearer := false
+	for _, confirmation := range a.Subject.Confirmations {
+		if confirmation.Method != bearerConfirmation {
+			continue
+		}
+		if d := confirmation.Data; d != nil {
+			if fault := cfg.checkValidity(d.NotBefore, d.NotOnOrAfter, now); fault != nil {
+				return soap.Principal{}, fault
+			}
+			expires = expires || d.NotOnOrAfter != ""
+		}
+		bearer = true
+		break
+	}
+	if !bearer {
+		return soap.Principal{}, unsupportedToken("The saml2:Assertion has no bearer subject confirmation")
+	}
+	if !expires {
+		return soap.Principal{}, invalidToken("The saml2:Assertion does not expire")
+	}
+
+	principal := soap.Principal{Name: strings.TrimSpace(a.Subject.NameID), Method: MethodSAML, Claims: map[string]interface{}{}}
+	for _, statement := range a.Statements {
+		for _, attr := range statement.Attributes {
+			var values []string
+			for _, value := range attr.Values {
+				values = append(values, strings.TrimSpace(value))
+			}
+			principal.Claims[attr.Name] = values
+			switch cfg.SAMLNameAttribute {
+			case "":
+			case attr.Name, attr.FriendlyName:
+				if len(values) > 0 {
+					principal.Name = values[0]
+				}
+			}
+			switch cfg.SAMLRoleAttribute {
+			case "":
+			case attr.Name, attr.FriendlyName:
+				principal.Roles = append(principal.Roles, values...)
+			}
+		}
+	}
+	if principal.Name == "" {
+		return soap.Principal{}, invalidToken("The saml2:Assertion does not name the subject")
+	}
+	return principal, nil
+}
+
+// checkValidity checks the NotBefore and NotOnOrAfter times of an assertion
+// at now, within the clock skew
+func (cfg Config) checkValidity(notBefore, notOnOrAfter string, now time.Time) *soapfault.Fault {
+	if notBefore != "" {
+		t, err := parseTime(notBefore)
+		if err != nil {
+			return invalidToken("Invalid NotBefore: " + err.Error())
+		}
+		if now.Add(cfg.ClockSkew).Before(t) {
+			return invalidToken(fmt.Sprintf("The saml2:Assertion is not valid before %s", notBefore))
+		}
+	}
+	if notOnOrAfter != "" {
+		t, err := parseTime(notOnOrAfter)
+		if err != nil {
+			return invalidToken("Invalid NotOnOrAfter: " + err.Error())
+		}
+		if !now.Before(t.Add(cfg.ClockSkew)) {
+			return invalidToken(fmt.Sprintf("The saml2:Assertion expired at %s", notOnOrAfter))
+		}
+	}
+	return nil
+}
+
+func containsTrimmed(list []string, s string) bool {
+	for _, item := range list {
+		if strings.TrimSpace(item) == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package wssec
+
+import (
+	"crypto/x509"
+	"strings"
+	"testing"
+	"time"
+)
+
+// samlEnvelope returns an envelope with a bearer assertion for name in the
+// Security header, to be signed in place of SIGNATURE. The header is
+// preceded by extra and the envelope followed by trailer.
+func samlEnvelope(name, extra, trailer string) string {
+	expires := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	return `<soap:Envelope xmlns:soap="` + testEnvelopeNS + `">` + extra + `<soap:Header>` +
+		`<wsse:Security xmlns:wsse="` + SecurityNamespace + `">` +
+		`<saml:Assertion xmlns:saml="` + AssertionNamespace + `" ID="_a1" Version="2.0"><saml:Issuer>idp</saml:Issuer>SIGNATURE` +
+		`<saml:Subject><saml:NameID>` + name + `</saml:NameID>` +
+		`<saml:SubjectConfirmation Method="` + bearerConfirmation + `"></saml:SubjectConfirmation></saml:Subject>` +
+		`<saml:Conditions NotOnOrAfter="` + expires + `"></saml:Conditions></saml:Assertion>` +
+		`</wsse:Security></soap:Header>` +
+		`<soap:Body><t:WhoRequest xmlns:t="` + testServiceNS + `"></t:WhoRequest></soap:Body></soap:Envelope>` + trailer
+}
+
+func TestAssertionAuthenticatesCaller(t *testing.T) {
+	signer := newTestSigner(t)
+	server := newTestServer(t, Config{SAMLTrustedCerts: []*x509.Certificate{signer.cert}})
+
+	response := call(t, server, signer.sign(t, samlEnvelope("alice", "", ""), true, "_a1"))
+	if !strings.Contains(response, "<name>alice</name>") {
+		t.Errorf("caller not authenticated by the assertion:\n%s", response)
+	}
+}
+
+func TestUnverifiedAssertionIsRejected(t *testing.T) {
+	signer := newTestSigner(t)
+	server := newTestServer(t, Config{SAMLTrustedCerts: []*x509.Certificate{signer.cert}})
+	signed := func(name, extra, trailer string) string {
+		return signer.sign(t, samlEnvelope(name, extra, trailer), true, "_a1")
+	}
+
+	tests := []struct {
+		name     string
+		envelope string
+	}{
+		{"unsigned", strings.Replace(samlEnvelope("admin", "", ""), "SIGNATURE", "", 1)},
+		{"tampered", strings.Replace(signed("alice", "", ""), "<saml:NameID>alice<", "<saml:NameID>admin<", 1)},
+		// The hook cannot parse these envelopes, which the decoders accept
+		{"unsigned with trailing element", strings.Replace(samlEnvelope("admin", "", "<x/>"), "SIGNATURE", "", 1)},
+		{"unsigned with undeclared prefix", strings.Replace(strings.Replace(samlEnvelope("admin", "", ""), "SIGNATURE", "", 1),
+			`<soap:Body>`, `<soap:Body undeclared:attr="1">`, 1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := call(t, server, tt.envelope)
+			if !strings.Contains(response, "Fault") || strings.Contains(response, "<name>") {
+				t.Errorf("request accepted:\n%s", response)
+			}
+		})
+	}
+}
+
+func TestOnlyVerifiedAssertionNamesCaller(t *testing.T) {
+	signer := newTestSigner(t)
+	server := newTestServer(t, Config{SAMLTrustedCerts: []*x509.Certificate{signer.cert}})
+
+	// An unsigned assertion in a header outside the envelope namespace,
+	// ahead of the signed one
+	expires := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	decoy := `<x:Header xmlns:x="urn:evil"><wsse:Security xmlns:wsse="` + SecurityNamespace + `">` +
+		`<saml:Assertion xmlns:saml="` + AssertionNamespace + `" ID="_decoy" Version="2.0"><saml:Issuer>idp</saml:Issuer>` +
+		`<saml:Subject><saml:NameID>admin</saml:NameID>` +
+		`<saml:SubjectConfirmation Method="` + bearerConfirmation + `"></saml:SubjectConfirmation></saml:Subject>` +
+		`<saml:Conditions NotOnOrAfter="` + expires + `"></saml:Conditions></saml:Assertion>` +
+		`</wsse:Security></x:Header>`
+
+	response := call(t, server, signer.sign(t, samlEnvelope("alice", decoy, ""), true, "_a1"))
+	if strings.Contains(response, "<name>admin</name>") {
+		t.Errorf("caller authenticated by the unsigned assertion:\n%s", response)
+	}
+}
@@ -0,0 +1,464 @@
+package wssec
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha1"
+	_ "crypto/sha256" // Digest and signature algorithms
+	_ "crypto/sha512"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"soap-server/soap"
+	"soap-server/soapfault"
+	"strings"
+	"time"
+)
+
+// XML-DSig namespace
+const SignatureNamespace = "http://www.w3.org/2000/09/xmldsig#"
+
+// EnvelopedSignature is the transform leaving a signature out of the
+// element it signs, as in signed SAML assertions
+const EnvelopedSignature = "http://www.w3.org/2000/09/xmldsig#enveloped-signature"
+
+// Token types of the X.509 token profile
+const (
+	x509v3Token         = "http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-x509-token-profile-1.0#X509v3"
+	x509SKIToken        = "http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-x509-token-profile-1.0#X509SubjectKeyIdentifier"
+	x509ThumbprintToken = "http://docs.oasis-open.org/wss/oasis-wss-soap-message-security-1.1#ThumbprintSHA1"
+)
+
+// signatureAlgorithm is a supported SignatureMethod
+type signatureAlgorithm struct {
+	Hash  crypto.Hash
+	ECDSA bool
+}
+
+var signatureAlgorithms = map[string]signatureAlgorithm{
+	"http://www.w3.org/2000/09/xmldsig#rsa-sha1":          {Hash: crypto.SHA1},
+	"http://www.w3.org/2001/04/xmldsig-more#rsa-sha256":   {Hash: crypto.SHA256},
+	"http://www.w3.org/2001/04/xmldsig-more#rsa-sha384":   {Hash: crypto.SHA384},
+	"http://www.w3.org/2001/04/xmldsig-more#rsa-sha512":   {Hash: crypto.SHA512},
+	"http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha256": {Hash: crypto.SHA256, ECDSA: true},
+	"http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha384": {Hash: crypto.SHA384, ECDSA: true},
+	"http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha512": {Hash: crypto.SHA512, ECDSA: true},
+}
+
+var digestAlgorithms = map[string]crypto.Hash{
+	"http://www.w3.org/2000/09/xmldsig#sha1":        crypto.SHA1,
+	"http://www.w3.org/2001/04/xmlenc#sha256":       crypto.SHA256,
+	"http://www.w3.org/2001/04/xmldsig-more#sha384": crypto.SHA384,
+	"http://www.w3.org/2001/04/xmlenc#sha512":       crypto.SHA512,
+}
+
+// LoadCertificates reads the PEM encoded certificates of a trust store file
+func LoadCertificates(path string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		if block, data = pem.Decode(data); block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("%s: no certificates found", path)
+	}
+	return certs, nil
+}
+
+// VerifySignature returns a request hook verifying the XML signature in the
+// Security header against the trusted certificates of cfg. The signature
+// must cover the Body and, if there is one, the Timestamp of the header,
+// canonicalized with Exclusive C14N. The signing certificate is taken from
+// a BinarySecurityToken, which must be issued by a trusted certificate, or
+// referenced in the trust store by issuer and serial number, subject key
+// identifier or thumbprint. Requests without a signature pass unless
+// cfg.RequireSignature is set. Envelopes that cannot be parsed, or have
+// more than one Header or Body, are rejected so that the Body dispatched is
+// the one verified. MTOM requests are verified on the root part as sent,
+// which the hook receives.
+func VerifySignature(cfg Config) soap.RequestHook {
+	roots := x509.NewCertPool()
+	for _, cert := range cfg.TrustedCerts {
+		roots.AddCert(cert)
+	}
+	return func(r *http.Request, body []byte) ([]byte, error) {
+		envelope, err := parseDocument(r.Context(), body)
+		if err != nil {
+			// The decoders may still read what this hook cannot
+			return nil, invalidSecurity("Malformed envelope: " + err.Error())
+		}
+		signer, fault := cfg.verify(envelope, roots, time.Now())
+		if fault != nil {
+			soap.Warn(r.Context(), "WS-Security signature check failed", soap.LogKeyError, fault)
+			return nil, fault
+		}
+		if signer != nil {
+			soap.Debug(r.Context(), "WS-Security signature verified", "subject", signer.Subject.String())
+		}
+		return body, nil
+	}
+}
+
+// verify checks the signature of the envelope at now and returns the
+// signing certificate, or nil if the envelope is not signed
+func (cfg Config) verify(envelope *element, roots *x509.CertPool, now time.Time) (*x509.Certificate, *soapfault.Fault) {
+	body, security, ok := envelopeParts(envelope)
+	if !ok {
+		return nil, invalidSecurity("The message is not a SOAP envelope with one Header and Body")
+	}
+	var signatures []*element
+	if security != nil {
+		signatures = security.children(SignatureNamespace, "Signature")
+	}
+	switch {
+	case len(signatures) == 0 && cfg.RequireSignature:
+		return nil, invalidSecurity("The message is not signed")
+	case len(signatures) == 0:
+		return nil, nil
+	case len(signatures) > 1:
+		return nil, invalidSecurity("The wsse:Security header has more than one ds:Signature")
+	}
+
+	ids, err := indexIDs(envelope)
+	if err != nil {
+		return nil, invalidSecurity("Invalid IDs: " + err.Error())
+	}
+	signed, cert, fault := cfg.checkSignature(signatures[0], security, ids, roots, now)
+	if fault != nil {
+		return nil, fault
+	}
+
+	// The references must cover the Body and the Timestamp
+	if body == nil || !signed[body] {
+		return nil, invalidSecurity("The signature does not cover the Body")
+	}
+	if timestamp := security.child(UtilityNamespace, "Timestamp"); timestamp != nil && !signed[timestamp] {
+		return nil, invalidSecurity("The signature does not cover the wsu:Timestamp")
+	}
+	return cert, nil
+}
+
+// checkSignature verifies the digests of the elements a ds:Signature
+// references, the trust in its certificate and its value at now, and
+// returns the signed elements and the signing certificate
+func (cfg Config) checkSignature(signature, security *element, ids map[string]*element, roots *x509.CertPool, now time.Time) (map[*element]bool, *x509.Certificate, *soapfault.Fault) {
+	signedInfo := signature.child(SignatureNamespace, "SignedInfo")
+	if signedInfo == nil {
+		return nil, nil, invalidSecurity("The ds:Signature has no ds:SignedInfo")
+	}
+	inclusive, fault := c14nMethod(signedInfo.child(SignatureNamespace, "CanonicalizationMethod"))
+	if fault != nil {
+		return nil, nil, fault
+	}
+	method := signedInfo.child(SignatureNamespace, "SignatureMethod")
+	if method == nil {
+		return nil, nil, invalidSecurity("The ds:SignedInfo has no ds:SignatureMethod")
+	}
+	algorithm, ok := signatureAlgorithms[method.attr("", "Algorithm")]
+	if !ok {
+		return nil, nil, unsupportedAlgorithm("Unsupported signature method " + method.attr("", "Algorithm"))
+	}
+
+	signed := map[*element]bool{}
+	for _, reference := range signedInfo.children(SignatureNamespace, "Reference") {
+		target, fault := verifyReference(reference, signature, ids)
+		if fault != nil {
+			return nil, nil, fault
+		}
+		signed[target] = true
+	}
+
+	cert, fault := cfg.signingCertificate(signature.child(SignatureNamespace, "KeyInfo"), security, ids)
+	if fault != nil {
+		return nil, nil, fault
+	}
+	if fault := cfg.trust(cert, roots, now); fault != nil {
+		return nil, nil, fault
+	}
+
+	value, err := decodeBase64(signature.child(SignatureNamespace, "SignatureValue"))
+	if err != nil {
+		return nil, nil, invalidSecurity("Invalid ds:SignatureValue: " + err.Error())
+	}
+	hash := algorithm.Hash.New()
+	hash.Write(canonicalize(signedInfo, inclusive, nil))
+	if err := verifySignatureValue(cert.PublicKey, algorithm, hash.Sum(nil), value); err != nil {
+		return nil, nil, failedCheck("Invalid signature: " + err.Error())
+	}
+	return signed, cert, nil
+}
+
+// verifyReference checks the digest of an element signed by the signature
+// and returns it. The enveloped signature transform leaves the signature
+// itself out of the digest.
+func verifyReference(reference, signature *element, ids map[string]*element) (*element, *soapfault.Fault) {
+	uri := reference.attr("", "URI")
+	target := ids[strings.TrimPrefix(uri, "#")]
+	if !strings.HasPrefix(uri, "#") || target == nil {
+		return nil, invalidSecurity("Unknown signature reference " + uri)
+	}
+
+	var inclusive []string
+	var exclude *element
+	transforms := reference.child(SignatureNamespace, "Transforms")
+	if transforms == nil {
+		return nil, unsupportedAlgorithm("The signature reference " + uri + " is not canonicalized")
+	}
+	for _, transform := range transforms.children(SignatureNamespace, "Transform") {
+		if transform.attr("", "Algorithm") == EnvelopedSignature {
+			exclude = signature
+			continue
+		}
+		var fault *soapfault.Fault
+		if inclusive, fault = c14nMethod(transform); fault != nil {
+			return nil, fault
+		}
+	}
+
+	method := reference.child(SignatureNamespace, "DigestMethod")
+	if method == nil {
+		return nil, invalidSecurity("The signature reference " + uri + " has no ds:DigestMethod")
+	}
+	digestHash, ok := digestAlgorithms[method.attr("", "Algorithm")]
+	if !ok {
+		return nil, unsupportedAlgorithm("Unsupported digest method " + method.attr("", "Algorithm"))
+	}
+	expected, err := decodeBase64(reference.child(SignatureNamespace, "DigestValue"))
+	if err != nil {
+		return nil, invalidSecurity("Invalid ds:DigestValue: " + err.Error())
+	}
+	hash := digestHash.New()
+	hash.Write(canonicalize(target, inclusive, exclude))
+	if subtle.ConstantTimeCompare(hash.Sum(nil), expected) != 1 {
+		return nil, failedCheck("The digest of " + uri + " does not match")
+	}
+	return target, nil
+}
+
+// c14nMethod checks that a CanonicalizationMethod or Transform is Exclusive
+// C14N and returns its inclusive namespace prefixes
+func c14nMethod(method *element) ([]string, *soapfault.Fault) {
+	if method == nil {
+		return nil, invalidSecurity("The ds:SignedInfo has no ds:CanonicalizationMethod")
+	}
+	if algorithm := method.attr("", "Algorithm"); algorithm != ExclusiveC14N {
+		return nil, unsupportedAlgorithm("Unsupported canonicalization or transform " + algorithm)
+	}
+	if inclusive := method.child(ExclusiveC14N, "InclusiveNamespaces"); inclusive != nil {
+		return strings.Fields(inclusive.attr("", "PrefixList")), nil
+	}
+	return nil, nil
+}
+
+// signingCertificate returns the certificate identified by the KeyInfo of
+// the signature
+func (cfg Config) signingCertificate(keyInfo *element, security *element, ids map[string]*element) (*x509.Certificate, *soapfault.Fault) {
+	if keyInfo == nil {
+		return nil, tokenUnavailable("The ds:Signature has no ds:KeyInfo")
+	}
+	if data := keyInfo.child(SignatureNamespace, "X509Data"); data != nil {
+		return cfg.x509DataCertificate(data)
+	}
+	reference := keyInfo.child(SecurityNamespace, "SecurityTokenReference")
+	if reference == nil {
+		return nil, tokenUnavailable("The ds:KeyInfo has no wsse:SecurityTokenReference")
+	}
+
+	if ref := reference.child(SecurityNamespace, "Reference"); ref != nil {
+		token := ids[strings.TrimPrefix(ref.attr("", "URI"), "#")]
+		if token == nil || token.Parent != security || token.Space != SecurityNamespace || token.Local != "BinarySecurityToken" {
+			return nil, tokenUnavailable("Unknown security token " + ref.attr("", "URI"))
+		}
+		if valueType := token.attr("", "ValueType"); valueType != x509v3Token {
+			return nil, unsupportedToken("Unsupported security token type " + valueType)
+		}
+		return parseCertificate(token)
+	}
+
+	if identifier := reference.child(SecurityNamespace, "KeyIdentifier"); identifier != nil {
+		valueType := identifier.attr("", "ValueType")
+		if valueType == x509v3Token {
+			return parseCertificate(identifier)
+		}
+		if valueType != x509SKIToken && valueType != x509ThumbprintToken {
+			return nil, unsupportedToken("Unsupported key identifier type " + valueType)
+		}
+		value, err := decodeBase64(identifier)
+		if err != nil {
+			return nil, invalidSecurity("Invalid wsse:KeyIdentifier: " + err.Error())
+		}
+		for _, cert := range cfg.TrustedCerts {
+			thumbprint := sha1.Sum(cert.Raw)
+			if (valueType == x509SKIToken && len(cert.SubjectKeyId) > 0 && bytes.Equal(cert.SubjectKeyId, value)) ||
+				(valueType == x509ThumbprintToken && bytes.Equal(thumbprint[:], value)) {
+				return cert, nil
+			}
+		}
+		return nil, tokenUnavailable("No trusted certificate matches the wsse:KeyIdentifier")
+	}
+
+	if data := reference.child(SignatureNamespace, "X509Data"); data != nil {
+		return cfg.x509DataCertificate(data)
+	}
+	return nil, tokenUnavailable("Unsupported wsse:SecurityTokenReference")
+}
+
+// x509DataCertificate returns the certificate of a ds:X509Data, either
+// included or a trusted one named by issuer and serial number
+func (cfg Config) x509DataCertificate(data *element) (*x509.Certificate, *soapfault.Fault) {
+	if included := data.child(SignatureNamespace, "X509Certificate"); included != nil {
+		return parseCertificate(included)
+	}
+	if issuerSerial := data.child(SignatureNamespace, "X509IssuerSerial"); issuerSerial != nil {
+		serial, ok := new(big.Int).SetString(strings.TrimSpace(textOf(issuerSerial.child(SignatureNamespace, "X509SerialNumber"))), 10)
+		if !ok {
+			return nil, invalidSecurity("Invalid ds:X509SerialNumber")
+		}
+		issuer := textOf(issuerSerial.child(SignatureNamespace, "X509IssuerName"))
+		for _, cert := range cfg.TrustedCerts {
+			if cert.SerialNumber.Cmp(serial) == 0 && sameName(cert.Issuer.String(), issuer) {
+				return cert, nil
+			}
+		}
+		return nil, tokenUnavailable("No trusted certificate matches the ds:X509IssuerSerial")
+	}
+	return nil, tokenUnavailable("Unsupported ds:X509Data")
+}
+
+// trust checks that the certificate is in the trust store or issued by a
+// certificate in it, and valid at now
+func (cfg Config) trust(cert *x509.Certificate, roots *x509.CertPool, now time.Time) *soapfault.Fault {
+	if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+		return invalidToken(fmt.Sprintf("The certificate %s is not valid at %s", cert.Subject, now.UTC().Format(time.RFC3339)))
+	}
+	for _, trusted := range cfg.TrustedCerts {
+		if bytes.Equal(trusted.Raw, cert.Raw) {
+			return nil
+		}
+	}
+	opts := x509.VerifyOptions{Roots: roots, CurrentTime: now, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}
+	if _, err := cert.Verify(opts); err != nil {
+		return invalidToken(fmt.Sprintf("The certificate %s is not trusted: %v", cert.Subject, err))
+	}
+	return nil
+}
+
+// verifySignatureValue verifies a SignatureValue over the digest of the
+// canonical SignedInfo. ECDSA values are the concatenated r and s.
+func verifySignatureValue(key crypto.PublicKey, algorithm signatureAlgorithm, digest, value []byte) error {
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if algorithm.ECDSA {
+			break
+		}
+		return rsa.VerifyPKCS1v15(key, algorithm.Hash, digest, value)
+	case *ecdsa.PublicKey:
+		if !algorithm.ECDSA {
+			break
+		}
+		half := len(value) / 2
+		r, s := new(big.Int).SetBytes(value[:half]), new(big.Int).SetBytes(value[half:])
+		if len(value)%2 != 0 || !ecdsa.Verify(key, digest, r, s) {
+			return fmt.Errorf("verification error")
+		}
+		return nil
+	}
+	return fmt.Errorf("the signature method does not match the %T key", key)
+}
+
+// indexIDs returns the elements of the document by wsu:Id or Id attribute.
+// IDs must be unique so a reference cannot be redirected to a copy of the
+// signed element.
+func indexIDs(root *element) (map[string]*element, error) {
+	ids := map[string]*element{}
+	var walk func(e *element) error
+	walk = func(e *element) error {
+		if id := elementID(e); id != "" {
+			if _, ok := ids[id]; ok {
+				return fmt.Errorf("duplicate ID %s", id)
+			}
+			ids[id] = e
+		}
+		for _, child := range e.Children {
+			if c, ok := child.(*element); ok {
+				if err := walk(c); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return ids, walk(root)
+}
+
+// elementID returns the wsu:Id or Id attribute of e
+func elementID(e *element) string {
+	for _, attr := range e.Attrs {
+		if (attr.Space == UtilityNamespace && attr.Local == "Id") || (attr.Space == "" && (attr.Local == "Id" || attr.Local == "ID")) {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// parseCertificate parses the base64 DER certificate inside e
+func parseCertificate(e *element) (*x509.Certificate, *soapfault.Fault) {
+	der, err := decodeBase64(e)
+	if err != nil {
+		return nil, invalidToken("Invalid certificate encoding: " + err.Error())
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, invalidToken("Invalid certificate: " + err.Error())
+	}
+	return cert, nil
+}
+
+// decodeBase64 decodes the base64 content of e, which may be wrapped
+func decodeBase64(e *element) ([]byte, error) {
+	if e == nil {
+		return nil, fmt.Errorf("missing value")
+	}
+	return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(e.text()), ""))
+}
+
+func textOf(e *element) string {
+	if e == nil {
+		return ""
+	}
+	return e.text()
+}
+
+// sameName compares distinguished names ignoring the spacing after the
+// separators, which differs between toolkits
+func sameName(a, b string) bool {
+	normalize := func(name string) string {
+		parts := strings.Split(name, ",")
+		for i, part := range parts {
+			parts[i] = strings.TrimSpace(part)
+		}
+		return strings.Join(parts, ",")
+	}
+	return normalize(a) == normalize(b)
+}
@@ -0,0 +1,78 @@
+package wssec
+
+import (
+	"crypto/x509"
+	"strings"
+	"testing"
+)
+
+// signedBody is the Body of the test envelopes, signed by its wsu:Id
+const signedBody = `<soap:Body wsu:Id="body" xmlns:wsu="` + UtilityNamespace + `">` +
+	`<t:WhoRequest xmlns:t="` + testServiceNS + `"><tag>signed</tag></t:WhoRequest></soap:Body>`
+
+// signedEnvelope returns an envelope whose Security header signs the Body,
+// with extra before the signed Body and trailer after the envelope
+func signedEnvelope(t *testing.T, signer testSigner, extra, trailer string) string {
+	t.Helper()
+	doc := `<soap:Envelope xmlns:soap="` + testEnvelopeNS + `"><soap:Header>` +
+		`<wsse:Security xmlns:wsse="` + SecurityNamespace + `">SIGNATURE</wsse:Security></soap:Header>` +
+		extra + signedBody + `</soap:Envelope>`
+	return signer.sign(t, doc, false, "body") + trailer
+}
+
+func TestSignedBodyIsAccepted(t *testing.T) {
+	signer := newTestSigner(t)
+	server := newTestServer(t, Config{TrustedCerts: []*x509.Certificate{signer.cert}, RequireSignature: true})
+
+	response := call(t, server, signedEnvelope(t, signer, "", ""))
+	if !strings.Contains(response, "<tag>signed</tag>") {
+		t.Errorf("signed request not served:\n%s", response)
+	}
+}
+
+func TestSignatureCannotBeBypassed(t *testing.T) {
+	signer := newTestSigner(t)
+	server := newTestServer(t, Config{TrustedCerts: []*x509.Certificate{signer.cert}, RequireSignature: true})
+	unsigned := `<soap:Envelope xmlns:soap="` + testEnvelopeNS + `">` +
+		`<soap:Body><t:WhoRequest xmlns:t="` + testServiceNS + `"><tag>evil</tag></t:WhoRequest></soap:Body></soap:Envelope>`
+
+	tests := []struct {
+		name     string
+		envelope string
+	}{
+		{"unsigned", unsigned},
+		{"unsigned with trailing element", unsigned + "<x/>"},
+		{"unsigned with undeclared prefix", strings.Replace(unsigned, "<soap:Body>", `<soap:Body undeclared:attr="1">`, 1)},
+		{"second Body", strings.Replace(signedEnvelope(t, signer, "", ""), "</soap:Envelope>",
+			`<soap:Body><t:WhoRequest xmlns:t="`+testServiceNS+`"><tag>evil</tag></t:WhoRequest></soap:Body></soap:Envelope>`, 1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := call(t, server, tt.envelope)
+			if !strings.Contains(response, "Fault") || strings.Contains(response, "<tag>evil</tag>") {
+				t.Errorf("request accepted:\n%s", response)
+			}
+		})
+	}
+}
+
+func TestOnlySignedBodyIsDispatched(t *testing.T) {
+	signer := newTestSigner(t)
+	server := newTestServer(t, Config{TrustedCerts: []*x509.Certificate{signer.cert}, RequireSignature: true})
+
+	// Elements named Body and Header outside the envelope namespace, ahead
+	// of the signed Body
+	wrappers := []string{
+		`<x:Body xmlns:x="urn:evil"><t:WhoRequest xmlns:t="` + testServiceNS + `"><tag>evil</tag></t:WhoRequest></x:Body>`,
+		`<x:Header xmlns:x="urn:evil"></x:Header><x:Body xmlns:x="urn:evil"><t:WhoRequest xmlns:t="` + testServiceNS + `"><tag>evil</tag></t:WhoRequest></x:Body>`,
+	}
+	for _, wrapper := range wrappers {
+		response := call(t, server, signedEnvelope(t, signer, wrapper, ""))
+		if strings.Contains(response, "<tag>evil</tag>") {
+			t.Errorf("unsigned Body dispatched:\n%s", response)
+		}
+		if !strings.Contains(response, "<tag>signed</tag>") {
+			t.Errorf("signed Body not dispatched:\n%s", response)
+		}
+	}
+}
@@ -0,0 +1,318 @@
+// Package wssec implements the receiving side of OASIS WS-Security for the
+// SOAP endpoints: the wsse:Security header of requests is checked by a
+// middleware before the operation runs, while encrypted data is decrypted
+// and XML signatures are verified by request hooks on the raw envelope.
+package wssec
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"soap-server/soap"
+	"soap-server/soapfault"
+	"strings"
+	"time"
+)
+
+// WS-Security 1.0 namespaces
+const (
+	SecurityNamespace = "http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-secext-1.0.xsd"
+	UtilityNamespace  = "http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-utility-1.0.xsd"
+)
+
+// DefaultClockSkew is the tolerated difference between the clocks of the
+// client and the server unless configured otherwise, as in WSS4J and WCF
+const DefaultClockSkew = 5 * time.Minute
+
+// Config selects the checks applied to the Security header
+type Config struct {
+	// RequireTimestamp rejects requests without a wsu:Timestamp. Timestamps
+	// are validated whenever they are present.
+	RequireTimestamp bool
+
+	// ClockSkew is added to the validity of timestamps in both directions.
+	// Defaults to DefaultClockSkew.
+	ClockSkew time.Duration
+
+	// MaxAge rejects messages created longer ago, even if their timestamp
+	// has no or a later wsu:Expires. Zero means no limit.
+	MaxAge time.Duration
+
+	// TrustedCerts enables the verification of XML signatures in the
+	// Security header, by certificates in or issued by the trust store
+	TrustedCerts []*x509.Certificate
+
+	// RequireSignature rejects requests without a signature. It requires
+	// trusted certificates.
+	RequireSignature bool
+
+	// PrivateKey enables the decryption of encrypted data in the Body of
+	// requests with the key of the server certificate
+	PrivateKey *rsa.PrivateKey
+
+	// SAMLTrustedCerts enables SAML 2.0 bearer assertions in the Security
+	// header, which must be signed by a certificate in or issued by this
+	// trust store. The subject of the assertion becomes the caller.
+	SAMLTrustedCerts []*x509.Certificate
+
+	// SAMLIssuer, if set, must be the Issuer of the assertions
+	SAMLIssuer string
+
+	// SAMLAudience, if set, must be in every AudienceRestriction of the
+	// assertions, which must have one
+	SAMLAudience string
+
+	// SAMLNameAttribute names the attribute naming the caller instead of
+	// the NameID of the subject
+	SAMLNameAttribute string
+
+	// SAMLRoleAttribute names the attribute granting roles to the caller
+	SAMLRoleAttribute string
+}
+
+// securityHeader is the content of the wsse:Security header
+type securityHeader struct {
+	Timestamps []timestamp `xml:"http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-utility-1.0.xsd Timestamp"`
+	Assertions []assertion `xml:"urn:oasis:names:tc:SAML:2.0:assertion Assertion"`
+
+	// Other children, which this package does not process
+	Others []struct {
+		XMLName xml.Name
+	} `xml:",any"`
+}
+
+// timestamp is a wsu:Timestamp
+type timestamp struct {
+	Created string `xml:"http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-utility-1.0.xsd Created"`
+	Expires string `xml:"http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-utility-1.0.xsd Expires"`
+}
+
+// Middleware checks the Security header addressed to the server as
+// configured, answering violations with the WS-Security faults
+// (wsse:InvalidSecurity, wsse:MessageExpired). The caller of a request with
+// a SAML assertion is authenticated by its subject. The header is marked
+// understood when it holds nothing else than what was checked, here or by
+// the VerifySignature, VerifyAssertions and DecryptBody hooks, so a
+// mustUnderstand header with unsupported content is still rejected.
+func Middleware(cfg Config) soap.Middleware {
+	if cfg.ClockSkew <= 0 {
+		cfg.ClockSkew = DefaultClockSkew
+	}
+	return func(next soap.SOAPHandler) soap.SOAPHandler {
+		return func(w http.ResponseWriter, r *http.Request) {
+			principal, err := cfg.check(r.Context(), time.Now())
+			if err != nil {
+				soap.Warn(r.Context(), "WS-Security check failed", soap.LogKeyError, err)
+				soap.WriteFault(w, r, err)
+				return
+			}
+			if principal != nil {
+				soap.Debug(r.Context(), "SAML assertion accepted", "subject", principal.Name)
+				r = r.WithContext(soap.WithPrincipal(r.Context(), *principal))
+			}
+			next(w, r)
+		}
+	}
+}
+
+// check validates the Security header of the request at now and returns
+// the caller authenticated by a SAML assertion, if any
+func (cfg Config) check(ctx context.Context, now time.Time) (*soap.Principal, *soapfault.Fault) {
+	block := securityBlock(soap.HeaderFromContext(ctx))
+	if block == nil {
+		if cfg.RequireTimestamp {
+			return nil, invalidSecurity("The message has no wsse:Security header")
+		}
+		return nil, nil
+	}
+	var header securityHeader
+	if err := block.Decode(&header); err != nil {
+		return nil, invalidSecurity("Malformed wsse:Security header: " + err.Error())
+	}
+
+	switch len(header.Timestamps) {
+	case 0:
+		if cfg.RequireTimestamp {
+			return nil, invalidSecurity("The wsse:Security header has no wsu:Timestamp")
+		}
+	case 1:
+		if err := cfg.checkTimestamp(header.Timestamps[0], now); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, invalidSecurity("The wsse:Security header has more than one wsu:Timestamp")
+	}
+
+	// Assertions are only accepted if their signatures were verified
+	if len(header.Assertions) > 0 && len(cfg.SAMLTrustedCerts) == 0 {
+		return nil, nil
+	}
+	var principal *soap.Principal
+	switch len(header.Assertions) {
+	case 0:
+	case 1:
+		// The assertion of the header is only trusted as the hook read it
+		verified, ok := ctx.Value(verifiedAssertionKey{}).(assertion)
+		if !ok {
+			return nil, invalidToken("The saml2:Assertion was not verified")
+		}
+		p, err := cfg.checkAssertion(verified, now)
+		if err != nil {
+			return nil, err
+		}
+		principal = &p
+	default:
+		return nil, invalidSecurity("The wsse:Security header has more than one saml2:Assertion")
+	}
+
+	for _, other := range header.Others {
+		if !cfg.processed(other.XMLName) {
+			return principal, nil
+		}
+	}
+	block.MarkUnderstood()
+	return principal, nil
+}
+
+// processed reports whether a child of the Security header is processed by
+// the VerifySignature or DecryptBody hook
+func (cfg Config) processed(name xml.Name) bool {
+	verifies, decrypts := len(cfg.TrustedCerts) > 0, cfg.PrivateKey != nil
+	switch {
+	case name.Space == SignatureNamespace && name.Local == "Signature":
+		return verifies
+	case name.Space == SecurityNamespace && name.Local == "BinarySecurityToken":
+		return verifies || decrypts
+	case name.Space == EncryptionNamespace && (name.Local == "EncryptedKey" || name.Local == "ReferenceList"):
+		return decrypts
+	}
+	return false
+}
+
+// checkTimestamp checks that the message was created and has not expired
+// at now, within the clock skew
+func (cfg Config) checkTimestamp(ts timestamp, now time.Time) *soapfault.Fault {
+	var created time.Time
+	if ts.Created != "" {
+		var err error
+		if created, err = parseTime(ts.Created); err != nil {
+			return invalidSecurity("Invalid wsu:Created: " + err.Error())
+		}
+		if created.After(now.Add(cfg.ClockSkew)) {
+			return invalidSecurity(fmt.Sprintf("The message was created in the future (%s)", ts.Created))
+		}
+		if cfg.MaxAge > 0 && now.Sub(created) > cfg.MaxAge+cfg.ClockSkew {
+			return messageExpired(fmt.Sprintf("The message was created at %s, more than %s ago", ts.Created, cfg.MaxAge))
+		}
+	} else if cfg.MaxAge > 0 {
+		return invalidSecurity("The wsu:Timestamp has no wsu:Created")
+	}
+
+	if ts.Expires != "" {
+		expires, err := parseTime(ts.Expires)
+		if err != nil {
+			return invalidSecurity("Invalid wsu:Expires: " + err.Error())
+		}
+		if !created.IsZero() && expires.Before(created) {
+			return invalidSecurity("The wsu:Timestamp expires before it was created")
+		}
+		if !now.Before(expires.Add(cfg.ClockSkew)) {
+			return messageExpired(fmt.Sprintf("The message expired at %s", ts.Expires))
+		}
+	}
+	return nil
+}
+
+// securityBlock returns the Security header addressed to the server, or nil
+func securityBlock(h *soap.Header) *soap.HeaderBlock {
+	if h == nil {
+		return nil
+	}
+	for _, block := range h.Blocks {
+		if block.Name.Space == SecurityNamespace && block.Name.Local == "Security" && block.TargetsUs() {
+			return block
+		}
+	}
+	return nil
+}
+
+// envelopeParts returns the Body and the Security header addressed to the
+// server of a parsed envelope; ok is false if it is not a SOAP envelope or
+// has more than one Header or Body
+func envelopeParts(envelope *element) (body, security *element, ok bool) {
+	version, ok := soap.VersionFromNamespace(envelope.Space)
+	if !ok || envelope.Local != "Envelope" {
+		return nil, nil, false
+	}
+	ns := version.EnvelopeNamespace()
+	headers, bodies := envelope.children(ns, "Header"), envelope.children(ns, "Body")
+	if len(headers) > 1 || len(bodies) > 1 {
+		return nil, nil, false
+	}
+	if len(headers) == 1 {
+		for _, block := range headers[0].children(SecurityNamespace, "Security") {
+			role := block.attr(ns, "actor") + block.attr(ns, "role")
+			if (&soap.HeaderBlock{Role: role}).TargetsUs() {
+				security = block
+				break
+			}
+		}
+	}
+	if len(bodies) == 1 {
+		body = bodies[0]
+	}
+	return body, security, true
+}
+
+// parseTime parses an xsd:dateTime with a time zone, as WS-Security requires
+func parseTime(s string) (time.Time, error) {
+	return time.Parse(time.RFC3339Nano, strings.TrimSpace(s))
+}
+
+// invalidSecurity returns the fault for a Security header that could not be
+// processed
+func invalidSecurity(detail string) *soapfault.Fault {
+	return soapfault.Client("An error was discovered processing the <wsse:Security> header", detail).
+		WithSubcode(SecurityNamespace, "InvalidSecurity")
+}
+
+// messageExpired returns the fault for a message that is no longer valid
+func messageExpired(detail string) *soapfault.Fault {
+	return soapfault.Client("The message has expired", detail).
+		WithSubcode(SecurityNamespace, "MessageExpired")
+}
+
+// failedCheck returns the fault for an invalid signature
+func failedCheck(detail string) *soapfault.Fault {
+	return soapfault.Client("The signature or decryption was invalid", detail).
+		WithSubcode(SecurityNamespace, "FailedCheck")
+}
+
+// unsupportedAlgorithm returns the fault for an algorithm the server does
+// not implement
+func unsupportedAlgorithm(detail string) *soapfault.Fault {
+	return soapfault.Client("An unsupported signature or encryption algorithm was used", detail).
+		WithSubcode(SecurityNamespace, "UnsupportedAlgorithm")
+}
+
+// unsupportedToken returns the fault for a security token of an unknown type
+func unsupportedToken(detail string) *soapfault.Fault {
+	return soapfault.Client("An unsupported token was provided", detail).
+		WithSubcode(SecurityNamespace, "UnsupportedSecurityToken")
+}
+
+// invalidToken returns the fault for a malformed or untrusted security token
+func invalidToken(detail string) *soapfault.Fault {
+	return soapfault.Client("An invalid security token was provided", detail).
+		WithSubcode(SecurityNamespace, "InvalidSecurityToken")
+}
+
+// tokenUnavailable returns the fault for a security token reference that
+// cannot be resolved
+func tokenUnavailable(detail string) *soapfault.Fault {
+	return soapfault.Client("Referenced security token could not be retrieved", detail).
+		WithSubcode(SecurityNamespace, "SecurityTokenUnavailable")
+}
@@ -0,0 +1,154 @@
+package wssec
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/xml"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"soap-server/soap"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Namespaces and element names of the test envelopes
+const (
+	testEnvelopeNS = "http://schemas.xmlsoap.org/soap/envelope/"
+	testServiceNS  = "urn:test"
+)
+
+type whoRequest struct {
+	XMLName xml.Name `xml:"urn:test WhoRequest"`
+	Tag     string   `xml:"tag"`
+}
+
+type whoResponse struct {
+	XMLName xml.Name `xml:"urn:test WhoResponse"`
+	Name    string   `xml:"name"`
+	Tag     string   `xml:"tag"`
+}
+
+// testSigner signs test envelopes with a self-signed certificate
+type testSigner struct {
+	key  *rsa.PrivateKey
+	cert *x509.Certificate
+}
+
+func newTestSigner(t *testing.T) testSigner {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test signer"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testSigner{key: key, cert: cert}
+}
+
+// sign replaces the SIGNATURE placeholder of the document with a signature
+// referencing the elements with the given IDs, with the enveloped signature
+// transform if enveloped. The certificate is sent in the ds:KeyInfo.
+func (s testSigner) sign(t *testing.T, doc string, enveloped bool, ids ...string) string {
+	t.Helper()
+	root, err := parseDocument(context.Background(), []byte(strings.Replace(doc, "SIGNATURE", "", 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := indexIDs(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transforms := `<ds:Transform Algorithm="http://www.w3.org/2001/10/xml-exc-c14n#"></ds:Transform>`
+	if enveloped {
+		transforms = `<ds:Transform Algorithm="` + EnvelopedSignature + `"></ds:Transform>` + transforms
+	}
+	var references strings.Builder
+	for _, id := range ids {
+		target := index[id]
+		if target == nil {
+			t.Fatalf("no element with ID %s", id)
+		}
+		digest := crypto.SHA256.New()
+		digest.Write(canonicalize(target, nil, nil))
+		references.WriteString(`<ds:Reference URI="#` + id + `"><ds:Transforms>` + transforms + `</ds:Transforms>` +
+			`<ds:DigestMethod Algorithm="http://www.w3.org/2001/04/xmlenc#sha256"></ds:DigestMethod>` +
+			`<ds:DigestValue>` + base64.StdEncoding.EncodeToString(digest.Sum(nil)) + `</ds:DigestValue></ds:Reference>`)
+	}
+	signedInfo := `<ds:SignedInfo xmlns:ds="` + SignatureNamespace + `">` +
+		`<ds:CanonicalizationMethod Algorithm="http://www.w3.org/2001/10/xml-exc-c14n#"></ds:CanonicalizationMethod>` +
+		`<ds:SignatureMethod Algorithm="http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"></ds:SignatureMethod>` +
+		references.String() + `</ds:SignedInfo>`
+
+	parsed, err := parseDocument(context.Background(), []byte(signedInfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := crypto.SHA256.New()
+	hash.Write(canonicalize(parsed, nil, nil))
+	value, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, hash.Sum(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := `<ds:Signature xmlns:ds="` + SignatureNamespace + `">` +
+		strings.Replace(signedInfo, ` xmlns:ds="`+SignatureNamespace+`"`, "", 1) +
+		`<ds:SignatureValue>` + base64.StdEncoding.EncodeToString(value) + `</ds:SignatureValue>` +
+		`<ds:KeyInfo><ds:X509Data><ds:X509Certificate>` + base64.StdEncoding.EncodeToString(s.cert.Raw) +
+		`</ds:X509Certificate></ds:X509Data></ds:KeyInfo></ds:Signature>`
+	return strings.Replace(doc, "SIGNATURE", signature, 1)
+}
+
+// newTestServer returns a SOAP server with the WS-Security hooks and
+// middleware of cfg, whose operation answers with the caller and the tag of
+// the request
+func newTestServer(t *testing.T, cfg Config) *soap.Server {
+	t.Helper()
+	registry := soap.NewOperationRegistry()
+	err := registry.RegisterFunc(soap.Operation{Name: "Who", Namespace: testServiceNS, SOAPAction: testServiceNS + "/Who", RequestElement: "WhoRequest"},
+		func(ctx context.Context, req whoRequest) (whoResponse, error) {
+			principal, _ := soap.PrincipalFromContext(ctx)
+			return whoResponse{Name: principal.Name, Tag: req.Tag}, nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := soap.NewServer(registry)
+	if len(cfg.TrustedCerts) > 0 {
+		server.OnRequest(VerifySignature(cfg))
+	}
+	if len(cfg.SAMLTrustedCerts) > 0 {
+		server.OnRequest(VerifyAssertions(cfg))
+	}
+	server.Use(Middleware(cfg))
+	return server
+}
+
+// call posts the envelope to the server and returns the response body
+func call(t *testing.T, server http.Handler, envelope string) string {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/soap", strings.NewReader(envelope))
+	req.Header.Set("Content-Type", "text/xml; charset=utf-8")
+	req.Header.Set("SOAPAction", testServiceNS+"/Who")
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	return rec.Body.String()
+}
@@ -0,0 +1,354 @@
+package xsd
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var (
+	timeType        = reflect.TypeOf(time.Time{})
+	unmarshalerType = reflect.TypeOf((*xml.Unmarshaler)(nil)).Elem()
+)
+
+// The XML namespace of the xml:lang attribute and its schema
+const (
+	xmlNamespace      = "http://www.w3.org/XML/1998/namespace"
+	xmlSchemaLocation = "http://www.w3.org/2001/xml.xsd"
+)
+
+// Generate produces an XSD document declaring a global element for each of
+// the given struct types, following the encoding/xml mapping of the types:
+//
+//   - The element name comes from the XMLName field tag or the type name
+//   - Fields tagged omitempty and pointer fields are optional (minOccurs="0")
+//   - Slices other than []byte may occur any number of times
+//   - Named byte slices implementing xml.Unmarshaler are base64Binary
+//   - Fields tagged ",attr" become attributes; ",innerxml", ",comment" and
+//     "-" fields are not part of the content model
+//   - Structs with a ",chardata" field and otherwise attributes only have
+//     simple content of its type; xml:lang refers to the XML namespace
+//
+// Restrictions on string fields are given in an xsd struct tag, e.g.
+// `xsd:"minLength=1,maxLength=255"`, `xsd:"pattern=[0-9]+"` or
+// `xsd:"enum=active|disabled"`.
+func Generate(namespace string, types []reflect.Type) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	fmt.Fprintf(&buf, `<xsd:schema xmlns:xsd="%s"`+"\n", Namespace)
+	fmt.Fprintf(&buf, `            xmlns:tns="%s"`+"\n", escapeAttr(namespace))
+	fmt.Fprintf(&buf, `            targetNamespace="%s"`+"\n", escapeAttr(namespace))
+	buf.WriteString(`            elementFormDefault="qualified">` + "\n")
+
+	var body bytes.Buffer
+	seen := make(map[string]bool)
+	for _, t := range types {
+		t = deref(t)
+		if t.Kind() != reflect.Struct {
+			return nil, fmt.Errorf("type %s is not a struct", t)
+		}
+		name := elementName(t)
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		body.WriteString("\n")
+		if err := writeElement(&body, name, t, "", 1); err != nil {
+			return nil, err
+		}
+	}
+
+	// Attributes such as xml:lang need the schema of the XML namespace
+	if bytes.Contains(body.Bytes(), []byte(`ref="xml:`)) {
+		fmt.Fprintf(&buf, "    <xsd:import namespace=\"%s\" schemaLocation=\"%s\"/>\n", xmlNamespace, xmlSchemaLocation)
+	}
+	buf.Write(body.Bytes())
+	buf.WriteString("</xsd:schema>\n")
+	return buf.Bytes(), nil
+}
+
+// writeElement writes an element declaration of type t
+func writeElement(buf *bytes.Buffer, name string, t reflect.Type, occurs string, depth int) error {
+	pad := strings.Repeat("    ", depth)
+	t = deref(t)
+
+	if simple, ok := builtinType(t); ok {
+		fmt.Fprintf(buf, "%s<xsd:element name=\"%s\" type=\"xsd:%s\"%s/>\n", pad, name, simple, occurs)
+		return nil
+	}
+	if t.Kind() != reflect.Struct {
+		return fmt.Errorf("element %s: unsupported type %s", name, t)
+	}
+
+	if text, ok := simpleContent(t); ok {
+		return writeSimpleContent(buf, name, t, text, occurs, depth)
+	}
+
+	fmt.Fprintf(buf, "%s<xsd:element name=\"%s\"%s>\n", pad, name, occurs)
+	fmt.Fprintf(buf, "%s    <xsd:complexType>\n", pad)
+	if err := writeContent(buf, t, depth+2); err != nil {
+		return err
+	}
+	fmt.Fprintf(buf, "%s    </xsd:complexType>\n", pad)
+	fmt.Fprintf(buf, "%s</xsd:element>\n", pad)
+	return nil
+}
+
+// writeContent writes the sequence and attributes of a struct type
+func writeContent(buf *bytes.Buffer, t reflect.Type, depth int) error {
+	pad := strings.Repeat("    ", depth)
+
+	var attrs []string
+	fmt.Fprintf(buf, "%s<xsd:sequence>\n", pad)
+	for _, field := range contentFields(t) {
+		name, options := parseTag(field.Tag.Get("xml"))
+		if name == "" {
+			name = field.Name
+		}
+
+		ft := field.Type
+		optional := options["omitempty"] || ft.Kind() == reflect.Ptr
+
+		if options["attr"] {
+			attr, err := attribute(field, pad)
+			if err != nil {
+				return err
+			}
+			attrs = append(attrs, attr)
+			continue
+		}
+
+		var occurs string
+		if optional {
+			occurs = ` minOccurs="0"`
+		}
+		if ft.Kind() == reflect.Slice && ft.Elem().Kind() != reflect.Uint8 {
+			occurs = ` minOccurs="0" maxOccurs="unbounded"`
+			ft = ft.Elem()
+		}
+
+		if restriction := field.Tag.Get("xsd"); restriction != "" {
+			if err := writeRestricted(buf, name, ft, occurs, restriction, depth+1); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := writeElement(buf, name, ft, occurs, depth+1); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(buf, "%s</xsd:sequence>\n", pad)
+
+	for _, attr := range attrs {
+		buf.WriteString(attr)
+	}
+	return nil
+}
+
+// writeSimpleContent writes an element of a struct type with simple content
+func writeSimpleContent(buf *bytes.Buffer, name string, t reflect.Type, text reflect.StructField, occurs string, depth int) error {
+	pad := strings.Repeat("    ", depth)
+
+	simple, ok := builtinType(deref(text.Type))
+	if !ok {
+		return fmt.Errorf("element %s: unsupported text type %s", name, text.Type)
+	}
+
+	fmt.Fprintf(buf, "%s<xsd:element name=\"%s\"%s>\n", pad, name, occurs)
+	fmt.Fprintf(buf, "%s    <xsd:complexType>\n", pad)
+	fmt.Fprintf(buf, "%s        <xsd:simpleContent>\n", pad)
+	fmt.Fprintf(buf, "%s            <xsd:extension base=\"xsd:%s\">\n", pad, simple)
+	for _, field := range contentFields(t) {
+		attr, err := attribute(field, pad+"                ")
+		if err != nil {
+			return err
+		}
+		buf.WriteString(attr)
+	}
+	fmt.Fprintf(buf, "%s            </xsd:extension>\n", pad)
+	fmt.Fprintf(buf, "%s        </xsd:simpleContent>\n", pad)
+	fmt.Fprintf(buf, "%s    </xsd:complexType>\n", pad)
+	fmt.Fprintf(buf, "%s</xsd:element>\n", pad)
+	return nil
+}
+
+// attribute returns the declaration of an attribute field. Attributes in the
+// XML namespace refer to its declarations.
+func attribute(field reflect.StructField, pad string) (string, error) {
+	name, options := parseTag(field.Tag.Get("xml"))
+	if name == "" {
+		name = field.Name
+	}
+	if space, local, ok := strings.Cut(name, " "); ok {
+		if space != xmlNamespace {
+			return "", fmt.Errorf("attribute %s: unsupported namespace %s", local, space)
+		}
+		return fmt.Sprintf("%s<xsd:attribute ref=\"xml:%s\"/>\n", pad, local), nil
+	}
+
+	simple, ok := builtinType(deref(field.Type))
+	if !ok {
+		return "", fmt.Errorf("attribute %s: unsupported type %s", name, field.Type)
+	}
+	use := ` use="required"`
+	if options["omitempty"] || field.Type.Kind() == reflect.Ptr {
+		use = ""
+	}
+	return fmt.Sprintf("%s<xsd:attribute name=\"%s\" type=\"xsd:%s\"%s/>\n", pad, name, simple, use), nil
+}
+
+// writeRestricted writes an element with an anonymous simple type carrying
+// the facets of an xsd struct tag
+func writeRestricted(buf *bytes.Buffer, name string, t reflect.Type, occurs, tag string, depth int) error {
+	pad := strings.Repeat("    ", depth)
+
+	simple, ok := builtinType(deref(t))
+	if !ok {
+		return fmt.Errorf("element %s: restrictions need a simple type, got %s", name, t)
+	}
+
+	fmt.Fprintf(buf, "%s<xsd:element name=\"%s\"%s>\n", pad, name, occurs)
+	fmt.Fprintf(buf, "%s    <xsd:simpleType>\n", pad)
+	fmt.Fprintf(buf, "%s        <xsd:restriction base=\"xsd:%s\">\n", pad, simple)
+	for _, facet := range strings.Split(tag, ",") {
+		key, value, _ := strings.Cut(facet, "=")
+		switch key {
+		case "minLength", "maxLength", "pattern":
+			fmt.Fprintf(buf, "%s            <xsd:%s value=\"%s\"/>\n", pad, key, escapeAttr(value))
+		case "enum":
+			for _, v := range strings.Split(value, "|") {
+				fmt.Fprintf(buf, "%s            <xsd:enumeration value=\"%s\"/>\n", pad, escapeAttr(v))
+			}
+		default:
+			return fmt.Errorf("element %s: unknown xsd facet %q", name, key)
+		}
+	}
+	fmt.Fprintf(buf, "%s        </xsd:restriction>\n", pad)
+	fmt.Fprintf(buf, "%s    </xsd:simpleType>\n", pad)
+	fmt.Fprintf(buf, "%s</xsd:element>\n", pad)
+	return nil
+}
+
+// contentFields returns the exported fields of a struct type that map to
+// elements or attributes, with the fields of embedded structs promoted
+func contentFields(t reflect.Type) []reflect.StructField {
+	var fields []reflect.StructField
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, options := parseTag(field.Tag.Get("xml"))
+
+		if field.Anonymous && name == "" && deref(field.Type).Kind() == reflect.Struct {
+			fields = append(fields, contentFields(deref(field.Type))...)
+			continue
+		}
+		if field.PkgPath != "" || field.Name == "XMLName" || name == "-" ||
+			options["chardata"] || options["innerxml"] || options["comment"] || options["any"] {
+			continue
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// simpleContent returns the ",chardata" field of a struct type whose other
+// content fields are attributes
+func simpleContent(t reflect.Type) (reflect.StructField, bool) {
+	for _, field := range contentFields(t) {
+		if _, options := parseTag(field.Tag.Get("xml")); !options["attr"] {
+			return reflect.StructField{}, false
+		}
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if _, options := parseTag(field.Tag.Get("xml")); options["chardata"] && field.PkgPath == "" {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// builtinType maps a Go type to the built-in XML Schema type encoding/xml
+// reads and writes it as
+func builtinType(t reflect.Type) (string, bool) {
+	if t == timeType {
+		return "dateTime", true
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "string", true
+	case reflect.Bool:
+		return "boolean", true
+	case reflect.Int, reflect.Int64:
+		return "long", true
+	case reflect.Int32:
+		return "int", true
+	case reflect.Int16:
+		return "short", true
+	case reflect.Int8:
+		return "byte", true
+	case reflect.Uint, reflect.Uint64:
+		return "unsignedLong", true
+	case reflect.Uint32:
+		return "unsignedInt", true
+	case reflect.Uint16:
+		return "unsignedShort", true
+	case reflect.Uint8:
+		return "unsignedByte", true
+	case reflect.Float64:
+		return "double", true
+	case reflect.Float32:
+		return "float", true
+	case reflect.Slice:
+		// encoding/xml writes []byte as raw text; named byte slices with
+		// their own XML mapping, such as soap.BinaryField, are base64
+		if t.Elem().Kind() == reflect.Uint8 && reflect.PointerTo(t).Implements(unmarshalerType) {
+			return "base64Binary", true
+		}
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "string", true
+		}
+	}
+	return "", false
+}
+
+// elementName returns the element name of a struct type: the name in its
+// XMLName tag or else the type name
+func elementName(t reflect.Type) string {
+	if field, ok := t.FieldByName("XMLName"); ok {
+		name, _ := parseTag(field.Tag.Get("xml"))
+		if i := strings.LastIndexByte(name, ' '); i >= 0 {
+			name = name[i+1:]
+		}
+		if name != "" {
+			return name
+		}
+	}
+	return t.Name()
+}
+
+// parseTag splits an xml struct tag into the name and its options
+func parseTag(tag string) (string, map[string]bool) {
+	parts := strings.Split(tag, ",")
+	options := make(map[string]bool)
+	for _, option := range parts[1:] {
+		options[option] = true
+	}
+	return parts[0], options
+}
+
+func deref(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Ptr {
+		return t.Elem()
+	}
+	return t
+}
+
+func escapeAttr(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
@@ -0,0 +1,407 @@
+// Package xsd implements the subset of XML Schema used by the service
+// contracts: global elements, named and anonymous complex types with
+// sequence/all content, occurrence constraints and simple types with
+// facet restrictions. Complex types with simple content are validated as
+// their base type; attributes are not validated. It is used to validate
+// request and response elements.
+package xsd
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// Namespace is the XML Schema namespace
+const Namespace = "http://www.w3.org/2001/XMLSchema"
+
+// Unbounded is the MaxOccurs value of maxOccurs="unbounded"
+const Unbounded = -1
+
+// Schema is a set of global element declarations from one or more XSD documents
+type Schema struct {
+	elements     map[xml.Name]*Element
+	complexTypes map[xml.Name]*ComplexType
+	simpleTypes  map[xml.Name]*SimpleType
+}
+
+// Element is an element declaration
+type Element struct {
+	Name      xml.Name
+	MinOccurs int
+	MaxOccurs int // Unbounded for no limit
+	Nillable  bool
+
+	typeName    xml.Name
+	complexType *ComplexType
+	simpleType  *SimpleType
+}
+
+// ComplexType is a complex type with element-only content
+type ComplexType struct {
+	Name      xml.Name
+	Particles []*Element
+	All       bool // xsd:all (any order) instead of xsd:sequence
+}
+
+// SimpleType is a built-in type or a restriction of one
+type SimpleType struct {
+	Name         xml.Name
+	Base         string // Local name of the built-in base type
+	MinLength    int
+	MaxLength    int // -1 for no limit
+	Pattern      string
+	Enumerations []string
+}
+
+// Parse reads the xsd:schema elements of the given documents into a single
+// schema. A document is either a standalone XSD or a document embedding
+// schemas, such as the types section of a WSDL.
+func Parse(docs ...[]byte) (*Schema, error) {
+	s := &Schema{
+		elements:     make(map[xml.Name]*Element),
+		complexTypes: make(map[xml.Name]*ComplexType),
+		simpleTypes:  make(map[xml.Name]*SimpleType),
+	}
+
+	for _, doc := range docs {
+		if err := s.decode(doc); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.resolve(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// decode adds every xsd:schema element found in doc
+func (s *Schema) decode(doc []byte) error {
+	decoder := xml.NewDecoder(bytes.NewReader(doc))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to parse schema: %w", err)
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Space != Namespace || start.Name.Local != "schema" {
+			continue
+		}
+
+		var raw rawSchema
+		if err := decoder.DecodeElement(&raw, &start); err != nil {
+			return fmt.Errorf("failed to parse schema: %w", err)
+		}
+		if err := s.add(&raw); err != nil {
+			return err
+		}
+	}
+}
+
+// Element returns the global element declaration with the given name
+func (s *Schema) Element(namespace, local string) (*Element, bool) {
+	el, ok := s.elements[xml.Name{Space: namespace, Local: local}]
+	return el, ok
+}
+
+// Elements returns all global element declarations sorted by name
+func (s *Schema) Elements() []*Element {
+	elements := make([]*Element, 0, len(s.elements))
+	for _, el := range s.elements {
+		elements = append(elements, el)
+	}
+	sort.Slice(elements, func(i, j int) bool {
+		return elements[i].Name.Local < elements[j].Name.Local
+	})
+	return elements
+}
+
+// ComplexType returns the complex type of the element, or nil for simple content
+func (el *Element) ComplexType() *ComplexType {
+	return el.complexType
+}
+
+// SimpleType returns the simple type of the element, or nil for complex content
+func (el *Element) SimpleType() *SimpleType {
+	return el.simpleType
+}
+
+// rawSchema and the types below mirror the XSD document structure
+type rawSchema struct {
+	TargetNamespace    string           `xml:"targetNamespace,attr"`
+	ElementFormDefault string           `xml:"elementFormDefault,attr"`
+	Elements           []rawElement     `xml:"http://www.w3.org/2001/XMLSchema element"`
+	ComplexTypes       []rawComplexType `xml:"http://www.w3.org/2001/XMLSchema complexType"`
+	SimpleTypes        []rawSimpleType  `xml:"http://www.w3.org/2001/XMLSchema simpleType"`
+}
+
+type rawElement struct {
+	Name        string          `xml:"name,attr"`
+	Ref         string          `xml:"ref,attr"`
+	Type        string          `xml:"type,attr"`
+	MinOccurs   string          `xml:"minOccurs,attr"`
+	MaxOccurs   string          `xml:"maxOccurs,attr"`
+	Nillable    bool            `xml:"nillable,attr"`
+	ComplexType *rawComplexType `xml:"http://www.w3.org/2001/XMLSchema complexType"`
+	SimpleType  *rawSimpleType  `xml:"http://www.w3.org/2001/XMLSchema simpleType"`
+}
+
+type rawComplexType struct {
+	Name          string            `xml:"name,attr"`
+	Sequence      *rawParticle      `xml:"http://www.w3.org/2001/XMLSchema sequence"`
+	All           *rawParticle      `xml:"http://www.w3.org/2001/XMLSchema all"`
+	SimpleContent *rawSimpleContent `xml:"http://www.w3.org/2001/XMLSchema simpleContent"`
+}
+
+type rawSimpleContent struct {
+	Extension *rawRestriction `xml:"http://www.w3.org/2001/XMLSchema extension"`
+}
+
+type rawParticle struct {
+	Elements []rawElement `xml:"http://www.w3.org/2001/XMLSchema element"`
+}
+
+type rawSimpleType struct {
+	Name        string          `xml:"name,attr"`
+	Restriction *rawRestriction `xml:"http://www.w3.org/2001/XMLSchema restriction"`
+}
+
+type rawRestriction struct {
+	Base         string     `xml:"base,attr"`
+	MinLength    *rawFacet  `xml:"http://www.w3.org/2001/XMLSchema minLength"`
+	MaxLength    *rawFacet  `xml:"http://www.w3.org/2001/XMLSchema maxLength"`
+	Pattern      *rawFacet  `xml:"http://www.w3.org/2001/XMLSchema pattern"`
+	Enumerations []rawFacet `xml:"http://www.w3.org/2001/XMLSchema enumeration"`
+}
+
+type rawFacet struct {
+	Value string `xml:"value,attr"`
+}
+
+// add converts the declarations of one document into the schema
+func (s *Schema) add(raw *rawSchema) error {
+	tns := raw.TargetNamespace
+	qualified := raw.ElementFormDefault == "qualified"
+
+	for i := range raw.SimpleTypes {
+		st := convertSimpleType(&raw.SimpleTypes[i], tns)
+		s.simpleTypes[st.Name] = st
+	}
+	for i := range raw.ComplexTypes {
+		if st := convertSimpleContent(&raw.ComplexTypes[i], tns); st != nil {
+			s.simpleTypes[st.Name] = st
+			continue
+		}
+		ct, err := s.convertComplexType(&raw.ComplexTypes[i], tns, qualified)
+		if err != nil {
+			return err
+		}
+		s.complexTypes[ct.Name] = ct
+	}
+	for i := range raw.Elements {
+		el, err := s.convertElement(&raw.Elements[i], tns, true)
+		if err != nil {
+			return err
+		}
+		if _, exists := s.elements[el.Name]; exists {
+			return fmt.Errorf("element %s is declared twice", el.Name.Local)
+		}
+		s.elements[el.Name] = el
+	}
+
+	return nil
+}
+
+func (s *Schema) convertElement(raw *rawElement, tns string, qualified bool) (*Element, error) {
+	if raw.Name == "" {
+		return nil, fmt.Errorf("element references (ref=%q) are not supported", raw.Ref)
+	}
+
+	el := &Element{MinOccurs: 1, MaxOccurs: 1, Nillable: raw.Nillable}
+	el.Name.Local = raw.Name
+	if qualified {
+		el.Name.Space = tns
+	}
+
+	var err error
+	if el.MinOccurs, err = parseOccurs(raw.MinOccurs, 1); err != nil {
+		return nil, fmt.Errorf("element %s: %w", raw.Name, err)
+	}
+	if el.MaxOccurs, err = parseOccurs(raw.MaxOccurs, 1); err != nil {
+		return nil, fmt.Errorf("element %s: %w", raw.Name, err)
+	}
+
+	switch {
+	case raw.ComplexType != nil && raw.ComplexType.SimpleContent != nil:
+		el.simpleType = convertSimpleContent(raw.ComplexType, tns)
+	case raw.ComplexType != nil:
+		el.complexType, err = s.convertComplexType(raw.ComplexType, tns, true)
+		if err != nil {
+			return nil, err
+		}
+	case raw.SimpleType != nil:
+		el.simpleType = convertSimpleType(raw.SimpleType, tns)
+	case raw.Type != "":
+		el.typeName = resolveQName(raw.Type, tns)
+	default:
+		// No type means xsd:anyType; treat as string content
+		el.simpleType = &SimpleType{Base: "string", MaxLength: -1}
+	}
+
+	return el, nil
+}
+
+func (s *Schema) convertComplexType(raw *rawComplexType, tns string, qualified bool) (*ComplexType, error) {
+	ct := &ComplexType{Name: xml.Name{Space: tns, Local: raw.Name}}
+
+	particle := raw.Sequence
+	if raw.All != nil {
+		particle = raw.All
+		ct.All = true
+	}
+	if particle == nil {
+		return ct, nil
+	}
+
+	for i := range particle.Elements {
+		el, err := s.convertElement(&particle.Elements[i], tns, qualified)
+		if err != nil {
+			return nil, err
+		}
+		ct.Particles = append(ct.Particles, el)
+	}
+
+	return ct, nil
+}
+
+// convertSimpleContent returns the simple type of a complex type with simple
+// content, or nil if it has element content. The extension base must be a
+// built-in type.
+func convertSimpleContent(raw *rawComplexType, tns string) *SimpleType {
+	if raw.SimpleContent == nil {
+		return nil
+	}
+	st := &SimpleType{Name: xml.Name{Space: tns, Local: raw.Name}, Base: "string", MaxLength: -1}
+	if ext := raw.SimpleContent.Extension; ext != nil && ext.Base != "" {
+		st.Base = localName(ext.Base)
+	}
+	return st
+}
+
+func convertSimpleType(raw *rawSimpleType, tns string) *SimpleType {
+	st := &SimpleType{Name: xml.Name{Space: tns, Local: raw.Name}, Base: "string", MaxLength: -1}
+	if raw.Restriction == nil {
+		return st
+	}
+
+	r := raw.Restriction
+	st.Base = localName(r.Base)
+	if r.MinLength != nil {
+		st.MinLength, _ = strconv.Atoi(r.MinLength.Value)
+	}
+	if r.MaxLength != nil {
+		if n, err := strconv.Atoi(r.MaxLength.Value); err == nil {
+			st.MaxLength = n
+		}
+	}
+	if r.Pattern != nil {
+		st.Pattern = r.Pattern.Value
+	}
+	for _, e := range r.Enumerations {
+		st.Enumerations = append(st.Enumerations, e.Value)
+	}
+
+	return st
+}
+
+// resolve links element type references to their declarations
+func (s *Schema) resolve() error {
+	var resolveElement func(el *Element) error
+	visited := make(map[*ComplexType]bool)
+
+	resolveComplex := func(ct *ComplexType) error {
+		if visited[ct] {
+			return nil
+		}
+		visited[ct] = true
+		for _, p := range ct.Particles {
+			if err := resolveElement(p); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	resolveElement = func(el *Element) error {
+		if el.typeName.Local != "" && el.complexType == nil && el.simpleType == nil {
+			if el.typeName.Space == Namespace {
+				el.simpleType = &SimpleType{Name: el.typeName, Base: el.typeName.Local, MaxLength: -1}
+			} else if ct, ok := s.complexTypes[el.typeName]; ok {
+				el.complexType = ct
+			} else if st, ok := s.simpleTypes[el.typeName]; ok {
+				el.simpleType = st
+			} else {
+				return fmt.Errorf("element %s: unknown type %s", el.Name.Local, el.typeName.Local)
+			}
+		}
+		if el.complexType != nil {
+			return resolveComplex(el.complexType)
+		}
+		return nil
+	}
+
+	for _, el := range s.elements {
+		if err := resolveElement(el); err != nil {
+			return err
+		}
+	}
+	for _, ct := range s.complexTypes {
+		if err := resolveComplex(ct); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveQName resolves a prefixed type name. The xsd/xs prefixes map to the
+// XML Schema namespace, any other prefix to the target namespace.
+func resolveQName(qname, tns string) xml.Name {
+	prefix, local := "", qname
+	for i := 0; i < len(qname); i++ {
+		if qname[i] == ':' {
+			prefix, local = qname[:i], qname[i+1:]
+			break
+		}
+	}
+	switch prefix {
+	case "xsd", "xs":
+		return xml.Name{Space: Namespace, Local: local}
+	}
+	return xml.Name{Space: tns, Local: local}
+}
+
+func localName(qname string) string {
+	return resolveQName(qname, "").Local
+}
+
+func parseOccurs(value string, def int) (int, error) {
+	switch value {
+	case "":
+		return def, nil
+	case "unbounded":
+		return Unbounded, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid occurrence %q", value)
+	}
+	return n, nil
+}
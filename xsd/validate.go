@@ -0,0 +1,261 @@
+package xsd
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ValidationError describes a schema violation and where it occurred
+type ValidationError struct {
+	Line    int
+	Column  int
+	Element string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Message)
+}
+
+// ValidateElement validates the element that starts with start against its
+// global declaration. The decoder must be positioned right after start; on
+// success the element is fully consumed. Line and column numbers come from
+// the decoder and are therefore relative to the whole document.
+func (s *Schema) ValidateElement(decoder *xml.Decoder, start xml.StartElement) error {
+	v := &validator{decoder: decoder}
+	v.line, v.column = decoder.InputPos()
+
+	el, ok := s.elements[start.Name]
+	if !ok {
+		return v.errorf(start.Name.Local, "element {%s}%s is not declared in the schema", start.Name.Space, start.Name.Local)
+	}
+	return v.element(el, start)
+}
+
+type validator struct {
+	decoder      *xml.Decoder
+	line, column int
+}
+
+func (v *validator) errorf(element, format string, args ...interface{}) error {
+	return &ValidationError{
+		Line:    v.line,
+		Column:  v.column,
+		Element: element,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// next returns the next start or end element, remembering its position.
+// Character data is collected and returned separately.
+func (v *validator) next() (xml.Token, string, error) {
+	var text strings.Builder
+	for {
+		line, column := v.decoder.InputPos()
+		token, err := v.decoder.Token()
+		if err != nil {
+			v.line, v.column = line, column
+			return nil, "", v.errorf("", "malformed XML: %v", err)
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			v.line, v.column = line, column
+			return t, text.String(), nil
+		case xml.EndElement:
+			v.line, v.column = line, column
+			return t, text.String(), nil
+		case xml.CharData:
+			text.Write(t)
+		}
+	}
+}
+
+func (v *validator) element(el *Element, start xml.StartElement) error {
+	if el.Nillable && isNil(start) {
+		return v.skip(start)
+	}
+	if el.complexType != nil {
+		return v.complex(el, start)
+	}
+	return v.simple(el, start)
+}
+
+func (v *validator) complex(el *Element, start xml.StartElement) error {
+	ct := el.complexType
+	counts := make([]int, len(ct.Particles))
+	current := 0
+
+	for {
+		token, text, err := v.next()
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(text) != "" {
+			return v.errorf(el.Name.Local, "element %s must not contain text", el.Name.Local)
+		}
+
+		child, ok := token.(xml.StartElement)
+		if !ok {
+			break
+		}
+
+		index := -1
+		if ct.All {
+			index = findParticle(ct.Particles, child.Name)
+		} else {
+			// Advance through the sequence past satisfied particles
+			for i := current; i < len(ct.Particles); i++ {
+				if ct.Particles[i].Name == child.Name {
+					index = i
+					break
+				}
+				if counts[i] < ct.Particles[i].MinOccurs {
+					return v.errorf(child.Name.Local, "expected element %s in %s, found %s",
+						ct.Particles[i].Name.Local, el.Name.Local, child.Name.Local)
+				}
+			}
+		}
+		if index < 0 {
+			return v.errorf(child.Name.Local, "unexpected element %s in %s", child.Name.Local, el.Name.Local)
+		}
+
+		particle := ct.Particles[index]
+		if particle.MaxOccurs != Unbounded && counts[index] >= particle.MaxOccurs {
+			return v.errorf(child.Name.Local, "element %s occurs more than %d times in %s",
+				child.Name.Local, particle.MaxOccurs, el.Name.Local)
+		}
+		counts[index]++
+		current = index
+
+		if err := v.element(particle, child); err != nil {
+			return err
+		}
+	}
+
+	for i, particle := range ct.Particles {
+		if counts[i] < particle.MinOccurs {
+			return v.errorf(el.Name.Local, "missing required element %s in %s", particle.Name.Local, el.Name.Local)
+		}
+	}
+	return nil
+}
+
+func (v *validator) simple(el *Element, start xml.StartElement) error {
+	line, column := v.line, v.column
+	token, text, err := v.next()
+	if err != nil {
+		return err
+	}
+	if child, ok := token.(xml.StartElement); ok {
+		return v.errorf(child.Name.Local, "element %s must not contain element %s", el.Name.Local, child.Name.Local)
+	}
+	if err := checkValue(el.simpleType, text); err != nil {
+		v.line, v.column = line, column
+		return v.errorf(el.Name.Local, "invalid value for %s: %v", el.Name.Local, err)
+	}
+	return nil
+}
+
+// skip consumes the rest of the element
+func (v *validator) skip(start xml.StartElement) error {
+	if err := v.decoder.Skip(); err != nil {
+		return v.errorf(start.Name.Local, "malformed XML: %v", err)
+	}
+	return nil
+}
+
+func findParticle(particles []*Element, name xml.Name) int {
+	for i, p := range particles {
+		if p.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func isNil(start xml.StartElement) bool {
+	for _, attr := range start.Attr {
+		if attr.Name.Space == "http://www.w3.org/2001/XMLSchema-instance" && attr.Name.Local == "nil" {
+			return attr.Value == "true" || attr.Value == "1"
+		}
+	}
+	return false
+}
+
+// checkValue checks text against the built-in base type and the facets of st
+func checkValue(st *SimpleType, text string) error {
+	value := text
+	if st.Base != "string" && st.Base != "normalizedString" {
+		value = strings.TrimSpace(text)
+	}
+
+	if err := checkBuiltin(st.Base, value); err != nil {
+		return err
+	}
+
+	length := utf8.RuneCountInString(value)
+	if length < st.MinLength {
+		return fmt.Errorf("length %d is less than %d", length, st.MinLength)
+	}
+	if st.MaxLength >= 0 && length > st.MaxLength {
+		return fmt.Errorf("length %d exceeds %d", length, st.MaxLength)
+	}
+	if st.Pattern != "" {
+		re, err := regexp.Compile("^(?:" + st.Pattern + ")$")
+		if err == nil && !re.MatchString(value) {
+			return fmt.Errorf("%q does not match pattern %s", value, st.Pattern)
+		}
+	}
+	if len(st.Enumerations) > 0 {
+		for _, e := range st.Enumerations {
+			if e == value {
+				return nil
+			}
+		}
+		return fmt.Errorf("%q is not one of %s", value, strings.Join(st.Enumerations, ", "))
+	}
+	return nil
+}
+
+func checkBuiltin(base, value string) error {
+	var err error
+	switch base {
+	case "boolean":
+		switch value {
+		case "true", "false", "1", "0":
+		default:
+			err = fmt.Errorf("%q is not a boolean", value)
+		}
+	case "int", "integer", "long", "short", "byte":
+		if _, perr := strconv.ParseInt(value, 10, 64); perr != nil {
+			err = fmt.Errorf("%q is not an integer", value)
+		}
+	case "unsignedInt", "unsignedLong", "nonNegativeInteger", "positiveInteger":
+		if n, perr := strconv.ParseUint(value, 10, 64); perr != nil || (base == "positiveInteger" && n == 0) {
+			err = fmt.Errorf("%q is not a %s", value, base)
+		}
+	case "decimal", "double", "float":
+		if _, perr := strconv.ParseFloat(value, 64); perr != nil {
+			err = fmt.Errorf("%q is not a number", value)
+		}
+	case "base64Binary":
+		if _, perr := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(value), "")); perr != nil {
+			err = fmt.Errorf("invalid base64 data")
+		}
+	case "dateTime":
+		if _, perr := time.Parse(time.RFC3339, value); perr != nil {
+			err = fmt.Errorf("%q is not a dateTime", value)
+		}
+	case "date":
+		if _, perr := time.Parse("2006-01-02", value); perr != nil {
+			err = fmt.Errorf("%q is not a date", value)
+		}
+	}
+	return err
+}